@@ -0,0 +1,160 @@
+package client
+
+import (
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// Behaviours of a batch operation when one of its items fails.
+const (
+	// OnErrorAbort stops processing the batch at the first failed item,
+	// items before it remain applied. This is the default.
+	OnErrorAbort = "abort"
+
+	// OnErrorContinue skips failed items and applies the remainder.
+	OnErrorContinue = "continue"
+
+	// OnErrorRollback restores the device to its state before the batch if
+	// any item fails.
+	OnErrorRollback = "rollback"
+)
+
+// BatchOperation is a single operation of a batch, exactly one of AddPeer or
+// RemovePeer must be given. ExpectedGeneration and DryRun are ignored on
+// individual operations, and are instead taken from the batch.
+type BatchOperation struct {
+	AddPeer    *AddPeerRequest    `json:"add_peer,omitempty"`
+	RemovePeer *RemovePeerRequest `json:"remove_peer,omitempty"`
+}
+
+// BatchResult is the outcome of a single item of a batch.
+type BatchResult struct {
+	// Index of the item within the batch request.
+	Index     int    `json:"index"`
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Line of the file the item was read from, when importing Peers.
+	Line int `json:"line,omitempty"`
+
+	// Gateway is the name of the WG-API server the item was applied to, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+
+	// OK is true if the item was applied and remains applied.
+	OK bool `json:"ok"`
+
+	// AllocatedIPs are the allowed ips assigned to the Peer if
+	// AllocateAllowedIPs was requested.
+	AllocatedIPs []string `json:"allocated_ips,omitempty"`
+
+	// Error is the reason the item could not be applied. It is not set for
+	// items that were not attempted, such as those after an aborted item.
+	Error *jsonrpc.Error `json:"error,omitempty"`
+}
+
+type AddPeersRequest struct {
+	Peers []*AddPeerRequest `json:"peers"`
+
+	// OnError is one of abort (default), continue or rollback.
+	OnError string `json:"on_error,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type AddPeersResponse struct {
+	// OK is true if every item was applied.
+	OK bool `json:"ok"`
+
+	// RolledBack is true if an item failed and the device was restored to
+	// its state before the batch.
+	RolledBack bool `json:"rolled_back,omitempty"`
+
+	Results []*BatchResult `json:"results"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the batch was applied.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+type RemovePeersRequest struct {
+	PublicKeys []string `json:"public_keys"`
+
+	// OnError is one of abort (default), continue or rollback.
+	OnError string `json:"on_error,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type RemovePeersResponse struct {
+	// OK is true if every Peer was removed.
+	OK bool `json:"ok"`
+
+	// RolledBack is true if a Peer could not be removed and the device was
+	// restored to its state before the batch.
+	RolledBack bool `json:"rolled_back,omitempty"`
+
+	Results []*BatchResult `json:"results"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the batch was applied.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+type ApplyBatchRequest struct {
+	Operations []*BatchOperation `json:"operations"`
+
+	// OnError is one of abort (default), continue or rollback.
+	OnError string `json:"on_error,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type ApplyBatchResponse struct {
+	// OK is true if every operation was applied.
+	OK bool `json:"ok"`
+
+	// RolledBack is true if an operation failed and the device was restored
+	// to its state before the batch.
+	RolledBack bool `json:"rolled_back,omitempty"`
+
+	Results []*BatchResult `json:"results"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the batch was applied.
+	Generation uint64 `json:"generation,omitempty"`
+}
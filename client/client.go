@@ -16,9 +16,18 @@ type Client interface {
 	// optionally with pagination.
 	ListPeers(context.Context, *ListPeersRequest) (*ListPeersResponse, error)
 
+	// ListPeerKeys retrieves only the public keys of the Peers of the
+	// device, in order and optionally a page at a time, such that clients
+	// comparing sets of Peers need not list every Peer in full.
+	ListPeerKeys(context.Context, *ListPeerKeysRequest) (*ListPeerKeysResponse, error)
+
 	// GetPeer retrieves a specific Peer by their public key.
 	GetPeer(context.Context, *GetPeerRequest) (*GetPeerResponse, error)
 
+	// GetPeerCount returns the number of Peers of the device, and how many
+	// are online, without listing them, for dashboards that poll often.
+	GetPeerCount(context.Context, *GetPeerCountRequest) (*GetPeerCountResponse, error)
+
 	// AddPeer inserts a new Peer into the WireGuard interfaces table, multiple
 	// calls to AddPeer can be used to update details of the Peer.
 	AddPeer(context.Context, *AddPeerRequest) (*AddPeerResponse, error)
@@ -26,6 +35,116 @@ type Client interface {
 	// RemovePeer deletes a Peer from the WireGuard interfaces table by their
 	// public key,
 	RemovePeer(context.Context, *RemovePeerRequest) (*RemovePeerResponse, error)
+
+	// RemoveAllPeers deletes every Peer from the WireGuard interfaces table at
+	// once, such as when decommissioning a device or before rebuilding it
+	// from a source of truth. The name of the device must be given to
+	// confirm.
+	RemoveAllPeers(context.Context, *RemoveAllPeersRequest) (*RemoveAllPeersResponse, error)
+
+	// AddPeers inserts or updates many Peers at once, with the behaviour on
+	// failure of any one Peer controlled by OnError.
+	AddPeers(context.Context, *AddPeersRequest) (*AddPeersResponse, error)
+
+	// ApplyBatch applies a sequence of Peer additions and removals to the
+	// device, with the behaviour on failure of any one operation controlled
+	// by OnError.
+	ApplyBatch(context.Context, *ApplyBatchRequest) (*ApplyBatchResponse, error)
+
+	// ImportPeers adds Peers described in CSV or JSON Lines format, such as
+	// those exported from a spreadsheet or another VPN manager.
+	ImportPeers(context.Context, *ImportPeersRequest) (*ImportPeersResponse, error)
+
+	// ExportPeers returns every Peer of the device, including their metadata
+	// and usage stats, in CSV or JSON Lines format.
+	ExportPeers(context.Context, *ExportPeersRequest) (*ExportPeersResponse, error)
+
+	// GetServerInfo returns the version and build of the server, the
+	// implementation of WireGuard of its device and its enabled features.
+	GetServerInfo(context.Context, *GetServerInfoRequest) (*GetServerInfoResponse, error)
+
+	// GetCapabilities returns the methods supported by the server, its
+	// enabled features, limits and version of the API.
+	GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error)
+
+	// Ping returns the time of the server, such that clients may cheaply
+	// verify they can reach and authenticate with it.
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
+
+	// GetServerStats returns runtime statistics of the server, such as its
+	// uptime and the requests made of each method.
+	GetServerStats(context.Context, *GetServerStatsRequest) (*GetServerStatsResponse, error)
+
+	// WatchDevices returns every WireGuard device on the host, waiting until
+	// they change if the generation of the devices given is current.
+	WatchDevices(context.Context, *WatchDevicesRequest) (*WatchDevicesResponse, error)
+
+	// TopTalkers returns the Peers that have transferred the most over a
+	// recent window, by rate or by bytes.
+	TopTalkers(context.Context, *TopTalkersRequest) (*TopTalkersResponse, error)
+
+	// ProbePeerEndpoint probes the endpoint of a Peer by ICMP and UDP from
+	// the server, to distinguish a Peer that is offline from one whose
+	// endpoint cannot be reached.
+	ProbePeerEndpoint(context.Context, *ProbePeerEndpointRequest) (*ProbePeerEndpointResponse, error)
+
+	// DiagnoseMTU probes the path MTU toward the endpoint of a Peer from the
+	// server, recommending the MTU of the device for it.
+	DiagnoseMTU(context.Context, *DiagnoseMTURequest) (*DiagnoseMTUResponse, error)
+
+	// MovePeers moves Peers from the device to another WireGuard device on
+	// the same host, keeping their configuration.
+	MovePeers(context.Context, *MovePeersRequest) (*MovePeersResponse, error)
+
+	// RenameDevice renames a WireGuard device on the host, other than that of
+	// the server.
+	RenameDevice(context.Context, *RenameDeviceRequest) (*RenameDeviceResponse, error)
+
+	// ClonePeer adds a Peer with the configuration of an existing Peer under
+	// a new public key.
+	ClonePeer(context.Context, *ClonePeerRequest) (*ClonePeerResponse, error)
+
+	// UpdatePeer changes the configuration of a Peer already on the device,
+	// failing if it is not.
+	UpdatePeer(context.Context, *UpdatePeerRequest) (*UpdatePeerResponse, error)
+
+	// RemovePeers deletes many Peers at once, with the behaviour on failure
+	// of any one Peer controlled by OnError.
+	RemovePeers(context.Context, *RemovePeersRequest) (*RemovePeersResponse, error)
+
+	// ListDevices returns every device served, such as by a server managing
+	// many devices.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+
+	// SetDeviceConfig changes the listen port, firewall mark or private key
+	// of the device.
+	SetDeviceConfig(context.Context, *SetDeviceConfigRequest) (*SetDeviceConfigResponse, error)
+
+	// GenerateKeyPair returns a new private key and its public key.
+	GenerateKeyPair(context.Context, *GenerateKeyPairRequest) (*GenerateKeyPairResponse, error)
+
+	// GeneratePresharedKey returns a new preshared key.
+	GeneratePresharedKey(context.Context, *GeneratePresharedKeyRequest) (*GeneratePresharedKeyResponse, error)
+
+	// SetPeerMetadata replaces or merges the metadata of a Peer already on
+	// the device, without changing its configuration.
+	SetPeerMetadata(context.Context, *SetPeerMetadataRequest) (*SetPeerMetadataResponse, error)
+
+	// GenerateClientConfig returns the wg-quick configuration of a Peer
+	// connecting to the device.
+	GenerateClientConfig(context.Context, *GenerateClientConfigRequest) (*GenerateClientConfigResponse, error)
+
+	// CreateDevice creates a WireGuard device on the host, assigns its
+	// addresses and brings it up.
+	CreateDevice(context.Context, *CreateDeviceRequest) (*CreateDeviceResponse, error)
+
+	// DeleteDevice deletes a WireGuard device on the host, other than that of
+	// the server.
+	DeleteDevice(context.Context, *DeleteDeviceRequest) (*DeleteDeviceResponse, error)
+
+	// SyncPeers changes the Peers of the device to exactly those given,
+	// applying only the changes required.
+	SyncPeers(context.Context, *SyncPeersRequest) (*SyncPeersResponse, error)
 }
 
 type Device struct {
@@ -35,12 +154,40 @@ type Device struct {
 	ListenPort   int    `json:"listen_port"`
 	FirewallMark int    `json:"firewall_mark,omitempty"`
 	NumPeers     int    `json:"num_peers"`
+
+	// Aliases are the names the device may also be addressed by in requests,
+	// in place of the name of its network interface.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Generation is incremented every time the configuration of the device
+	// changes, whether through WG-API or externally.
+	Generation uint64 `json:"generation"`
+
+	// Gateway is the name of the WG-API server the device belongs to, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
 }
 
-type GetDeviceInfoRequest struct{}
+type GetDeviceInfoRequest struct {
+	// Device, if given, is the name or alias of the device described, of
+	// those served by a server managing many devices.
+	Device string `json:"device,omitempty"`
+}
+
+type ListDevicesRequest struct{}
+
+type ListDevicesResponse struct {
+	// Devices are those served, or those of every WG-API server behind a
+	// proxy.
+	Devices []*Device `json:"devices"`
+}
 
 type GetDeviceInfoResponse struct {
 	Device *Device `json:"device"`
+
+	// Gateways are the devices of every WG-API server behind a proxy, Device
+	// then summarizes all of them.
+	Gateways []*Device `json:"gateways,omitempty"`
 }
 
 type Peer struct {
@@ -53,15 +200,108 @@ type Peer struct {
 	TransmitBytes       int64     `json:"transmit_bytes"`
 	AllowedIPs          []string  `json:"allowed_ips"`
 	ProtocolVersion     int       `json:"protocol_version"`
+
+	// ReceiveRate and TransmitRate are the bytes per second received from
+	// and transmitted to the Peer, averaged over a recent window. They are
+	// omitted until the Peer has been sampled twice.
+	ReceiveRate  float64 `json:"receive_rate,omitempty"`
+	TransmitRate float64 `json:"transmit_rate,omitempty"`
+
+	// Latency is the round trip time of the Peer through the tunnel over a
+	// recent window, if measured. It is omitted until the Peer has been
+	// probed while connected.
+	Latency *PeerLatency `json:"latency,omitempty"`
+
+	// Metadata is arbitrary information about the Peer kept by WG-API, such
+	// as the name or owner of the Peer.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Template the Peer was added with, if any.
+	Template string `json:"template,omitempty"`
+
+	// ExpiresAt is when the Peer will be removed from the device, if ever.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Gateway is the name of the WG-API server the Peer belongs to, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type WatchDevicesRequest struct {
+	// Generation of the devices last returned, if the devices are still at
+	// this generation the request waits until they change or Timeout. Zero
+	// returns the devices immediately.
+	Generation uint64 `json:"generation,omitempty"`
+
+	// Timeout is how long to wait for the devices to change, such as 30s. By
+	// default 30 seconds, at most 5 minutes.
+	Timeout string `json:"timeout,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server whose devices are
+	// watched when requested through a proxy. It is required if there is
+	// more than one.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type WatchDevicesResponse struct {
+	// Devices are every WireGuard device on the host, by name.
+	Devices []*Device `json:"devices"`
+
+	// Generation is incremented every time a device appears or disappears.
+	Generation uint64 `json:"generation"`
 }
 
 type ListPeersRequest struct {
 	Limit  int `json:"limit,omitempty"`
 	Offset int `json:"offset,omitempty"`
+
+	// After, if given, is the Next of the previous page, Peers are returned
+	// in order of their public key after it.
+	After string `json:"after,omitempty"`
 }
 
 type ListPeersResponse struct {
 	Peers []*Peer `json:"peers"`
+
+	// Next is given to After to list the next page, empty if this is the
+	// last page.
+	Next string `json:"next,omitempty"`
+
+	// NextOffset is given to Offset, with the same After, to list the next
+	// page, zero if this is the last page.
+	NextOffset int `json:"next_offset,omitempty"`
+
+	// Total is the number of Peers of the device, regardless of the page.
+	Total int `json:"total"`
+
+	// Generation of the device at the time Peers were listed.
+	Generation uint64 `json:"generation"`
+}
+
+type ListPeerKeysRequest struct {
+	// Limit is the maximum number of public keys returned, by default every
+	// public key.
+	Limit int `json:"limit,omitempty"`
+
+	// After, if given, is the Next of the previous page, public keys are
+	// returned in order after it.
+	After string `json:"after,omitempty"`
+
+	// Gateway is the name of the WG-API server whose public keys are listed
+	// when requested through a proxy, required if there is more than one.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type ListPeerKeysResponse struct {
+	// PublicKeys of the Peers of the device, in order.
+	PublicKeys []string `json:"public_keys"`
+
+	// Next is given to After to list the next page, empty if this is the
+	// last page.
+	Next string `json:"next,omitempty"`
+
+	// Generation of the device at the time the public keys were listed.
+	Generation uint64 `json:"generation"`
 }
 
 type GetPeerRequest struct {
@@ -72,6 +312,40 @@ type GetPeerResponse struct {
 	Peer *Peer `json:"peer"`
 }
 
+// PeerCount is the number of Peers of a device.
+type PeerCount struct {
+	Peers int `json:"peers"`
+
+	// Online is the number of Peers that have completed a handshake within
+	// the last 3 minutes.
+	Online int `json:"online"`
+
+	// Gateway is the name of the WG-API server the device belongs to, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type GetPeerCountRequest struct{}
+
+type GetPeerCountResponse struct {
+	Count *PeerCount `json:"count"`
+
+	// Gateways are the counts of every WG-API server behind a proxy, Count
+	// is then their total.
+	Gateways []*PeerCount `json:"gateways,omitempty"`
+}
+
+// DisableKeepAlive, given as the PersistentKeepAlive of an AddPeerRequest,
+// removes the persistent keepalive of a Peer, as an empty PersistentKeepAlive
+// leaves it unchanged. Any zero duration, such as 0, is equivalent.
+const DisableKeepAlive = "0s"
+
+// NoPresharedKey, the zero key, given as the PresharedKey of an
+// AddPeerRequest removes the preshared key of a Peer, as an empty
+// PresharedKey leaves it unchanged. Any other key replaces it, without
+// removing the Peer or its session.
+const NoPresharedKey = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
 type AddPeerRequest struct {
 	PublicKey           string   `json:"public_key"`
 	PresharedKey        string   `json:"preshared_key,omitempty"`
@@ -79,23 +353,170 @@ type AddPeerRequest struct {
 	PersistentKeepAlive string   `json:"persistent_keep_alive,omitempty"`
 	AllowedIPs          []string `json:"allowed_ips,omitempty"`
 
-	// ValidateOnly ensures only validation is completed, no side effects
-	ValidateOnly bool `json:"validate_only"`
+	// Metadata, if given, replaces any existing metadata of the Peer.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// ExpiresAt or TTL, if given, is when, or how long from now, such as
+	// 72h, the Peer is removed from the device, replacing any expiry it
+	// already has. Only one may be given.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+
+	// Template, if given, is the name of a template configured on the server
+	// whose defaults and constraints are applied to the Peer.
+	Template string `json:"template,omitempty"`
+
+	// AllocateAllowedIPs assigns allowed ips to the Peer from the IP address
+	// management plugin configured on the server, in addition to AllowedIPs.
+	AllocateAllowedIPs bool `json:"allocate_allowed_ips,omitempty"`
+
+	// UpdateOnly fails the request with a PeerNotFound error if the Peer is
+	// not already on the device, rather than adding it, such that a mistyped
+	// public key does not add a new Peer.
+	UpdateOnly bool `json:"update_only,omitempty"`
+
+	// ClearEndpoint removes the endpoint of the Peer, such that it is only
+	// learnt from handshakes the Peer initiates. WireGuard cannot unset an
+	// endpoint, so a Peer with one is removed and added again without it,
+	// which drops its last handshake and session, such that it must
+	// handshake again, and resets its transfer counters. A Peer without an
+	// endpoint is left alone. It cannot be given with Endpoint.
+	ClearEndpoint bool `json:"clear_endpoint,omitempty"`
+
+	// CreateOnly fails the request with a Conflict error if the Peer is
+	// already on the device, rather than updating it, such that creating
+	// and updating a Peer are distinct operations.
+	CreateOnly bool `json:"create_only,omitempty"`
+
+	// Force adds the Peer even if its allowed ips overlap those of another
+	// Peer, when the server rejects overlapping allowed ips. WireGuard then
+	// takes any identical allowed ips from the other Peer.
+	Force bool `json:"force,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server the Peer is added
+	// to when requested through a proxy. By default the Peer is added to the
+	// server it already belongs to, or else the server with the fewest Peers.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// ValidateOnly is equivalent to DryRun.
+	//
+	// Deprecated: use DryRun.
+	ValidateOnly bool `json:"validate_only,omitempty"`
 }
 
 type AddPeerResponse struct {
-	// OK will only ever be false if ValidateOnly has been requested.
 	OK bool `json:"ok"`
+
+	// Gateway is the name of the WG-API server the Peer was added to, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+
+	// AllocatedIPs are the allowed ips assigned to the Peer if
+	// AllocateAllowedIPs was requested.
+	AllocatedIPs []string `json:"allocated_ips,omitempty"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the Peer was added.
+	Generation uint64 `json:"generation,omitempty"`
 }
 
 type RemovePeerRequest struct {
 	PublicKey string `json:"public_key"`
 
-	// ValidateOnly ensures only validation is completed, no side effects
-	ValidateOnly bool `json:"validate_only"`
+	// Gateway, if given, is the name of the WG-API server the Peer is removed
+	// from when requested through a proxy. By default the Peer is removed
+	// from the server it belongs to.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// ValidateOnly is equivalent to DryRun.
+	//
+	// Deprecated: use DryRun.
+	ValidateOnly bool `json:"validate_only,omitempty"`
 }
 
 type RemovePeerResponse struct {
-	// OK will only ever be false if ValidateOnly has been requested.
 	OK bool `json:"ok"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the Peer was removed.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+type RemoveAllPeersRequest struct {
+	// Confirm must be the name of the device, such as wg0, guarding against
+	// every Peer being removed by mistake.
+	Confirm string `json:"confirm"`
+
+	// Gateway is the name of the WG-API server whose Peers are removed when
+	// requested through a proxy, required if there is more than one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type RemoveAllPeersResponse struct {
+	OK bool `json:"ok"`
+
+	// Removed is the number of Peers removed from the device.
+	Removed int `json:"removed"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after every Peer was removed.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+// Actions describing a change made to a Peer.
+const (
+	ChangeAdd    = "add"
+	ChangeUpdate = "update"
+	ChangeRemove = "remove"
+)
+
+// PeerChange describes a change made to a Peer of a device, including the
+// state of the Peer before and after the change.
+type PeerChange struct {
+	Action    string `json:"action"`
+	PublicKey string `json:"public_key"`
+	Before    *Peer  `json:"before,omitempty"`
+	After     *Peer  `json:"after,omitempty"`
 }
@@ -0,0 +1,65 @@
+package client
+
+type ClonePeerRequest struct {
+	// PublicKey is that of the Peer whose configuration is cloned.
+	PublicKey string `json:"public_key"`
+
+	// NewPublicKey is the public key of the clone, unless GenerateKey is
+	// set, generating the keys of the clone on the server such that the
+	// private key is returned.
+	NewPublicKey string `json:"new_public_key,omitempty"`
+	GenerateKey  bool   `json:"generate_key,omitempty"`
+
+	// AllowedIPs, if given, are the allowed ips of the clone in place of any
+	// of the Peer not assigned by IP address management, which would
+	// otherwise be taken from it.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+
+	// Metadata, if given, replaces the metadata of the Peer given to the
+	// clone.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server the Peer is cloned
+	// on when requested through a proxy. By default it is cloned on the
+	// server the Peer belongs to.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type ClonePeerResponse struct {
+	OK bool `json:"ok"`
+
+	// PublicKey is that of the clone, and PrivateKey its private key if it
+	// was generated.
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// PresharedKey is the new preshared key of the clone, given one if the
+	// Peer has a preshared key.
+	PresharedKey string `json:"preshared_key,omitempty"`
+
+	// AllocatedIPs are the allowed ips assigned to the clone by IP address
+	// management, in place of those assigned to the Peer.
+	AllocatedIPs []string `json:"allocated_ips,omitempty"`
+
+	// Gateway is the name of the WG-API server the Peer was cloned on, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the clone was added.
+	Generation uint64 `json:"generation,omitempty"`
+}
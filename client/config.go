@@ -0,0 +1,71 @@
+package client
+
+// Formats of the QR code of a generated configuration.
+const (
+	// QRFormatPNG is a PNG image, encoded as base64.
+	QRFormatPNG = "png"
+
+	// QRFormatASCII is text of half block characters, which is displayed in
+	// a terminal with a dark background.
+	QRFormatASCII = "ascii"
+)
+
+type GenerateClientConfigRequest struct {
+	// PrivateKey is that of the Peer the configuration is generated for. If
+	// not given, a new key pair is generated and returned, for the Peer to be
+	// added with its public key.
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// Address are the addresses of the interface of the Peer, by default the
+	// allowed ips of the Peer on the device, which are required if it is not.
+	Address []string `json:"address,omitempty"`
+
+	// Endpoint is the host[:port] the Peer connects to the device at, by
+	// default that configured on the server. The port defaults to the listen
+	// port of the device.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// DNS are the DNS servers, or search domains, of the Peer, by default
+	// those configured on the server.
+	DNS []string `json:"dns,omitempty"`
+
+	// AllowedIPs are the ranges the Peer routes through the device, by
+	// default every address (0.0.0.0/0 and ::/0).
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+
+	// PresharedKey and PersistentKeepAlive are those of the Peer, by default
+	// those of the Peer on the device, if any.
+	PresharedKey        string `json:"preshared_key,omitempty"`
+	PersistentKeepAlive string `json:"persistent_keep_alive,omitempty"`
+
+	// MTU of the interface of the Peer, if non-zero.
+	MTU int `json:"mtu,omitempty"`
+
+	// Format, if given, also returns the configuration as a QR code, either
+	// png or ascii, which the official apps can scan.
+	Format string `json:"format,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server whose device the
+	// configuration connects to when requested through a proxy. By default
+	// it is the server the Peer belongs to.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type GenerateClientConfigResponse struct {
+	// Config is the configuration of the Peer in the format of wg-quick,
+	// with an [Interface] section and a [Peer] section of the device.
+	Config string `json:"config"`
+
+	// PublicKey is that of the Peer, and PrivateKey its private key if it
+	// was generated by the server, which does not retain it.
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// QRCode is the configuration as a QR code in the Format of the request,
+	// if one was given.
+	QRCode string `json:"qr_code,omitempty"`
+
+	// Gateway is the name of the WG-API server whose device the
+	// configuration connects to, when requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
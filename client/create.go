@@ -0,0 +1,71 @@
+package client
+
+type CreateDeviceRequest struct {
+	// Name is the name of the network interface of the device, at most 15
+	// characters, which must not already exist.
+	Name string `json:"name"`
+
+	// Addresses are assigned to the interface of the device, each an address
+	// with the prefix length of its network, such as 10.6.0.1/24.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// ListenPort is the UDP port the device listens on, by default a random
+	// port.
+	ListenPort int `json:"listen_port,omitempty"`
+
+	// PrivateKey is that of the device, by default one generated on the
+	// server that is never returned.
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// MTU of the interface, if non-zero, otherwise that chosen by the
+	// kernel.
+	MTU int `json:"mtu,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server the device is
+	// created on when requested through a proxy, required if there is more
+	// than one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// DryRun validates the request without creating the device.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type CreateDeviceResponse struct {
+	OK bool `json:"ok"`
+
+	// DryRun is true if the device was not created, because either the
+	// request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Device is the device once created, or as it would be if DryRun.
+	Device *Device `json:"device"`
+
+	// Gateway is the name of the WG-API server the device was created on,
+	// when requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type DeleteDeviceRequest struct {
+	// Name is the name, or an alias, of the device to delete.
+	Name string `json:"name"`
+
+	// Gateway, if given, is the name of the WG-API server whose device is
+	// deleted when requested through a proxy, required if there is more
+	// than one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// DryRun validates the request without deleting the device.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type DeleteDeviceResponse struct {
+	OK bool `json:"ok"`
+
+	// DryRun is true if the device was not deleted, because either the
+	// request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Gateway is the name of the WG-API server whose device was deleted,
+	// when requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
@@ -0,0 +1,46 @@
+package client
+
+type SetDeviceConfigRequest struct {
+	// ListenPort, if given, is the UDP port the device listens on, or zero
+	// for a random port.
+	ListenPort *int `json:"listen_port,omitempty"`
+
+	// FirewallMark, if given, marks the packets sent by the device, or zero
+	// to stop marking them.
+	FirewallMark *int `json:"firewall_mark,omitempty"`
+
+	// PrivateKey, if given, replaces the private key of the device, unless
+	// GeneratePrivateKey is set, replacing it with one generated on the
+	// server that is never returned. Every Peer must then be given the new
+	// public key of the device.
+	PrivateKey         string `json:"private_key,omitempty"`
+	GeneratePrivateKey bool   `json:"generate_private_key,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server whose device is
+	// configured when requested through a proxy, required if there is more
+	// than one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun validates the request without configuring the device.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type SetDeviceConfigResponse struct {
+	OK bool `json:"ok"`
+
+	// DryRun is true if the device was not configured, because either the
+	// request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Device is the device once configured, or as it would be if DryRun,
+	// including its new public key if its private key was replaced.
+	Device *Device `json:"device"`
+
+	// Gateway is the name of the WG-API server whose device was configured,
+	// when requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
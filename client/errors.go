@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// Error codes returned by WG-API in addition to those defined by the
+// JSON-RPC 2.0 specification.
+const (
+	// ErrCodeDevice is returned when the WireGuard device could not be read
+	// from or configured.
+	ErrCodeDevice = -32000
+
+	// ErrCodeConflict is returned when a precondition of the request, such
+	// as the expected generation of the device, does not hold.
+	ErrCodeConflict = -32001
+
+	// ErrCodeStore is returned when information about Peers that is kept
+	// outside of the device, such as their metadata, could not be read or
+	// written.
+	ErrCodeStore = -32002
+
+	// ErrCodeIPAM is returned when allowed ips could not be allocated to a
+	// Peer by the IP address management plugin.
+	ErrCodeIPAM = -32003
+
+	// ErrCodePolicy is returned when a request is rejected by a policy rule
+	// configured on the server.
+	ErrCodePolicy = -32004
+
+	// ErrCodeNotLeader is returned when a change is requested of a standby
+	// server, the leader is given in the error data.
+	ErrCodeNotLeader = -32005
+
+	// ErrCodeGateway is returned by a proxy when a WG-API server behind it
+	// could not be reached, the server is given in the error data.
+	ErrCodeGateway = -32006
+
+	// ErrCodeUnauthorized is returned by the self-service API when the Peer
+	// making the request could not be identified.
+	ErrCodeUnauthorized = -32007
+
+	// ErrCodeLimit is returned when a request would exceed a limit configured
+	// on the server, such as the maximum number of Peers of the device.
+	ErrCodeLimit = -32008
+
+	// ErrCodePeerNotFound is returned when a request may only change a Peer
+	// already on the device, such as with UpdateOnly, and it is not.
+	ErrCodePeerNotFound = -32009
+
+	// ErrCodeTimeout is returned when a request did not complete before its
+	// timeout_ms, or the client disconnected. A mutating request may still
+	// have changed the device if it timed out while configuring it.
+	ErrCodeTimeout = -32010
+
+	// ErrCodeForbidden is returned when the credentials of a request do not
+	// permit its method, such as a read-only token calling AddPeer.
+	ErrCodeForbidden = -32011
+
+	// ErrCodeRateLimited is returned when a client has made too many
+	// requests, by its source ip or its token, and should retry later.
+	ErrCodeRateLimited = -32029
+)
+
+// ErrorCode returns the code of err if it is a JSON-RPC error, such as
+// ErrCodeConflict, and its ErrorData if it has any.
+func ErrorCode(err error) (int, *ErrorData, bool) {
+	var rpcErr *jsonrpc.Error
+	if !errors.As(err, &rpcErr) {
+		return 0, nil, false
+	}
+
+	data, _ := rpcErr.Data.(*ErrorData)
+
+	return rpcErr.Code, data, true
+}
+
+// ErrorData is attached to the Data field of every JSON-RPC error returned
+// by WG-API, allowing clients to programatically react to failures rather
+// than interpreting the human readable message.
+type ErrorData struct {
+	// Field is the name of the request parameter at fault, if any, as it
+	// appears in the JSON request.
+	Field string `json:"field,omitempty"`
+
+	// Value is the offending value given for Field.
+	Value string `json:"value,omitempty"`
+
+	// ConflictingPublicKey is the public key of an existing Peer that the
+	// request conflicts with.
+	ConflictingPublicKey string `json:"conflicting_public_key,omitempty"`
+
+	// CurrentGeneration is the generation of the device at the time of a
+	// Conflict error.
+	CurrentGeneration uint64 `json:"current_generation,omitempty"`
+
+	// Policy is the name of the policy rule that rejected the request.
+	Policy string `json:"policy,omitempty"`
+
+	// Leader is the identity of the server that changes must be made to,
+	// when the server is a standby.
+	Leader string `json:"leader,omitempty"`
+
+	// Gateway is the name of the WG-API server behind a proxy that the
+	// error originated from.
+	Gateway string `json:"gateway,omitempty"`
+
+	// Retryable is true if the same request may succeed if retried later
+	// without modification.
+	Retryable bool `json:"retryable"`
+}
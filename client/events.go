@@ -0,0 +1,122 @@
+package client
+
+import (
+	"time"
+)
+
+// Types of Event.
+const (
+	EventPeerAdded   = "peer.added"
+	EventPeerUpdated = "peer.updated"
+	EventPeerRemoved = "peer.removed"
+
+	// EventPeerConnected and EventPeerDisconnected are derived from the
+	// handshakes of a Peer, rather than changes to the device.
+	EventPeerConnected    = "peer.connected"
+	EventPeerDisconnected = "peer.disconnected"
+
+	// EventPeerHandshake and EventPeerEndpointChanged are published when a
+	// Peer is observed to have completed a handshake or roamed to another
+	// endpoint, if the event stream is enabled.
+	EventPeerHandshake       = "peer.handshake"
+	EventPeerEndpointChanged = "peer.endpoint_changed"
+
+	// EventPeerExpired is published when a Peer is removed from the device
+	// because it expired, in addition to peer.removed, describing the Peer
+	// and its metadata as it was before it was removed.
+	EventPeerExpired = "peer.expired"
+
+	// EventPeerUsage is published periodically for every Peer, describing
+	// the traffic of the Peer since the previous peer.usage Event.
+	EventPeerUsage = "peer.usage"
+
+	// EventAudit is published for every request that may change the device.
+	EventAudit = "audit"
+
+	// EventDeviceAdded and EventDeviceRemoved are published when any
+	// WireGuard device appears or disappears on the host, Device is then the
+	// name of that device.
+	EventDeviceAdded   = "device.added"
+	EventDeviceRemoved = "device.removed"
+
+	// EventAlertFiring and EventAlertResolved are published when the
+	// condition of an alert rule configured on the server begins and stops
+	// holding.
+	EventAlertFiring   = "alert.firing"
+	EventAlertResolved = "alert.resolved"
+)
+
+// Event describes something that has happened to a device, such as a Peer
+// being added.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+
+	// PublicKey of the Peer the Event relates to, if any.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Change made to the Peer, for peer.added, peer.updated and peer.removed
+	// Events.
+	Change *PeerChange `json:"change,omitempty"`
+
+	// Peer is the state of the Peer, for peer.connected, peer.disconnected,
+	// peer.handshake, peer.endpoint_changed, peer.expired and peer.usage
+	// Events.
+	Peer *Peer `json:"peer,omitempty"`
+
+	// Usage is the traffic of the Peer, for peer.usage Events.
+	Usage *Usage `json:"usage,omitempty"`
+
+	// Audit describes the request, for audit Events.
+	Audit *Audit `json:"audit,omitempty"`
+
+	// Alert describes the alert, for alert.firing and alert.resolved Events.
+	Alert *Alert `json:"alert,omitempty"`
+}
+
+// Usage is the traffic of a Peer over a period of time.
+type Usage struct {
+	Since         time.Time `json:"since"`
+	ReceiveBytes  int64     `json:"receive_bytes"`
+	TransmitBytes int64     `json:"transmit_bytes"`
+}
+
+// Audit describes a request made to WG-API. The parameters of the request
+// are not included, as they may contain preshared keys.
+type Audit struct {
+	Method     string `json:"method"`
+	RemoteAddr string `json:"remote_addr"`
+	Duration   string `json:"duration"`
+
+	// Error is the message of the error returned, if the request failed.
+	Error string `json:"error,omitempty"`
+}
+
+// States of an Alert.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is the state of an alert rule configured on the server, for a Peer or
+// range of addresses if the rule applies to many.
+type Alert struct {
+	Rule  string `json:"rule"`
+	Kind  string `json:"kind"`
+	State string `json:"state"`
+
+	// Subject is what the alert is about, such as the public key of a Peer
+	// or a range of addresses, if the rule applies to many.
+	Subject string `json:"subject,omitempty"`
+
+	Message string `json:"message"`
+
+	// Value is the value of the condition when the alert changed state,
+	// compared against Threshold.
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold,omitempty"`
+
+	// Since is when the condition began to hold.
+	Since time.Time `json:"since"`
+}
@@ -0,0 +1,673 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// HTTPClient is a Client that makes JSON-RPC requests to a WG-API server
+// over HTTP(S). It is safe for concurrent use once configured.
+type HTTPClient struct {
+	// URL of the WG-API server, such as http://localhost:8080.
+	URL string
+
+	// Token, if set, is given to the server in the Authorization header.
+	Token string
+
+	// PrivateKey, if set, is the WireGuard private key of an admin identity
+	// that signs each request in place of Token. DevicePublicKey, the public
+	// key of the device of the server, is then required.
+	PrivateKey      string
+	DevicePublicKey string
+
+	// HTTPClient is used to make requests, http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+}
+
+var _ Client = (*HTTPClient)(nil)
+
+// NewHTTPClient returns a Client that makes requests to the WG-API server at
+// url, optionally authenticating with token.
+func NewHTTPClient(url, token string) *HTTPClient {
+	return &HTTPClient{URL: url, Token: token}
+}
+
+// requestID numbers the requests of every HTTPClient uniquely, such that a
+// response can be correlated with its request.
+var requestID atomic.Uint64
+
+type request struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      uint64      `json:"id"`
+
+	TimeoutMS int64 `json:"timeout_ms,omitempty"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// responseError is a JSON-RPC error whose data is yet to be decoded.
+type responseError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// rpcError returns e as a *jsonrpc.Error, whose Data is an *ErrorData if it
+// is an object, as it always is from a WG-API server.
+func (e *responseError) rpcError() *jsonrpc.Error {
+	rpcErr := &jsonrpc.Error{Code: e.Code, Message: e.Message}
+
+	if len(e.Data) == 0 || string(e.Data) == "null" {
+		return rpcErr
+	}
+
+	data := new(ErrorData)
+	if err := json.Unmarshal(e.Data, data); err == nil {
+		rpcErr.Data = data
+	} else {
+		json.Unmarshal(e.Data, &rpcErr.Data)
+	}
+
+	return rpcErr
+}
+
+// call makes a JSON-RPC request to the server, decoding the result into res.
+// If the server returns a JSON-RPC error, it is returned as a *jsonrpc.Error
+// whose Data is an *ErrorData.
+func (c *HTTPClient) call(ctx context.Context, method string, params, res interface{}) error {
+	if timeout := getCallOptions(ctx).timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	rpcReq := &request{Version: "2.0", Method: method, Params: params, ID: requestID.Add(1)}
+
+	// the deadline of ctx is given to the server, such that it stops waiting
+	// on the device once the result would no longer be used.
+	if deadline, ok := ctx.Deadline(); ok {
+		rpcReq.TimeoutMS = max(time.Until(deadline).Milliseconds(), 1)
+	}
+
+	body, err := json.Marshal(rpcReq)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", jsonrpc.ContentType)
+
+	r, err := c.do(req, body)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	var rpcRes response
+	if err := json.NewDecoder(r.Body).Decode(&rpcRes); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	// the id of an error is null if the server could not read the request.
+	if id := string(rpcRes.ID); id != strconv.FormatUint(rpcReq.ID, 10) && (rpcRes.Error == nil || (id != "" && id != "null")) {
+		return fmt.Errorf("response id %s does not match request id %d", id, rpcReq.ID)
+	}
+
+	if rpcRes.Error != nil {
+		return rpcRes.Error.rpcError()
+	}
+
+	return json.Unmarshal(rpcRes.Result, res)
+}
+
+// do authenticates and makes an HTTP request to the server, whose body is
+// body, with the headers of the call options of its context, returning an
+// error if the response is not 200 OK.
+func (c *HTTPClient) do(req *http.Request, body []byte) (*http.Response, error) {
+	for key, values := range getCallOptions(req.Context()).header {
+		req.Header[key] = values
+	}
+
+	if c.PrivateKey != "" {
+		if err := SignRequest(req, body, c.PrivateKey, c.DevicePublicKey); err != nil {
+			return nil, fmt.Errorf("could not sign request: %w", err)
+		}
+	} else if c.Token != "" {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	r, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StatusCode != http.StatusOK {
+		defer r.Body.Close()
+
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 512))
+
+		// a request the server could not read is answered with a JSON-RPC
+		// error, rather than a result.
+		var rpcRes response
+		if strings.HasPrefix(r.Header.Get("Content-Type"), jsonrpc.ContentType) && json.Unmarshal(msg, &rpcRes) == nil && rpcRes.Error != nil {
+			return nil, rpcRes.Error.rpcError()
+		}
+
+		return nil, fmt.Errorf("unexpected http status %d: %s", r.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return r, nil
+}
+
+// GetDeviceInfo returns information such as the public key and type of
+// interface for the currently configured device.
+func (c *HTTPClient) GetDeviceInfo(ctx context.Context, req *GetDeviceInfoRequest) (*GetDeviceInfoResponse, error) {
+	res := new(GetDeviceInfoResponse)
+	if err := c.call(ctx, "GetDeviceInfo", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ListPeers retrieves information about all Peers known to the current
+// WireGuard interface, including allowed IP addresses and usage stats,
+// optionally with pagination.
+func (c *HTTPClient) ListPeers(ctx context.Context, req *ListPeersRequest) (*ListPeersResponse, error) {
+	res := new(ListPeersResponse)
+	if err := c.call(ctx, "ListPeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// StreamErrorTrailer is the HTTP trailer of a streamed response that holds
+// the error that ended it early, if any.
+const StreamErrorTrailer = "Wg-Api-Error"
+
+// GetSchema retrieves the Schema of every method of the server from
+// /schema.
+func (c *HTTPClient) GetSchema(ctx context.Context) (*Schema, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/schema", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	schema := new(Schema)
+	if err := json.NewDecoder(r.Body).Decode(schema); err != nil {
+		return nil, fmt.Errorf("could not decode schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+// GetPublicStats retrieves the aggregate statistics of the Peers of the
+// device published on /stats, which requires no authentication.
+func (c *HTTPClient) GetPublicStats(ctx context.Context) (*PublicStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	stats := new(PublicStats)
+	if err := json.NewDecoder(r.Body).Decode(stats); err != nil {
+		return nil, fmt.Errorf("could not decode stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// StreamPeers retrieves every Peer of the device as JSON Lines from the
+// /peers endpoint of the server, calling fn with each as it is received
+// rather than decoding them all into memory at once. If fn returns an error,
+// streaming stops and it is returned.
+func (c *HTTPClient) StreamPeers(ctx context.Context, fn func(*Peer) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/peers", nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	r, err := c.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+
+	for {
+		peer := new(Peer)
+		if err := dec.Decode(peer); err == io.EOF {
+			// the server cannot change the status of a response once it
+			// has begun, so an error part way through is given in a
+			// trailer.
+			if msg := r.Trailer.Get(StreamErrorTrailer); msg != "" {
+				return fmt.Errorf("stream interrupted: %s", msg)
+			}
+
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not decode peer: %w", err)
+		}
+
+		if err := fn(peer); err != nil {
+			return err
+		}
+	}
+}
+
+// ListPeerKeys retrieves only the public keys of the Peers of the device, in
+// order and optionally a page at a time.
+func (c *HTTPClient) ListPeerKeys(ctx context.Context, req *ListPeerKeysRequest) (*ListPeerKeysResponse, error) {
+	res := new(ListPeerKeysResponse)
+	if err := c.call(ctx, "ListPeerKeys", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetPeer retrieves a specific Peer by their public key.
+func (c *HTTPClient) GetPeer(ctx context.Context, req *GetPeerRequest) (*GetPeerResponse, error) {
+	res := new(GetPeerResponse)
+	if err := c.call(ctx, "GetPeer", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetPeerCount returns the number of Peers of the device, and how many are
+// online, without listing them.
+func (c *HTTPClient) GetPeerCount(ctx context.Context, req *GetPeerCountRequest) (*GetPeerCountResponse, error) {
+	res := new(GetPeerCountResponse)
+	if err := c.call(ctx, "GetPeerCount", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AddPeer inserts a new Peer into the WireGuard interfaces table, multiple
+// calls to AddPeer can be used to update details of the Peer.
+func (c *HTTPClient) AddPeer(ctx context.Context, req *AddPeerRequest) (*AddPeerResponse, error) {
+	res := new(AddPeerResponse)
+	if err := c.call(ctx, "AddPeer", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RemovePeer deletes a Peer from the WireGuard interfaces table by their
+// public key,
+func (c *HTTPClient) RemovePeer(ctx context.Context, req *RemovePeerRequest) (*RemovePeerResponse, error) {
+	res := new(RemovePeerResponse)
+	if err := c.call(ctx, "RemovePeer", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RemoveAllPeers deletes every Peer from the WireGuard interfaces table at
+// once. The name of the device must be given to confirm.
+func (c *HTTPClient) RemoveAllPeers(ctx context.Context, req *RemoveAllPeersRequest) (*RemoveAllPeersResponse, error) {
+	res := new(RemoveAllPeersResponse)
+	if err := c.call(ctx, "RemoveAllPeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AddPeers inserts or updates many Peers at once, with the behaviour on
+// failure of any one Peer controlled by OnError.
+func (c *HTTPClient) AddPeers(ctx context.Context, req *AddPeersRequest) (*AddPeersResponse, error) {
+	res := new(AddPeersResponse)
+	if err := c.call(ctx, "AddPeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ApplyBatch applies a sequence of Peer additions and removals to the
+// device, with the behaviour on failure of any one operation controlled by
+// OnError.
+func (c *HTTPClient) ApplyBatch(ctx context.Context, req *ApplyBatchRequest) (*ApplyBatchResponse, error) {
+	res := new(ApplyBatchResponse)
+	if err := c.call(ctx, "ApplyBatch", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ImportPeers adds Peers described in CSV or JSON Lines format, such as
+// those exported from a spreadsheet or another VPN manager.
+func (c *HTTPClient) ImportPeers(ctx context.Context, req *ImportPeersRequest) (*ImportPeersResponse, error) {
+	res := new(ImportPeersResponse)
+	if err := c.call(ctx, "ImportPeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ExportPeers returns every Peer of the device, including their metadata and
+// usage stats, in CSV or JSON Lines format.
+func (c *HTTPClient) ExportPeers(ctx context.Context, req *ExportPeersRequest) (*ExportPeersResponse, error) {
+	res := new(ExportPeersResponse)
+	if err := c.call(ctx, "ExportPeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetServerInfo returns the version and build of the server, the
+// implementation of WireGuard of its device and its enabled features.
+func (c *HTTPClient) GetServerInfo(ctx context.Context, req *GetServerInfoRequest) (*GetServerInfoResponse, error) {
+	res := new(GetServerInfoResponse)
+	if err := c.call(ctx, "GetServerInfo", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetCapabilities returns the methods supported by the server, its enabled
+// features, limits and version of the API.
+func (c *HTTPClient) GetCapabilities(ctx context.Context, req *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error) {
+	res := new(GetCapabilitiesResponse)
+	if err := c.call(ctx, "GetCapabilities", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Ping returns the time of the server, such that clients may cheaply verify
+// they can reach and authenticate with it.
+func (c *HTTPClient) Ping(ctx context.Context, req *PingRequest) (*PingResponse, error) {
+	res := new(PingResponse)
+	if err := c.call(ctx, "Ping", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetServerStats returns runtime statistics of the server, such as its uptime
+// and the requests made of each method.
+func (c *HTTPClient) GetServerStats(ctx context.Context, req *GetServerStatsRequest) (*GetServerStatsResponse, error) {
+	res := new(GetServerStatsResponse)
+	if err := c.call(ctx, "GetServerStats", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// WatchDevices returns every WireGuard device on the host, waiting until they
+// change if the generation of the devices given is current.
+func (c *HTTPClient) WatchDevices(ctx context.Context, req *WatchDevicesRequest) (*WatchDevicesResponse, error) {
+	res := new(WatchDevicesResponse)
+	if err := c.call(ctx, "WatchDevices", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// TopTalkers returns the Peers that have transferred the most over a recent
+// window, by rate or by bytes.
+func (c *HTTPClient) TopTalkers(ctx context.Context, req *TopTalkersRequest) (*TopTalkersResponse, error) {
+	res := new(TopTalkersResponse)
+	if err := c.call(ctx, "TopTalkers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ProbePeerEndpoint probes the endpoint of a Peer by ICMP and UDP from the
+// server, to distinguish a Peer that is offline from one whose endpoint
+// cannot be reached.
+func (c *HTTPClient) ProbePeerEndpoint(ctx context.Context, req *ProbePeerEndpointRequest) (*ProbePeerEndpointResponse, error) {
+	res := new(ProbePeerEndpointResponse)
+	if err := c.call(ctx, "ProbePeerEndpoint", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// DiagnoseMTU probes the path MTU toward the endpoint of a Peer from the
+// server, recommending the MTU of the device for it.
+func (c *HTTPClient) DiagnoseMTU(ctx context.Context, req *DiagnoseMTURequest) (*DiagnoseMTUResponse, error) {
+	res := new(DiagnoseMTUResponse)
+	if err := c.call(ctx, "DiagnoseMTU", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// MovePeers moves Peers from the device to another WireGuard device on the
+// same host, keeping their configuration.
+func (c *HTTPClient) MovePeers(ctx context.Context, req *MovePeersRequest) (*MovePeersResponse, error) {
+	res := new(MovePeersResponse)
+	if err := c.call(ctx, "MovePeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RenameDevice renames a WireGuard device on the host, other than that of the
+// server.
+func (c *HTTPClient) RenameDevice(ctx context.Context, req *RenameDeviceRequest) (*RenameDeviceResponse, error) {
+	res := new(RenameDeviceResponse)
+	if err := c.call(ctx, "RenameDevice", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ClonePeer adds a Peer with the configuration of an existing Peer under a
+// new public key.
+func (c *HTTPClient) ClonePeer(ctx context.Context, req *ClonePeerRequest) (*ClonePeerResponse, error) {
+	res := new(ClonePeerResponse)
+	if err := c.call(ctx, "ClonePeer", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// UpdatePeer changes the configuration of a Peer already on the device,
+// failing if it is not.
+func (c *HTTPClient) UpdatePeer(ctx context.Context, req *UpdatePeerRequest) (*UpdatePeerResponse, error) {
+	res := new(UpdatePeerResponse)
+	if err := c.call(ctx, "UpdatePeer", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RemovePeers deletes many Peers at once, with the behaviour on failure of
+// any one Peer controlled by OnError.
+func (c *HTTPClient) RemovePeers(ctx context.Context, req *RemovePeersRequest) (*RemovePeersResponse, error) {
+	res := new(RemovePeersResponse)
+	if err := c.call(ctx, "RemovePeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ListDevices returns every device served, such as by a server managing many
+// devices.
+func (c *HTTPClient) ListDevices(ctx context.Context, req *ListDevicesRequest) (*ListDevicesResponse, error) {
+	res := new(ListDevicesResponse)
+	if err := c.call(ctx, "ListDevices", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SetDeviceConfig changes the listen port, firewall mark or private key of
+// the device.
+func (c *HTTPClient) SetDeviceConfig(ctx context.Context, req *SetDeviceConfigRequest) (*SetDeviceConfigResponse, error) {
+	res := new(SetDeviceConfigResponse)
+	if err := c.call(ctx, "SetDeviceConfig", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GenerateKeyPair returns a new private key and its public key.
+func (c *HTTPClient) GenerateKeyPair(ctx context.Context, req *GenerateKeyPairRequest) (*GenerateKeyPairResponse, error) {
+	res := new(GenerateKeyPairResponse)
+	if err := c.call(ctx, "GenerateKeyPair", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GeneratePresharedKey returns a new preshared key.
+func (c *HTTPClient) GeneratePresharedKey(ctx context.Context, req *GeneratePresharedKeyRequest) (*GeneratePresharedKeyResponse, error) {
+	res := new(GeneratePresharedKeyResponse)
+	if err := c.call(ctx, "GeneratePresharedKey", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SetPeerMetadata replaces or merges the metadata of a Peer already on the
+// device, without changing its configuration.
+func (c *HTTPClient) SetPeerMetadata(ctx context.Context, req *SetPeerMetadataRequest) (*SetPeerMetadataResponse, error) {
+	res := new(SetPeerMetadataResponse)
+	if err := c.call(ctx, "SetPeerMetadata", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GenerateClientConfig returns the wg-quick configuration of a Peer
+// connecting to the device.
+func (c *HTTPClient) GenerateClientConfig(ctx context.Context, req *GenerateClientConfigRequest) (*GenerateClientConfigResponse, error) {
+	res := new(GenerateClientConfigResponse)
+	if err := c.call(ctx, "GenerateClientConfig", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// CreateDevice creates a WireGuard device on the host, assigns its addresses
+// and brings it up.
+func (c *HTTPClient) CreateDevice(ctx context.Context, req *CreateDeviceRequest) (*CreateDeviceResponse, error) {
+	res := new(CreateDeviceResponse)
+	if err := c.call(ctx, "CreateDevice", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// DeleteDevice deletes a WireGuard device on the host, other than that of the
+// server.
+func (c *HTTPClient) DeleteDevice(ctx context.Context, req *DeleteDeviceRequest) (*DeleteDeviceResponse, error) {
+	res := new(DeleteDeviceResponse)
+	if err := c.call(ctx, "DeleteDevice", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SyncPeers changes the Peers of the device to exactly those given, applying
+// only the changes required.
+func (c *HTTPClient) SyncPeers(ctx context.Context, req *SyncPeersRequest) (*SyncPeersResponse, error) {
+	res := new(SyncPeersResponse)
+	if err := c.call(ctx, "SyncPeers", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+var _ SelfService = (*HTTPClient)(nil)
+
+// GetChallenge returns a challenge that must be proven within a short time
+// to authenticate GetSelf by possession of a private key. The URL of the
+// HTTPClient must be the self-service endpoint of the server.
+func (c *HTTPClient) GetChallenge(ctx context.Context, req *GetChallengeRequest) (*GetChallengeResponse, error) {
+	res := new(GetChallengeResponse)
+	if err := c.call(ctx, "GetChallenge", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetSelf returns the Peer making the request, along with its usage. The URL
+// of the HTTPClient must be the self-service endpoint of the server.
+func (c *HTTPClient) GetSelf(ctx context.Context, req *GetSelfRequest) (*GetSelfResponse, error) {
+	res := new(GetSelfResponse)
+	if err := c.call(ctx, "GetSelf", req, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,82 @@
+package client
+
+// Formats of files describing Peers, used to import and export Peers.
+const (
+	// FormatCSV is comma separated values with a header row. The columns
+	// public_key, preshared_key, endpoint, persistent_keep_alive,
+	// allowed_ips and template are recognised, multiple allowed ips may be separated by
+	// commas, semicolons or spaces. Columns written only by ExportPeers are
+	// ignored, any other column is metadata.
+	FormatCSV = "csv"
+
+	// FormatJSONL is JSON Lines, where each line is a Peer in the same
+	// format as AddPeerRequest.
+	FormatJSONL = "jsonl"
+)
+
+type ImportPeersRequest struct {
+	// Format of Data, either csv or jsonl.
+	Format string `json:"format"`
+	Data   string `json:"data"`
+
+	// OnError is one of abort (default), continue or rollback.
+	OnError string `json:"on_error,omitempty"`
+
+	// Gateway is the name of the WG-API server the Peers are imported into
+	// when requested through a proxy, required if the proxy has more than
+	// one server.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun validates every Peer and returns the outcome of importing them
+	// without making any changes.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type ImportPeersResponse struct {
+	// OK is true if every Peer was imported.
+	OK bool `json:"ok"`
+
+	// Total is the number of Peers read from Data, of which Imported were
+	// added to the device and Failed were not.
+	Total    int `json:"total"`
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+
+	// RolledBack is true if a Peer failed and the device was restored to its
+	// state before the import.
+	RolledBack bool `json:"rolled_back,omitempty"`
+
+	// Results of each Peer, including the line it was read from.
+	Results []*BatchResult `json:"results"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Generation of the device after the Peers were imported.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+type ExportPeersRequest struct {
+	// Format of Data, either csv or jsonl.
+	Format string `json:"format"`
+
+	// Gateway is the name of the WG-API server the Peers are exported from
+	// when requested through a proxy, required if the proxy has more than
+	// one server.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type ExportPeersResponse struct {
+	// Format of Data, either csv or jsonl.
+	Format string `json:"format"`
+
+	// Data contains every Peer of the device including their metadata and
+	// usage stats. In CSV, the metadata of each Peer is written as additional
+	// columns.
+	Data string `json:"data"`
+}
@@ -0,0 +1,196 @@
+package client
+
+import "time"
+
+// SchemaVersion is the version of the API, incremented whenever methods or
+// fields are changed incompatibly.
+const SchemaVersion = 1
+
+// Optional features of a WG-API server, listed by GetServerInfo when they
+// are enabled.
+const (
+	FeatureDryRun    = "dry_run"
+	FeatureTemplates = "templates"
+	FeaturePolicy    = "policy"
+	FeatureIPAM      = "ipam"
+	FeatureEvents    = "events"
+	FeatureUsage     = "usage"
+	FeatureHA        = "ha"
+	FeatureProxy     = "proxy"
+	FeatureRates     = "rates"
+)
+
+// ServerInfo describes the build and configuration of a WG-API server, such
+// that fleets can audit what is deployed.
+type ServerInfo struct {
+	Version string `json:"version"`
+
+	// Commit and BuildDate are those of the source WG-API was built from,
+	// if known.
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+
+	GoVersion string `json:"go_version"`
+
+	// Backend is the implementation of WireGuard of the device, such as
+	// Linux kernel or userspace.
+	Backend string `json:"backend,omitempty"`
+
+	// Features are the optional features enabled on the server.
+	Features []string `json:"features,omitempty"`
+
+	// Gateway is the name of the WG-API server, when requested through a
+	// proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type GetServerInfoRequest struct{}
+
+type GetServerInfoResponse struct {
+	Server *ServerInfo `json:"server"`
+
+	// Gateways are every WG-API server behind a proxy, Server then describes
+	// the proxy itself.
+	Gateways []*ServerInfo `json:"gateways,omitempty"`
+}
+
+// Limits are the limits configured on a WG-API server, zero if unlimited.
+type Limits struct {
+	// MaxPeers is the number of Peers the device may have.
+	MaxPeers int `json:"max_peers,omitempty"`
+
+	// MaxBatchSize is the number of Peers or operations that may be given to
+	// AddPeers, ApplyBatch or ImportPeers at once.
+	MaxBatchSize int `json:"max_batch_size,omitempty"`
+}
+
+// Capabilities describe what a WG-API server supports, such that clients of
+// servers of different versions can detect features rather than fail.
+type Capabilities struct {
+	SchemaVersion int `json:"schema_version"`
+
+	// Methods are the names of the methods supported.
+	Methods []string `json:"methods"`
+
+	// Features are the optional features enabled on the server.
+	Features []string `json:"features,omitempty"`
+
+	Limits *Limits `json:"limits"`
+
+	// Gateway is the name of the WG-API server, when requested through a
+	// proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type GetCapabilitiesRequest struct{}
+
+type GetCapabilitiesResponse struct {
+	// Capabilities of the server, or those common to every WG-API server
+	// behind a proxy.
+	Capabilities *Capabilities `json:"capabilities"`
+
+	// Gateways are the capabilities of every WG-API server behind a proxy.
+	Gateways []*Capabilities `json:"gateways,omitempty"`
+}
+
+type PingRequest struct{}
+
+type PingResponse struct {
+	// Time of the server when the Ping was answered, such that clients may
+	// estimate the skew of their clock.
+	Time time.Time `json:"time"`
+
+	// Counter is incremented on every Ping since the server started.
+	Counter uint64 `json:"counter"`
+}
+
+// ServerStats are runtime statistics of a WG-API server.
+type ServerStats struct {
+	StartedAt time.Time `json:"started_at"`
+	Uptime    string    `json:"uptime"`
+
+	// Methods counts the requests of each method since the server started.
+	Methods map[string]*MethodStats `json:"methods,omitempty"`
+
+	Goroutines int          `json:"goroutines"`
+	Memory     *MemoryStats `json:"memory"`
+
+	// EventQueueDepth is the number of Events waiting to be published, of at
+	// most EventQueueSize. DroppedEvents were not published as the queue was
+	// full.
+	EventQueueDepth int    `json:"event_queue_depth"`
+	EventQueueSize  int    `json:"event_queue_size"`
+	DroppedEvents   uint64 `json:"dropped_events"`
+
+	// Gateway is the name of the WG-API server, when requested through a
+	// proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// MethodStats counts the requests of a method.
+type MethodStats struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+
+	// DurationSeconds is the total time taken by every request, and
+	// DurationBuckets count the requests taking at most each duration, in
+	// order.
+	DurationSeconds float64           `json:"duration_seconds"`
+	DurationBuckets []*DurationBucket `json:"duration_buckets,omitempty"`
+}
+
+// DurationBucket counts the requests taking at most LE seconds, as a bucket
+// of a Prometheus histogram.
+type DurationBucket struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// MemoryStats describe the memory used by a WG-API server.
+type MemoryStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+}
+
+type GetServerStatsRequest struct{}
+
+type GetServerStatsResponse struct {
+	Stats *ServerStats `json:"stats"`
+
+	// Gateways are the statistics of every WG-API server behind a proxy,
+	// Stats then describes the proxy itself.
+	Gateways []*ServerStats `json:"gateways,omitempty"`
+}
+
+// PublicStats are aggregate statistics of the Peers of a device which
+// identify no Peer, such that they may be published on a status page.
+type PublicStats struct {
+	Peers int `json:"peers"`
+
+	// ConnectedPeers have completed a handshake within the last 3 minutes.
+	ConnectedPeers int `json:"connected_peers"`
+
+	// ReceiveBytes and TransmitBytes are the total transferred with every
+	// Peer of the device.
+	ReceiveBytes  int64 `json:"receive_bytes"`
+	TransmitBytes int64 `json:"transmit_bytes"`
+
+	// ReceiveRate and TransmitRate are the total bytes per second
+	// transferred with every Peer, if the server samples transfer.
+	ReceiveRate  float64 `json:"receive_rate,omitempty"`
+	TransmitRate float64 `json:"transmit_rate,omitempty"`
+
+	// HandshakeAge are percentiles of the seconds since the last handshake
+	// of every Peer that has completed one.
+	HandshakeAge *Percentiles `json:"handshake_age_seconds,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// Percentiles summarize a distribution.
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
@@ -0,0 +1,18 @@
+package client
+
+type GenerateKeyPairRequest struct{}
+
+type GenerateKeyPairResponse struct {
+	// PrivateKey is a new Curve25519 private key, and PublicKey its public
+	// key. The private key is not retained by the server.
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+type GeneratePresharedKeyRequest struct{}
+
+type GeneratePresharedKeyResponse struct {
+	// PresharedKey is a new random preshared key, not retained by the
+	// server.
+	PresharedKey string `json:"preshared_key"`
+}
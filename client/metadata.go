@@ -0,0 +1,42 @@
+package client
+
+type SetPeerMetadataRequest struct {
+	// PublicKey is that of the Peer whose metadata is set, which must
+	// already be on the device.
+	PublicKey string `json:"public_key"`
+
+	// Metadata replaces any existing metadata of the Peer, an empty Metadata
+	// removing it, unless Merge is set.
+	Metadata map[string]string `json:"metadata"`
+
+	// Merge sets only the keys of Metadata, removing those whose value is
+	// empty, and keeps any other metadata of the Peer.
+	Merge bool `json:"merge,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server the metadata is
+	// set on when requested through a proxy. By default it is set on the
+	// server the Peer belongs to.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the metadata the Peer would have without setting it.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type SetPeerMetadataResponse struct {
+	OK bool `json:"ok"`
+
+	// Metadata is that of the Peer once set.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// DryRun is true if the metadata was not set, because either the
+	// request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Gateway is the name of the WG-API server the metadata was set on, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
@@ -0,0 +1,49 @@
+package client
+
+type MovePeersRequest struct {
+	PublicKeys []string `json:"public_keys"`
+
+	// ToDevice is the name of the WireGuard device, on the same host, the
+	// Peers are moved to. It must not already have any of the Peers.
+	ToDevice string `json:"to_device"`
+
+	// RegeneratePresharedKeys gives each Peer that has a preshared key a new
+	// one on ToDevice, returned in PresharedKeys, rather than keeping its
+	// existing preshared key.
+	RegeneratePresharedKeys bool `json:"regenerate_preshared_keys,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server the Peers are
+	// moved by when requested through a proxy, required if there is more
+	// than one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type MovePeersResponse struct {
+	OK bool `json:"ok"`
+
+	// DryRun is true if no changes were made to either device, because
+	// either the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Peers are the Peers moved, as they were on the device, including
+	// their metadata which is no longer kept once they are moved.
+	Peers []*Peer `json:"peers"`
+
+	// PresharedKeys are the new preshared keys of the Peers moved, by
+	// public key, if RegeneratePresharedKeys was requested.
+	PresharedKeys map[string]string `json:"preshared_keys,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the Peers were moved.
+	Generation uint64 `json:"generation,omitempty"`
+}
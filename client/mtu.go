@@ -0,0 +1,43 @@
+package client
+
+type DiagnoseMTURequest struct {
+	PublicKey string `json:"public_key"`
+
+	// Gateway, if given, is the name of the WG-API server the path is
+	// diagnosed from when requested through a proxy. By default it is
+	// diagnosed from the server the Peer belongs to.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// MTUDiagnosis is the path MTU toward the endpoint of a Peer, and the MTU of
+// the device recommended for it.
+type MTUDiagnosis struct {
+	Endpoint string `json:"endpoint"`
+
+	// PathMTU is the size in bytes of the largest packet that reached the
+	// host of the endpoint unfragmented, at most 1500.
+	PathMTU int `json:"path_mtu,omitempty"`
+
+	// InterfaceMTU is the MTU of the device.
+	InterfaceMTU int `json:"interface_mtu,omitempty"`
+
+	// RecommendedMTU is the largest MTU of the device whose packets still
+	// fit within PathMTU once encapsulated by WireGuard.
+	RecommendedMTU int `json:"recommended_mtu,omitempty"`
+
+	// Blackhole is whether the MTU of the device exceeds RecommendedMTU,
+	// such that its largest packets are silently dropped or fragmented.
+	Blackhole bool `json:"blackhole"`
+
+	// Error describes why the path MTU could not be diagnosed, such as the
+	// host of the endpoint not answering ICMP echo requests.
+	Error string `json:"error,omitempty"`
+}
+
+type DiagnoseMTUResponse struct {
+	Diagnosis *MTUDiagnosis `json:"diagnosis"`
+
+	// Gateway is the name of the WG-API server the path was diagnosed from,
+	// when requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
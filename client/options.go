@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DeviceHeader is the HTTP header naming the device a request is intended
+// for, which the server refuses if it does not manage that device.
+const DeviceHeader = "Wg-Api-Device"
+
+// CallOption configures the calls made by a HTTPClient with a context given
+// to WithCallOptions, such that a single HTTPClient may be shared by callers
+// needing different headers, timeouts or devices.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	header  http.Header
+	timeout time.Duration
+}
+
+// WithCallOptions returns a copy of ctx whose calls by a HTTPClient are
+// configured by opts, in addition to any options of ctx.
+func WithCallOptions(ctx context.Context, opts ...CallOption) context.Context {
+	o := &callOptions{header: make(http.Header)}
+
+	if parent, ok := ctx.Value(callOptionsKey{}).(*callOptions); ok {
+		o.header = parent.header.Clone()
+		o.timeout = parent.timeout
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return context.WithValue(ctx, callOptionsKey{}, o)
+}
+
+type callOptionsKey struct{}
+
+// getCallOptions returns the options of calls made with ctx.
+func getCallOptions(ctx context.Context) *callOptions {
+	if o, ok := ctx.Value(callOptionsKey{}).(*callOptions); ok {
+		return o
+	}
+
+	return &callOptions{}
+}
+
+// Header sets the HTTP header key of calls to value, such as a request ID
+// correlating them with the logs of a workflow.
+func Header(key, value string) CallOption {
+	return func(o *callOptions) {
+		o.header.Set(key, value)
+	}
+}
+
+// IdempotencyKey sets the Idempotency-Key header of calls to key, such that
+// a proxy or load balancer that deduplicates retried requests recognises a
+// retried call.
+func IdempotencyKey(key string) CallOption {
+	return Header("Idempotency-Key", key)
+}
+
+// Timeout is how long each call may take, after which it is cancelled and
+// the server stops waiting on the device.
+func Timeout(d time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = d
+	}
+}
+
+// ForDevice names the device calls are intended for, which the server
+// refuses if it does not manage that device.
+func ForDevice(name string) CallOption {
+	return Header(DeviceHeader, name)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"iter"
+)
+
+// DefaultPageSize is the number of Peers Peers lists at a time, unless
+// requested otherwise.
+const DefaultPageSize = 100
+
+// Peers iterates over the Peers of the device of c, listing them a page at a
+// time such that every Peer is never held in memory at once. req.Limit, if
+// given, is the size of each page, and req.After the public key Peers are
+// listed after. Iteration stops at the first error, yielded with a nil Peer.
+//
+// Peers are listed in order of their public key, such that pages stay
+// consistent while Peers are added and removed during iteration.
+func Peers(ctx context.Context, c Client, req *ListPeersRequest) iter.Seq2[*Peer, error] {
+	return func(yield func(*Peer, error) bool) {
+		var page ListPeersRequest
+		if req != nil {
+			page = *req
+		}
+
+		if page.Limit == 0 {
+			page.Limit = DefaultPageSize
+		}
+
+		for {
+			res, err := c.ListPeers(ctx, &page)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, peer := range res.Peers {
+				if !yield(peer, nil) {
+					return
+				}
+			}
+
+			if res.Next == "" {
+				return
+			}
+
+			page.After, page.Offset = res.Next, 0
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+// DefaultProbeCount is how many ICMP echo requests ProbePeerEndpoint sends,
+// unless requested otherwise, and MaxProbeCount is the most it may send.
+const (
+	DefaultProbeCount = 3
+	MaxProbeCount     = 10
+)
+
+type ProbePeerEndpointRequest struct {
+	PublicKey string `json:"public_key"`
+
+	// Gateway, if given, is the name of the WG-API server the Peer is probed
+	// from when requested through a proxy. By default it is probed from the
+	// server it belongs to.
+	Gateway string `json:"gateway,omitempty"`
+
+	// Count is the number of ICMP echo requests sent to the host of the
+	// endpoint, by default DefaultProbeCount.
+	Count int `json:"count,omitempty"`
+}
+
+// EndpointProbe is the reachability of the endpoint of a Peer from the
+// server, distinguishing a Peer that is offline from one whose endpoint
+// cannot be reached.
+type EndpointProbe struct {
+	Endpoint string `json:"endpoint"`
+
+	// Connected is whether the Peer has completed a handshake within the
+	// last 3 minutes.
+	Connected bool `json:"connected"`
+
+	// Reachable is whether the host of the endpoint answered either probe.
+	Reachable bool `json:"reachable"`
+
+	// Sent and Received are the ICMP echo requests sent to the host of the
+	// endpoint, and the replies received.
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+
+	// RTTMS is the average round trip time of the replies in milliseconds.
+	RTTMS float64 `json:"rtt_ms,omitempty"`
+
+	// PortUnreachable is whether the host of the endpoint reported nothing
+	// listening on its port, such as WireGuard not running.
+	PortUnreachable bool `json:"port_unreachable"`
+
+	// Error describes why the host of the endpoint could not be sent ICMP
+	// echo requests, such as the server lacking permission, if it could not.
+	Error string `json:"error,omitempty"`
+}
+
+// PeerLatency is the round trip time of a Peer through the tunnel, from ICMP
+// echo requests sent to its address over a recent window.
+type PeerLatency struct {
+	// Address is the allowed ip of the Peer echo requests are sent to.
+	Address string `json:"address"`
+
+	// Sent is the echo requests sent over the window, and Loss is the
+	// fraction of them that were not replied to.
+	Sent int     `json:"sent"`
+	Loss float64 `json:"loss"`
+
+	// RTTMS is the average round trip time of the replies in milliseconds.
+	RTTMS float64 `json:"rtt_ms,omitempty"`
+}
+
+type ProbePeerEndpointResponse struct {
+	Probe *EndpointProbe `json:"probe"`
+
+	// Gateway is the name of the WG-API server the Peer was probed from,
+	// when requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+}
@@ -0,0 +1,32 @@
+package client
+
+type RenameDeviceRequest struct {
+	// Name is the name, or an alias, of the device to rename.
+	Name string `json:"name"`
+
+	// NewName is the name of the network interface of the device once
+	// renamed, at most 15 characters.
+	NewName string `json:"new_name"`
+
+	// Gateway, if given, is the name of the WG-API server whose device is
+	// renamed when requested through a proxy, required if there is more
+	// than one.
+	Gateway string `json:"gateway,omitempty"`
+
+	// DryRun validates the request without renaming the device.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type RenameDeviceResponse struct {
+	OK bool `json:"ok"`
+
+	// DryRun is true if the device was not renamed, because either the
+	// request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Name is the new name of the device.
+	Name string `json:"name"`
+
+	// Aliases are the aliases of the device, which it is still addressed by.
+	Aliases []string `json:"aliases,omitempty"`
+}
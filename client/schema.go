@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of SchemaType.
+const (
+	KindString  = "string"
+	KindInteger = "integer"
+	KindNumber  = "number"
+	KindBoolean = "boolean"
+
+	// KindTime is a string of an RFC 3339 time.
+	KindTime = "time"
+
+	// KindObject is the SchemaObject named by Ref.
+	KindObject = "object"
+
+	// KindArray is a list of Elem.
+	KindArray = "array"
+
+	// KindMap is an object of string keys to Elem.
+	KindMap = "map"
+
+	// KindAny is any JSON value.
+	KindAny = "any"
+)
+
+// Schema describes every method of the API and the types of their params
+// and results, such that clients in other languages may be generated from it.
+type Schema struct {
+	// Version is the SchemaVersion of the API.
+	Version int `json:"version"`
+
+	Methods []*SchemaMethod `json:"methods"`
+
+	// Types are every object used by the methods, sorted by name.
+	Types []*SchemaObject `json:"types"`
+}
+
+// SchemaMethod is a method of the API, named by the SchemaObjects of its
+// params and result.
+type SchemaMethod struct {
+	Name   string `json:"name"`
+	Params string `json:"params"`
+	Result string `json:"result"`
+}
+
+// SchemaObject is a JSON object with named fields.
+type SchemaObject struct {
+	Name   string         `json:"name"`
+	Fields []*SchemaField `json:"fields"`
+}
+
+// SchemaField is a field of a SchemaObject, Optional if it may be omitted.
+type SchemaField struct {
+	Name     string      `json:"name"`
+	Type     *SchemaType `json:"type"`
+	Optional bool        `json:"optional,omitempty"`
+}
+
+// SchemaType is the type of a field, or the element of an array or map.
+type SchemaType struct {
+	Kind string      `json:"kind"`
+	Ref  string      `json:"ref,omitempty"`
+	Elem *SchemaType `json:"elem,omitempty"`
+}
+
+var (
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// NewSchema returns the Schema of every method of Client.
+func NewSchema() *Schema {
+	s := &Schema{Version: SchemaVersion}
+	types := make(map[string]*SchemaObject)
+
+	iface := reflect.TypeOf((*Client)(nil)).Elem()
+
+	for i := 0; i < iface.NumMethod(); i++ {
+		m := iface.Method(i)
+
+		if m.Type.NumIn() != 2 || m.Type.In(0) != contextType || m.Type.NumOut() != 2 || m.Type.Out(1) != errorType {
+			continue
+		}
+
+		s.Methods = append(s.Methods, &SchemaMethod{
+			Name:   m.Name,
+			Params: schemaObject(m.Type.In(1), types),
+			Result: schemaObject(m.Type.Out(0), types),
+		})
+	}
+
+	for _, obj := range types {
+		s.Types = append(s.Types, obj)
+	}
+
+	sort.Slice(s.Types, func(i, j int) bool { return s.Types[i].Name < s.Types[j].Name })
+
+	return s
+}
+
+// schemaObject adds the SchemaObject of the struct t, and of every struct it
+// refers to, to types, returning its name.
+func schemaObject(t reflect.Type, types map[string]*SchemaObject) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if _, ok := types[t.Name()]; ok {
+		return t.Name()
+	}
+
+	obj := &SchemaObject{Name: t.Name()}
+	types[t.Name()] = obj
+
+	obj.Fields = schemaFields(t, types)
+
+	return obj.Name
+}
+
+// schemaFields returns the fields of the struct t as encoded by
+// encoding/json, including those of embedded structs.
+func schemaFields(t reflect.Type, types map[string]*SchemaObject) []*SchemaField {
+	fields := make([]*SchemaField, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, schemaFields(ft, types)...)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, &SchemaField{
+			Name:     name,
+			Type:     schemaType(f.Type, types),
+			Optional: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+
+	return fields
+}
+
+// schemaType returns the SchemaType of t.
+func schemaType(t reflect.Type, types map[string]*SchemaObject) *SchemaType {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &SchemaType{Kind: KindTime}
+
+	case rawMessageType:
+		return &SchemaType{Kind: KindAny}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &SchemaType{Kind: KindString}
+
+	case reflect.Bool:
+		return &SchemaType{Kind: KindBoolean}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &SchemaType{Kind: KindInteger}
+
+	case reflect.Float32, reflect.Float64:
+		return &SchemaType{Kind: KindNumber}
+
+	case reflect.Slice, reflect.Array:
+		// []byte is encoded as a base64 string.
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &SchemaType{Kind: KindString}
+		}
+
+		return &SchemaType{Kind: KindArray, Elem: schemaType(t.Elem(), types)}
+
+	case reflect.Map:
+		return &SchemaType{Kind: KindMap, Elem: schemaType(t.Elem(), types)}
+
+	case reflect.Struct:
+		return &SchemaType{Kind: KindObject, Ref: schemaObject(t, types)}
+	}
+
+	return &SchemaType{Kind: KindAny}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// SelfService is implemented by the self-service API of a WG-API server,
+// through which a Peer may query only its own record without an
+// authentication token.
+type SelfService interface {
+	// GetChallenge returns a challenge that must be proven within a short
+	// time to authenticate GetSelf by possession of a private key.
+	GetChallenge(context.Context, *GetChallengeRequest) (*GetChallengeResponse, error)
+
+	// GetSelf returns the Peer making the request, along with its usage.
+	GetSelf(context.Context, *GetSelfRequest) (*GetSelfResponse, error)
+}
+
+type GetChallengeRequest struct{}
+
+type GetChallengeResponse struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type GetSelfRequest struct {
+	// PublicKey, Challenge and Proof authenticate the Peer by possession of
+	// its private key, see SelfProof. If not given, the Peer may instead be
+	// identified by the source address of the request, if the server allows
+	// it.
+	PublicKey string `json:"public_key,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+	Proof     string `json:"proof,omitempty"`
+}
+
+type GetSelfResponse struct {
+	Peer  *Peer      `json:"peer"`
+	Usage *SelfUsage `json:"usage"`
+}
+
+// SelfUsage is the traffic of a Peer and its quota, if any.
+type SelfUsage struct {
+	// TransferBytes is the total number of bytes received and transmitted
+	// by the Peer, including before any failover of the server.
+	TransferBytes int64 `json:"transfer_bytes"`
+
+	// QuotaBytes is the number of bytes the Peer may transfer before it is
+	// removed, of which RemainingBytes remain.
+	QuotaBytes     int64 `json:"quota_bytes,omitempty"`
+	RemainingBytes int64 `json:"remaining_bytes,omitempty"`
+}
+
+// SelfProof proves possession of the private key of a Peer for challenge.
+// The proof is an HMAC-SHA256 of the challenge keyed by a key derived from the
+// Curve25519 shared secret of the private key of the Peer and the public key
+// of the device, which the server can compute from its own private key.
+func SelfProof(privateKey, devicePublicKey, challenge string) (string, error) {
+	priv, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	pub, err := wgtypes.ParseKey(devicePublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return keyProof(priv, pub, labelSelf, challenge)
+}
+
+// Labels of the keys derived from the shared secret of a private key and the
+// public key of a device, one for each purpose, such that a proof made for
+// one is never accepted for another.
+const (
+	// labelRequest keys the signatures of requests made with SignRequest.
+	labelRequest = "wg-api request"
+
+	// labelSelf keys the proofs of the self-service API made with
+	// SelfProof.
+	labelSelf = "wg-api self"
+)
+
+// keyProof computes the HMAC-SHA256 of message keyed by the HKDF-SHA256,
+// with the info label, of the Curve25519 shared secret of privateKey and
+// publicKey. Either party to the shared secret may compute it, proving
+// possession of their private key to the other.
+func keyProof(privateKey, publicKey wgtypes.Key, label, message string) (string, error) {
+	shared, err := curve25519.X25519(privateKey[:], publicKey[:])
+	if err != nil {
+		return "", err
+	}
+
+	key, err := hkdf.Key(sha256.New, shared, nil, label, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifySelfProof returns true if proof was computed by SelfProof with the
+// private key of publicKey, given the private key of the device.
+func VerifySelfProof(devicePrivateKey, publicKey wgtypes.Key, challenge, proof string) bool {
+	expected, err := keyProof(devicePrivateKey, publicKey, labelSelf, challenge)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal([]byte(expected), []byte(proof))
+}
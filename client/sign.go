@@ -0,0 +1,125 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// AuthSchemeWireGuard is the scheme of the Authorization header of requests
+// signed with a WireGuard private key, given as
+// "WireGuard <public key>:<unix time>:<nonce>:<signature>".
+const AuthSchemeWireGuard = "WireGuard"
+
+// MaxSignatureAge is how far the time a request was signed may differ from
+// the time of the server.
+const MaxSignatureAge = time.Minute
+
+// maxNonceLength is the longest nonce of a signed request, which the server
+// keeps until the signature expires.
+const maxNonceLength = 64
+
+// signedMessage is the message signed for a request, covering its method,
+// path, time, nonce and body.
+func signedMessage(method, uri, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	return method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(digest[:])
+}
+
+// SignRequest signs r, whose body is body, with the private key of an admin
+// identity registered on the server of the device with devicePublicKey.
+// Only the server, which holds the private key of the device, can verify the
+// signature. Every signature has a random nonce, such that the server can
+// refuse a request replayed within MaxSignatureAge.
+func SignRequest(r *http.Request, body []byte, privateKey, devicePublicKey string) error {
+	priv, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	pub, err := wgtypes.ParseKey(devicePublicKey)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(b)
+
+	sig, err := keyProof(priv, pub, labelRequest, signedMessage(r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s %s:%s:%s:%s", AuthSchemeWireGuard, priv.PublicKey(), timestamp, nonce, sig))
+
+	return nil
+}
+
+// SignedRequest is the identity and nonce of a request verified by
+// VerifyRequest.
+type SignedRequest struct {
+	PublicKey wgtypes.Key
+	Nonce     string
+
+	// SignedAt is the time the request was signed, a replay of which must
+	// be refused until MaxSignatureAge after it.
+	SignedAt time.Time
+}
+
+// VerifyRequest verifies the signature of r, whose body is body, returning
+// the identity that signed it and its nonce. It is up to the caller to
+// refuse a nonce it has already seen.
+func VerifyRequest(r *http.Request, body []byte, devicePrivateKey wgtypes.Key) (*SignedRequest, error) {
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), AuthSchemeWireGuard+" ")
+	if !ok {
+		return nil, fmt.Errorf("request is not signed")
+	}
+
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("malformed signature")
+	}
+
+	publicKey, err := wgtypes.ParseKey(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %w", err)
+	}
+
+	signedAt := time.Unix(unix, 0)
+	if age := time.Since(signedAt); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return nil, fmt.Errorf("signature expired")
+	}
+
+	if parts[2] == "" || len(parts[2]) > maxNonceLength {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	expected, err := keyProof(devicePrivateKey, publicKey, labelRequest, signedMessage(r.Method, r.URL.RequestURI(), parts[1], parts[2], body))
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(parts[3])) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	return &SignedRequest{PublicKey: publicKey, Nonce: parts[2], SignedAt: signedAt}, nil
+}
@@ -0,0 +1,52 @@
+package client
+
+type SyncPeersRequest struct {
+	// Peers are every Peer the device should have once synced, each as given
+	// to AddPeer. Peers on the device not given are removed, so it must be
+	// given, an empty list removing every Peer.
+	Peers []*AddPeerRequest `json:"peers"`
+
+	// OnError is one of abort (default), continue or rollback.
+	OnError string `json:"on_error,omitempty"`
+
+	// Gateway is the name of the WG-API server whose device is synced when
+	// requested through a proxy, required if the proxy has more than one
+	// server.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type SyncPeersResponse struct {
+	// OK is true if every Peer was added, updated or removed.
+	OK bool `json:"ok"`
+
+	// RolledBack is true if a Peer failed and the device was restored to its
+	// state before the sync.
+	RolledBack bool `json:"rolled_back,omitempty"`
+
+	// Results are of the Peers added or updated, in the order they were
+	// given, followed by those removed. Index is that of the Peer within
+	// Peers, or -1 for those removed.
+	Results []*BatchResult `json:"results"`
+
+	// Unchanged is the number of Peers already on the device as given, which
+	// were left alone.
+	Unchanged int `json:"unchanged"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device once synced.
+	Generation uint64 `json:"generation,omitempty"`
+}
@@ -0,0 +1,59 @@
+package client
+
+// DefaultTopTalkers is how many Peers are returned by TopTalkers, unless
+// requested otherwise.
+const DefaultTopTalkers = 10
+
+// Orders of TopTalkers.
+const (
+	// TopTalkersByRate orders Peers by their rate of transfer at the end of
+	// the window.
+	TopTalkersByRate = "rate"
+
+	// TopTalkersByBytes orders Peers by the bytes transferred over the
+	// whole window.
+	TopTalkersByBytes = "bytes"
+)
+
+type TopTalkersRequest struct {
+	// Limit is the number of Peers returned, by default DefaultTopTalkers.
+	Limit int `json:"limit,omitempty"`
+
+	// By is the order of Peers, either rate (default) or bytes.
+	By string `json:"by,omitempty"`
+
+	// Window is the period transfer is measured over, such as 30s. By
+	// default, and at most, the rate window of the server.
+	Window string `json:"window,omitempty"`
+}
+
+// Talker is the transfer of a Peer over a recent window.
+type Talker struct {
+	Peer *Peer `json:"peer"`
+
+	// ReceiveBytes and TransmitBytes are the bytes received from and
+	// transmitted to the Peer over the window.
+	ReceiveBytes  int64 `json:"receive_bytes"`
+	TransmitBytes int64 `json:"transmit_bytes"`
+
+	// ReceiveRate and TransmitRate are the bytes per second received from
+	// and transmitted to the Peer over the window.
+	ReceiveRate  float64 `json:"receive_rate"`
+	TransmitRate float64 `json:"transmit_rate"`
+}
+
+// Score returns the value Talkers are ordered by, largest first.
+func (t *Talker) Score(by string) float64 {
+	if by == TopTalkersByBytes {
+		return float64(t.ReceiveBytes + t.TransmitBytes)
+	}
+
+	return t.ReceiveRate + t.TransmitRate
+}
+
+type TopTalkersResponse struct {
+	Talkers []*Talker `json:"talkers"`
+
+	// Window is the period transfer was measured over.
+	Window string `json:"window"`
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"time"
+)
+
+type UpdatePeerRequest struct {
+	// PublicKey is that of the Peer updated, which must already be on the
+	// device.
+	PublicKey string `json:"public_key"`
+
+	// PresharedKey, Endpoint and PersistentKeepAlive, if given, replace
+	// those of the Peer. A PresharedKey of NoPresharedKey removes it.
+	PresharedKey        string `json:"preshared_key,omitempty"`
+	Endpoint            string `json:"endpoint,omitempty"`
+	PersistentKeepAlive string `json:"persistent_keep_alive,omitempty"`
+
+	// AllowedIPs are added to those of the Peer, unless ReplaceAllowedIPs
+	// is set, replacing them. Allowed ips assigned to the Peer by IP address
+	// management are kept either way.
+	AllowedIPs        []string `json:"allowed_ips,omitempty"`
+	ReplaceAllowedIPs bool     `json:"replace_allowed_ips,omitempty"`
+
+	// ClearEndpoint removes the endpoint of the Peer, as with AddPeer, which
+	// drops its handshake and session and resets its transfer counters if it
+	// has one. It cannot be given with Endpoint.
+	ClearEndpoint bool `json:"clear_endpoint,omitempty"`
+
+	// ClearKeepAlive disables the persistent keepalive of the Peer. It
+	// cannot be given with PersistentKeepAlive.
+	ClearKeepAlive bool `json:"clear_keep_alive,omitempty"`
+
+	// Force updates the Peer even if its allowed ips overlap those of another
+	// Peer, as with AddPeer.
+	Force bool `json:"force,omitempty"`
+
+	// Metadata, if given, replaces any existing metadata of the Peer.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// ExpiresAt or TTL, if given, replaces the expiry of the Peer, as with
+	// AddPeer.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+
+	// Gateway, if given, is the name of the WG-API server the Peer is
+	// updated on when requested through a proxy. By default it is updated
+	// on the server it belongs to.
+	Gateway string `json:"gateway,omitempty"`
+
+	// ExpectedGeneration, if non-zero, causes the request to fail with a
+	// Conflict error if the device is no longer at this generation.
+	ExpectedGeneration uint64 `json:"expected_generation,omitempty"`
+
+	// DryRun returns the changes that would be made to the device without
+	// making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type UpdatePeerResponse struct {
+	OK bool `json:"ok"`
+
+	// Gateway is the name of the WG-API server the Peer was updated on, when
+	// requested through a proxy.
+	Gateway string `json:"gateway,omitempty"`
+
+	// DryRun is true if no changes were made to the device, because either
+	// the request or the server is in dry run mode.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Changes made, or that would have been made, to the Peers of the device.
+	Changes []*PeerChange `json:"changes"`
+
+	// Generation of the device after the Peer was updated.
+	Generation uint64 `json:"generation,omitempty"`
+}
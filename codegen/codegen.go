@@ -0,0 +1,101 @@
+// Package codegen generates typed clients of the WG-API in languages other
+// than Go from the client.Schema of its methods, such that consumers need
+// not write the params and results of every method by hand.
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// Languages clients may be generated in.
+const (
+	TypeScript = "typescript"
+	Python     = "python"
+)
+
+// header is the first line of every generated client, recognised by tools
+// as generated code.
+const header = "Code generated by wg-api genclient. DO NOT EDIT."
+
+// Generate writes a client of every method of schema in lang to w.
+func Generate(w io.Writer, lang string, schema *client.Schema) error {
+	var buf bytes.Buffer
+
+	switch lang {
+	case TypeScript:
+		generateTypeScript(&buf, schema)
+
+	case Python:
+		generatePython(&buf, schema)
+
+	default:
+		return fmt.Errorf("unknown language %q, must be one of typescript or python", lang)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// words splits a name in camel case, such as GetDeviceInfo, into its words,
+// keeping acronyms such as TLS whole.
+func words(name string) []string {
+	var words []string
+	var word []rune
+
+	runes := []rune(name)
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if prevLower || nextLower {
+				words = append(words, string(word))
+				word = nil
+			}
+		}
+
+		word = append(word, r)
+	}
+
+	return append(words, string(word))
+}
+
+// camelCase returns name with its first word in lower case, such as
+// getDeviceInfo.
+func camelCase(name string) string {
+	w := words(name)
+	w[0] = strings.ToLower(w[0])
+
+	return strings.Join(w, "")
+}
+
+// snakeCase returns name in lower case separated by underscores, such as
+// get_device_info.
+func snakeCase(name string) string {
+	return strings.ToLower(strings.Join(words(name), "_"))
+}
+
+// required returns whether the object name of schema has a field that may
+// not be omitted.
+func required(schema *client.Schema, name string) bool {
+	for _, obj := range schema.Types {
+		if obj.Name != name {
+			continue
+		}
+
+		for _, f := range obj.Fields {
+			if !f.Optional {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,156 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// pythonKeywords may not be the name of a field of a TypedDict declared
+// with class syntax.
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true,
+	"assert": true, "async": true, "await": true, "break": true,
+	"class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true,
+	"global": true, "if": true, "import": true, "in": true, "is": true,
+	"lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true,
+	"raise": true, "return": true, "try": true, "while": true, "with": true,
+	"yield": true,
+}
+
+// pyType returns the Python type of t.
+func pyType(t *client.SchemaType) string {
+	switch t.Kind {
+	case client.KindString, client.KindTime:
+		return "str"
+
+	case client.KindInteger:
+		return "int"
+
+	case client.KindNumber:
+		return "float"
+
+	case client.KindBoolean:
+		return "bool"
+
+	case client.KindObject:
+		return t.Ref
+
+	case client.KindArray:
+		return "List[" + pyType(t.Elem) + "]"
+
+	case client.KindMap:
+		return "Dict[str, " + pyType(t.Elem) + "]"
+	}
+
+	return "Any"
+}
+
+// generatePython writes a TypedDict of every type of schema, and a Client
+// class with a method of every method, using only the standard library.
+func generatePython(buf *bytes.Buffer, schema *client.Schema) {
+	fmt.Fprintf(buf, "# %s\n", header)
+	fmt.Fprintf(buf, "# WG-API schema version %d. Requires Python 3.11 or later.\n\n", schema.Version)
+
+	buf.WriteString(`from __future__ import annotations
+
+import itertools
+import json
+import urllib.request
+from typing import Any, Dict, List, NotRequired, Optional, TypedDict
+`)
+
+	for _, obj := range schema.Types {
+		var keyword bool
+		for _, f := range obj.Fields {
+			keyword = keyword || pythonKeywords[f.Name]
+		}
+
+		field := func(f *client.SchemaField) string {
+			if f.Optional {
+				return "NotRequired[" + pyType(f.Type) + "]"
+			}
+
+			return pyType(f.Type)
+		}
+
+		// fields named by keywords require the functional syntax, where
+		// types are evaluated immediately and so must be quoted.
+		if keyword {
+			fields := make([]string, len(obj.Fields))
+			for i, f := range obj.Fields {
+				fields[i] = fmt.Sprintf("%q: %q", f.Name, field(f))
+			}
+
+			fmt.Fprintf(buf, "\n\n%s = TypedDict(%q, {%s})\n", obj.Name, obj.Name, strings.Join(fields, ", "))
+			continue
+		}
+
+		fmt.Fprintf(buf, "\n\nclass %s(TypedDict):\n", obj.Name)
+
+		if len(obj.Fields) == 0 {
+			buf.WriteString("    pass\n")
+		}
+
+		for _, f := range obj.Fields {
+			fmt.Fprintf(buf, "    %s: %s\n", f.Name, field(f))
+		}
+	}
+
+	buf.WriteString(`
+
+class WgApiError(Exception):
+    """A JSON-RPC error returned by the server."""
+
+    def __init__(self, code: int, message: str, data: Optional[Dict[str, Any]] = None):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.data = data
+
+
+class Client:
+    """Calls the methods of a WG-API server.
+
+    url is the address of the server, such as http://localhost:8080, and
+    token, if given, authenticates each request.
+    """
+
+    def __init__(self, url: str, token: Optional[str] = None, timeout: float = 30):
+        self.url = url
+        self.token = token
+        self.timeout = timeout
+        self._ids = itertools.count(1)
+
+    def _call(self, method: str, params: Any) -> Any:
+        body = json.dumps({"jsonrpc": "2.0", "id": next(self._ids), "method": method, "params": params})
+
+        req = urllib.request.Request(self.url, data=body.encode(), method="POST")
+        req.add_header("Content-Type", "application/json")
+        if self.token:
+            req.add_header("Authorization", "Token " + self.token)
+
+        with urllib.request.urlopen(req, timeout=self.timeout) as res:
+            data = json.load(res)
+
+        if data.get("error"):
+            err = data["error"]
+            raise WgApiError(err["code"], err["message"], err.get("data"))
+
+        return data["result"]
+`)
+
+	for _, m := range schema.Methods {
+		// params may be omitted if none are required.
+		params := "params: " + m.Params
+		if !required(schema, m.Params) {
+			params = "params: Optional[" + m.Params + "] = None"
+		}
+
+		fmt.Fprintf(buf, "\n    def %s(self, %s) -> %s:\n", snakeCase(m.Name), params, m.Result)
+		fmt.Fprintf(buf, "        return self._call(%q, params or {})\n", m.Name)
+	}
+}
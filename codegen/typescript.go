@@ -0,0 +1,116 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// tsType returns the TypeScript type of t.
+func tsType(t *client.SchemaType) string {
+	switch t.Kind {
+	case client.KindString, client.KindTime:
+		return "string"
+
+	case client.KindInteger, client.KindNumber:
+		return "number"
+
+	case client.KindBoolean:
+		return "boolean"
+
+	case client.KindObject:
+		return t.Ref
+
+	case client.KindArray:
+		return tsType(t.Elem) + "[]"
+
+	case client.KindMap:
+		return "Record<string, " + tsType(t.Elem) + ">"
+	}
+
+	return "unknown"
+}
+
+// generateTypeScript writes an interface of every type of schema, and a
+// Client class with a method of every method, using fetch.
+func generateTypeScript(buf *bytes.Buffer, schema *client.Schema) {
+	fmt.Fprintf(buf, "// %s\n", header)
+	fmt.Fprintf(buf, "// WG-API schema version %d.\n\n", schema.Version)
+
+	for _, obj := range schema.Types {
+		fmt.Fprintf(buf, "export interface %s {\n", obj.Name)
+
+		for _, f := range obj.Fields {
+			optional := ""
+			if f.Optional {
+				optional = "?"
+			}
+
+			fmt.Fprintf(buf, "  %s%s: %s;\n", f.Name, optional, tsType(f.Type))
+		}
+
+		fmt.Fprintf(buf, "}\n\n")
+	}
+
+	buf.WriteString(`// WgApiError is a JSON-RPC error returned by the server.
+export class WgApiError extends Error {
+  constructor(
+    public code: number,
+    message: string,
+    public data?: Record<string, unknown>,
+  ) {
+    super(message);
+    this.name = "WgApiError";
+  }
+}
+
+// Client calls the methods of a WG-API server.
+export class Client {
+  private id = 0;
+
+  // url is the address of the server, such as http://localhost:8080, and
+  // token, if given, authenticates each request.
+  constructor(
+    private url: string,
+    private token?: string,
+  ) {}
+
+  private async call<T>(method: string, params: unknown): Promise<T> {
+    const headers: Record<string, string> = { "Content-Type": "application/json" };
+    if (this.token) {
+      headers["Authorization"] = "Token " + this.token;
+    }
+
+    const res = await fetch(this.url, {
+      method: "POST",
+      headers,
+      body: JSON.stringify({ jsonrpc: "2.0", id: ++this.id, method, params }),
+    });
+    if (!res.ok) {
+      throw new Error("unexpected http status " + res.status + ": " + (await res.text()).trim());
+    }
+
+    const body = await res.json();
+    if (body.error) {
+      throw new WgApiError(body.error.code, body.error.message, body.error.data);
+    }
+
+    return body.result as T;
+  }
+`)
+
+	for _, m := range schema.Methods {
+		// params may be omitted if none are required.
+		params := "params: " + m.Params
+		if !required(schema, m.Params) {
+			params += " = {}"
+		}
+
+		fmt.Fprintf(buf, "\n  %s(%s): Promise<%s> {\n", camelCase(m.Name), params, m.Result)
+		fmt.Fprintf(buf, "    return this.call<%s>(%q, params);\n", m.Result, m.Name)
+		fmt.Fprintf(buf, "  }\n")
+	}
+
+	buf.WriteString("}\n")
+}
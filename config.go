@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var configFile = flag.String("config", "", "")
+
+// reloadedOptions are the options of --config that take effect when it is
+// read again on SIGHUP, along with the tokens and certificates they name.
+// Any other option requires a restart.
+var reloadedOptions = map[string]bool{
+	"token":      true,
+	"token-ro":   true,
+	"token-rw":   true,
+	"token-file": true,
+	"admin-key":  true,
+}
+
+// config is the options of --config, which are those of the command line
+// keyed by their name, such as:
+//
+//	device: [ wg0 ]
+//	listen: 10.6.0.1:8080
+//	tls: true
+//	token: [ <random string> ]
+//
+// Options given on the command line override those of the file.
+type config struct {
+	filename string
+
+	// cmdline are the options given on the command line, which the file
+	// never sets.
+	cmdline map[string]bool
+
+	// values are the options of the file when it was last read, and
+	// defaults those they replaced, restored if removed from the file.
+	values   map[string][]string
+	defaults map[string][]string
+}
+
+// loadConfig reads the options of filename, setting each of fs that was not
+// given on the command line.
+func loadConfig(fs *flag.FlagSet, filename string) (*config, error) {
+	c := &config{
+		filename: filename,
+		cmdline:  make(map[string]bool),
+		defaults: make(map[string][]string),
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		c.cmdline[f.Name] = true
+	})
+
+	values, err := readConfig(fs, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.apply(fs, values, nil); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// reload reads the file again, setting only reloadedOptions. A change to any
+// other option is logged, as it is not applied until a restart.
+func (c *config) reload(fs *flag.FlagSet) error {
+	values, err := readConfig(fs, c.filename)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range optionNames(values, c.values) {
+		if !reloadedOptions[name] && !c.cmdline[name] && !slices.Equal(values[name], c.values[name]) {
+			log.Printf("warn: server: --%s changed in --config, restart to apply it\n", name)
+		}
+	}
+
+	return c.apply(fs, values, reloadedOptions)
+}
+
+// apply sets each option of values, restoring the defaults of those that
+// have been removed since the file was last read. If only is not nil, no
+// other option is changed.
+func (c *config) apply(fs *flag.FlagSet, values map[string][]string, only map[string]bool) error {
+	for _, name := range optionNames(values, c.values) {
+		if c.cmdline[name] || (only != nil && !only[name]) {
+			continue
+		}
+
+		f := fs.Lookup(name)
+
+		if _, ok := c.defaults[name]; !ok {
+			c.defaults[name] = flagValues(f)
+		}
+
+		vals, ok := values[name]
+		if !ok {
+			vals = c.defaults[name]
+		}
+
+		if err := setFlag(f, vals); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if c.values == nil || only == nil {
+		c.values = values
+		return nil
+	}
+
+	// options that were not applied are kept as they were, such that a
+	// change is warned of again on the next reload.
+	next := make(map[string][]string, len(values))
+	for name, vals := range c.values {
+		if !only[name] {
+			next[name] = vals
+		}
+	}
+
+	for name, vals := range values {
+		if only[name] {
+			next[name] = vals
+		}
+	}
+
+	c.values = next
+
+	return nil
+}
+
+// readConfig reads the options of filename, each of which must be an option
+// of fs.
+func readConfig(fs *flag.FlagSet, filename string) (map[string][]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file map[string]interface{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]string, len(file))
+
+	for name, v := range file {
+		if name == "config" {
+			return nil, fmt.Errorf("config cannot be given in a config file")
+		} else if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+
+		vals, err := configValues(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+
+		values[name] = vals
+	}
+
+	return values, nil
+}
+
+// configValues returns the values of an option of the file, a list for
+// options that may be given multiple times, or a map for those given as
+// <key>=<value>, such as --device-alias.
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+
+	case []interface{}:
+		vals := make([]string, len(v))
+		for i, e := range v {
+			switch e.(type) {
+			case []interface{}, map[string]interface{}:
+				return nil, errors.New("list must only contain values")
+			}
+
+			vals[i] = fmt.Sprint(e)
+		}
+
+		return vals, nil
+
+	case map[string]interface{}:
+		vals := make([]string, 0, len(v))
+		for key, e := range v {
+			switch e.(type) {
+			case []interface{}, map[string]interface{}:
+				return nil, errors.New("map must only contain values")
+			}
+
+			vals = append(vals, fmt.Sprintf("%s=%v", key, e))
+		}
+
+		sort.Strings(vals)
+
+		return vals, nil
+
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// optionNames returns the names of the options of either a or b, sorted.
+func optionNames(a, b map[string][]string) []string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// flagValues returns the current values of f.
+func flagValues(f *flag.Flag) []string {
+	if sv, ok := f.Value.(flag.SliceValue); ok {
+		return slices.Clone(sv.GetSlice())
+	}
+
+	return []string{f.Value.String()}
+}
+
+// setFlag replaces the values of f with vals, of which there must be one
+// unless f may be given multiple times.
+func setFlag(f *flag.Flag, vals []string) error {
+	if sv, ok := f.Value.(flag.SliceValue); ok {
+		return sv.Replace(vals)
+	}
+
+	if len(vals) != 1 {
+		return errors.New("must be a single value")
+	}
+
+	return f.Value.Set(vals[0])
+}
@@ -0,0 +1,162 @@
+// Package controller reconciles the Peers of a device to a desired state
+// kept elsewhere, such as etcd.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// Reconcile changes the Peers of the device such that they match desired.
+// Peers not in desired are removed, and Peers that differ are updated. The
+// endpoint of an existing Peer is not compared, as it changes as the Peer
+// roams. Desired Peers are applied independently, such that one invalid
+// Peer does not prevent the others from being applied.
+func Reconcile(ctx context.Context, c client.Client, desired []*client.AddPeerRequest) (*client.ApplyBatchResponse, error) {
+	list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*client.Peer, len(list.Peers))
+	for _, peer := range list.Peers {
+		current[peer.PublicKey] = peer
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	var ops []*client.BatchOperation
+
+	for _, req := range desired {
+		wanted[req.PublicKey] = true
+
+		peer, ok := current[req.PublicKey]
+		if ok && !differs(req, peer) {
+			continue
+		}
+
+		// an empty keepalive leaves that of the device unchanged, so it
+		// must be disabled explicitly.
+		if ok && req.PersistentKeepAlive == "" && durationOf(peer.PersistentKeepAlive) != 0 {
+			c := *req
+			c.PersistentKeepAlive = client.DisableKeepAlive
+			req = &c
+		}
+
+		ops = append(ops, &client.BatchOperation{AddPeer: req})
+	}
+
+	var removals []string
+	for publicKey := range current {
+		if !wanted[publicKey] {
+			removals = append(removals, publicKey)
+		}
+	}
+
+	sort.Strings(removals)
+
+	for _, publicKey := range removals {
+		ops = append(ops, &client.BatchOperation{RemovePeer: &client.RemovePeerRequest{PublicKey: publicKey}})
+	}
+
+	if len(ops) == 0 {
+		return &client.ApplyBatchResponse{OK: true, Generation: list.Generation}, nil
+	}
+
+	return c.ApplyBatch(ctx, &client.ApplyBatchRequest{
+		Operations:         ops,
+		OnError:            client.OnErrorContinue,
+		ExpectedGeneration: list.Generation,
+	})
+}
+
+// differs returns true if applying req would change peer.
+func differs(req *client.AddPeerRequest, peer *client.Peer) bool {
+	if req.PresharedKey == client.NoPresharedKey {
+		if peer.HasPresharedKey {
+			return true
+		}
+	} else if req.PresharedKey != "" && !peer.HasPresharedKey {
+		return true
+	}
+
+	if durationOf(req.PersistentKeepAlive) != durationOf(peer.PersistentKeepAlive) {
+		return true
+	}
+
+	if !stringsEqual(canonicalIPs(req.AllowedIPs), canonicalIPs(peer.AllowedIPs)) {
+		return true
+	}
+
+	if req.Template != peer.Template {
+		return true
+	}
+
+	if len(req.Metadata) != len(peer.Metadata) {
+		return true
+	}
+
+	for k, v := range req.Metadata {
+		if peer.Metadata[k] != v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func durationOf(s string) time.Duration {
+	d, _ := time.ParseDuration(s)
+	return d
+}
+
+// canonicalIPs returns the sorted canonical form of allowed ips, invalid
+// ranges are kept as given such that they are reported when applied.
+func canonicalIPs(allowedIPs []string) []string {
+	canonical := make([]string, len(allowedIPs))
+
+	for i, allowedIP := range allowedIPs {
+		if _, n, err := net.ParseCIDR(allowedIP); err == nil {
+			canonical[i] = n.String()
+		} else {
+			canonical[i] = allowedIP
+		}
+	}
+
+	sort.Strings(canonical)
+
+	return canonical
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// summarize describes the outcome of a reconciliation for logging.
+func summarize(res *client.ApplyBatchResponse) string {
+	applied, failed := 0, 0
+
+	for _, result := range res.Results {
+		if result.OK {
+			applied++
+		} else {
+			failed++
+		}
+	}
+
+	return fmt.Sprintf("applied=%d failed=%d generation=%d", applied, failed, res.Generation)
+}
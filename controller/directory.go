@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// directoryResync is how often the device is reconciled even if no file has
+// changed, such that changes made outside of WG-API are reverted.
+const directoryResync = time.Minute
+
+// directorySettle is how long after a file changes the device is reconciled,
+// such that a file being written is read once complete and many changes at
+// once are applied together.
+const directorySettle = time.Second
+
+// Directory reconciles the device to the Peers defined by files in a
+// directory, each file holding an AddPeerRequest as YAML or JSON.
+type Directory struct {
+	path string
+}
+
+// NewDirectory returns a Directory of the Peers defined in path.
+func NewDirectory(path string) (*Directory, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+
+	return &Directory{path: path}, nil
+}
+
+// Run reconciles the device whenever a file in the directory is created,
+// changed or removed, until ctx is cancelled.
+func (d *Directory) Run(ctx context.Context, c client.Client) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.Add(d.path); err != nil {
+		return err
+	}
+
+	t := time.NewTicker(directoryResync)
+	defer t.Stop()
+
+	settle := time.NewTimer(0)
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event := <-w.Events:
+			if isPeerFile(event.Name) {
+				settle.Reset(directorySettle)
+			}
+
+		case err := <-w.Errors:
+			log.Printf("error: peers-dir: watch: %s\n", err)
+
+		case <-t.C:
+			settle.Reset(0)
+
+		case <-settle.C:
+			if err := d.reconcile(ctx, c); err != nil {
+				log.Printf("error: peers-dir: %s\n", err)
+			}
+		}
+	}
+}
+
+// isPeerFile returns true if the file at path may define a Peer, hidden
+// files such as those left by editors are ignored.
+func isPeerFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+
+	default:
+		return false
+	}
+}
+
+// reconcile reads every file in the directory and applies them to the
+// device.
+func (d *Directory) reconcile(ctx context.Context, c client.Client) error {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return fmt.Errorf("could not read directory: %w", err)
+	}
+
+	var desired []*client.AddPeerRequest
+	files := make(map[string]string)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isPeerFile(entry.Name()) {
+			continue
+		}
+
+		req, err := readPeerFile(filepath.Join(d.path, entry.Name()))
+		if err != nil {
+			log.Printf("warn: peers-dir: ignoring %s: %s\n", entry.Name(), err)
+			continue
+		} else if other, ok := files[req.PublicKey]; ok {
+			log.Printf("warn: peers-dir: ignoring %s: peer already defined by %s\n", entry.Name(), other)
+			continue
+		}
+
+		files[req.PublicKey] = entry.Name()
+		desired = append(desired, req)
+	}
+
+	result, err := Reconcile(ctx, c, desired)
+	if err != nil {
+		return fmt.Errorf("could not reconcile device: %w", err)
+	}
+
+	if len(result.Results) > 0 {
+		log.Printf("info: peers-dir: reconciled %d files: %s\n", len(desired), summarize(result))
+	}
+
+	for _, r := range result.Results {
+		if r.Error != nil {
+			name, ok := files[r.PublicKey]
+			if !ok {
+				name = r.PublicKey
+			}
+
+			log.Printf("warn: peers-dir: could not apply %s: %s\n", name, r.Error.Message)
+		}
+	}
+
+	return nil
+}
+
+// readPeerFile reads a Peer from a YAML or JSON file, with the same fields
+// as the JSON of AddPeerRequest.
+func readPeerFile(filename string) (*client.AddPeerRequest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so both are read as YAML then converted to JSON
+	// to be decoded with the field names of AddPeerRequest.
+	var v map[string]interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(client.AddPeerRequest)
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	} else if req.PublicKey == "" {
+		return nil, fmt.Errorf("public_key is required")
+	}
+
+	return req, nil
+}
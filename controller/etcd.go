@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdResync is how often the device is reconciled even if the desired
+// state has not changed, such that changes made outside of WG-API are
+// reverted and status is refreshed.
+const etcdResync = time.Minute
+
+// Etcd reconciles the device to the desired Peers kept in etcd, each key
+// under Prefix holding an AddPeerRequest as JSON. The status of each Peer
+// is written back under StatusPrefix.
+type Etcd struct {
+	client *clientv3.Client
+	cfg    *EtcdConfig
+}
+
+// EtcdConfig configures the connection to etcd and where desired state is
+// kept.
+type EtcdConfig struct {
+	Endpoints []string
+	Username  string
+	Password  string
+
+	// Prefix under which desired Peers are kept, such as /wg-api/peers/.
+	Prefix string
+
+	// StatusPrefix, if set, is where the status of each desired Peer is
+	// written, keyed by public key, such as /wg-api/status/gw1/. It should be
+	// unique to each gateway.
+	StatusPrefix string
+}
+
+// NewEtcd connects to etcd.
+func NewEtcd(cfg *EtcdConfig) (*Etcd, error) {
+	if cfg.Prefix == "" {
+		return nil, fmt.Errorf("etcd prefix is required")
+	} else if cfg.StatusPrefix != "" && strings.HasPrefix(cfg.StatusPrefix, cfg.Prefix) {
+		return nil, fmt.Errorf("etcd status prefix must not be under prefix %s", cfg.Prefix)
+	}
+
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		DialTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Etcd{client: c, cfg: cfg}, nil
+}
+
+// Run reconciles the device whenever the desired state changes, until ctx is
+// cancelled.
+func (e *Etcd) Run(ctx context.Context, c client.Client) error {
+	t := time.NewTicker(etcdResync)
+	defer t.Stop()
+
+	for {
+		rev, err := e.reconcile(ctx, c)
+		if err != nil {
+			log.Printf("error: etcd: %s\n", err)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+				continue
+			}
+		}
+
+		wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		watch := e.client.Watch(wctx, e.cfg.Prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			return nil
+
+		case res := <-watch:
+			if err := res.Err(); err != nil {
+				log.Printf("error: etcd: watch: %s\n", err)
+			}
+
+		case <-t.C:
+		}
+
+		cancel()
+	}
+}
+
+// reconcile reads the desired state and applies it to the device, returning
+// the revision of etcd that was applied.
+func (e *Etcd) reconcile(ctx context.Context, c client.Client) (int64, error) {
+	res, err := e.client.Get(ctx, e.cfg.Prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("could not get desired peers: %w", err)
+	}
+
+	var desired []*client.AddPeerRequest
+
+	for _, kv := range res.Kvs {
+		req := new(client.AddPeerRequest)
+		if err := json.Unmarshal(kv.Value, req); err != nil {
+			log.Printf("warn: etcd: ignoring %s: %s\n", kv.Key, err)
+			continue
+		}
+
+		desired = append(desired, req)
+	}
+
+	result, err := Reconcile(ctx, c, desired)
+	if err != nil {
+		return 0, fmt.Errorf("could not reconcile device: %w", err)
+	}
+
+	if len(result.Results) > 0 {
+		log.Printf("info: etcd: reconciled revision %d: %s\n", res.Header.Revision, summarize(result))
+	}
+
+	for _, r := range result.Results {
+		if r.Error != nil {
+			log.Printf("warn: etcd: could not apply %s: %s\n", r.PublicKey, r.Error.Message)
+		}
+	}
+
+	if e.cfg.StatusPrefix != "" {
+		if err := e.writeStatus(ctx, c, desired, result); err != nil {
+			log.Printf("error: etcd: could not write status: %s\n", err)
+		}
+	}
+
+	return res.Header.Revision, nil
+}
+
+// PeerStatus is written to etcd for each desired Peer.
+type PeerStatus struct {
+	// Peer is the state of the Peer on the device, nil if it could not be
+	// applied.
+	Peer *client.Peer `json:"peer,omitempty"`
+
+	// Error is the reason the Peer could not be applied.
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// writeStatus writes the status of each desired Peer, and removes the status
+// of Peers that are no longer desired.
+func (e *Etcd) writeStatus(ctx context.Context, c client.Client, desired []*client.AddPeerRequest, result *client.ApplyBatchResponse) error {
+	list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return err
+	}
+
+	peers := make(map[string]*client.Peer, len(list.Peers))
+	for _, peer := range list.Peers {
+		peers[peer.PublicKey] = peer
+	}
+
+	errs := make(map[string]string)
+	for _, r := range result.Results {
+		if r.Error != nil {
+			errs[r.PublicKey] = r.Error.Message
+		}
+	}
+
+	now := time.Now().UTC()
+	wanted := make(map[string]bool, len(desired))
+
+	for _, req := range desired {
+		wanted[req.PublicKey] = true
+
+		data, err := json.Marshal(&PeerStatus{Peer: peers[req.PublicKey], Error: errs[req.PublicKey], UpdatedAt: now})
+		if err != nil {
+			return err
+		}
+
+		if _, err := e.client.Put(ctx, e.cfg.StatusPrefix+req.PublicKey, string(data)); err != nil {
+			return err
+		}
+	}
+
+	existing, err := e.client.Get(ctx, e.cfg.StatusPrefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range existing.Kvs {
+		if !wanted[strings.TrimPrefix(string(kv.Key), e.cfg.StatusPrefix)] {
+			if _, err := e.client.Delete(ctx, string(kv.Key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close closes the connection to etcd.
+func (e *Etcd) Close() error {
+	return e.client.Close()
+}
@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubernetesResync is how often the device is reconciled even if no
+// WireGuardPeer has changed, such that changes made outside of WG-API are
+// reverted and status is refreshed.
+const kubernetesResync = time.Minute
+
+// WireGuardPeerResource is the custom resource reconciled by Kubernetes,
+// whose spec holds an AddPeerRequest.
+var WireGuardPeerResource = schema.GroupVersionResource{
+	Group:    "wg-api.io",
+	Version:  "v1alpha1",
+	Resource: "wireguardpeers",
+}
+
+// Kubernetes reconciles the device to the WireGuardPeer custom resources of
+// a namespace, writing the state of each Peer on the device back to the
+// status of its resource.
+type Kubernetes struct {
+	client dynamic.ResourceInterface
+	cfg    *KubernetesConfig
+}
+
+// KubernetesConfig configures the connection to Kubernetes and which
+// WireGuardPeers are reconciled.
+type KubernetesConfig struct {
+	// Kubeconfig is the path of a kubeconfig file, if empty the configuration
+	// of the pod WG-API is running in is used.
+	Kubeconfig string
+
+	Namespace string
+
+	// Selector, if set, limits the WireGuardPeers reconciled to those whose
+	// labels match, such as gateway=gw1.
+	Selector string
+}
+
+// NewKubernetes connects to Kubernetes.
+func NewKubernetes(cfg *KubernetesConfig) (*Kubernetes, error) {
+	if cfg.Namespace == "" {
+		return nil, fmt.Errorf("kubernetes namespace is required")
+	}
+
+	rc, err := clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := dynamic.NewForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Kubernetes{client: dc.Resource(WireGuardPeerResource).Namespace(cfg.Namespace), cfg: cfg}, nil
+}
+
+// Run reconciles the device whenever a WireGuardPeer changes, until ctx is
+// cancelled.
+func (k *Kubernetes) Run(ctx context.Context, c client.Client) error {
+	t := time.NewTicker(kubernetesResync)
+	defer t.Stop()
+
+	for {
+		rv, err := k.reconcile(ctx, c)
+		if err != nil {
+			log.Printf("error: kubernetes: %s\n", err)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+				continue
+			}
+		}
+
+		watch, err := k.client.Watch(ctx, metav1.ListOptions{LabelSelector: k.cfg.Selector, ResourceVersion: rv})
+		if err != nil {
+			log.Printf("error: kubernetes: watch: %s\n", err)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			watch.Stop()
+			return nil
+
+		case <-watch.ResultChan():
+		case <-t.C:
+		}
+
+		watch.Stop()
+	}
+}
+
+// kubernetesStatus is written to the status of each WireGuardPeer.
+type kubernetesStatus struct {
+	// Applied is true if the Peer is on the device as desired.
+	Applied bool `json:"applied"`
+
+	// Error is the reason the Peer could not be applied.
+	Error string `json:"error,omitempty"`
+
+	AllowedIPs    []string   `json:"allowedIPs,omitempty"`
+	Endpoint      string     `json:"endpoint,omitempty"`
+	LastHandshake *time.Time `json:"lastHandshake,omitempty"`
+
+	// ObservedGeneration is the generation of the resource that was applied.
+	ObservedGeneration int64 `json:"observedGeneration"`
+}
+
+// reconcile lists the WireGuardPeers and applies them to the device,
+// returning the resource version of the list that was applied.
+func (k *Kubernetes) reconcile(ctx context.Context, c client.Client) (string, error) {
+	list, err := k.client.List(ctx, metav1.ListOptions{LabelSelector: k.cfg.Selector})
+	if err != nil {
+		return "", fmt.Errorf("could not list wireguardpeers: %w", err)
+	}
+
+	var desired []*client.AddPeerRequest
+	specErrs := make(map[string]string)
+
+	for _, item := range list.Items {
+		req, err := wireGuardPeerSpec(&item)
+		if err != nil {
+			specErrs[item.GetName()] = err.Error()
+			continue
+		}
+
+		desired = append(desired, req)
+	}
+
+	result, err := Reconcile(ctx, c, desired)
+	if err != nil {
+		return "", fmt.Errorf("could not reconcile device: %w", err)
+	}
+
+	if len(result.Results) > 0 {
+		log.Printf("info: kubernetes: reconciled resource version %s: %s\n", list.GetResourceVersion(), summarize(result))
+	}
+
+	errs := make(map[string]string)
+	for _, r := range result.Results {
+		if r.Error != nil {
+			log.Printf("warn: kubernetes: could not apply %s: %s\n", r.PublicKey, r.Error.Message)
+			errs[r.PublicKey] = r.Error.Message
+		}
+	}
+
+	peers, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return "", fmt.Errorf("could not list peers: %w", err)
+	}
+
+	byKey := make(map[string]*client.Peer, len(peers.Peers))
+	for _, peer := range peers.Peers {
+		byKey[peer.PublicKey] = peer
+	}
+
+	for i := range list.Items {
+		item := &list.Items[i]
+		status := &kubernetesStatus{ObservedGeneration: item.GetGeneration()}
+
+		if msg, ok := specErrs[item.GetName()]; ok {
+			status.Error = msg
+		} else {
+			publicKey, _, _ := unstructured.NestedString(item.Object, "spec", "public_key")
+			status.Error = errs[publicKey]
+
+			if peer, ok := byKey[publicKey]; ok {
+				status.Applied = status.Error == ""
+				status.AllowedIPs = peer.AllowedIPs
+				status.Endpoint = peer.Endpoint
+
+				if !peer.LastHandshake.IsZero() {
+					status.LastHandshake = &peer.LastHandshake
+				}
+			}
+		}
+
+		if err := k.writeStatus(ctx, item, status); err != nil {
+			log.Printf("error: kubernetes: could not write status of %s: %s\n", item.GetName(), err)
+		}
+	}
+
+	return list.GetResourceVersion(), nil
+}
+
+// wireGuardPeerSpec decodes the spec of a WireGuardPeer.
+func wireGuardPeerSpec(item *unstructured.Unstructured) (*client.AddPeerRequest, error) {
+	spec, ok := item.Object["spec"]
+	if !ok {
+		return nil, fmt.Errorf("spec is required")
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(client.AddPeerRequest)
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+
+	return req, nil
+}
+
+// writeStatus updates the status of a WireGuardPeer, if it has changed, such
+// that writing status does not itself cause the device to be reconciled
+// again.
+func (k *Kubernetes) writeStatus(ctx context.Context, item *unstructured.Unstructured, status *kubernetesStatus) error {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return err
+	}
+
+	if current, ok := item.Object["status"]; ok && reflect.DeepEqual(current, obj) {
+		return nil
+	}
+
+	item.Object["status"] = obj
+
+	_, err = k.client.UpdateStatus(ctx, item, metav1.UpdateOptions{})
+	return err
+}
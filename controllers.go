@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/controller"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	etcdEndpoints    = flag.StringSlice("etcd-endpoints", nil, "")
+	etcdPrefix       = flag.String("etcd-prefix", "/wg-api/peers/", "")
+	etcdStatusPrefix = flag.String("etcd-status-prefix", "", "")
+	etcdUsername     = flag.String("etcd-username", "", "")
+
+	kubernetesNamespace = flag.String("kubernetes-namespace", "", "")
+	kubernetesSelector  = flag.String("kubernetes-selector", "", "")
+	kubeconfig          = flag.String("kubeconfig", "", "")
+
+	peersDir = flag.String("peers-dir", "", "")
+)
+
+// startControllers starts each configured controller, which reconcile the
+// device to a desired state kept elsewhere.
+func startControllers(ctx context.Context, c client.Client) error {
+	n := 0
+	for _, enabled := range []bool{len(*etcdEndpoints) > 0, *kubernetesNamespace != "", *peersDir != ""} {
+		if enabled {
+			n++
+		}
+	}
+
+	if n > 1 {
+		return fmt.Errorf("only one of etcd, kubernetes or peers-dir may be given")
+	}
+
+	if len(*etcdEndpoints) > 0 {
+		e, err := controller.NewEtcd(&controller.EtcdConfig{
+			Endpoints:    *etcdEndpoints,
+			Username:     *etcdUsername,
+			Password:     os.Getenv("WGAPI_ETCD_PASSWORD"),
+			Prefix:       *etcdPrefix,
+			StatusPrefix: *etcdStatusPrefix,
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("info: etcd: reconciling device to %s\n", *etcdPrefix)
+
+		go e.Run(ctx, c)
+	}
+
+	if *kubernetesNamespace != "" {
+		k, err := controller.NewKubernetes(&controller.KubernetesConfig{
+			Kubeconfig: *kubeconfig,
+			Namespace:  *kubernetesNamespace,
+			Selector:   *kubernetesSelector,
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("info: kubernetes: reconciling device to wireguardpeers of namespace %s\n", *kubernetesNamespace)
+
+		go k.Run(ctx, c)
+	}
+
+	if *peersDir != "" {
+		d, err := controller.NewDirectory(*peersDir)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("info: peers-dir: reconciling device to files in %s\n", *peersDir)
+
+		go func() {
+			if err := d.Run(ctx, c); err != nil {
+				log.Printf("error: peers-dir: %s\n", err)
+			}
+		}()
+	}
+
+	return nil
+}
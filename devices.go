@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// wireguardRunDir is where userspace implementations of WireGuard listen on
+// the UAPI socket of each device, and where wg-quick on macOS records the
+// utun device of each configuration in <name>.name.
+const wireguardRunDir = "/var/run/wireguard"
+
+// openDevice opens the WireGuard device name, explaining the names of the
+// devices that do exist if it does not, and the privileges required if it
+// may not be opened. On Windows, where devices are named after their tunnel
+// in WireGuard for Windows, name is matched regardless of case. On macOS,
+// where the kernel names devices utunN, name may be that of the
+// configuration it was brought up from by wg-quick.
+func openDevice(wg server.WireGuard, name string) (*wgtypes.Device, error) {
+	iface := name
+	if utun, ok := utunName(name); ok {
+		iface = utun
+	}
+
+	device, err := wg.Device(iface)
+	if os.IsNotExist(err) && runtime.GOOS == "windows" {
+		if devices, err := wg.Devices(); err == nil {
+			for _, d := range devices {
+				if strings.EqualFold(d.Name, name) {
+					return d, nil
+				}
+			}
+		}
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("device %q does not exist, %s", name, knownDevices(wg))
+
+	case os.IsPermission(err):
+		return nil, fmt.Errorf("could not open WireGuard device %q: %w, %s", name, err, privilegeHelp())
+
+	case err != nil:
+		return nil, fmt.Errorf("could not open WireGuard device %q: %w", name, err)
+	}
+
+	return device, nil
+}
+
+// knownDevices describes the names of the WireGuard devices on this system.
+func knownDevices(wg server.WireGuard) string {
+	devices, err := wg.Devices()
+	if err != nil || len(devices) == 0 {
+		return "no WireGuard devices were found"
+	}
+
+	configs := configNames()
+
+	names := make([]string, len(devices))
+	for i, device := range devices {
+		names[i] = fmt.Sprintf("%q", device.Name)
+
+		if config, ok := configs[device.Name]; ok {
+			names[i] = fmt.Sprintf("%q (%s)", config, device.Name)
+		}
+	}
+
+	return "devices on this system are " + strings.Join(names, ", ")
+}
+
+// privilegeHelp describes the privileges required to manage WireGuard
+// devices on this platform.
+func privilegeHelp() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "WG-API must be run as Administrator or the LocalSystem account"
+
+	case "linux":
+		return "WG-API must be run as root, with CAP_NET_ADMIN or with --helper"
+
+	default:
+		return "WG-API must be run as root"
+	}
+}
+
+// utunName returns the utun device of the configuration name on macOS, as
+// recorded by wg-quick, or false if it is not known.
+func utunName(name string) (string, bool) {
+	if runtime.GOOS != "darwin" || name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+
+	data, err := os.ReadFile(filepath.Join(wireguardRunDir, name+".name"))
+	if err != nil {
+		return "", false
+	}
+
+	iface := strings.TrimSpace(string(data))
+
+	return iface, strings.HasPrefix(iface, "utun")
+}
+
+// configNames returns the name of the configuration of each utun device on
+// macOS, as recorded by wg-quick.
+func configNames() map[string]string {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(wireguardRunDir, "*.name"))
+	if err != nil {
+		return nil
+	}
+
+	names := make(map[string]string, len(paths))
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".name")
+
+		if iface, ok := utunName(name); ok {
+			names[iface] = name
+		}
+	}
+
+	return names
+}
+
+// parseDeviceAliases parses the aliases of --device-alias, each given as
+// <alias>=<name>, into the names of devices by their alias.
+func parseDeviceAliases(specs []string) (map[string]string, error) {
+	aliases := make(map[string]string, len(specs))
+
+	for _, spec := range specs {
+		alias, name, ok := strings.Cut(spec, "=")
+		if !ok || alias == "" || name == "" {
+			return nil, fmt.Errorf("alias %q must be given as <alias>=<name>", spec)
+		} else if _, ok := aliases[alias]; ok {
+			return nil, fmt.Errorf("alias %q is given more than once", alias)
+		}
+
+		aliases[alias] = name
+	}
+
+	return aliases, nil
+}
+
+// openDevices opens the WireGuard devices of --device, each of which may be
+// an alias, or with all every device of this system ordered by name. The
+// first device is the default of requests.
+func openDevices(wg server.WireGuard, names []string, all bool, aliases map[string]string) ([]*wgtypes.Device, error) {
+	if all {
+		if len(names) > 0 {
+			return nil, fmt.Errorf("--device cannot be given with --all-devices")
+		}
+
+		devices, err := wg.Devices()
+		if os.IsPermission(err) {
+			return nil, fmt.Errorf("could not list WireGuard devices: %w, %s", err, privilegeHelp())
+		} else if err != nil {
+			return nil, fmt.Errorf("could not list WireGuard devices: %w", err)
+		} else if len(devices) == 0 {
+			return nil, fmt.Errorf("no WireGuard devices were found")
+		}
+
+		sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+
+		return devices, nil
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("--device or --all-devices is required")
+	}
+
+	devices := make([]*wgtypes.Device, 0, len(names))
+	seen := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		if device, ok := aliases[name]; ok {
+			name = device
+		}
+
+		device, err := openDevice(wg, name)
+		if err != nil {
+			return nil, err
+		} else if seen[device.Name] {
+			return nil, fmt.Errorf("device %q is given more than once", device.Name)
+		}
+
+		seen[device.Name] = true
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// deviceStateFile returns the --state-file of the device name, that given if
+// it is the only device served, otherwise with the name of the device before
+// its extension, such as peers.wg1.json, such that each device is restored
+// to its own Peers.
+func deviceStateFile(filename, name string, devices int) string {
+	if devices <= 1 {
+		return filename
+	}
+
+	ext := filepath.Ext(filename)
+
+	return strings.TrimSuffix(filename, ext) + "." + name + ext
+}
+
+// createDevice creates the WireGuard device name if it does not exist, for
+// --create-if-missing, with the addresses and listen port of --device-address
+// and --device-listen-port. The private key of the device is read from
+// keyFile, if given, which is created with a new key if it does not exist,
+// such that the device keeps its public key when it is created again.
+func createDevice(wg server.WireGuard, name string, addresses []string, listenPort int, keyFile string) error {
+	if _, err := wg.Device(name); !os.IsNotExist(err) {
+		// any other error is explained when the device is opened.
+		return nil
+	}
+
+	req := &client.CreateDeviceRequest{Name: name, Addresses: addresses, ListenPort: listenPort}
+
+	if keyFile != "" {
+		key, err := deviceKey(keyFile)
+		if err != nil {
+			return fmt.Errorf("--device-key-file: %w", err)
+		}
+
+		req.PrivateKey = key.String()
+	}
+
+	device, err := server.NewDevice(wg, req)
+	if os.IsPermission(err) {
+		return fmt.Errorf("could not create WireGuard device %q: %w, %s", name, err, privilegeHelp())
+	} else if err != nil {
+		return fmt.Errorf("could not create WireGuard device %q: %w", name, err)
+	}
+
+	log.Printf("info: server: created device %s with public key %s\n", device.Name, device.PublicKey)
+
+	return nil
+}
+
+// deviceKey reads the private key of a device from filename, creating it with
+// a new key if it does not exist.
+func deviceKey(filename string) (wgtypes.Key, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return key, err
+		}
+
+		return key, os.WriteFile(filename, []byte(key.String()+"\n"), 0600)
+	} else if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	return wgtypes.ParseKey(strings.TrimSpace(string(data)))
+}
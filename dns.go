@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/jamescun/wg-api/dns"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	hostsFile       = flag.String("hosts-file", "", "")
+	zoneFile        = flag.String("zone-file", "", "")
+	dnsZone         = flag.String("dns-zone", "", "")
+	dnsNameMetadata = flag.String("dns-name-metadata", dns.DefaultNameMetadata, "")
+	dnsUpdate       = flag.String("dns-update", "", "")
+	dnsTSIGName     = flag.String("dns-tsig-name", "", "")
+	dnsTSIGAlg      = flag.String("dns-tsig-algorithm", "", "")
+	dnsListen       = flag.String("dns-listen", "", "")
+)
+
+// dnsInterval is how often names are rewritten regardless of events, such
+// that changes made outside of WG-API are reflected.
+const dnsInterval = time.Minute
+
+// loadDNS returns the writer of the names of peers, if configured.
+func loadDNS() (*dns.Writer, error) {
+	if *hostsFile == "" && *zoneFile == "" && *dnsUpdate == "" && *dnsListen == "" {
+		return nil, nil
+	}
+
+	cfg := &dns.Config{
+		HostsFile:    *hostsFile,
+		ZoneFile:     *zoneFile,
+		Listen:       *dnsListen,
+		Zone:         *dnsZone,
+		NameMetadata: *dnsNameMetadata,
+	}
+
+	if *dnsUpdate != "" {
+		server := *dnsUpdate
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+
+		cfg.Update = &dns.UpdateConfig{
+			Server:        server,
+			TSIGName:      *dnsTSIGName,
+			TSIGSecret:    os.Getenv("WGAPI_DNS_TSIG_SECRET"),
+			TSIGAlgorithm: *dnsTSIGAlg,
+		}
+	}
+
+	return dns.NewWriter(cfg)
+}
@@ -0,0 +1,314 @@
+// Package dns maintains the names of Peers mapped to their addresses, in files
+// of hosts or RFC 1035 zone format for consumption by DNS servers such as
+// dnsmasq or unbound, in DNS servers through dynamic updates, or served by an
+// embedded DNS server.
+package dns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	dnsmsg "github.com/miekg/dns"
+)
+
+// DefaultNameMetadata is the metadata of a Peer that it is named by, unless
+// configured otherwise.
+const DefaultNameMetadata = "name"
+
+// zoneTTL is the time to live of every record of a zone file or update.
+const zoneTTL = 60
+
+// Config configures the files written, DNS servers updated, and queries
+// answered by a Writer.
+type Config struct {
+	// HostsFile, if set, is written in hosts format.
+	HostsFile string
+
+	// ZoneFile, if set, is written as an RFC 1035 zone of Zone.
+	ZoneFile string
+
+	// Update, if set, sends changes to the names of Peers to a DNS server.
+	Update *UpdateConfig
+
+	// Listen, if set, is the address queries for the names of Peers are
+	// answered on by Serve, such as the address of the device 10.6.0.1:53.
+	Listen string
+
+	// Zone is the domain Peers are named under, such as vpn.example.com. It
+	// is required for ZoneFile, Update and Listen, and names in HostsFile are
+	// qualified with it if set.
+	Zone string
+
+	// NameMetadata is the metadata of a Peer that it is named by, by default
+	// DefaultNameMetadata. Peers without it are not named.
+	NameMetadata string
+}
+
+// Writer writes the names of the Peers of the device to files, and sends
+// them to a DNS server, whenever they change. It is an EventSink, such that
+// Peers being added, updated or removed cause the names to be rewritten.
+type Writer struct {
+	cfg     *Config
+	changed chan struct{}
+
+	// last is the records last written, such that names are only rewritten
+	// when they change. It is nil until the first are written.
+	last []record
+
+	// table is the records answered by Serve, replaced whenever they change.
+	table atomic.Pointer[table]
+}
+
+var _ server.EventSink = (*Writer)(nil)
+
+// NewWriter returns a Writer of the names configured by cfg.
+func NewWriter(cfg *Config) (*Writer, error) {
+	if cfg.HostsFile == "" && cfg.ZoneFile == "" && cfg.Update == nil && cfg.Listen == "" {
+		return nil, fmt.Errorf("hosts file, zone file, update server or listen address is required")
+	} else if (cfg.ZoneFile != "" || cfg.Update != nil || cfg.Listen != "") && cfg.Zone == "" {
+		return nil, fmt.Errorf("zone is required for zone file, updates and listen address")
+	}
+
+	if cfg.NameMetadata == "" {
+		cfg.NameMetadata = DefaultNameMetadata
+	}
+
+	cfg.Zone = strings.Trim(strings.ToLower(cfg.Zone), ".")
+
+	return &Writer{cfg: cfg, changed: make(chan struct{}, 1)}, nil
+}
+
+// Publish causes the names to be rewritten if event changes the Peers of the
+// device.
+func (w *Writer) Publish(ctx context.Context, event *client.Event) error {
+	switch event.Type {
+	case client.EventPeerAdded, client.EventPeerUpdated, client.EventPeerRemoved:
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Run writes the names whenever the Peers of the device change, and every
+// interval such that changes made outside of WG-API are reflected, until ctx
+// is cancelled.
+func (w *Writer) Run(ctx context.Context, c client.Client, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := w.sync(ctx, c); err != nil {
+			log.Printf("error: dns: could not write names of peers: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-w.changed:
+		case <-t.C:
+		}
+	}
+}
+
+// record is the address of a named Peer.
+type record struct {
+	name string
+	ip   net.IP
+}
+
+// sync writes the names if the names or addresses of Peers have changed.
+func (w *Writer) sync(ctx context.Context, c client.Client) error {
+	list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return err
+	}
+
+	records := w.records(list.Peers)
+	if w.last != nil && recordsEqual(records, w.last) {
+		return nil
+	}
+
+	w.table.Store(newTable(records, dnsmsg.Fqdn(w.cfg.Zone)))
+
+	if w.cfg.HostsFile != "" {
+		if err := writeFile(w.cfg.HostsFile, w.hosts(records)); err != nil {
+			return err
+		}
+	}
+
+	if w.cfg.ZoneFile != "" {
+		if err := writeFile(w.cfg.ZoneFile, w.zone(records)); err != nil {
+			return err
+		}
+	}
+
+	if w.cfg.Update != nil {
+		if err := w.update(w.last, records); err != nil {
+			return err
+		}
+	}
+
+	if records == nil {
+		records = []record{}
+	}
+
+	w.last = records
+
+	return nil
+}
+
+// records returns the address of every named Peer, sorted by name. Only
+// allowed ips of a single address are named. If many Peers have the same
+// name, the name is given to the first by public key.
+func (w *Writer) records(peers []*client.Peer) []record {
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PublicKey < peers[j].PublicKey })
+
+	owners := make(map[string]string)
+	var records []record
+
+	for _, peer := range peers {
+		name := label(peer.Metadata[w.cfg.NameMetadata])
+		if name == "" {
+			continue
+		}
+
+		if owner, ok := owners[name]; ok {
+			log.Printf("warn: dns: name %q of %s is already given to %s\n", name, peer.PublicKey, owner)
+			continue
+		}
+
+		owners[name] = peer.PublicKey
+
+		for _, allowedIP := range peer.AllowedIPs {
+			ip, n, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				continue
+			}
+
+			if ones, bits := n.Mask.Size(); ones == bits {
+				records = append(records, record{name: name, ip: ip})
+			}
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].name < records[j].name })
+
+	return records
+}
+
+// label converts the name of a Peer into a DNS label, replacing any
+// character that is not a letter, digit or hyphen.
+func label(name string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+
+	s := strings.Trim(b.String(), "-")
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+
+	return s
+}
+
+func recordsEqual(a, b []record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].name != b[i].name || !a[i].ip.Equal(b[i].ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hosts renders records in hosts format.
+func (w *Writer) hosts(records []record) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("# generated by wg-api, do not edit\n")
+
+	for _, r := range records {
+		if w.cfg.Zone != "" {
+			fmt.Fprintf(&buf, "%s\t%s.%s %s\n", r.ip, r.name, w.cfg.Zone, r.name)
+		} else {
+			fmt.Fprintf(&buf, "%s\t%s\n", r.ip, r.name)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// zone renders records as an RFC 1035 zone, with a serial of the current
+// time.
+func (w *Writer) zone(records []record) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "; generated by wg-api, do not edit\n")
+	fmt.Fprintf(&buf, "$ORIGIN %s.\n", w.cfg.Zone)
+	fmt.Fprintf(&buf, "$TTL %d\n", zoneTTL)
+	fmt.Fprintf(&buf, "@\tIN\tSOA\tns.%s. hostmaster.%s. %d 3600 600 86400 %d\n", w.cfg.Zone, w.cfg.Zone, time.Now().Unix(), zoneTTL)
+	fmt.Fprintf(&buf, "@\tIN\tNS\tns.%s.\n", w.cfg.Zone)
+
+	for _, r := range records {
+		rrType := "A"
+		if r.ip.To4() == nil {
+			rrType = "AAAA"
+		}
+
+		fmt.Fprintf(&buf, "%s\tIN\t%s\t%s\n", r.name, rrType, r.ip)
+	}
+
+	return buf.Bytes()
+}
+
+// writeFile replaces the file at filename with data, such that readers never
+// see a partially written file.
+func writeFile(filename string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
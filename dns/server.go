@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	dnsmsg "github.com/miekg/dns"
+)
+
+// table is the records served by the embedded DNS server, indexed by the
+// fully qualified name of each Peer and by reverse name of each address.
+type table struct {
+	names   map[string][]net.IP
+	reverse map[string]string
+	serial  uint32
+}
+
+func newTable(records []record, zone string) *table {
+	t := &table{
+		names:   make(map[string][]net.IP),
+		reverse: make(map[string]string),
+		serial:  uint32(time.Now().Unix()),
+	}
+
+	for _, r := range records {
+		name := r.name + "." + zone
+
+		t.names[name] = append(t.names[name], r.ip)
+
+		if arpa, err := dnsmsg.ReverseAddr(r.ip.String()); err == nil {
+			t.reverse[arpa] = name
+		}
+	}
+
+	return t
+}
+
+// Serve answers A, AAAA and PTR queries for the names of Peers on the address
+// of Config.Listen, over UDP and TCP, until ctx is cancelled. Queries outside
+// of the zone or reverse zones of Peers are refused.
+func (w *Writer) Serve(ctx context.Context) error {
+	errs := make(chan error, 2)
+	servers := []*dnsmsg.Server{
+		{Addr: w.cfg.Listen, Net: "udp", Handler: w},
+		{Addr: w.cfg.Listen, Net: "tcp", Handler: w},
+	}
+
+	for _, s := range servers {
+		go func(s *dnsmsg.Server) {
+			errs <- s.ListenAndServe()
+		}(s)
+	}
+
+	select {
+	case <-ctx.Done():
+		for _, s := range servers {
+			s.Shutdown()
+		}
+
+		return nil
+
+	case err := <-errs:
+		for _, s := range servers {
+			s.Shutdown()
+		}
+
+		return err
+	}
+}
+
+// ServeDNS answers a query from the names of Peers last synced.
+func (w *Writer) ServeDNS(rw dnsmsg.ResponseWriter, req *dnsmsg.Msg) {
+	res := new(dnsmsg.Msg)
+	res.SetReply(req)
+
+	if len(req.Question) != 1 {
+		res.Rcode = dnsmsg.RcodeFormatError
+		rw.WriteMsg(res)
+		return
+	}
+
+	t := w.table.Load()
+	if t == nil {
+		res.Rcode = dnsmsg.RcodeServerFailure
+		rw.WriteMsg(res)
+		return
+	}
+
+	zone := dnsmsg.Fqdn(w.cfg.Zone)
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+
+	switch {
+	case dnsmsg.IsSubDomain(zone, name):
+		res.Authoritative = true
+
+		if ips, ok := t.names[name]; ok {
+			for _, ip := range ips {
+				if ip4 := ip.To4(); ip4 != nil && (q.Qtype == dnsmsg.TypeA || q.Qtype == dnsmsg.TypeANY) {
+					res.Answer = append(res.Answer, &dnsmsg.A{Hdr: answerHeader(q.Name, dnsmsg.TypeA), A: ip4})
+				} else if ip4 == nil && (q.Qtype == dnsmsg.TypeAAAA || q.Qtype == dnsmsg.TypeANY) {
+					res.Answer = append(res.Answer, &dnsmsg.AAAA{Hdr: answerHeader(q.Name, dnsmsg.TypeAAAA), AAAA: ip})
+				}
+			}
+		} else if name == zone && q.Qtype == dnsmsg.TypeSOA {
+			res.Answer = append(res.Answer, soa(zone, t.serial))
+		} else if name != zone {
+			res.Rcode = dnsmsg.RcodeNameError
+		}
+
+		if len(res.Answer) == 0 {
+			res.Ns = append(res.Ns, soa(zone, t.serial))
+		}
+
+	case strings.HasSuffix(name, ".in-addr.arpa.") || strings.HasSuffix(name, ".ip6.arpa."):
+		target, ok := t.reverse[name]
+		if !ok {
+			res.Rcode = dnsmsg.RcodeNameError
+		} else if q.Qtype == dnsmsg.TypePTR || q.Qtype == dnsmsg.TypeANY {
+			res.Authoritative = true
+			res.Answer = append(res.Answer, &dnsmsg.PTR{Hdr: answerHeader(q.Name, dnsmsg.TypePTR), Ptr: target})
+		}
+
+	default:
+		res.Rcode = dnsmsg.RcodeRefused
+	}
+
+	rw.WriteMsg(res)
+}
+
+func answerHeader(name string, rrType uint16) dnsmsg.RR_Header {
+	return dnsmsg.RR_Header{Name: name, Rrtype: rrType, Class: dnsmsg.ClassINET, Ttl: zoneTTL}
+}
+
+// soa returns the start of authority of zone, as written to zone files.
+func soa(zone string, serial uint32) dnsmsg.RR {
+	return &dnsmsg.SOA{
+		Hdr:     answerHeader(zone, dnsmsg.TypeSOA),
+		Ns:      "ns." + zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  zoneTTL,
+	}
+}
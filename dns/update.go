@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"fmt"
+	"time"
+
+	dnsmsg "github.com/miekg/dns"
+)
+
+// UpdateConfig configures dynamic updates (RFC 2136) of the records of Peers
+// in a zone of a DNS server, such as BIND, Knot or PowerDNS.
+type UpdateConfig struct {
+	// Server is the address of the primary DNS server of the zone, such as
+	// ns1.example.com:53.
+	Server string
+
+	// TSIGName, TSIGSecret and TSIGAlgorithm, if set, sign each update with
+	// the named key. TSIGSecret is base64 encoded, and TSIGAlgorithm is by
+	// default hmac-sha256.
+	TSIGName      string
+	TSIGSecret    string
+	TSIGAlgorithm string
+}
+
+// updateTimeout is how long an update may take before it is retried on the
+// next change or interval.
+const updateTimeout = 10 * time.Second
+
+// update sends the changes from old to records to the DNS server. If old is
+// nil, as the records previously sent are unknown, every name in records is
+// replaced.
+func (w *Writer) update(old, records []record) error {
+	cfg := w.cfg.Update
+	zone := dnsmsg.Fqdn(w.cfg.Zone)
+
+	m := new(dnsmsg.Msg)
+	m.SetUpdate(zone)
+
+	if old == nil {
+		seen := make(map[string]bool)
+
+		for _, r := range records {
+			if !seen[r.name] {
+				seen[r.name] = true
+
+				m.RemoveRRset([]dnsmsg.RR{
+					&dnsmsg.A{Hdr: header(r.name, zone, dnsmsg.TypeA)},
+					&dnsmsg.AAAA{Hdr: header(r.name, zone, dnsmsg.TypeAAAA)},
+				})
+			}
+		}
+	} else if removed := difference(old, records); len(removed) > 0 {
+		m.Remove(resourceRecords(removed, zone))
+	}
+
+	if added := difference(records, old); len(added) > 0 {
+		m.Insert(resourceRecords(added, zone))
+	}
+
+	if len(m.Ns) == 0 {
+		return nil
+	}
+
+	c := &dnsmsg.Client{Net: "tcp", Timeout: updateTimeout}
+
+	if cfg.TSIGName != "" {
+		name := dnsmsg.Fqdn(cfg.TSIGName)
+
+		alg := dnsmsg.HmacSHA256
+		if cfg.TSIGAlgorithm != "" {
+			alg = dnsmsg.Fqdn(cfg.TSIGAlgorithm)
+		}
+
+		c.TsigSecret = map[string]string{name: cfg.TSIGSecret}
+		m.SetTsig(name, alg, 300, time.Now().Unix())
+	}
+
+	res, _, err := c.Exchange(m, cfg.Server)
+	if err != nil {
+		return err
+	} else if res.Rcode != dnsmsg.RcodeSuccess {
+		return fmt.Errorf("update of zone %s rejected: %s", w.cfg.Zone, dnsmsg.RcodeToString[res.Rcode])
+	}
+
+	return nil
+}
+
+func header(name, zone string, rrType uint16) dnsmsg.RR_Header {
+	return dnsmsg.RR_Header{Name: name + "." + zone, Rrtype: rrType, Class: dnsmsg.ClassINET, Ttl: zoneTTL}
+}
+
+// resourceRecords converts records into A and AAAA records of zone.
+func resourceRecords(records []record, zone string) []dnsmsg.RR {
+	rrs := make([]dnsmsg.RR, len(records))
+
+	for i, r := range records {
+		if ip4 := r.ip.To4(); ip4 != nil {
+			rrs[i] = &dnsmsg.A{Hdr: header(r.name, zone, dnsmsg.TypeA), A: ip4}
+		} else {
+			rrs[i] = &dnsmsg.AAAA{Hdr: header(r.name, zone, dnsmsg.TypeAAAA), AAAA: r.ip}
+		}
+	}
+
+	return rrs
+}
+
+// difference returns the records of a that are not in b.
+func difference(a, b []record) []record {
+	in := make(map[string]bool, len(b))
+	for _, r := range b {
+		in[r.name+" "+r.ip.String()] = true
+	}
+
+	var diff []record
+	for _, r := range a {
+		if !in[r.name+" "+r.ip.String()] {
+			diff = append(diff, r)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,274 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultAlertMessage is the Template of the message of each alert, unless
+// configured otherwise.
+const DefaultAlertMessage = "[{{.Alert.State}}] {{.Device}}: {{.Alert.Message}}"
+
+// PagerDutyURL is the Events API v2 endpoint alerts are sent to PagerDuty
+// through.
+const PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// notifyTimeout is how long an alert may take to be delivered.
+const notifyTimeout = 10 * time.Second
+
+// Notifier delivers alert.firing and alert.resolved Events to people through
+// webhooks, Slack, email or PagerDuty, ignoring every other Event. Each
+// destination is rate limited, such that many alerts firing at once, such as
+// every Peer going offline, do not flood it.
+type Notifier struct {
+	message  *Template
+	channels []*channel
+	http     *http.Client
+}
+
+var _ server.EventSink = (*Notifier)(nil)
+
+// NotifierConfig configures the destinations of alerts. At least one is
+// required.
+type NotifierConfig struct {
+	// Webhook, if set, is a URL each alert is POSTed to as the JSON of its
+	// Event, with the rendered message as message.
+	Webhook string
+
+	// Slack, if set, is the URL of a Slack incoming webhook.
+	Slack string
+
+	// PagerDutyKey, if set, is the routing key of a PagerDuty Events API v2
+	// integration. Alerts are triggered and resolved by rule and subject.
+	PagerDutyKey string
+
+	// Email, if set, sends each alert by email.
+	Email *EmailConfig
+
+	// Message is a Template of the message of each alert, by default
+	// DefaultAlertMessage.
+	Message string
+
+	// RateLimit is the number of alerts delivered to each destination per
+	// minute, further alerts are dropped. By default 10.
+	RateLimit int
+}
+
+// EmailConfig configures delivery of alerts by email.
+type EmailConfig struct {
+	// SMTP is the host:port of the mail server.
+	SMTP string
+
+	From string
+	To   []string
+
+	// Username and Password, if set, authenticate with the mail server.
+	Username string
+	Password string
+}
+
+// channel is a destination of alerts.
+type channel struct {
+	name    string
+	limiter *rate.Limiter
+	notify  func(ctx context.Context, event *client.Event, message string) error
+}
+
+// NewNotifier returns a Notifier delivering alerts to each destination
+// configured by cfg.
+func NewNotifier(cfg *NotifierConfig) (*Notifier, error) {
+	text := cfg.Message
+	if text == "" {
+		text = DefaultAlertMessage
+	}
+
+	message, err := ParseTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	limit := cfg.RateLimit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	n := &Notifier{message: message, http: &http.Client{Timeout: notifyTimeout}}
+
+	add := func(name string, notify func(context.Context, *client.Event, string) error) {
+		n.channels = append(n.channels, &channel{
+			name:    name,
+			limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(limit)), limit),
+			notify:  notify,
+		})
+	}
+
+	if cfg.Webhook != "" {
+		add("webhook", func(ctx context.Context, event *client.Event, message string) error {
+			return n.post(ctx, cfg.Webhook, &struct {
+				*client.Event
+				Message string `json:"message"`
+			}{event, message})
+		})
+	}
+
+	if cfg.Slack != "" {
+		add("slack", func(ctx context.Context, event *client.Event, message string) error {
+			return n.post(ctx, cfg.Slack, map[string]string{"text": message})
+		})
+	}
+
+	if cfg.PagerDutyKey != "" {
+		add("pagerduty", func(ctx context.Context, event *client.Event, message string) error {
+			return n.post(ctx, PagerDutyURL, pagerDutyEvent(cfg.PagerDutyKey, event, message))
+		})
+	}
+
+	if cfg.Email != nil {
+		if cfg.Email.SMTP == "" || cfg.Email.From == "" || len(cfg.Email.To) == 0 {
+			return nil, fmt.Errorf("smtp server, from and to addresses are required for email")
+		}
+
+		add("email", func(ctx context.Context, event *client.Event, message string) error {
+			return sendEmail(cfg.Email, event, message)
+		})
+	}
+
+	if len(n.channels) == 0 {
+		return nil, fmt.Errorf("webhook, slack, pagerduty or email is required")
+	}
+
+	return n, nil
+}
+
+// Publish delivers event to every destination, if it is an alert.
+func (n *Notifier) Publish(ctx context.Context, event *client.Event) error {
+	if event.Alert == nil {
+		return nil
+	}
+
+	message, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, c := range n.channels {
+		if !c.limiter.Allow() {
+			log.Printf("warn: alerts: rate limit of %s exceeded, dropped alert %s\n", c.name, event.Alert.Rule)
+			continue
+		}
+
+		if err := c.notify(ctx, event, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// post sends v as JSON to url.
+func (n *Notifier) post(ctx context.Context, url string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected http status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// pagerDutyEvent returns the PagerDuty Events API v2 event of an alert,
+// deduplicated by the rule and subject of the alert such that it is resolved
+// by the same key it was triggered by.
+func pagerDutyEvent(routingKey string, event *client.Event, message string) interface{} {
+	action := "trigger"
+	if event.Alert.State == client.AlertResolved {
+		action = "resolve"
+	}
+
+	dedupKey := event.Device + "/" + event.Alert.Rule
+	if event.Alert.Subject != "" {
+		dedupKey += "/" + event.Alert.Subject
+	}
+
+	return map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": action,
+		"dedup_key":    dedupKey,
+		"payload": map[string]interface{}{
+			"summary":        message,
+			"source":         event.Device,
+			"severity":       "warning",
+			"timestamp":      event.Time.Format(time.RFC3339),
+			"component":      event.Alert.Subject,
+			"class":          event.Alert.Kind,
+			"custom_details": event.Alert,
+		},
+	}
+}
+
+// sendEmail sends an alert by email, with the message as its subject.
+func sendEmail(cfg *EmailConfig, event *client.Event, message string) error {
+	var body bytes.Buffer
+
+	fmt.Fprintf(&body, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(message))
+	fmt.Fprintf(&body, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&body, "%s\r\n\r\n", event.Alert.Message)
+	fmt.Fprintf(&body, "Rule: %s\r\n", event.Alert.Rule)
+	fmt.Fprintf(&body, "State: %s\r\n", event.Alert.State)
+	fmt.Fprintf(&body, "Device: %s\r\n", event.Device)
+
+	if event.Alert.Subject != "" {
+		fmt.Fprintf(&body, "About: %s\r\n", event.Alert.Subject)
+	}
+
+	fmt.Fprintf(&body, "Since: %s\r\n", event.Alert.Since.Format(time.RFC3339))
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host := cfg.SMTP
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	return smtp.SendMail(cfg.SMTP, auth, cfg.From, cfg.To, body.Bytes())
+}
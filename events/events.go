@@ -0,0 +1,38 @@
+// Package events implements destinations for the Events published by WG-API
+// when the Peers of a device change, such as message queues.
+package events
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// Template renders a destination, such as a subject or topic, for an Event.
+// Templates use text/template syntax with the fields of client.Event, for
+// example "wg-api.{{.Device}}.{{.Type}}".
+type Template struct {
+	tmpl *template.Template
+}
+
+// ParseTemplate parses a destination Template.
+func ParseTemplate(text string) (*Template, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Render returns the destination of event.
+func (t *Template) Render(event *client.Event) (string, error) {
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, event); err != nil {
+		return "", fmt.Errorf("could not render template: %w", err)
+	}
+
+	return sb.String(), nil
+}
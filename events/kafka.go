@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SASL mechanisms supported by Kafka.
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+// Kafka produces Events as JSON messages, keyed by the public key of the
+// Peer such that Events of a Peer are kept in order.
+type Kafka struct {
+	writer *kafka.Writer
+	topic  *Template
+}
+
+var _ server.EventSink = (*Kafka)(nil)
+
+// KafkaConfig configures the producer of a Kafka EventSink.
+type KafkaConfig struct {
+	Brokers []string
+
+	// Topic is a Template for the topic of each Event, such as "wg-api" or
+	// "wg-api.{{.Type}}".
+	Topic string
+
+	// SASLMechanism is one of plain, scram-sha-256 or scram-sha-512, if
+	// authentication is required.
+	SASLMechanism string
+	Username      string
+	Password      string
+
+	// TLS, if set, is used to connect to the brokers.
+	TLS *tls.Config
+}
+
+// NewKafka configures a Kafka producer. Brokers are not connected to until
+// the first Event is published.
+func NewKafka(cfg *KafkaConfig) (*Kafka, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("at least one broker is required")
+	}
+
+	topic, err := ParseTemplate(cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, err := saslMechanism(cfg.SASLMechanism, cfg.Username, cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Kafka{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// events are published one at a time, so are not batched.
+			BatchSize: 1,
+			Transport: &kafka.Transport{
+				SASL: mechanism,
+				TLS:  cfg.TLS,
+			},
+		},
+		topic: topic,
+	}, nil
+}
+
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+
+	case SASLPlain:
+		return plain.Mechanism{Username: username, Password: password}, nil
+
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, username, password)
+
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, username, password)
+
+	default:
+		return nil, fmt.Errorf("unknown sasl mechanism %q", name)
+	}
+}
+
+// Publish produces event to the topic rendered for it.
+func (k *Kafka) Publish(ctx context.Context, event *client.Event) error {
+	topic, err := k.topic.Render(event)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return k.writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(event.PublicKey),
+		Value: data,
+		Time:  event.Time,
+	})
+}
+
+// Close flushes any pending Events and closes connections to the brokers.
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
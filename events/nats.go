@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS publishes Events as JSON to NATS subjects, optionally with JetStream
+// such that publishing is acknowledged by the server.
+type NATS struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject *Template
+}
+
+var _ server.EventSink = (*NATS)(nil)
+
+// NATSConfig configures the connection of a NATS EventSink.
+type NATSConfig struct {
+	// URL of the NATS servers, comma separated.
+	URL string
+
+	// Subject is a Template for the subject of each Event, such as
+	// "wg-api.{{.Device}}.{{.Type}}".
+	Subject string
+
+	// Credentials, if set, is a NATS credentials file used to authenticate.
+	Credentials string
+
+	// JetStream publishes Events to a JetStream stream, which must already
+	// exist, waiting for each to be acknowledged.
+	JetStream bool
+}
+
+// NewNATS connects to NATS.
+func NewNATS(cfg *NATSConfig) (*NATS, error) {
+	subject, err := ParseTemplate(cfg.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []nats.Option{nats.Name("wg-api")}
+	if cfg.Credentials != "" {
+		opts = append(opts, nats.UserCredentials(cfg.Credentials))
+	}
+
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &NATS{conn: conn, subject: subject}
+
+	if cfg.JetStream {
+		n.js, err = jetstream.New(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// Publish sends event to the subject rendered for it.
+func (n *NATS) Publish(ctx context.Context, event *client.Event) error {
+	subject, err := n.subject.Render(event)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if n.js != nil {
+		_, err := n.js.Publish(ctx, subject, data)
+		return err
+	}
+
+	return n.conn.Publish(subject, data)
+}
+
+// Close flushes any pending Events and closes the connection.
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,32 @@
+package events
+
+import (
+	"context"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/redact"
+	"github.com/jamescun/wg-api/server"
+)
+
+// redacted publishes Events to an EventSink with the public keys and
+// endpoints of their Peers redacted.
+type redacted struct {
+	sink server.EventSink
+	r    *redact.Redactor
+}
+
+// Redacted returns an EventSink publishing Events to sink with the public
+// keys and endpoints of Peers redacted by r, such that destinations outside
+// of the control of the operator of the device only receive what they need.
+// If r is nil, sink is returned unchanged.
+func Redacted(sink server.EventSink, r *redact.Redactor) server.EventSink {
+	if r == nil {
+		return sink
+	}
+
+	return &redacted{sink: sink, r: r}
+}
+
+func (s *redacted) Publish(ctx context.Context, event *client.Event) error {
+	return s.sink.Publish(ctx, s.r.Event(event))
+}
@@ -0,0 +1,192 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis publishes Events as JSON to Redis channels, and optionally mirrors
+// the Peers of the device into a Redis hash keyed by public key, such that
+// they may be read without calling WG-API.
+type Redis struct {
+	client  *redis.Client
+	channel *Template
+	mirror  *Template
+}
+
+var _ server.EventSink = (*Redis)(nil)
+
+// RedisConfig configures the connection of a Redis EventSink.
+type RedisConfig struct {
+	// URL of the Redis server, such as redis://localhost:6379/0.
+	URL string
+
+	// Channel is a Template for the channel of each Event, such as
+	// "wg-api.{{.Device}}.{{.Type}}". If empty, Events are not published.
+	Channel string
+
+	// Mirror is a Template for the key of the hash the Peers of the device
+	// are mirrored into, such as "wg-api:{{.Device}}:peers". If empty, Peers
+	// are not mirrored.
+	Mirror string
+}
+
+// NewRedis connects to Redis.
+func NewRedis(ctx context.Context, cfg *RedisConfig) (*Redis, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Redis{client: redis.NewClient(opts)}
+
+	if cfg.Channel != "" {
+		if r.channel, err = ParseTemplate(cfg.Channel); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Mirror != "" {
+		if r.mirror, err = ParseTemplate(cfg.Mirror); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		r.client.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Publish sends event to the channel rendered for it, and updates the mirror
+// of the Peer it relates to.
+func (r *Redis) Publish(ctx context.Context, event *client.Event) error {
+	if r.channel != nil {
+		channel, err := r.channel.Render(event)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		if err := r.client.Publish(ctx, channel, data).Err(); err != nil {
+			return err
+		}
+	}
+
+	if r.mirror != nil {
+		return r.mirrorEvent(ctx, event)
+	}
+
+	return nil
+}
+
+// mirrorEvent updates the mirrored Peer an Event relates to.
+func (r *Redis) mirrorEvent(ctx context.Context, event *client.Event) error {
+	peer := event.Peer
+	if event.Change != nil {
+		peer = event.Change.After
+	}
+
+	if event.PublicKey == "" || (peer == nil && event.Type != client.EventPeerRemoved) {
+		return nil
+	}
+
+	key, err := r.mirror.Render(event)
+	if err != nil {
+		return err
+	}
+
+	if event.Type == client.EventPeerRemoved {
+		return r.client.HDel(ctx, key, event.PublicKey).Err()
+	}
+
+	data, err := json.Marshal(peer)
+	if err != nil {
+		return err
+	}
+
+	return r.client.HSet(ctx, key, event.PublicKey, data).Err()
+}
+
+// Mirror replaces the mirror of the Peers of the device every interval,
+// such that changes made outside of WG-API and usage stats are reflected,
+// until ctx is cancelled. It does nothing if mirroring is not configured.
+func (r *Redis) Mirror(ctx context.Context, c client.Client, interval time.Duration) {
+	if r.mirror == nil {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := r.sync(ctx, c); err != nil {
+			log.Printf("error: redis: could not mirror peers: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// sync replaces the mirror of the Peers of the device.
+func (r *Redis) sync(ctx context.Context, c client.Client) error {
+	info, err := c.GetDeviceInfo(ctx, &client.GetDeviceInfoRequest{})
+	if err != nil {
+		return err
+	}
+
+	list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return err
+	}
+
+	key, err := r.mirror.Render(&client.Event{Device: info.Device.Name})
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{}, len(list.Peers))
+	for _, peer := range list.Peers {
+		data, err := json.Marshal(peer)
+		if err != nil {
+			return err
+		}
+
+		values[peer.PublicKey] = data
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+
+		if len(values) > 0 {
+			pipe.HSet(ctx, key, values)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Close closes the connection to Redis.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
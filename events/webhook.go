@@ -0,0 +1,271 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+)
+
+// Headers of the requests of a Webhook.
+const (
+	// WebhookSignatureHeader is the hex HMAC-SHA256 of the timestamp and
+	// body of a request, as sha256=<hex>, keyed by the secret of the
+	// Webhook.
+	WebhookSignatureHeader = "Wg-Api-Signature"
+
+	// WebhookTimestampHeader is the Unix time a request was signed, such
+	// that a receiver may refuse requests replayed long after.
+	WebhookTimestampHeader = "Wg-Api-Timestamp"
+
+	// WebhookDeliveryHeader uniquely identifies an Event, and is the same for
+	// every attempt to deliver it, such that a receiver may ignore retries of
+	// Events it has already received.
+	WebhookDeliveryHeader = "Wg-Api-Delivery"
+)
+
+const (
+	// webhookTimeout is how long each attempt to deliver an Event may take.
+	webhookTimeout = 10 * time.Second
+
+	// webhookQueueSize is the number of Events that may be waiting to be
+	// delivered, further Events are dropped.
+	webhookQueueSize = 1024
+
+	// webhookMaxBackoff is the longest delay between attempts to deliver an
+	// Event.
+	webhookMaxBackoff = time.Minute
+)
+
+// DefaultWebhookEvents are the types of Event delivered by a Webhook unless
+// configured otherwise, those of changes to the Peers of the device.
+var DefaultWebhookEvents = []string{client.EventPeerAdded, client.EventPeerUpdated, client.EventPeerRemoved}
+
+// Webhook POSTs Events as JSON to a URL, signed with a secret such that the
+// receiver can verify they were sent by WG-API. Events are delivered in the
+// order they were published, each retried with exponential backoff while the
+// receiver cannot be reached or fails, without delaying other EventSinks.
+type Webhook struct {
+	url     string
+	secret  []byte
+	types   map[string]bool
+	retries int
+	backoff time.Duration
+
+	http   *http.Client
+	queue  chan *client.Event
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ server.EventSink = (*Webhook)(nil)
+
+// WebhookConfig configures the destination of a Webhook.
+type WebhookConfig struct {
+	URL string
+
+	// Secret, if set, signs every request in WebhookSignatureHeader.
+	Secret string
+
+	// Types of Event delivered, by default DefaultWebhookEvents.
+	Types []string
+
+	// Retries is the number of times an Event is retried before it is
+	// dropped, by default 5.
+	Retries int
+
+	// Backoff is the delay before the first retry, which doubles with every
+	// retry up to a minute, by default 1 second.
+	Backoff time.Duration
+}
+
+// NewWebhook returns a Webhook delivering Events to cfg.URL.
+func NewWebhook(cfg *WebhookConfig) (*Webhook, error) {
+	if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, fmt.Errorf("invalid webhook url %q", cfg.URL)
+	}
+
+	types := cfg.Types
+	if len(types) == 0 {
+		types = DefaultWebhookEvents
+	}
+
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = 5
+	}
+
+	backoff := cfg.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := &Webhook{
+		url:     cfg.URL,
+		secret:  []byte(cfg.Secret),
+		types:   make(map[string]bool, len(types)),
+		retries: retries,
+		backoff: backoff,
+		http:    &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan *client.Event, webhookQueueSize),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	for _, t := range types {
+		w.types[t] = true
+	}
+
+	go w.run(ctx)
+
+	return w, nil
+}
+
+// Publish queues event to be delivered, if it is of the types of the
+// Webhook. If the queue is full, such as while the receiver is down, the
+// Event is dropped.
+func (w *Webhook) Publish(ctx context.Context, event *client.Event) error {
+	if !w.types[event.Type] {
+		return nil
+	}
+
+	select {
+	case w.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full")
+	}
+}
+
+// Close stops delivering Events, abandoning any still queued.
+func (w *Webhook) Close() error {
+	w.cancel()
+	<-w.done
+
+	return nil
+}
+
+// run delivers queued Events until ctx is cancelled.
+func (w *Webhook) run(ctx context.Context) {
+	defer close(w.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event := <-w.queue:
+			if err := w.deliver(ctx, event); err != nil && ctx.Err() == nil {
+				log.Printf("error: webhook: dropped %s event: %s\n", event.Type, err)
+			}
+		}
+	}
+}
+
+// deliver POSTs event to the Webhook, retrying with backoff until it is
+// accepted, the receiver refuses it, or the retries are exhausted.
+func (w *Webhook) deliver(ctx context.Context, event *client.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	id, err := deliveryID()
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, id, data)
+		if err == nil {
+			return nil
+		} else if !retry || attempt >= w.retries || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("warn: webhook: could not deliver %s event, retrying in %s: %s\n", event.Type, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+}
+
+// post sends data to the Webhook once, returning whether it should be
+// retried if it was not accepted.
+func (w *Webhook) post(ctx context.Context, id string, data []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, id)
+
+	if len(w.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(w.secret, timestamp, data))
+	}
+
+	res, err := w.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		err := fmt.Errorf("unexpected http status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+
+		// other client errors will fail however many times they are retried.
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusRequestTimeout
+
+		return retry, err
+	}
+
+	return false, nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of timestamp and body, separated
+// by a period, keyed by secret, as given in WebhookSignatureHeader.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryID returns a new random identifier of a delivery.
+func deliveryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/metrics"
+
+	flag "github.com/spf13/pflag"
+)
+
+const exporterHelp = `Expose a WG-API server as Prometheus metrics
+Usage: wg-api exporter [options]
+
+Metrics are collected from the server through its API on every scrape, such
+that they may be exposed from a host other than the one running the server.
+
+Options:
+  --server=<url>          address of WG-API server
+                          (default http://localhost:8080)
+  --token=<token>         authentication token, may also be given with the
+                          WGAPI_TOKEN environment variable
+  --listen=<[host:]port>  address where metrics are served on /metrics
+                          (default localhost:9586)
+  --redact=<mode>         redact the public keys of peers in labels, one of
+                          hash or truncate. hashes are keyed by the
+                          WGAPI_REDACT_KEY environment variable if set.
+`
+
+func runExporter(args []string) {
+	fs := flag.NewFlagSet("exporter", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(exporterHelp) }
+
+	serverURL := fs.String("server", "http://localhost:8080", "")
+	token := fs.String("token", os.Getenv("WGAPI_TOKEN"), "")
+	listenAddr := fs.String("listen", "localhost:9586", "")
+	redactMode := fs.String("redact", "", "")
+
+	fs.Parse(args)
+
+	r, err := loadRedactor(*redactMode)
+	if err != nil {
+		exitError("--redact: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(client.NewHTTPClient(*serverURL, *token), r))
+
+	log.Printf("info: exporter: listening on http://%s/metrics for %s\n", *listenAddr, *serverURL)
+
+	if err := http.ListenAndServe(*listenAddr, mux); err != nil {
+		log.Fatalln("fatal: exporter:", err)
+	}
+}
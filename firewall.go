@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jamescun/wg-api/firewall"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	firewallFile   = flag.String("firewall", "", "")
+	flushConntrack = flag.Bool("flush-conntrack", false, "")
+)
+
+// firewallInterval is how often rules are reapplied regardless of events,
+// such that changes made outside of WG-API are reflected.
+const firewallInterval = time.Minute
+
+// loadFirewall returns the writer of the firewall rules of the peers of
+// device, if configured.
+func loadFirewall(device string) (*firewall.Writer, error) {
+	if *firewallFile == "" {
+		return nil, nil
+	} else if *enableSandbox {
+		return nil, errors.New("rules are applied by executing nft, which is denied by --sandbox")
+	}
+
+	cfg, err := firewall.LoadConfig(*firewallFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return firewall.NewWriter(cfg, device), nil
+}
@@ -0,0 +1,423 @@
+// Package firewall maintains nftables rules restricting the traffic of each
+// Peer by the firewall policies given to it in its metadata, such as denying
+// traffic between Peers or only allowing some ports, keyed by the allowed ips
+// of the Peer and kept in sync as Peers are added, updated or removed.
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultMetadata is the metadata of a Peer naming the policies given to it,
+// unless configured otherwise.
+const DefaultMetadata = "firewall"
+
+// Table is the nftables table the rules are kept in, which is replaced
+// whenever they change.
+const Table = "wg_api"
+
+// Config is the policies that may be given to Peers, read from a YAML (or
+// JSON) file.
+type Config struct {
+	// Metadata is the metadata of a Peer naming the policies given to it,
+	// separated by commas, by default DefaultMetadata.
+	Metadata string `yaml:"metadata"`
+
+	// Default is the policies of Peers without any in their metadata.
+	Default []string `yaml:"default"`
+
+	Policies map[string]*Policy `yaml:"policies"`
+
+	// Nft is the nft executable the rules are applied with, by default
+	// found in PATH.
+	Nft string `yaml:"nft"`
+}
+
+// Policy restricts the traffic a Peer may send through the device.
+type Policy struct {
+	// Isolate denies the Peer sending traffic to other Peers of the device.
+	Isolate bool `yaml:"isolate"`
+
+	// Allow, if set, are the only traffic the Peer may send, any other is
+	// denied. If a Peer is given many policies, it may send the traffic
+	// allowed by any of them.
+	Allow []*Rule `yaml:"allow"`
+}
+
+// Rule matches traffic sent by a Peer.
+type Rule struct {
+	// Proto is one of tcp, udp, icmp or icmpv6, or any if empty.
+	Proto string `yaml:"proto"`
+
+	// Ports are the destination ports of tcp or udp, or any if empty.
+	Ports []uint16 `yaml:"ports"`
+
+	// To are the destination addresses or ranges, or any if empty.
+	To []string `yaml:"to"`
+}
+
+// LoadConfig reads and validates the policies of a YAML (or JSON) file.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(Config)
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Metadata == "" {
+		cfg.Metadata = DefaultMetadata
+	}
+
+	if cfg.Nft == "" {
+		cfg.Nft = "nft"
+	}
+
+	for _, name := range cfg.Default {
+		if _, ok := cfg.Policies[name]; !ok {
+			return nil, fmt.Errorf("default policy %q does not exist", name)
+		}
+	}
+
+	for name, policy := range cfg.Policies {
+		if policy == nil {
+			return nil, fmt.Errorf("policy %q is empty", name)
+		}
+
+		for i, rule := range policy.Allow {
+			if err := rule.validate(); err != nil {
+				return nil, fmt.Errorf("policy %q: rule %d: %w", name, i, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func (r *Rule) validate() error {
+	if r == nil {
+		return fmt.Errorf("rule is empty")
+	}
+
+	switch r.Proto {
+	case "", "icmp", "icmpv6":
+		if len(r.Ports) > 0 {
+			return fmt.Errorf("ports require proto tcp or udp")
+		}
+
+	case "tcp", "udp":
+
+	default:
+		return fmt.Errorf("unknown proto %q", r.Proto)
+	}
+
+	for _, to := range r.To {
+		if _, _, err := parseCIDR(to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writer applies the rules of the policies of the Peers of the device
+// whenever they change. It is an EventSink, such that Peers being added,
+// updated or removed cause the rules to be reapplied.
+type Writer struct {
+	cfg     *Config
+	device  string
+	changed chan struct{}
+
+	// last is the ruleset last applied, such that rules are only applied
+	// when they change.
+	last []byte
+}
+
+var _ server.EventSink = (*Writer)(nil)
+
+// NewWriter returns a Writer of the rules of cfg for the Peers of device.
+func NewWriter(cfg *Config, device string) *Writer {
+	return &Writer{cfg: cfg, device: device, changed: make(chan struct{}, 1)}
+}
+
+// Publish causes the rules to be reapplied if event changes the Peers of the
+// device.
+func (w *Writer) Publish(ctx context.Context, event *client.Event) error {
+	switch event.Type {
+	case client.EventPeerAdded, client.EventPeerUpdated, client.EventPeerRemoved:
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Run applies the rules whenever the Peers of the device change, and every
+// interval such that changes made outside of WG-API are reflected, until ctx
+// is cancelled.
+func (w *Writer) Run(ctx context.Context, c client.Client, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := w.sync(ctx, c); err != nil {
+			log.Printf("error: firewall: could not apply rules of peers: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-w.changed:
+		case <-t.C:
+		}
+	}
+}
+
+// sync applies the rules if the policies or allowed ips of Peers have
+// changed.
+func (w *Writer) sync(ctx context.Context, c client.Client) error {
+	list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return err
+	}
+
+	ruleset := w.ruleset(list.Peers)
+	if w.last != nil && bytes.Equal(ruleset, w.last) {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, w.cfg.Nft, "-f", "-")
+	cmd.Stdin = bytes.NewReader(ruleset)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	w.last = ruleset
+
+	return nil
+}
+
+// policies returns the policies of peer, and whether they all exist.
+func (w *Writer) policies(peer *client.Peer) ([]*Policy, bool) {
+	names := w.cfg.Default
+
+	if value := strings.TrimSpace(peer.Metadata[w.cfg.Metadata]); value != "" {
+		names = strings.Split(value, ",")
+	}
+
+	var policies []*Policy
+
+	for _, name := range names {
+		policy, ok := w.cfg.Policies[strings.TrimSpace(name)]
+		if !ok {
+			log.Printf("warn: firewall: policy %q of %s does not exist, all of its traffic is denied\n", strings.TrimSpace(name), peer.PublicKey)
+			return nil, false
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, true
+}
+
+// ruleset renders the rules of every Peer given policies as a script of nft,
+// replacing the table of any rules previously applied at once. Each Peer is
+// given a chain, jumped to by traffic forwarded from the device with one of
+// its allowed ips as its source.
+func (w *Writer) ruleset(peers []*client.Peer) []byte {
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PublicKey < peers[j].PublicKey })
+
+	var forward, chains bytes.Buffer
+	var n int
+
+	for _, peer := range peers {
+		policies, ok := w.policies(peer)
+		if ok && len(policies) == 0 {
+			continue
+		}
+
+		var v4, v6 []string
+
+		for _, allowedIP := range peer.AllowedIPs {
+			if cidr, ip4, err := parseCIDR(allowedIP); err == nil && ip4 {
+				v4 = append(v4, cidr)
+			} else if err == nil {
+				v6 = append(v6, cidr)
+			}
+		}
+
+		if len(v4) == 0 && len(v6) == 0 {
+			continue
+		}
+
+		chain := "peer_" + strconv.Itoa(n)
+		n++
+
+		if len(v4) > 0 {
+			fmt.Fprintf(&forward, "\t\tiifname %q ip saddr { %s } jump %s comment %q\n", w.device, strings.Join(v4, ", "), chain, peer.PublicKey)
+		}
+
+		if len(v6) > 0 {
+			fmt.Fprintf(&forward, "\t\tiifname %q ip6 saddr { %s } jump %s comment %q\n", w.device, strings.Join(v6, ", "), chain, peer.PublicKey)
+		}
+
+		fmt.Fprintf(&chains, "\tchain %s {\n", chain)
+		w.chain(&chains, policies, ok)
+		fmt.Fprintf(&chains, "\t}\n")
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# generated by wg-api, do not edit\n")
+
+	// the table is created before being deleted, such that deleting it
+	// succeeds if it does not yet exist.
+	fmt.Fprintf(&buf, "table inet %s\n", Table)
+	fmt.Fprintf(&buf, "delete table inet %s\n", Table)
+	fmt.Fprintf(&buf, "table inet %s {\n", Table)
+	fmt.Fprintf(&buf, "\tchain forward {\n")
+	fmt.Fprintf(&buf, "\t\ttype filter hook forward priority filter; policy accept;\n")
+	buf.Write(forward.Bytes())
+	fmt.Fprintf(&buf, "\t}\n")
+	buf.Write(chains.Bytes())
+	fmt.Fprintf(&buf, "}\n")
+
+	return buf.Bytes()
+}
+
+// chain renders the rules of the chain of a Peer given policies, denying all
+// of its traffic if any of its policies do not exist.
+func (w *Writer) chain(buf *bytes.Buffer, policies []*Policy, ok bool) {
+	if !ok {
+		fmt.Fprintf(buf, "\t\tdrop\n")
+		return
+	}
+
+	// replies to connections made to the Peer are never denied.
+	fmt.Fprintf(buf, "\t\tct state established,related accept\n")
+
+	var restricted bool
+
+	for _, policy := range policies {
+		if policy.Isolate {
+			fmt.Fprintf(buf, "\t\toifname %q drop\n", w.device)
+			break
+		}
+	}
+
+	for _, policy := range policies {
+		if len(policy.Allow) == 0 {
+			continue
+		}
+
+		restricted = true
+
+		for _, rule := range policy.Allow {
+			for _, match := range rule.matches() {
+				fmt.Fprintf(buf, "\t\t%saccept\n", match)
+			}
+		}
+	}
+
+	if restricted {
+		fmt.Fprintf(buf, "\t\tdrop\n")
+	}
+}
+
+// matches renders the expressions of nft matching r, one for each family of
+// its destinations.
+func (r *Rule) matches() []string {
+	var proto string
+
+	switch r.Proto {
+	case "":
+
+	case "icmp":
+		proto = "meta l4proto icmp "
+
+	case "icmpv6":
+		proto = "meta l4proto ipv6-icmp "
+
+	default:
+		proto = "meta l4proto " + r.Proto + " "
+
+		if len(r.Ports) > 0 {
+			ports := make([]string, len(r.Ports))
+			for i, port := range r.Ports {
+				ports[i] = strconv.Itoa(int(port))
+			}
+
+			proto += "th dport { " + strings.Join(ports, ", ") + " } "
+		}
+	}
+
+	if len(r.To) == 0 {
+		return []string{proto}
+	}
+
+	var v4, v6 []string
+
+	for _, to := range r.To {
+		if cidr, ip4, err := parseCIDR(to); err == nil && ip4 {
+			v4 = append(v4, cidr)
+		} else if err == nil {
+			v6 = append(v6, cidr)
+		}
+	}
+
+	var matches []string
+
+	if len(v4) > 0 {
+		matches = append(matches, "ip daddr { "+strings.Join(v4, ", ")+" } "+proto)
+	}
+
+	if len(v6) > 0 {
+		matches = append(matches, "ip6 daddr { "+strings.Join(v6, ", ")+" } "+proto)
+	}
+
+	return matches
+}
+
+// parseCIDR parses an address or range, returning it as a range and whether
+// it is IPv4.
+func parseCIDR(s string) (string, bool, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", false, fmt.Errorf("invalid address %q", s)
+		} else if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String() + "/32", true, nil
+		}
+
+		return ip.String() + "/128", false, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid range %q", s)
+	}
+
+	return n.String(), n.IP.To4() != nil, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/codegen"
+
+	flag "github.com/spf13/pflag"
+)
+
+const genclientHelp = `Generate a typed client of the WG-API in another language
+Usage: wg-api genclient [options]
+
+The client has a typed method of every method of the API, and a type of the
+params and result of each, generated from the schema of the API.
+
+Options:
+  --lang=<lang>      (required) one of typescript or python
+  --schema=<schema>  JSON schema file, or the URL of a WG-API server whose
+                     /schema is used (default the schema of this build)
+  --token=<token>    authentication token of --schema, may also be given
+                     with the WGAPI_TOKEN environment variable
+  --output=<file>    file the client is written to (default stdout)
+  --print-schema     write the schema as JSON instead of a client
+`
+
+func runGenclient(args []string) {
+	fs := flag.NewFlagSet("genclient", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(genclientHelp) }
+
+	lang := fs.String("lang", "", "")
+	schemaSrc := fs.String("schema", "", "")
+	token := fs.String("token", os.Getenv("WGAPI_TOKEN"), "")
+	output := fs.String("output", "", "")
+	printSchema := fs.Bool("print-schema", false, "")
+
+	fs.Parse(args)
+
+	if *lang == "" && !*printSchema {
+		exitError("--lang is required")
+	}
+
+	schema, err := loadSchema(*schemaSrc, *token)
+	if err != nil {
+		exitError("could not load schema: %s", err)
+	}
+
+	var w io.Writer = os.Stdout
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			exitError("could not create output: %s", err)
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	if *printSchema {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(schema)
+	} else {
+		err = codegen.Generate(w, *lang, schema)
+	}
+
+	if err != nil {
+		exitError("could not generate client: %s", err)
+	}
+}
+
+// loadSchema reads the schema from a file, or the /schema of a server if src
+// is a URL, or returns the schema of this build if src is empty.
+func loadSchema(src, token string) (*client.Schema, error) {
+	if src == "" {
+		return client.NewSchema(), nil
+	}
+
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		return client.NewHTTPClient(src, token).GetSchema(ctx)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := new(client.Schema)
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
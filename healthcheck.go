@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+const healthcheckHelp = `Check a WG-API server is ready
+Usage: wg-api healthcheck [options]
+
+Exits 0 if the server is ready, otherwise 1, such that it may be used as the
+HEALTHCHECK of a container or the exec probe of a pod.
+
+Options:
+  --server=<url>       address of WG-API server (default http://localhost:8080)
+  --timeout=<dur>      how long the server may take to respond (default 5s)
+  --insecure           do not verify the TLS certificate of the server
+`
+
+func runHealthcheck(args []string) {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(healthcheckHelp) }
+
+	serverURL := fs.String("server", "http://localhost:8080", "")
+	timeout := fs.Duration("timeout", 5*time.Second, "")
+	insecure := fs.Bool("insecure", false, "")
+
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(*serverURL, "/")+"/readyz", nil)
+	if err != nil {
+		exitError("invalid server: %s", err)
+	}
+
+	hc := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+		},
+	}
+
+	res, err := hc.Do(req)
+	if err != nil {
+		exitError("server not ready: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		exitError("server not ready: http status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	fmt.Println("ok")
+}
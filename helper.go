@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/jamescun/wg-api/helper"
+	"github.com/jamescun/wg-api/sandbox"
+
+	flag "github.com/spf13/pflag"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+const helperHelp = `Serve a WireGuard device to an unprivileged WG-API server
+Usage: wg-api helper [options]
+
+The helper is the only process requiring CAP_NET_ADMIN, reading and
+configuring the peers of a single device on behalf of a WG-API server given
+--helper, which may then run as an unprivileged user.
+
+Options:
+  --device=<name>          (required) name of WireGuard device to serve
+  --socket=<file>          unix socket the helper listens on
+                           (default /run/wg-api/helper.sock)
+  --socket-group=<group>   group allowed to connect to the socket, otherwise
+                           only the user of the helper may connect
+  --share-private-key      give the private key of the device to the server,
+                           required by --admin-key and --self-service
+  --sandbox                once listening, deny system calls the helper never
+                           makes and access to files (Linux only)
+`
+
+func runHelper(args []string) {
+	fs := flag.NewFlagSet("helper", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(helperHelp) }
+
+	deviceName := fs.String("device", "", "")
+	socket := fs.String("socket", "/run/wg-api/helper.sock", "")
+	socketGroup := fs.String("socket-group", "", "")
+	sharePrivateKey := fs.Bool("share-private-key", false, "")
+	enableSandbox := fs.Bool("sandbox", false, "")
+
+	fs.Parse(args)
+
+	if *deviceName == "" {
+		exitError("--device is required")
+	}
+
+	wg, err := wgctrl.New()
+	if err != nil {
+		exitError("could not create WireGuard client: %s", err)
+	}
+
+	device, err := openDevice(wg, *deviceName)
+	if err != nil {
+		exitError("%s", err)
+	}
+
+	l, err := listenHelper(*socket, *socketGroup)
+	if err != nil {
+		exitError("could not listen on %s: %s", *socket, err)
+	}
+
+	if *enableSandbox {
+		if err := sandbox.Apply(sandbox.Options{ReadOnly: sandboxReadOnly}); err != nil {
+			exitError("could not sandbox helper: %s", err)
+		}
+	}
+
+	log.Printf("info: helper: serving %s on %s\n", device.Name, *socket)
+
+	err = helper.Serve(l, wg, helper.Options{Device: device.Name, SharePrivateKey: *sharePrivateKey})
+	if err != nil {
+		log.Fatalln("fatal: helper:", err)
+	}
+}
+
+// listenHelper listens on the unix socket, replacing any left by a previous
+// helper, such that only its user, and group if given, may connect.
+func listenHelper(socket, group string) (net.Listener, error) {
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0600)
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+
+		gid, err := strconv.Atoi(g.Gid)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.Chown(socket, -1, gid); err != nil {
+			return nil, err
+		}
+
+		mode = 0660
+	}
+
+	if err := os.Chmod(socket, mode); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
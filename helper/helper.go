@@ -0,0 +1,273 @@
+// Package helper separates reading and configuring WireGuard devices, which
+// requires CAP_NET_ADMIN, into a privileged helper process serving them on a
+// unix socket, such that the API may run unprivileged and a compromise of it
+// cannot drive netlink directly.
+//
+// The helper serves a single device, and only configures its Peers, never
+// its private key, listen port or firewall mark. Requests are encoded as
+// JSON, rather than gob, as gob does not distinguish a nil pointer from a
+// pointer to zero, such as a keepalive being disabled.
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+
+	"github.com/jamescun/wg-api/server"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Options restrict what the clients of a helper may do.
+type Options struct {
+	// Device is the only device that may be read or configured, every
+	// device of the host may be listed.
+	Device string
+
+	// SharePrivateKey returns the private key of Device to clients, which
+	// is required by admin keys and the self-service API.
+	SharePrivateKey bool
+}
+
+// ConfigureRequest is the request of the ConfigureDevice method.
+type ConfigureRequest struct {
+	Name   string         `json:"name"`
+	Config wgtypes.Config `json:"config"`
+}
+
+// errNotServed is returned when a device other than that of the helper is
+// requested, indistinguishable from the device not existing.
+var errNotServed = os.ErrNotExist
+
+// service is served by the helper, calling wgctrl.
+type service struct {
+	wg   server.WireGuard
+	opts Options
+}
+
+func (s *service) Devices(_ struct{}, res *[]*wgtypes.Device) error {
+	devs, err := s.wg.Devices()
+	if err != nil {
+		return rpcError(err)
+	}
+
+	for _, dev := range devs {
+		s.redact(dev)
+	}
+
+	*res = devs
+
+	return nil
+}
+
+func (s *service) Device(name string, res *wgtypes.Device) error {
+	if name != s.opts.Device {
+		return rpcError(errNotServed)
+	}
+
+	dev, err := s.wg.Device(name)
+	if err != nil {
+		return rpcError(err)
+	}
+
+	s.redact(dev)
+	*res = *dev
+
+	return nil
+}
+
+func (s *service) ConfigureDevice(req *ConfigureRequest, _ *struct{}) error {
+	if req.Name != s.opts.Device {
+		return rpcError(errNotServed)
+	}
+
+	if req.Config.PrivateKey != nil || req.Config.ListenPort != nil || req.Config.FirewallMark != nil {
+		return fmt.Errorf("helper only configures the peers of the device")
+	}
+
+	return rpcError(s.wg.ConfigureDevice(req.Name, req.Config))
+}
+
+// redact removes the private key of dev, unless it is the device of the
+// helper and it is shared, and the preshared keys of every other device.
+func (s *service) redact(dev *wgtypes.Device) {
+	if dev.Name == s.opts.Device {
+		if !s.opts.SharePrivateKey {
+			dev.PrivateKey = wgtypes.Key{}
+		}
+
+		return
+	}
+
+	dev.PrivateKey = wgtypes.Key{}
+
+	for i := range dev.Peers {
+		dev.Peers[i].PresharedKey = wgtypes.Key{}
+	}
+}
+
+// Serve accepts connections from l, such as a unix socket, serving the
+// device of opts through wg to each until l is closed.
+func Serve(l net.Listener, wg server.WireGuard, opts Options) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Helper", &service{wg: wg, opts: opts}); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Client is the WireGuard of a Server, calling a helper.
+type Client struct {
+	socket string
+
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+// Dial connects to the helper listening on the unix socket.
+func Dial(socket string) (*Client, error) {
+	c := &Client{socket: socket}
+
+	if _, err := c.conn(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// conn returns the connection to the helper, connecting again if it was
+// lost, such as when the helper is restarted.
+func (c *Client) conn() (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	conn, err := net.Dial("unix", c.socket)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = jsonrpc.NewClient(conn)
+
+	return c.client, nil
+}
+
+func (c *Client) call(method string, args, res interface{}) error {
+	rc, err := c.conn()
+	if err != nil {
+		return err
+	}
+
+	err = rc.Call("Helper."+method, args, res)
+
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.mu.Lock()
+		if c.client == rc {
+			c.client = nil
+		}
+		c.mu.Unlock()
+
+		rc.Close()
+	}
+
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) && string(serverErr) == os.ErrNotExist.Error() {
+		return os.ErrNotExist
+	}
+
+	return err
+}
+
+// Devices lists every WireGuard device of the host, without their private
+// keys.
+func (c *Client) Devices() ([]*wgtypes.Device, error) {
+	var devs []*wgtypes.Device
+	if err := c.call("Devices", struct{}{}, &devs); err != nil {
+		return nil, err
+	}
+
+	for _, dev := range devs {
+		normalize(dev)
+	}
+
+	return devs, nil
+}
+
+// Device returns the device served by the helper, any other does not exist.
+func (c *Client) Device(name string) (*wgtypes.Device, error) {
+	dev := new(wgtypes.Device)
+	if err := c.call("Device", name, dev); err != nil {
+		return nil, err
+	}
+
+	normalize(dev)
+
+	return dev, nil
+}
+
+// ConfigureDevice configures the Peers of the device served by the helper.
+func (c *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	return c.call("ConfigureDevice", &ConfigureRequest{Name: name, Config: cfg}, new(struct{}))
+}
+
+// Close closes the connection to the helper.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+
+	err := c.client.Close()
+	c.client = nil
+
+	return err
+}
+
+// normalize restores the IPv4 addresses of dev to their 4 byte form, as
+// addresses are always decoded from JSON in their 16 byte form.
+func normalize(dev *wgtypes.Device) {
+	for i := range dev.Peers {
+		peer := &dev.Peers[i]
+
+		for j, n := range peer.AllowedIPs {
+			if ip4 := n.IP.To4(); ip4 != nil && len(n.Mask) == net.IPv4len {
+				peer.AllowedIPs[j].IP = ip4
+			}
+		}
+
+		if peer.Endpoint != nil {
+			if ip4 := peer.Endpoint.IP.To4(); ip4 != nil {
+				peer.Endpoint.IP = ip4
+			}
+		}
+	}
+}
+
+// rpcError flattens err into an error that can be returned to the client,
+// which only receives its message.
+func rpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return errors.New(err.Error())
+}
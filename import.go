@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+
+	flag "github.com/spf13/pflag"
+)
+
+const importHelp = `Import Peers into a WG-API server from a CSV or JSON Lines file
+Usage: wg-api import [options] <file>
+
+Every Peer in the file is first validated by the server, then the Peers are
+imported in batches. Progress is reported after each batch.
+
+Batches are no larger than the --max-batch-size of the server.
+
+Options:
+  --server=<url>       address of WG-API server (default http://localhost:8080)
+  --token=<token>      authentication token, may also be given with the
+                       WGAPI_TOKEN environment variable
+  --format=<format>    format of file, either csv or jsonl (default taken from
+                       file extension)
+  --batch-size=<n>     number of Peers imported per request (default 100)
+  --on-error=<action>  one of abort (default), continue or rollback. rollback
+                       only restores the device to its state before the
+                       failed batch
+  --dry-run            only validate the file, no Peers are imported
+`
+
+// importChunk is a part of the imported file small enough to be given to the
+// server in a single request, along with the line each Peer within it was
+// read from.
+type importChunk struct {
+	data  string
+	lines []int
+}
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(importHelp) }
+
+	serverURL := fs.String("server", "http://localhost:8080", "")
+	token := fs.String("token", os.Getenv("WGAPI_TOKEN"), "")
+	format := fs.String("format", "", "")
+	batchSize := fs.Int("batch-size", 100, "")
+	onError := fs.String("on-error", client.OnErrorAbort, "")
+	dryRun := fs.Bool("dry-run", false, "")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		exitError("exactly one file to import is required")
+	} else if *batchSize < 1 {
+		exitError("batch size must be at least 1")
+	}
+
+	filename := fs.Arg(0)
+
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".csv":
+			*format = client.FormatCSV
+		case ".jsonl", ".ndjson":
+			*format = client.FormatJSONL
+		default:
+			exitError("could not determine format of %q, use --format", filename)
+		}
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		exitError("could not read file: %s", err)
+	}
+
+	c := client.NewHTTPClient(*serverURL, *token)
+	ctx := context.Background()
+
+	// a server too old to describe its capabilities has no limits.
+	if caps, err := c.GetCapabilities(ctx, &client.GetCapabilitiesRequest{}); err == nil && caps.Capabilities != nil && caps.Capabilities.Limits != nil {
+		if max := caps.Capabilities.Limits.MaxBatchSize; max > 0 && max < *batchSize {
+			*batchSize = max
+		}
+	}
+
+	var chunks []importChunk
+	if *format == client.FormatCSV {
+		chunks, err = chunkCSV(data, *batchSize)
+	} else {
+		chunks, err = chunkJSONL(data, *batchSize)
+	}
+	if err != nil {
+		exitError("could not read file: %s", err)
+	}
+
+	var total, invalid int
+	for _, chunk := range chunks {
+		total += len(chunk.lines)
+	}
+
+	// validate every peer before importing any of them, in the same batches
+	// as they are imported such that none exceeds the limit of the server.
+	for i, chunk := range chunks {
+		res, err := c.ImportPeers(ctx, &client.ImportPeersRequest{
+			Format:  *format,
+			Data:    chunk.data,
+			OnError: client.OnErrorContinue,
+			DryRun:  true,
+		})
+		if err != nil {
+			exitError("could not validate batch %d of %d: %s", i+1, len(chunks), err)
+		}
+
+		invalid += printImportErrors(res.Results, chunk.lines)
+	}
+
+	if invalid > 0 && *onError != client.OnErrorContinue {
+		exitError("%d of %d peers are invalid, no peers imported", invalid, total)
+	}
+
+	if *dryRun {
+		fmt.Printf("%d of %d peers are valid, no peers imported (dry run)\n", total-invalid, total)
+		return
+	}
+
+	var imported, failed int
+
+	for i, chunk := range chunks {
+		res, err := c.ImportPeers(ctx, &client.ImportPeersRequest{
+			Format:  *format,
+			Data:    chunk.data,
+			OnError: *onError,
+		})
+		if err != nil {
+			exitError("could not import batch %d of %d: %s", i+1, len(chunks), err)
+		}
+
+		imported += res.Imported
+		failed += res.Failed
+		printImportErrors(res.Results, chunk.lines)
+
+		fmt.Printf("batch %d of %d: imported %d of %d peers\n", i+1, len(chunks), imported, total)
+
+		if !res.OK && *onError != client.OnErrorContinue {
+			if res.RolledBack {
+				fmt.Printf("batch %d of %d was rolled back\n", i+1, len(chunks))
+			}
+
+			exitError("import stopped, %d peers imported, %d peers not imported", imported, total-imported)
+		}
+	}
+
+	fmt.Printf("imported %d of %d peers, %d failed\n", imported, total, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// printImportErrors prints every failed result, returning the number printed.
+// lines maps the index of each result to its line in the imported file.
+func printImportErrors(results []*client.BatchResult, lines []int) int {
+	n := 0
+
+	for _, result := range results {
+		if result.Error == nil {
+			continue
+		}
+
+		line := result.Line
+		if result.Index < len(lines) {
+			line = lines[result.Index]
+		}
+
+		fmt.Fprintf(os.Stderr, "line %d: %s\n", line, result.Error.Message)
+		n++
+	}
+
+	return n
+}
+
+// chunkCSV splits CSV data into chunks of at most size records, each
+// beginning with the header row.
+func chunkCSV(data []byte, size int) ([]importChunk, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var chunks []importChunk
+	var buf bytes.Buffer
+	var w *csv.Writer
+	var chunk importChunk
+
+	flush := func() {
+		if len(chunk.lines) > 0 {
+			w.Flush()
+			chunk.data = buf.String()
+			chunks = append(chunks, chunk)
+		}
+
+		buf.Reset()
+		w = csv.NewWriter(&buf)
+		w.Write(header)
+		chunk = importChunk{}
+	}
+
+	flush()
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+
+		w.Write(record)
+		chunk.lines = append(chunk.lines, line)
+
+		if len(chunk.lines) >= size {
+			flush()
+		}
+	}
+
+	flush()
+
+	return chunks, nil
+}
+
+// chunkJSONL splits JSON Lines data into chunks of at most size non-blank
+// lines.
+func chunkJSONL(data []byte, size int) ([]importChunk, error) {
+	var chunks []importChunk
+	var chunk importChunk
+	var buf strings.Builder
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		chunk.lines = append(chunk.lines, i+1)
+
+		if len(chunk.lines) >= size {
+			chunk.data = buf.String()
+			chunks = append(chunks, chunk)
+			chunk = importChunk{}
+			buf.Reset()
+		}
+	}
+
+	if len(chunk.lines) > 0 {
+		chunk.data = buf.String()
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
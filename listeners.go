@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jamescun/wg-api/server"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var listenersFile = flag.String("listeners", "", "")
+
+// api builds the handler of each listener of the server, which differ only
+// in how they are authenticated and the methods they serve.
+type api struct {
+	svc            *server.Server
+	mux            *server.Mux
+	self           jsonrpc.Handler
+	authenticators []server.Authenticator
+
+	// device is the name of the first device served, requests naming a
+	// device not served by mux are refused.
+	device string
+
+	// static is the directory of files served alongside the API, if any.
+	static string
+
+	// limiter, if any, limits the rate of requests of each client across
+	// every listener.
+	limiter *server.RateLimiter
+}
+
+// handler returns the handler of a listener, serving only methods if any are
+// given, where requests must be authenticated by any of auths and every
+// authenticator of plugins.
+func (a *api) handler(methods []string, auths []func(http.Handler) http.Handler) http.Handler {
+	allowed := func(method string) bool {
+		return len(methods) == 0 || stringInSlice(method, methods)
+	}
+
+	export, peers, events := server.ExportHandler(a.svc), server.PeersHandler(a.svc), server.EventsHandler(a.svc)
+	if !allowed("ExportPeers") {
+		export = http.NotFoundHandler()
+	}
+	if !allowed("ListPeers") {
+		peers = http.NotFoundHandler()
+		events = http.NotFoundHandler()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/export", server.RateLimitHTTP(export, a.limiter))
+	mux.Handle("/peers", server.RateLimitHTTP(peers, a.limiter))
+	mux.Handle("/events", server.RateLimitHTTP(events, a.limiter))
+	mux.Handle("/schema", server.SchemaHandler())
+
+	logger := server.Logger
+	if *logParams {
+		logger = server.LoggerWithParams
+	}
+
+	h := logger(server.RateLimit(server.AllowMethods(server.EnforceScopes(a.mux), methods...), a.limiter))
+	mux.Handle("/v1/", server.RESTHandler(h))
+
+	var rpc http.Handler = jsonrpc.HTTP(h)
+	if a.static != "" {
+		rpc = server.StaticHandler(a.static, rpc)
+	}
+
+	mux.Handle("/", rpc)
+
+	var handler http.Handler = server.RequireDevice(a.device, a.mux.Names()...)(mux)
+
+	if len(auths) > 0 {
+		handler = server.AuthAny(auths...)(handler)
+	}
+
+	for _, auth := range a.authenticators {
+		handler = server.Authenticate(auth)(handler)
+	}
+
+	// health checks and the self-service api are not behind the
+	// authentication of the rest of the api, the self-service api
+	// authenticates peers itself.
+	health := server.HealthHandler(a.svc)
+
+	outer := http.NewServeMux()
+	outer.Handle("/healthz", health)
+	outer.Handle("/readyz", health)
+	outer.Handle("/", handler)
+
+	if a.self != nil {
+		outer.Handle("/self", jsonrpc.HTTP(server.Logger(server.RateLimit(a.self, a.limiter))))
+	}
+
+	// a frontend served alongside the API makes requests of it from the
+	// same origin, but no other web page may.
+	if a.static != "" {
+		handler = server.PreventCrossOrigin(outer)
+	} else {
+		handler = server.PreventReferer(outer)
+	}
+
+	// public stats are fetched by status pages from browsers, so are
+	// not subject to PreventReferer, they identify no peer.
+	if *publicStats {
+		public := http.NewServeMux()
+		public.Handle("/stats", server.PublicStatsHandler(a.svc))
+		public.Handle("/", handler)
+
+		handler = public
+	}
+
+	return handler
+}
+
+// listenerConfig is a listener of --listeners, serving the API with its own
+// transport, authentication and methods.
+type listenerConfig struct {
+	Name string `yaml:"name"`
+
+	// Listen is the [host:]port to listen on, unix:<file> to listen on a
+	// unix socket, or npipe:<path> to listen on a named pipe on Windows.
+	Listen string `yaml:"listen"`
+
+	// SocketMode is the octal permissions of a unix socket, by default
+	// 0600 such that only the user of WG-API may connect, or 0666 if
+	// SocketAllowUsers or SocketAllowGroups are given.
+	SocketMode string `yaml:"socket_mode"`
+
+	// SocketAllowUsers and SocketAllowGroups, by name or id, authenticate
+	// the processes connecting to a unix socket by their credentials.
+	SocketAllowUsers  []string `yaml:"socket_allow_users"`
+	SocketAllowGroups []string `yaml:"socket_allow_groups"`
+
+	// PipeSecurity is the SDDL security descriptor of a named pipe, by
+	// default permitting only Administrators and SYSTEM to connect.
+	PipeSecurity string `yaml:"pipe_security"`
+
+	TLS *struct {
+		Cert     string `yaml:"cert"`
+		Key      string `yaml:"key"`
+		ClientCA string `yaml:"client_ca"`
+	} `yaml:"tls"`
+
+	Tokens    []string `yaml:"tokens"`
+	AdminKeys []string `yaml:"admin_keys"`
+
+	// ReadOnlyTokens may only call the methods that read the device.
+	ReadOnlyTokens []string `yaml:"read_only_tokens"`
+
+	// Methods, if set, are the only methods served by the listener.
+	Methods []string `yaml:"methods"`
+
+	AllowInsecurePublic bool `yaml:"allow_insecure_public"`
+}
+
+// listener is opened, and its certificate loaded, before it is served such
+// that it is ready before the server is sandboxed.
+type listener struct {
+	name string
+	url  string
+	l    net.Listener
+	srv  *http.Server
+
+	// addr is the address of a TCP listener, which must still be served
+	// with TLS and authentication when it is reloaded if it is public.
+	addr string
+
+	// handler and cert are replaced when the listeners are reloaded.
+	handler *reloadable
+	cert    *certificate
+}
+
+// serve serves the API on the listener until it is shut down.
+func (l *listener) serve() error {
+	if l.srv.TLSConfig != nil {
+		return l.srv.ServeTLS(l.l, "", "")
+	}
+
+	return l.srv.Serve(l.l)
+}
+
+// loadListeners reads and opens the listeners of a YAML (or JSON) file.
+func loadListeners(filename string, a *api) ([]*listener, error) {
+	cfgs, err := readListeners(filename, a)
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []*listener
+
+	for _, cfg := range cfgs {
+		l, err := a.listen(cfg)
+		if err != nil {
+			for _, l := range listeners {
+				l.l.Close()
+			}
+
+			return nil, fmt.Errorf("listener %q: %w", cfg.Name, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// reloadListeners reads the listeners of a YAML (or JSON) file again,
+// replacing the authentication, methods and certificate of each of listeners
+// of the same name. Any other change to the listeners requires a restart.
+// The reload is rejected, keeping the previous authentication of every
+// listener, if it would leave a public listener without authentication.
+func reloadListeners(filename string, a *api, listeners []*listener) error {
+	cfgs, err := readListeners(filename, a)
+	if err != nil {
+		return err
+	}
+
+	type reload struct {
+		l       *listener
+		cfg     *listenerConfig
+		handler http.Handler
+	}
+
+	var reloads []reload
+
+	for _, cfg := range cfgs {
+		i := slices.IndexFunc(listeners, func(l *listener) bool { return l.name == cfg.Name })
+		if i < 0 {
+			log.Printf("warn: server: not adding listener %q until restarted\n", cfg.Name)
+			continue
+		}
+
+		l := listeners[i]
+
+		handler, _, err := a.listenerHandler(cfg)
+		if err != nil {
+			return fmt.Errorf("listener %q: %w", cfg.Name, err)
+		}
+
+		if l.addr != "" {
+			if err := a.checkPublic(cfg, l.addr, l.cert != nil); err != nil {
+				return fmt.Errorf("listener %q: %w", cfg.Name, err)
+			}
+		}
+
+		reloads = append(reloads, reload{l: l, cfg: cfg, handler: handler})
+	}
+
+	for _, r := range reloads {
+		if r.l.cert != nil && r.cfg.TLS != nil {
+			if err := r.l.cert.load(r.cfg.TLS.Cert, r.cfg.TLS.Key); err != nil {
+				return fmt.Errorf("listener %q: could not load tls cert: %w", r.cfg.Name, err)
+			}
+		}
+
+		r.l.handler.set(r.handler)
+	}
+
+	return nil
+}
+
+// readListeners reads the listeners of a YAML (or JSON) file.
+func readListeners(filename string, a *api) ([]*listenerConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Listeners []*listenerConfig `yaml:"listeners"`
+	}
+
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	caps, err := a.svc.GetCapabilities(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, cfg := range file.Listeners {
+		if cfg == nil {
+			return nil, fmt.Errorf("listener %d: listener is empty", i)
+		} else if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("listener %d", i)
+		}
+
+		for _, method := range cfg.Methods {
+			if !stringInSlice(method, caps.Capabilities.Methods) {
+				return nil, fmt.Errorf("listener %q: unknown method %q", cfg.Name, method)
+			}
+		}
+	}
+
+	return file.Listeners, nil
+}
+
+// listenerHandler returns the handler of the listener of cfg, and whether
+// it authenticates processes connecting to its unix socket.
+func (a *api) listenerHandler(cfg *listenerConfig) (http.Handler, bool, error) {
+	auths := tokenAuths(cfg.Tokens, cfg.ReadOnlyTokens)
+
+	if len(cfg.AdminKeys) > 0 {
+		auth, err := server.AuthKeys(a.svc, cfg.AdminKeys...)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid admin key: %w", err)
+		}
+
+		auths = append(auths, auth)
+	}
+
+	peerCred, err := peerCredAuth(cfg.SocketAllowUsers, cfg.SocketAllowGroups)
+	if err != nil {
+		return nil, false, err
+	} else if peerCred != nil {
+		if _, ok := unixSocket(cfg.Listen); !ok {
+			return nil, false, fmt.Errorf("socket_allow_users and socket_allow_groups require a unix socket")
+		}
+
+		auths = append(auths, peerCred)
+	}
+
+	return a.handler(cfg.Methods, auths), peerCred != nil, nil
+}
+
+// checkPublic returns an error if the listener of cfg, on the TCP address
+// addr, is reachable from other hosts but is not served with both TLS and
+// authentication, unless allow_insecure_public is set.
+func (a *api) checkPublic(cfg *listenerConfig, addr string, tls bool) error {
+	authenticated := len(cfg.Tokens) > 0 || len(cfg.ReadOnlyTokens) > 0 || len(cfg.AdminKeys) > 0 || len(a.authenticators) > 0 || (cfg.TLS != nil && cfg.TLS.ClientCA != "")
+
+	if cfg.AllowInsecurePublic || *allowPublic || (tls && authenticated) {
+		return nil
+	}
+
+	if public, err := isPublicAddr(addr); err != nil {
+		return err
+	} else if public {
+		return fmt.Errorf("refusing to listen on %s without tls and authentication, unless allow_insecure_public is set", addr)
+	}
+
+	return nil
+}
+
+// listen opens the listener of cfg.
+func (a *api) listen(cfg *listenerConfig) (*listener, error) {
+	handler, peerCred, err := a.listenerHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &listener{name: cfg.Name, handler: newReloadable(handler)}
+
+	l.srv = newHTTPServer("", l.handler)
+	l.srv.ConnContext = server.PeerCredContext
+
+	if socket, ok := unixSocket(cfg.Listen); ok {
+		ln, err := listenUnix(socket, cfg.SocketMode, peerCred)
+		if err != nil {
+			return nil, err
+		}
+
+		l.l, l.url = ln, cfg.Listen
+
+		return l, nil
+	} else if pipe, ok := strings.CutPrefix(cfg.Listen, "npipe:"); ok {
+		ln, err := listenPipe(pipe, cfg.PipeSecurity)
+		if err != nil {
+			return nil, err
+		}
+
+		l.l, l.url = ln, cfg.Listen
+
+		return l, nil
+	} else if cfg.Listen == "" {
+		return nil, fmt.Errorf("listen is required")
+	}
+
+	if err := a.checkPublic(cfg, cfg.Listen, cfg.TLS != nil); err != nil {
+		return nil, err
+	}
+
+	if cfg.TLS != nil {
+		l.cert = new(certificate)
+		if err := l.cert.load(cfg.TLS.Cert, cfg.TLS.Key); err != nil {
+			return nil, fmt.Errorf("could not load tls cert: %w", err)
+		}
+
+		l.srv.TLSConfig = &tls.Config{GetCertificate: l.cert.get}
+
+		if cfg.TLS.ClientCA != "" {
+			pool, err := loadCertificatePool(cfg.TLS.ClientCA)
+			if err != nil {
+				return nil, fmt.Errorf("could not load client ca: %w", err)
+			}
+
+			l.srv.TLSConfig.ClientCAs = pool
+			l.srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	l.l, l.url, l.addr = ln, "http://"+cfg.Listen, cfg.Listen
+	if l.cert != nil {
+		l.url = "https://" + cfg.Listen
+	}
+
+	return l, nil
+}
+
+func stringInSlice(s string, vv []string) bool {
+	for _, v := range vv {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/jamescun/wg-api/helper"
+	"github.com/jamescun/wg-api/plugin"
 	"github.com/jamescun/wg-api/server"
-	"github.com/jamescun/wg-api/server/jsonrpc"
+	"github.com/jamescun/wg-api/store"
 
 	flag "github.com/spf13/pflag"
 	"golang.zx2c4.com/wireguard/wgctrl"
@@ -19,55 +25,441 @@ import (
 
 const help = `WG-API presents a JSON-RPC API to a WireGuard device
 Usage: wg-api [options]
+       wg-api <command> [options]
+
+Commands:
+  import       import Peers into a WG-API server from CSV or JSON Lines
+  proxy        present many WG-API servers as a single API
+  mesh         connect the devices of WG-API servers to each other
+  exporter     expose a WG-API server as Prometheus metrics
+  genclient    generate a typed client of the API in another language
+  healthcheck  exit 0 if a WG-API server is ready, otherwise 1
+  helper       serve a WireGuard device to an unprivileged WG-API server
 
 Helpers:
   --list-devices  list wireguard devices on this system and their name to be
                   given to --device
-  --version       display the version number of WG-API
+  --version       display the version number of WG-API, and its build as
+                  JSON with --json
 
 Options:
-  --device=<name>         (required) name of WireGuard device to manager
-  --listen=<[host:]port>  address where API server will bind
+  --config=<file>         read options from this YAML file, each named as on
+                          the command line, which overrides it. its tokens
+                          are read again on SIGHUP
+  --device=<name>         (required) name of WireGuard device to manager. may
+                          be specified multiple times to manage many devices,
+                          the first is the default device of requests
+  --all-devices           manage every WireGuard device on this system, in
+                          place of --device
+  --device-alias=<alias>=<name>
+                          address the device name by alias in requests, and
+                          in --device. may be specified multiple times.
+  --create-if-missing     create the first --device, on linux, if it does not
+                          exist, assigning it --device-address and bringing it
+                          up
+  --device-address=<cidr> address of the device created by --create-if-missing,
+                          such as 10.6.0.1/24. may be specified multiple times.
+  --device-listen-port=<port>
+                          listen port of the device created by
+                          --create-if-missing (default random)
+  --device-key-file=<file>
+                          private key of the device created by
+                          --create-if-missing, created with a new key if it
+                          does not exist (default a new key every time)
+  --helper=<socket>       manage the device through a wg-api helper listening
+                          on this unix socket, rather than directly
+  --listen=<[host:]port>  address where API server will bind, unix:<file> to
+                          bind a unix socket, empty to only serve --listeners
                           (default localhost:8080)
+  --socket-mode=<mode>    octal permissions of the unix socket of --listen
+                          (default 0600, or 0666 with --socket-allow-*)
+  --socket-allow-user=<user>
+                          user, by name or uid, whose processes may connect
+                          to the unix socket of --listen without a token.
+                          may be specified multiple times. (Linux only)
+  --socket-allow-group=<group>
+                          group, by name or gid, whose processes may connect
+                          to the unix socket of --listen without a token.
+                          may be specified multiple times. (Linux only)
+  --listeners=<file>      YAML file of further listeners, each with its own
+                          address, unix socket or named pipe, TLS,
+                          authentication and methods
+  --allow-insecure-public
+                          start even though --listen is not a loopback
+                          address and TLS or authentication is not enabled
   --tls                   enable Transport Layer Security (SSL) on server
   --tls-key               TLS private key
   --tks-cert              TLS certificate file
   --tls-client-ca         enable mutual TLS authentication (mTLS) of the client
   --token                 opaque value provided by the client to authenticate
                           requests. may be specified multiple times.
+  --token-rw              same as --token
+  --token-ro              token that may only call methods reading the
+                          device, such as ListPeers. may be specified
+                          multiple times.
+  --token-file=<file>     YAML or JSON file of tokens and their scope,
+                          read-only or read-write
+  --admin-key=<key>       WireGuard public key of an admin identity, whose
+                          private key may sign requests in place of a token.
+                          may be specified multiple times.
+  --dry-run               never make changes to the device, all mutating
+                          methods only return the changes they would make
+  --max-peers=<n>         maximum number of peers of the device, changes that
+                          would add more are rejected (default unlimited)
+  --max-batch-size=<n>    maximum number of peers or operations given to
+                          AddPeers, ApplyBatch or ImportPeers at once
+                          (default unlimited)
+  --reject-overlapping-ips
+                          reject adding or updating peers whose allowed ips
+                          overlap those of another peer, unless forced
+  --disable-method=<name> do not serve this method, such as RemoveAllPeers,
+                          as though it did not exist. may be specified
+                          multiple times.
+  --peer-cache-ttl=<dur>  how long a dump of the device is used to look up
+                          peers by public key or address (default 1s)
+  --rate-interval=<dur>   how often the transfer of every peer is sampled to
+                          compute its rate, 0 disables (default 10s)
+  --rate-window=<dur>     period the rate of transfer of each peer is averaged
+                          over (default 1m)
+  --latency-interval=<dur>
+                          how often every connected peer is pinged through the
+                          tunnel to measure its latency, 0 disables
+                          (default 0)
+  --latency-window=<dur>  period the latency and loss of each peer is averaged
+                          over (default 5m)
+  --log-redact=<mode>     redact the public keys of peers in logs, one of hash
+                          or truncate
+  --log-params            log the params of requests that may change the
+                          device, without preshared or private keys
+  --sandbox               once started, allow only the system calls WG-API
+                          makes with seccomp, and restrict files to those it
+                          was given with Landlock (Linux only)
+  --sandbox-path=<path>   also allow files beneath this path to be read and
+                          written once sandboxed. may be specified multiple
+                          times.
+  --templates=<file>      YAML file of named templates that may be referenced
+                          when adding Peers
+  --policy=<file>         YAML file of CEL rules that every change to the
+                          Peers of the device must satisfy
+  --alerts=<file>         YAML file of alert rules, such as peers being offline,
+                          evaluated against the device
+  --store=<store>         where information about peers, such as metadata, is
+                          kept, one of memory or sqlite:<file> (default memory)
+  --state-file=<file>     save the peers of the device to this file whenever
+                          they change, and restore the device to them on start.
+                          with many devices, each has its own file named after
+                          it, such as peers.wg1.json
+  --usage-hourly-after=<dur>
+                          roll up usage recorded in the store into the total
+                          of each hour once older than this, 0 disables
+                          (default 48h)
+  --usage-daily-after=<dur>
+                          roll up usage into the total of each day once older
+                          than this, 0 disables (default 720h)
+  --usage-retention=<dur> delete usage once older than this (default forever)
+  --audit-retention=<dur> delete the audit log once older than this
+                          (default forever)
+  --ipam-pool=<range>     allocate addresses to peers added with
+                          allocate_allowed_ips from this range. may be
+                          specified multiple times.
+  --ha                    run highly available with other instances sharing
+                          the same --store, only the elected leader makes
+                          changes to its device
+  --ha-id=<id>            identity of this instance (default hostname)
+  --ha-ttl=<duration>     how long leadership is held without being renewed
+                          (default 15s)
+  --plugin=<path>         executable of a plugin providing authentication,
+                          storage, event sinks or IP address management.
+                          may be specified multiple times.
+  --self-service          serve /self, where peers may query their own record
+                          and usage without a token by proving possession of
+                          their private key
+  --self-service-source-ip
+                          also identify peers on /self by the address the
+                          request is made from through the tunnel
+  --public-stats          serve /stats, aggregate statistics of the peers of
+                          the device for status pages without a token
+  --serve-static=<dir>    serve the files of this directory, such as a custom
+                          frontend, behind the same authentication as the API
+  --client-endpoint=<host[:port]>
+                          endpoint of the device in client configurations,
+                          the port defaults to the listen port of the device
+  --client-dns=<addr>     DNS server of client configurations. may be
+                          specified multiple times.
+  --read-header-timeout=<dur>
+                          how long a client may take to send the headers of a
+                          request (default 10s)
+  --read-timeout=<dur>    how long a client may take to send a request,
+                          including its body (default 30s)
+  --write-timeout=<dur>   how long a response may take to be written, which
+                          must exceed the timeout of WatchDevices and the time
+                          taken to write /export (default 10m)
+  --idle-timeout=<dur>    how long a keep-alive connection is kept open
+                          between requests (default 2m)
+  --shutdown-timeout=<dur>
+                          how long requests in progress are waited for once
+                          SIGTERM is received (default 30s)
+  --max-header-bytes=<n>  maximum size of the headers of a request
+                          (default 65536)
+  --rate-limit=<n/period> requests each source ip and each token may make,
+                          such as 100/min, where period is one of s, min or h
+
+Events:
+  --nats-url=<url>        publish events to NATS, such as nats://localhost:4222
+  --nats-subject=<tmpl>   template of the subject of each event
+                          (default wg-api.{{.Device}}.{{.Type}})
+  --nats-creds=<file>     NATS credentials file
+  --nats-jetstream        publish events to JetStream, waiting for each to be
+                          acknowledged
+  --nats-redact=<mode>    redact the public keys and endpoints of peers in
+                          events published to NATS, one of hash or truncate
+  --kafka-brokers=<addr>  publish events to Kafka, such as localhost:9092.
+                          may be specified multiple times.
+  --kafka-topic=<tmpl>    template of the topic of each event (default wg-api)
+  --kafka-sasl=<mech>     authenticate with one of plain, scram-sha-256 or
+                          scram-sha-512
+  --kafka-username        username of SASL authentication
+  --kafka-tls             connect to Kafka using TLS
+  --kafka-tls-ca=<file>   CA certificates to verify Kafka brokers
+  --kafka-redact=<mode>   redact peers in events published to Kafka
+  --redis-url=<url>       publish events to Redis, such as
+                          redis://localhost:6379/0
+  --redis-channel=<tmpl>  template of the channel of each event, events are
+                          not published if empty
+                          (default wg-api.{{.Device}}.{{.Type}})
+  --redis-mirror=<tmpl>   template of the key of a hash the peers of the device
+                          are mirrored into, such as wg-api:{{.Device}}:peers
+  --redis-redact=<mode>   redact peers in events published to Redis, the
+                          mirror is never redacted
+  --webhook-url=<url>     POST events to this URL as JSON, signed with the
+                          secret WGAPI_WEBHOOK_SECRET and retried with backoff
+  --webhook-events=<types>
+                          types of the events POSTed to --webhook-url
+                          (default peer.added,peer.updated,peer.removed)
+  --webhook-retries=<n>   times each event is retried before it is dropped
+                          (default 5)
+  --webhook-redact=<mode> redact peers in events POSTed to --webhook-url
+  --usage-interval=<dur>  publish the usage of every peer at this interval,
+                          such as 5m
+  --event-stream          stream events to clients of /events as server-sent
+                          events, and publish peer.handshake and
+                          peer.endpoint_changed events
+
+Alerts:
+  --alert-webhook=<url>   POST alerts of --alerts rules to this URL as JSON
+  --alert-slack=<url>     send alerts to this Slack incoming webhook
+  --alert-email=<addr>    send alerts by email to this address. may be
+                          specified multiple times.
+  --alert-smtp=<host:port>
+                          mail server alerts are sent through
+  --alert-email-from=<addr>
+                          address alerts are sent by email from
+  --alert-smtp-username   username of SMTP authentication
+  --alert-message=<tmpl>  template of the message of each alert
+                          (default [{{.Alert.State}}] {{.Device}}: {{.Alert.Message}})
+  --alert-rate-limit=<n>  maximum alerts sent to each destination per minute
+                          (default 10)
+  --alert-redact=<mode>   redact peers in alerts
+
+Metrics:
+  --metrics-listen=<[host:]port>
+                          address where Prometheus metrics of every device are
+                          served on /metrics
+  --metrics-redact=<mode> redact the public keys of peers in labels, one of
+                          hash or truncate
+
+SNMP:
+  --snmp                  expose the device and its peers to the SNMP agent of
+                          this host as an AgentX subagent
+  --snmp-master=<addr>    unix socket or host:port of the AgentX master agent
+                          (default /var/agentx/master)
+  --snmp-oid=<oid>        root of the WG-API MIB
+                          (default 1.3.6.1.4.1.8072.9999.9999.51820)
+
+Names:
+  --hosts-file=<file>     write the names and addresses of peers to this file
+                          in hosts format
+  --zone-file=<file>      write the names and addresses of peers to this file
+                          as a DNS zone
+  --dns-zone=<domain>     domain peers are named under, such as
+                          vpn.example.com. required with --zone-file,
+                          --dns-update and --dns-listen
+  --dns-name-metadata=<key>
+                          metadata peers are named by (default name)
+  --dns-update=<host[:port]>
+                          send the names and addresses of peers to the
+                          primary DNS server of --dns-zone as dynamic updates
+  --dns-tsig-name=<key>   name of the TSIG key updates are signed with
+  --dns-tsig-algorithm=<alg>
+                          algorithm of the TSIG key (default hmac-sha256)
+  --dns-listen=<addr>     answer queries for the names and addresses of peers
+                          on this address, such as 10.6.0.1:53
+
+Firewall:
+  --firewall=<file>       YAML file of firewall policies given to peers by
+                          their firewall metadata, applied to traffic from
+                          peers as nftables rules (Linux only)
+  --flush-conntrack       delete the connection tracking entries of addresses
+                          peers lose when removed or their allowed ips shrink,
+                          ending their established flows (Linux only)
+
+Desired State:
+  --etcd-endpoints=<url>  reconcile the device to the peers kept in etcd, such
+                          as http://localhost:2379. may be specified multiple
+                          times.
+  --etcd-prefix=<key>     prefix of the keys of desired peers
+                          (default /wg-api/peers/)
+  --etcd-status-prefix=<key>
+                          prefix where the status of each desired peer is
+                          written, unique to each gateway
+  --etcd-username         username of etcd authentication
+  --kubernetes-namespace=<namespace>
+                          reconcile the device to the WireGuardPeer resources
+                          of this Kubernetes namespace
+  --kubernetes-selector=<labels>
+                          only reconcile WireGuardPeers matching this label
+                          selector, such as gateway=gw1
+  --kubeconfig=<file>     kubeconfig used to connect to Kubernetes (default
+                          configuration of the pod)
+  --peers-dir=<dir>       reconcile the device to the peers defined by the
+                          YAML or JSON files in this directory
 
 Environment Variables:
-  WGAPI_TOKENS  comma seperated list of authentication tokens, equivalent to
-                calling --token one or more times.
+  WGAPI_TOKENS           comma seperated list of authentication tokens,
+                         equivalent to calling --token one or more times.
+  WGAPI_TOKENS_RO        comma seperated list of read-only authentication
+                         tokens, equivalent to calling --token-ro.
+  WGAPI_KAFKA_PASSWORD   password of Kafka SASL authentication
+  WGAPI_ETCD_PASSWORD    password of etcd authentication
+  WGAPI_DNS_TSIG_SECRET  base64 secret of the TSIG key of --dns-tsig-name
+  WGAPI_PAGERDUTY_KEY    routing key of a PagerDuty integration alerts are
+                         sent to
+  WGAPI_SMTP_PASSWORD    password of SMTP authentication
+  WGAPI_WEBHOOK_SECRET   secret events POSTed to --webhook-url are signed with
+  WGAPI_REDACT_KEY       secret key of the hashes of --*-redact=hash, such
+                         that they cannot be reversed by hashing known keys
 
 Warnings:
   WG-API can perform sensitive network operations, as such it should not be
   publicly exposed. It should be bound to the local interface only, or
   failing that, be behind an authenticating proxy or have mTLS enabled.
-  Additionally authentication tokens should be configured.
+  Additionally authentication tokens should be configured. WG-API refuses
+  to listen on any other address without both TLS and authentication, unless
+  given --allow-insecure-public.
 `
 
 var Version = "1.0.0"
 
+// Commit and BuildDate are set when building with -ldflags, such as
+// "-X main.Commit=$(git rev-parse HEAD)", otherwise they are taken from the
+// version control information embedded by go build.
+var Commit, BuildDate string
+
 var (
 	// helpers
 	listDevices = flag.Bool("list-devices", false, "")
 	showVersion = flag.Bool("version", false, "")
+	versionJSON = flag.Bool("json", false, "")
 
 	// options
-	deviceName  = flag.String("device", "", "")
+	deviceNames = flag.StringArray("device", nil, "")
+	allDevices  = flag.Bool("all-devices", false, "")
+	deviceAlias = flag.StringArray("device-alias", nil, "")
+	createDev   = flag.Bool("create-if-missing", false, "")
+	deviceAddrs = flag.StringArray("device-address", nil, "")
+	devicePort  = flag.Int("device-listen-port", 0, "")
+	deviceKeyFn = flag.String("device-key-file", "", "")
+	helperAddr  = flag.String("helper", "", "")
 	listenAddr  = flag.String("listen", "localhost:8080", "")
+	allowPublic = flag.Bool("allow-insecure-public", false, "")
 	enableTLS   = flag.Bool("tls", false, "")
 	tlsKey      = flag.String("tls-key", "", "")
 	tlsCert     = flag.String("tls-cert", "", "")
 	tlsClientCA = flag.String("tls-client-ca", "", "")
 	authTokens  = flag.StringArray("token", nil, "")
+	dryRun      = flag.Bool("dry-run", false, "")
+	templates   = flag.String("templates", "", "")
+	policy      = flag.String("policy", "", "")
+	alertRules  = flag.String("alerts", "", "")
+	storeSpec   = flag.String("store", "memory", "")
+	stateFile   = flag.String("state-file", "", "")
+	hourlyAfter = flag.Duration("usage-hourly-after", 48*time.Hour, "")
+	dailyAfter  = flag.Duration("usage-daily-after", 30*24*time.Hour, "")
+	usageRetain = flag.Duration("usage-retention", 0, "")
+	auditRetain = flag.Duration("audit-retention", 0, "")
+	ipamPools   = flag.StringArray("ipam-pool", nil, "")
+	enableHA    = flag.Bool("ha", false, "")
+	haID        = flag.String("ha-id", "", "")
+	haTTL       = flag.Duration("ha-ttl", 15*time.Second, "")
+	plugins     = flag.StringArray("plugin", nil, "")
+	selfService = flag.Bool("self-service", false, "")
+	selfByIP    = flag.Bool("self-service-source-ip", false, "")
+	publicStats = flag.Bool("public-stats", false, "")
+	staticDir   = flag.String("serve-static", "", "")
+	clientEP    = flag.String("client-endpoint", "", "")
+	clientDNS   = flag.StringSlice("client-dns", nil, "")
+	adminKeys   = flag.StringArray("admin-key", nil, "")
+	maxPeers    = flag.Int("max-peers", 0, "")
+	maxBatch    = flag.Int("max-batch-size", 0, "")
+	noOverlaps  = flag.Bool("reject-overlapping-ips", false, "")
+	disabled    = flag.StringArray("disable-method", nil, "")
+	peerCache   = flag.Duration("peer-cache-ttl", time.Second, "")
+	rateEvery   = flag.Duration("rate-interval", 10*time.Second, "")
+	rateWindow  = flag.Duration("rate-window", time.Minute, "")
+	latencyInt  = flag.Duration("latency-interval", 0, "")
+	latencyWin  = flag.Duration("latency-window", 5*time.Minute, "")
+	logRedact   = flag.String("log-redact", "", "")
+	logParams   = flag.Bool("log-params", false, "")
+
+	// http server
+	readHeaderTimeout = flag.Duration("read-header-timeout", 10*time.Second, "")
+	readTimeout       = flag.Duration("read-timeout", 30*time.Second, "")
+	writeTimeout      = flag.Duration("write-timeout", 10*time.Minute, "")
+	idleTimeout       = flag.Duration("idle-timeout", 2*time.Minute, "")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 30*time.Second, "")
+	maxHeaderBytes    = flag.Int("max-header-bytes", 1<<16, "")
+	rateLimit         = flag.String("rate-limit", "", "")
 )
 
+// commands are run instead of the server if given as the first argument.
+var commands = map[string]func(args []string){
+	"import":      runImport,
+	"proxy":       runProxy,
+	"mesh":        runMesh,
+	"exporter":    runExporter,
+	"genclient":   runGenclient,
+	"healthcheck": runHealthcheck,
+	"helper":      runHelper,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = func() { fmt.Println(help) }
 	flag.Parse()
 
+	var cfg *config
+
+	if *configFile != "" {
+		c, err := loadConfig(flag.CommandLine, *configFile)
+		if err != nil {
+			exitError("could not load --config: %s", err)
+		}
+
+		cfg = c
+	}
+
+	if r, err := loadRedactor(*logRedact); err != nil {
+		exitError("--log-redact: %s", err)
+	} else if r != nil {
+		log.SetOutput(r.Writer(log.Writer()))
+	}
+
 	switch {
 	case *listDevices:
 		client, err := wgctrl.New()
@@ -76,89 +468,594 @@ func main() {
 		}
 
 		devices, err := client.Devices()
-		if err != nil {
+		if os.IsPermission(err) {
+			exitError("could not list WireGuard devices: %s, %s", err, privilegeHelp())
+		} else if err != nil {
 			exitError("could not list WireGuard devices: %s", err)
 		}
 
 		if len(devices) > 0 {
+			configs := configNames()
+
 			for _, device := range devices {
-				fmt.Println(device.Name)
+				if config, ok := configs[device.Name]; ok {
+					fmt.Println(config)
+				} else {
+					fmt.Println(device.Name)
+				}
 			}
 		} else {
 			fmt.Println("No WireGuard devices found.")
 		}
 
 	case *showVersion:
+		info := server.BuildInfo(Version, Commit, BuildDate)
+
+		if *versionJSON {
+			data, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				exitError("could not encode version: %s", err)
+			}
+
+			fmt.Println(string(data))
+			return
+		}
+
 		fmt.Println("WG-API Version:", Version)
 
+		if info.Commit != "" {
+			fmt.Println("Commit:", info.Commit)
+		}
+
+		if info.BuildDate != "" {
+			fmt.Println("Build Date:", info.BuildDate)
+		}
+
+		fmt.Println("Go Version:", info.GoVersion)
+
 	default:
-		client, err := wgctrl.New()
+		var (
+			client server.WireGuard
+			err    error
+		)
+
+		if *helperAddr != "" {
+			client, err = helper.Dial(*helperAddr)
+		} else {
+			client, err = wgctrl.New()
+		}
+
 		if err != nil {
 			exitError("could not create WireGuard client: %s", err)
 		}
 
-		device, err := client.Device(*deviceName)
-		if os.IsNotExist(err) {
-			exitError("device %q does not exist", *deviceName)
-		} else if err != nil {
-			exitError("could not open WireGuard device %q: %s", *deviceName, err)
+		aliases, err := parseDeviceAliases(*deviceAlias)
+		if err != nil {
+			exitError("invalid --device-alias: %s", err)
+		}
+
+		if *createDev {
+			if len(*deviceNames) == 0 {
+				exitError("--create-if-missing requires --device")
+			}
+
+			name := (*deviceNames)[0]
+			if device, ok := aliases[name]; ok {
+				name = device
+			}
+
+			if err := createDevice(client, name, *deviceAddrs, *devicePort, *deviceKeyFn); err != nil {
+				exitError("%s", err)
+			}
 		}
 
-		svc, err := server.NewServer(client, device.Name)
+		devices, err := openDevices(client, *deviceNames, *allDevices, aliases)
+		if err != nil {
+			exitError("%s", err)
+		} else if len(devices) > 1 && *enableHA {
+			exitError("--ha cannot be given with more than one device")
+		}
+
+		device := devices[0]
+
+		opts := []server.Option{server.WithDeviceAliases(aliases)}
+
+		if *dryRun {
+			log.Println("info: server: dry run enabled, no changes will be made to the device")
+			opts = append(opts, server.WithDryRun())
+		}
+
+		if *templates != "" {
+			tmpls, err := server.LoadTemplates(*templates)
+			if err != nil {
+				exitError("could not load templates: %s", err)
+			}
+
+			opts = append(opts, server.WithTemplates(tmpls))
+		}
+
+		if *policy != "" {
+			p, err := server.LoadPolicy(*policy)
+			if err != nil {
+				exitError("could not load policy: %s", err)
+			}
+
+			opts = append(opts, server.WithPolicy(p))
+		}
+
+		st, err := store.Open(*storeSpec)
+		if err != nil {
+			exitError("could not open store: %s", err)
+		}
+
+		opts = append(opts, server.WithStore(st), server.WithRetention(&store.Retention{
+			HourlyAfter: *hourlyAfter,
+			DailyAfter:  *dailyAfter,
+			Usage:       *usageRetain,
+			Audit:       *auditRetain,
+		}))
+
+		opts = append(opts, server.WithServerInfo(server.BuildInfo(Version, Commit, BuildDate)))
+		opts = append(opts, server.WithLimits(*maxPeers, *maxBatch))
+		opts = append(opts, server.WithDisabledMethods(*disabled...))
+		opts = append(opts, server.WithPeerCacheTTL(*peerCache))
+		opts = append(opts, server.WithRates(*rateEvery, *rateWindow))
+		opts = append(opts, server.WithLatency(*latencyInt, *latencyWin))
+		opts = append(opts, server.WithClientDefaults(*clientEP, *clientDNS))
+
+		if *noOverlaps {
+			opts = append(opts, server.WithOverlapCheck())
+		}
+
+		if len(*ipamPools) > 0 {
+			leases, ok := st.(store.Leases)
+			if !ok {
+				exitError("store %q cannot keep ipam leases", *storeSpec)
+			}
+
+			pool, err := server.NewPool(leases, *ipamPools...)
+			if err != nil {
+				exitError("could not configure ipam: %s", err)
+			}
+
+			opts = append(opts, server.WithIPAM(pool))
+		}
+
+		if *stateFile != "" && *enableHA {
+			exitError("--state-file cannot be given with --ha, peers are restored from the store")
+		}
+
+		if *enableHA {
+			elector, ok := st.(store.Elector)
+			if !ok || *storeSpec == "memory" {
+				exitError("store %q cannot be shared for high availability", *storeSpec)
+			}
+
+			if *haID == "" {
+				if *haID, err = os.Hostname(); err != nil {
+					exitError("could not get hostname for --ha-id: %s", err)
+				}
+			}
+
+			log.Printf("info: ha: running highly available as %q\n", *haID)
+
+			opts = append(opts, server.WithHA(elector, *haID, *haTTL))
+		}
+
+		pluginOpts, authenticators, err := loadPlugins(*plugins)
+		if err != nil {
+			exitError("could not load plugins: %s", err)
+		}
+
+		opts = append(opts, pluginOpts...)
+
+		if *flushConntrack {
+			opts = append(opts, server.WithConntrackFlush())
+		}
+
+		// the Events of every device are published to the same sinks, each
+		// naming the device it is of.
+		sinkOpts, rds, err := loadEventSinks()
+		if err != nil {
+			exitError("could not connect to event sinks: %s", err)
+		}
+
+		opts = append(opts, sinkOpts...)
+
+		// every device is served with the options above, and its own alerts
+		// and state file.
+		deviceOpts := func(name string) []server.Option {
+			opts := append([]server.Option(nil), opts...)
+
+			// alerts keep the state of the rules of the device they
+			// evaluate, so are loaded for each device.
+			if *alertRules != "" {
+				a, err := server.LoadAlerts(*alertRules)
+				if err != nil {
+					exitError("could not load alerts: %s", err)
+				}
+
+				opts = append(opts, server.WithAlerts(a))
+			}
+
+			if *stateFile != "" {
+				opts = append(opts, server.WithStateFile(deviceStateFile(*stateFile, name, len(devices))))
+			}
+
+			return opts
+		}
+
+		// the options below apply only to the first device.
+		first := deviceOpts(device.Name)
+
+		// names and firewall rules are written for the Peers of a single
+		// device, which would be replaced by those of any other.
+		names, err := loadDNS()
+		if err != nil {
+			exitError("could not configure dns: %s", err)
+		} else if names != nil && len(devices) > 1 {
+			exitError("--hosts-file, --zone-file, --dns-update and --dns-listen cannot be given with more than one device")
+		} else if names != nil {
+			first = append(first, server.WithEventSink(names))
+		}
+
+		rules, err := loadFirewall(device.Name)
+		if err != nil {
+			exitError("could not configure firewall: %s", err)
+		} else if rules != nil && len(devices) > 1 {
+			exitError("--firewall cannot be given with more than one device")
+		} else if rules != nil {
+			first = append(first, server.WithEventSink(rules))
+		}
+
+		svc, err := server.NewServer(client, device.Name, first...)
 		if err != nil {
 			exitError("could not create WG-API server: %s", err)
 		}
 
-		handler := jsonrpc.HTTP(server.Logger(svc))
+		go svc.Run(context.Background())
 
-		if tokens := envArray("WGAPI_TOKENS"); len(tokens) > 0 {
-			*authTokens = append(*authTokens, tokens...)
+		servers := []*server.Server{svc}
+
+		for _, dev := range devices[1:] {
+			other, err := server.NewServer(client, dev.Name, deviceOpts(dev.Name)...)
+			if err != nil {
+				exitError("could not create WG-API server of %s: %s", dev.Name, err)
+			}
+
+			go other.Run(context.Background())
+
+			servers = append(servers, other)
 		}
 
-		if len(*authTokens) > 0 {
-			handler = server.AuthTokens(*authTokens...)(handler)
+		if rds != nil {
+			for _, s := range servers {
+				go rds.Mirror(context.Background(), s, redisMirrorInterval)
+			}
 		}
 
-		handler = server.PreventReferer(handler)
+		if names != nil {
+			go names.Run(context.Background(), svc, dnsInterval)
+		}
+
+		if rules != nil {
+			go rules.Run(context.Background(), svc, firewallInterval)
+		}
+
+		if *dnsListen != "" {
+			go func() {
+				if err := names.Serve(context.Background()); err != nil {
+					exitError("could not serve dns: %s", err)
+				}
+			}()
+		}
+
+		if err := startControllers(context.Background(), svc); err != nil {
+			exitError("could not start controllers: %s", err)
+		}
+
+		if err := startSNMP(context.Background(), svc); err != nil {
+			exitError("could not start snmp subagent: %s", err)
+		}
+
+		if err := startMetrics(servers); err != nil {
+			exitError("could not serve metrics: %s", err)
+		}
+
+		socket, isSocket := unixSocket(*listenAddr)
+
+		peerCred, err := peerCredAuth(*socketAllowUsers, *socketAllowGroups)
+		if err != nil {
+			exitError("invalid --socket-allow-user or --socket-allow-group: %s", err)
+		} else if peerCred != nil && !isSocket {
+			exitError("--socket-allow-user and --socket-allow-group require --listen=unix:<file>")
+		}
+
+		auths, err := loadAuths(svc, peerCred)
+		if err != nil {
+			exitError("%s", err)
+		}
+
+		if insecure, err := insecureListen(len(auths) > 0 || len(authenticators) > 0); err != nil {
+			exitError("invalid --listen: %s", err)
+		} else if insecure {
+			exitError(insecurePublicHelp, *listenAddr)
+		}
+
+		a := &api{svc: svc, mux: server.NewMux(servers...), authenticators: authenticators, device: device.Name, static: *staticDir}
+
+		if *rateLimit != "" {
+			a.limiter, err = server.ParseRateLimit(*rateLimit)
+			if err != nil {
+				exitError("invalid --rate-limit: %s", err)
+			}
+		}
+
+		if *staticDir != "" {
+			if info, err := os.Stat(*staticDir); err != nil {
+				exitError("invalid --serve-static: %s", err)
+			} else if !info.IsDir() {
+				exitError("invalid --serve-static: %s is not a directory", *staticDir)
+			}
+		}
+
+		if *selfService {
+			a.self, err = server.SelfHandler(svc, *selfByIP)
+			if err != nil {
+				exitError("could not create self-service api: %s", err)
+			}
+		}
+
+		var listeners []*listener
+
+		if *listenersFile != "" {
+			listeners, err = loadListeners(*listenersFile, a)
+			if err != nil {
+				exitError("could not load listeners: %s", err)
+			}
+		}
 
-		s := &http.Server{
-			Addr:    *listenAddr,
-			Handler: handler,
+		if *listenAddr == "" && len(listeners) == 0 {
+			exitError("--listen or --listeners is required")
 		}
 
+		handler := newReloadable(a.handler(nil, auths))
+
+		s := newHTTPServer(*listenAddr, handler)
+		s.ConnContext = server.PeerCredContext
+
+		var cert *certificate
+
 		if *enableTLS {
 			if *tlsKey == "" || *tlsCert == "" {
 				exitError("tls key and cert required for TLS")
 			}
 
+			cert = new(certificate)
+			if err := cert.load(*tlsCert, *tlsKey); err != nil {
+				exitError("could not load tls cert: %s", err)
+			}
+
+			s.TLSConfig = &tls.Config{GetCertificate: cert.get}
+
 			if *tlsClientCA != "" {
 				pool, err := loadCertificatePool(*tlsClientCA)
 				if err != nil {
 					exitError("could not load client ca: %s", err)
 				}
 
-				s.TLSConfig = &tls.Config{
-					ClientCAs:  pool,
-					ClientAuth: tls.RequireAndVerifyClientCert,
-				}
+				s.TLSConfig.ClientCAs = pool
+				s.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			}
+		}
 
-			log.Printf("info: server: listening on https://%s\n", s.Addr)
+		// the socket is created before the server is sandboxed, which may
+		// prevent it from creating files.
+		var ln net.Listener
 
-			if err := s.ListenAndServeTLS(*tlsCert, *tlsKey); err != nil {
-				log.Fatalln("fatal: server:", err)
+		if isSocket {
+			ln, err = listenUnix(socket, *socketMode, peerCred != nil)
+			if err != nil {
+				exitError("could not listen on %s: %s", *listenAddr, err)
 			}
-		} else {
+		}
+
+		// the certificates of listeners are loaded again on SIGHUP.
+		var certFiles []string
+		for _, l := range listeners {
+			if l.cert != nil {
+				certFiles = append(certFiles, l.cert.certFile, l.cert.keyFile)
+			}
+		}
+
+		if err := applySandbox(certFiles...); err != nil {
+			exitError("could not sandbox server: %s", err)
+		}
+
+		errs := make(chan error, len(listeners)+1)
+
+		for _, l := range listeners {
+			log.Printf("info: server: listening on %s for listener %q\n", l.url, l.name)
+
+			go func() { errs <- l.serve() }()
+		}
+
+		if ln != nil {
+			log.Printf("info: server: listening on %s\n", *listenAddr)
+
+			go func() {
+				if *enableTLS {
+					errs <- s.ServeTLS(ln, "", "")
+				} else {
+					errs <- s.Serve(ln)
+				}
+			}()
+		} else if *listenAddr != "" && *enableTLS {
+			log.Printf("info: server: listening on https://%s\n", s.Addr)
+
+			go func() { errs <- s.ListenAndServeTLS("", "") }()
+		} else if *listenAddr != "" {
 			log.Printf("info: server: listening on http://%s\n", s.Addr)
 
-			if err := s.ListenAndServe(); err != nil {
-				log.Fatalln("fatal: server:", err)
+			go func() { errs <- s.ListenAndServe() }()
+		}
+
+		reload := func() error {
+			if cfg != nil {
+				if err := cfg.reload(flag.CommandLine); err != nil {
+					return fmt.Errorf("could not reload config: %w", err)
+				}
+			}
+
+			auths, err := loadAuths(svc, peerCred)
+			if err != nil {
+				return err
+			}
+
+			// the tokens may be emptied by the reload, which must not leave
+			// a public listener without authentication.
+			if insecure, err := insecureListen(len(auths) > 0 || len(authenticators) > 0); err != nil {
+				return err
+			} else if insecure {
+				return fmt.Errorf("refusing to serve %s without TLS and authentication, keeping the previous tokens", *listenAddr)
+			}
+
+			if cert != nil {
+				if err := cert.reload(); err != nil {
+					return fmt.Errorf("could not load tls cert: %w", err)
+				}
+			}
+
+			if *listenersFile != "" {
+				if err := reloadListeners(*listenersFile, a, listeners); err != nil {
+					return fmt.Errorf("could not reload listeners: %w", err)
+				}
+			}
+
+			handler.set(a.handler(nil, auths))
+
+			return nil
+		}
+
+		// event streams are never idle, so are closed for the servers to
+		// shut down without waiting for them.
+		shutdown := func(ctx context.Context) error {
+			svc.CloseEventStreams()
+
+			servers := []*http.Server{s}
+			for _, l := range listeners {
+				servers = append(servers, l.srv)
 			}
+
+			return shutdownServers(ctx, servers)
+		}
+
+		err = waitForSignals(errs, reload, shutdown)
+		plugin.Cleanup()
+
+		if err != nil {
+			log.Fatalln("fatal: server:", err)
+		}
+
+		log.Println("info: server: shut down")
+	}
+}
+
+// loadAuths returns the authentication of requests to --listen by tokens,
+// admin keys and peerCred, if given.
+func loadAuths(svc *server.Server, peerCred func(http.Handler) http.Handler) ([]func(http.Handler) http.Handler, error) {
+	rw, ro, err := loadTokens()
+	if err != nil {
+		return nil, fmt.Errorf("could not load tokens: %w", err)
+	}
+
+	auths := tokenAuths(rw, ro)
+
+	if len(*adminKeys) > 0 {
+		auth, err := server.AuthKeys(svc, *adminKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin key: %w", err)
+		}
+
+		auths = append(auths, auth)
+	}
+
+	if peerCred != nil {
+		auths = append(auths, peerCred)
+	}
+
+	return auths, nil
+}
+
+// newHTTPServer returns a HTTP server with the timeouts configured, such
+// that slow clients cannot hold connections open indefinitely, exhausting
+// the resources of the server.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+	}
+}
+
+// insecurePublicHelp explains why the server refused to listen on an address
+// other than loopback.
+const insecurePublicHelp = `refusing to listen on %s without TLS and authentication
+
+This address is reachable from other hosts, where anyone able to connect could
+read and change the peers of the device. Either:
+  - listen on a loopback address only, such as --listen=localhost:8080
+  - enable --tls and authentication with --token, --admin-key, an
+    authentication plugin or --tls-client-ca
+  - give --allow-insecure-public if the server is protected some other way,
+    such as behind an authenticating proxy on a private network`
+
+// insecureListen returns true if --listen is reachable from other hosts but
+// is not served with both TLS and authentication, by authenticated or
+// --tls-client-ca, unless --allow-insecure-public is given.
+func insecureListen(authenticated bool) (bool, error) {
+	if _, ok := unixSocket(*listenAddr); ok || *listenAddr == "" || *allowPublic {
+		return false, nil
+	} else if *enableTLS && (authenticated || *tlsClientCA != "") {
+		return false, nil
+	}
+
+	return isPublicAddr(*listenAddr)
+}
+
+// isPublicAddr returns true if the host of addr, such as that given to
+// --listen, resolves to any address other than loopback. An empty host
+// listens on every address of the host, so is public.
+func isPublicAddr(addr string) (bool, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, err
+	}
+
+	if host == "" {
+		return true, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return true, nil
 		}
 	}
+
+	return false, nil
 }
 
 func exitError(format string, args ...interface{}) {
+	plugin.Cleanup()
 	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
 	os.Exit(1)
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/mesh"
+
+	flag "github.com/spf13/pflag"
+)
+
+const meshHelp = `Connect the devices of WG-API servers to each other
+Usage: wg-api mesh [options]
+
+The public key and endpoint of each device are exchanged and every device is
+added as a Peer of every other, such as to link two sites. Sites already
+connected are updated.
+
+Options:
+  --site=<url>,<endpoint>[,<allowed ip>...]
+                       (required) address of a WG-API server, the address its
+                       device is reached at by other sites, with the port of
+                       the device if omitted, and the addresses routed to the
+                       site. given at least twice.
+  --token=<token>      authentication token, may also be given with the
+                       WGAPI_TOKEN environment variable
+  --preshared-key      generate a preshared key for each pair of sites
+  --keep-alive=<dur>   persistent keep alive of every Peer, such as 25s
+  --dry-run            only print the changes that would be made
+`
+
+func runMesh(args []string) {
+	fs := flag.NewFlagSet("mesh", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(meshHelp) }
+
+	siteSpecs := fs.StringArray("site", nil, "")
+	token := fs.String("token", os.Getenv("WGAPI_TOKEN"), "")
+	presharedKey := fs.Bool("preshared-key", false, "")
+	keepAlive := fs.String("keep-alive", "", "")
+	dryRun := fs.Bool("dry-run", false, "")
+
+	fs.Parse(args)
+
+	if len(*siteSpecs) < 2 {
+		exitError("at least two --site are required")
+	}
+
+	var sites []*mesh.Site
+
+	for _, spec := range *siteSpecs {
+		parts := strings.Split(spec, ",")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			exitError("invalid site %q, expected <url>,<endpoint>[,<allowed ip>...]", spec)
+		}
+
+		sites = append(sites, &mesh.Site{
+			Client:     client.NewHTTPClient(parts[0], *token),
+			Endpoint:   parts[1],
+			AllowedIPs: parts[2:],
+		})
+	}
+
+	links, err := mesh.Mesh(context.Background(), sites, mesh.Options{
+		PresharedKey:        *presharedKey,
+		PersistentKeepAlive: *keepAlive,
+		DryRun:              *dryRun,
+	})
+
+	for _, link := range links {
+		printMeshChanges(link.A, link.ChangesA)
+		printMeshChanges(link.B, link.ChangesB)
+	}
+
+	if err != nil {
+		exitError("could not connect sites: %s", err)
+	}
+
+	if *dryRun {
+		fmt.Printf("%d pairs of sites would be connected (dry run)\n", len(links))
+	} else {
+		fmt.Printf("connected %d pairs of sites\n", len(links))
+	}
+}
+
+func printMeshChanges(site string, changes []*client.PeerChange) {
+	for _, change := range changes {
+		fmt.Printf("%s: %s %s\n", site, change.Action, change.PublicKey)
+	}
+}
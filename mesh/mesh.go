@@ -0,0 +1,178 @@
+// Package mesh connects the devices of WG-API servers to each other, such as
+// to link two sites or build a full mesh of gateways.
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// MetadataKey is the metadata of Peers added by Connect, set to the Name of
+// the Site the Peer connects to.
+const MetadataKey = "mesh"
+
+// Site is a WG-API server whose device is connected to other Sites.
+type Site struct {
+	// Name of the Site, by default its Endpoint.
+	Name string
+
+	Client client.Client
+
+	// Endpoint is the address other Sites reach the device at. If it does
+	// not include a port, the listen port of the device is used.
+	Endpoint string
+
+	// AllowedIPs are the addresses routed to this Site by other Sites.
+	AllowedIPs []string
+}
+
+func (s *Site) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return s.Endpoint
+}
+
+// Options configure the Peers added between Sites.
+type Options struct {
+	// PresharedKey, if true, generates a random preshared key for each pair
+	// of Sites.
+	PresharedKey bool
+
+	// PersistentKeepAlive, if set, is given to the Peers of both Sites, such
+	// as when a Site is behind NAT.
+	PersistentKeepAlive string
+
+	// DryRun returns the changes that would be made to each device without
+	// making them.
+	DryRun bool
+}
+
+// Link is the outcome of connecting two Sites.
+type Link struct {
+	A, B string
+
+	// Changes made, or that would have been made, to the device of each Site.
+	ChangesA []*client.PeerChange
+	ChangesB []*client.PeerChange
+}
+
+// device returns the public key and endpoint of the device of s.
+func (s *Site) device(ctx context.Context) (string, string, error) {
+	res, err := s.Client.GetDeviceInfo(ctx, &client.GetDeviceInfoRequest{})
+	if err != nil {
+		return "", "", err
+	}
+
+	endpoint := s.Endpoint
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		if res.Device.ListenPort == 0 {
+			return "", "", fmt.Errorf("endpoint %q has no port and device has no listen port", endpoint)
+		}
+
+		endpoint = net.JoinHostPort(endpoint, strconv.Itoa(res.Device.ListenPort))
+	}
+
+	return res.Device.PublicKey, endpoint, nil
+}
+
+// Connect exchanges the public keys and endpoints of the devices of Sites a
+// and b, adding each as a Peer of the other. If adding the Peer to b fails,
+// the Peer added to a is removed, unless it already existed.
+func Connect(ctx context.Context, a, b *Site, opts Options) (*Link, error) {
+	keyA, endpointA, err := a.device(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.name(), err)
+	}
+
+	keyB, endpointB, err := b.device(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.name(), err)
+	} else if keyA == keyB {
+		return nil, fmt.Errorf("%s and %s are the same device", a.name(), b.name())
+	}
+
+	var psk string
+	if opts.PresharedKey {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		psk = key.String()
+	}
+
+	resA, err := a.Client.AddPeer(ctx, &client.AddPeerRequest{
+		PublicKey:           keyB,
+		PresharedKey:        psk,
+		Endpoint:            endpointB,
+		PersistentKeepAlive: opts.PersistentKeepAlive,
+		AllowedIPs:          b.AllowedIPs,
+		Metadata:            map[string]string{MetadataKey: b.name()},
+		DryRun:              opts.DryRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not add %s: %w", a.name(), b.name(), err)
+	}
+
+	resB, err := b.Client.AddPeer(ctx, &client.AddPeerRequest{
+		PublicKey:           keyA,
+		PresharedKey:        psk,
+		Endpoint:            endpointA,
+		PersistentKeepAlive: opts.PersistentKeepAlive,
+		AllowedIPs:          a.AllowedIPs,
+		Metadata:            map[string]string{MetadataKey: a.name()},
+		DryRun:              opts.DryRun,
+	})
+	if err != nil {
+		if !resA.DryRun && added(resA.Changes) {
+			a.Client.RemovePeer(ctx, &client.RemovePeerRequest{PublicKey: keyB})
+		}
+
+		return nil, fmt.Errorf("%s: could not add %s: %w", b.name(), a.name(), err)
+	}
+
+	return &Link{
+		A:        a.name(),
+		B:        b.name(),
+		ChangesA: resA.Changes,
+		ChangesB: resB.Changes,
+	}, nil
+}
+
+// added returns true if changes include a Peer being added.
+func added(changes []*client.PeerChange) bool {
+	for _, change := range changes {
+		if change.Action == client.ChangeAdd {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Mesh connects every pair of sites, stopping at the first pair that cannot
+// be connected. Pairs already connected are not disconnected.
+func Mesh(ctx context.Context, sites []*Site, opts Options) ([]*Link, error) {
+	var links []*Link
+
+	for i := range sites {
+		for j := i + 1; j < len(sites); j++ {
+			link, err := Connect(ctx, sites[i], sites[j], opts)
+			if err != nil {
+				return links, err
+			}
+
+			links = append(links, link)
+		}
+	}
+
+	return links, nil
+}
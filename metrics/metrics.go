@@ -0,0 +1,291 @@
+// Package metrics exposes the device and Peers of a WG-API server as
+// Prometheus metrics, collected through its API such that they may be
+// scraped from a host other than the one running the server.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/redact"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// scrapeTimeout is how long collecting metrics from the server may take.
+const scrapeTimeout = 10 * time.Second
+
+// Handler serves the metrics of the server c, collected on each request.
+// If the server cannot be reached, wgapi_up is 0 and no other metrics are
+// given. If r is not nil, the public keys of Peers are redacted by it.
+func Handler(c client.Client, r *redact.Redactor) http.Handler {
+	return DevicesHandler([]client.Client{c}, r)
+}
+
+// DevicesHandler serves the metrics of the device of each of clients, such
+// as those of a server managing many devices, as Handler does.
+func DevicesHandler(clients []client.Client, r *redact.Redactor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), scrapeTimeout)
+		defer cancel()
+
+		var buf bytes.Buffer
+
+		start := time.Now()
+
+		if err := CollectDevices(ctx, clients, r, &buf); err != nil {
+			log.Printf("error: metrics: could not collect metrics: %s\n", err)
+
+			buf.Reset()
+			metric(&buf, "wgapi_up", "gauge", "Whether the WG-API server could be reached.")
+			sample(&buf, "wgapi_up", nil, 0)
+		} else {
+			metric(&buf, "wgapi_up", "gauge", "Whether the WG-API server could be reached.")
+			sample(&buf, "wgapi_up", nil, 1)
+		}
+
+		metric(&buf, "wgapi_scrape_duration_seconds", "gauge", "How long collecting metrics from the WG-API server took.")
+		sample(&buf, "wgapi_scrape_duration_seconds", nil, time.Since(start).Seconds())
+
+		w.Header().Set("Content-Type", ContentType)
+		w.Write(buf.Bytes())
+	})
+}
+
+// Collect writes the metrics of the server c to buf, redacting the public
+// keys of Peers by r if it is not nil.
+func Collect(ctx context.Context, c client.Client, r *redact.Redactor, buf *bytes.Buffer) error {
+	return CollectDevices(ctx, []client.Client{c}, r, buf)
+}
+
+// device is the state of a device collected through a Client.
+type device struct {
+	dev   *client.Device
+	peers []*client.Peer
+
+	// stats are those of the server of the device, nil if they could not
+	// be collected, such as if GetServerStats is not permitted.
+	stats *client.ServerStats
+}
+
+// CollectDevices writes the metrics of the device of each of clients to buf,
+// redacting the public keys of Peers by r if it is not nil.
+func CollectDevices(ctx context.Context, clients []client.Client, r *redact.Redactor, buf *bytes.Buffer) error {
+	devices := make([]*device, len(clients))
+
+	for i, c := range clients {
+		info, err := c.GetDeviceInfo(ctx, &client.GetDeviceInfoRequest{})
+		if err != nil {
+			return err
+		}
+
+		list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+		if err != nil {
+			return err
+		}
+
+		for i, peer := range list.Peers {
+			list.Peers[i] = r.Peer(peer)
+		}
+
+		devices[i] = &device{dev: info.Device, peers: list.Peers}
+
+		if stats, err := c.GetServerStats(ctx, &client.GetServerStatsRequest{}); err == nil {
+			devices[i].stats = stats.Stats
+		}
+	}
+
+	now := time.Now()
+
+	metric(buf, "wgapi_device_info", "gauge", "Information about the WireGuard device.")
+	for _, d := range devices {
+		sample(buf, "wgapi_device_info", []string{"device", d.dev.Name, "public_key", d.dev.PublicKey, "type", d.dev.Type}, 1)
+	}
+
+	metric(buf, "wgapi_device_listen_port", "gauge", "Port the WireGuard device listens on.")
+	for _, d := range devices {
+		sample(buf, "wgapi_device_listen_port", []string{"device", d.dev.Name}, float64(d.dev.ListenPort))
+	}
+
+	metric(buf, "wgapi_device_generation", "counter", "Number of times the configuration of the device has changed.")
+	for _, d := range devices {
+		sample(buf, "wgapi_device_generation", []string{"device", d.dev.Name}, float64(d.dev.Generation))
+	}
+
+	metric(buf, "wgapi_peers", "gauge", "Number of Peers of the device.")
+	for _, d := range devices {
+		sample(buf, "wgapi_peers", []string{"device", d.dev.Name}, float64(len(d.peers)))
+	}
+
+	peerMetric(buf, devices, "wgapi_peer_receive_bytes_total", "counter", "Bytes received from the Peer.", func(peer *client.Peer) (float64, bool) {
+		return float64(peer.ReceiveBytes), true
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_transmit_bytes_total", "counter", "Bytes transmitted to the Peer.", func(peer *client.Peer) (float64, bool) {
+		return float64(peer.TransmitBytes), true
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_receive_rate_bytes", "gauge", "Bytes per second received from the Peer, averaged over the rate window of the server.", func(peer *client.Peer) (float64, bool) {
+		return peer.ReceiveRate, true
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_transmit_rate_bytes", "gauge", "Bytes per second transmitted to the Peer, averaged over the rate window of the server.", func(peer *client.Peer) (float64, bool) {
+		return peer.TransmitRate, true
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_latency_seconds", "gauge", "Round trip time of the Peer through the tunnel, averaged over the latency window of the server.", func(peer *client.Peer) (float64, bool) {
+		if peer.Latency != nil && peer.Latency.Loss < 1 {
+			return peer.Latency.RTTMS / 1000, true
+		}
+
+		return 0, false
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_latency_loss_ratio", "gauge", "Fraction of echo requests sent to the Peer through the tunnel not replied to, over the latency window of the server.", func(peer *client.Peer) (float64, bool) {
+		if peer.Latency != nil {
+			return peer.Latency.Loss, true
+		}
+
+		return 0, false
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_last_handshake_seconds", "gauge", "Unix time of the last handshake with the Peer, 0 if never.", func(peer *client.Peer) (float64, bool) {
+		if peer.LastHandshake.IsZero() {
+			return 0, true
+		}
+
+		return float64(peer.LastHandshake.Unix()), true
+	})
+
+	peerMetric(buf, devices, "wgapi_peer_handshake_age_seconds", "gauge", "Seconds since the last handshake with the Peer, absent if never.", func(peer *client.Peer) (float64, bool) {
+		if peer.LastHandshake.IsZero() {
+			return 0, false
+		}
+
+		return now.Sub(peer.LastHandshake).Seconds(), true
+	})
+
+	writeMethodStats(buf, devices)
+
+	return nil
+}
+
+// peerMetric writes the metric of every Peer of devices for which value
+// returns true.
+func peerMetric(buf *bytes.Buffer, devices []*device, name, typ, help string, value func(*client.Peer) (float64, bool)) {
+	metric(buf, name, typ, help)
+
+	for _, d := range devices {
+		for _, peer := range d.peers {
+			if v, ok := value(peer); ok {
+				sample(buf, name, peerLabels(d.dev, peer), v)
+			}
+		}
+	}
+}
+
+// writeMethodStats writes the requests of each method of the server of each
+// of devices whose stats were collected, and the histogram of how long they
+// took, if the server records it.
+func writeMethodStats(buf *bytes.Buffer, devices []*device) {
+	var collected []*device
+	for _, d := range devices {
+		if d.stats != nil {
+			collected = append(collected, d)
+		}
+	}
+
+	if len(collected) == 0 {
+		return
+	}
+
+	metric(buf, "wgapi_rpc_requests_total", "counter", "Requests made of each method of the WG-API server.")
+	for _, d := range collected {
+		for _, name := range sortedMethods(d.stats) {
+			sample(buf, "wgapi_rpc_requests_total", []string{"device", d.dev.Name, "method", name}, float64(d.stats.Methods[name].Requests))
+		}
+	}
+
+	metric(buf, "wgapi_rpc_errors_total", "counter", "Requests of each method of the WG-API server that failed.")
+	for _, d := range collected {
+		for _, name := range sortedMethods(d.stats) {
+			sample(buf, "wgapi_rpc_errors_total", []string{"device", d.dev.Name, "method", name}, float64(d.stats.Methods[name].Errors))
+		}
+	}
+
+	metric(buf, "wgapi_rpc_duration_seconds", "histogram", "How long requests of each method of the WG-API server took.")
+	for _, d := range collected {
+		for _, name := range sortedMethods(d.stats) {
+			m := d.stats.Methods[name]
+			if m.DurationBuckets == nil {
+				continue
+			}
+
+			for _, b := range m.DurationBuckets {
+				sample(buf, "wgapi_rpc_duration_seconds_bucket", []string{"device", d.dev.Name, "method", name, "le", strconv.FormatFloat(b.LE, 'f', -1, 64)}, float64(b.Count))
+			}
+
+			sample(buf, "wgapi_rpc_duration_seconds_bucket", []string{"device", d.dev.Name, "method", name, "le", "+Inf"}, float64(m.Requests))
+			sample(buf, "wgapi_rpc_duration_seconds_sum", []string{"device", d.dev.Name, "method", name}, m.DurationSeconds)
+			sample(buf, "wgapi_rpc_duration_seconds_count", []string{"device", d.dev.Name, "method", name}, float64(m.Requests))
+		}
+	}
+}
+
+// sortedMethods returns the names of the methods of stats, sorted.
+func sortedMethods(stats *client.ServerStats) []string {
+	names := make([]string, 0, len(stats.Methods))
+	for name := range stats.Methods {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func peerLabels(dev *client.Device, peer *client.Peer) []string {
+	return []string{"device", dev.Name, "public_key", peer.PublicKey, "allowed_ips", strings.Join(peer.AllowedIPs, ",")}
+}
+
+// metric writes the help and type of a metric.
+func metric(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, typ)
+}
+
+// sample writes a sample of a metric, with labels given as pairs of name and
+// value.
+func sample(buf *bytes.Buffer, name string, labels []string, value float64) {
+	buf.WriteString(name)
+
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			fmt.Fprintf(buf, `%s="%s"`, labels[i], escaper.Replace(labels[i+1]))
+		}
+
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	buf.WriteByte('\n')
+}
+
+// escaper escapes label values as required by the text exposition format.
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
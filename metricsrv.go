@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/metrics"
+	"github.com/jamescun/wg-api/server"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	metricsListen = flag.String("metrics-listen", "", "")
+	metricsRedact = flag.String("metrics-redact", "", "")
+)
+
+// startMetrics serves Prometheus metrics of the devices of servers on
+// /metrics, if enabled. It listens before returning, such that it is not
+// prevented from doing so once the server is sandboxed.
+func startMetrics(servers []*server.Server) error {
+	if *metricsListen == "" {
+		return nil
+	}
+
+	r, err := loadRedactor(*metricsRedact)
+	if err != nil {
+		return err
+	}
+
+	clients := make([]client.Client, len(servers))
+	for i, s := range servers {
+		clients[i] = s
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.DevicesHandler(clients, r))
+
+	l, err := net.Listen("tcp", *metricsListen)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("info: metrics: listening on http://%s/metrics\n", l.Addr())
+
+	go func() {
+		if err := newHTTPServer("", mux).Serve(l); err != nil {
+			log.Fatalln("fatal: metrics:", err)
+		}
+	}()
+
+	return nil
+}
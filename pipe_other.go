@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenPipe is only supported on Windows.
+func listenPipe(path, sddl string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on windows")
+}
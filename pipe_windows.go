@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// defaultPipeSecurity is the security descriptor of a named pipe unless
+// configured otherwise, permitting only Administrators and SYSTEM to connect.
+const defaultPipeSecurity = "D:P(A;;GA;;;BA)(A;;GA;;;SY)"
+
+// listenPipe listens on the named pipe at path, which those permitted by the
+// security descriptor sddl may connect to.
+func listenPipe(path, sddl string) (net.Listener, error) {
+	if sddl == "" {
+		sddl = defaultPipeSecurity
+	}
+
+	return winio.ListenPipe(path, &winio.PipeConfig{SecurityDescriptor: sddl})
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+
+	"github.com/jamescun/wg-api/server"
+
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+type authPlugin struct {
+	impl server.Authenticator
+}
+
+func (p *authPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &authServer{impl: p.impl}, nil
+}
+
+func (p *authPlugin) Client(b *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &authClient{client: c}, nil
+}
+
+// authClient is the Authenticator used by WG-API, calling the plugin.
+type authClient struct {
+	client *rpc.Client
+}
+
+func (a *authClient) Authenticate(ctx context.Context, req *server.AuthRequest) (bool, error) {
+	var ok bool
+	err := a.client.Call("Plugin.Authenticate", req, &ok)
+
+	return ok, err
+}
+
+// authServer is served by the plugin, calling its Authenticator.
+type authServer struct {
+	impl server.Authenticator
+}
+
+func (a *authServer) Authenticate(req *server.AuthRequest, ok *bool) error {
+	var err error
+	*ok, err = a.impl.Authenticate(context.Background(), req)
+
+	return rpcError(err)
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+type eventsPlugin struct {
+	impl server.EventSink
+}
+
+func (p *eventsPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &eventsServer{impl: p.impl}, nil
+}
+
+func (p *eventsPlugin) Client(b *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &eventsClient{client: c}, nil
+}
+
+// eventsClient is the EventSink used by WG-API, calling the plugin.
+type eventsClient struct {
+	client *rpc.Client
+}
+
+func (e *eventsClient) Publish(ctx context.Context, event *client.Event) error {
+	return e.client.Call("Plugin.Publish", event, new(struct{}))
+}
+
+// eventsServer is served by the plugin, calling its EventSink.
+type eventsServer struct {
+	impl server.EventSink
+}
+
+func (e *eventsServer) Publish(event *client.Event, _ *struct{}) error {
+	return rpcError(e.impl.Publish(context.Background(), event))
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+
+	"github.com/jamescun/wg-api/server"
+
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+type ipamPlugin struct {
+	impl server.IPAM
+}
+
+func (p *ipamPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &ipamServer{impl: p.impl}, nil
+}
+
+func (p *ipamPlugin) Client(b *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &ipamClient{client: c}, nil
+}
+
+// ipamClient is the IPAM used by WG-API, calling the plugin.
+type ipamClient struct {
+	client *rpc.Client
+}
+
+func (i *ipamClient) Allocate(ctx context.Context, publicKey string) ([]string, error) {
+	var allowedIPs []string
+	if err := i.client.Call("Plugin.Allocate", publicKey, &allowedIPs); err != nil {
+		return nil, err
+	}
+
+	return allowedIPs, nil
+}
+
+func (i *ipamClient) Release(ctx context.Context, publicKey string) error {
+	return i.client.Call("Plugin.Release", publicKey, new(struct{}))
+}
+
+// ipamServer is served by the plugin, calling its IPAM.
+type ipamServer struct {
+	impl server.IPAM
+}
+
+func (i *ipamServer) Allocate(publicKey string, allowedIPs *[]string) error {
+	var err error
+	*allowedIPs, err = i.impl.Allocate(context.Background(), publicKey)
+
+	return rpcError(err)
+}
+
+func (i *ipamServer) Release(publicKey string, _ *struct{}) error {
+	return rpcError(i.impl.Release(context.Background(), publicKey))
+}
@@ -0,0 +1,195 @@
+// Package plugin allows WG-API to be extended by external processes, using
+// hashicorp/go-plugin over net/rpc. A plugin may provide any of
+// authentication, storage of Peer information, destinations for Events and
+// IP address management.
+//
+// Plugin authors implement the relevant interfaces and call Serve from their
+// main function, WG-API launches the plugin with Open.
+package plugin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jamescun/wg-api/server"
+	"github.com/jamescun/wg-api/store"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+// Handshake is shared between WG-API and its plugins, to ensure a plugin is
+// compatible before it is used.
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "WGAPI_PLUGIN",
+	MagicCookieValue: "4a8c1f0e-wg-api",
+}
+
+// Names of the capabilities a plugin may provide.
+const (
+	NameAuth   = "auth"
+	NameStore  = "store"
+	NameEvents = "events"
+	NameIPAM   = "ipam"
+)
+
+// Plugins are the capabilities provided by a plugin, any of which may be
+// nil if not provided.
+type Plugins struct {
+	Auth   server.Authenticator
+	Store  store.Store
+	Events server.EventSink
+	IPAM   server.IPAM
+}
+
+// Serve is called from the main function of a plugin to serve its
+// capabilities to WG-API. It does not return.
+func Serve(p *Plugins) {
+	set := goplugin.PluginSet{}
+
+	if p.Auth != nil {
+		set[NameAuth] = &authPlugin{impl: p.Auth}
+	}
+	if p.Store != nil {
+		set[NameStore] = &storePlugin{impl: p.Store}
+	}
+	if p.Events != nil {
+		set[NameEvents] = &eventsPlugin{impl: p.Events}
+	}
+	if p.IPAM != nil {
+		set[NameIPAM] = &ipamPlugin{impl: p.IPAM}
+	}
+
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         set,
+	})
+}
+
+// pluginSet is every capability known to WG-API, used when dispensing from a
+// plugin.
+var pluginSet = goplugin.PluginSet{
+	NameAuth:   &authPlugin{},
+	NameStore:  &storePlugin{},
+	NameEvents: &eventsPlugin{},
+	NameIPAM:   &ipamPlugin{},
+}
+
+// Client is a plugin process launched by WG-API.
+type Client struct {
+	client *goplugin.Client
+	rpc    goplugin.ClientProtocol
+}
+
+// Open launches the plugin executable at path.
+func Open(path string) (*Client, error) {
+	c := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          pluginSet,
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolNetRPC},
+		Managed:          true,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin",
+			Output: os.Stderr,
+			Level:  hclog.Warn,
+		}),
+	})
+
+	rpc, err := c.Client()
+	if err != nil {
+		c.Kill()
+		return nil, err
+	}
+
+	return &Client{client: c, rpc: rpc}, nil
+}
+
+// Kill stops the plugin process.
+func (c *Client) Kill() {
+	c.client.Kill()
+}
+
+// Cleanup stops every plugin process launched with Open, it should be called
+// before WG-API exits.
+func Cleanup() {
+	goplugin.CleanupClients()
+}
+
+// dispense returns the implementation of a capability of the plugin, or nil
+// if the plugin does not provide it.
+func (c *Client) dispense(name string) (interface{}, error) {
+	raw, err := c.rpc.Dispense(name)
+	if err != nil {
+		if isUnknownPlugin(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// Authenticator returns the Authenticator of the plugin, or nil if it does
+// not provide authentication.
+func (c *Client) Authenticator() (server.Authenticator, error) {
+	raw, err := c.dispense(NameAuth)
+	if raw == nil {
+		return nil, err
+	}
+
+	return raw.(server.Authenticator), nil
+}
+
+// Store returns the Store of the plugin, or nil if it does not provide
+// storage.
+func (c *Client) Store() (store.Store, error) {
+	raw, err := c.dispense(NameStore)
+	if raw == nil {
+		return nil, err
+	}
+
+	return raw.(store.Store), nil
+}
+
+// EventSink returns the EventSink of the plugin, or nil if it does not
+// provide one.
+func (c *Client) EventSink() (server.EventSink, error) {
+	raw, err := c.dispense(NameEvents)
+	if raw == nil {
+		return nil, err
+	}
+
+	return raw.(server.EventSink), nil
+}
+
+// IPAM returns the IPAM of the plugin, or nil if it does not provide IP
+// address management.
+func (c *Client) IPAM() (server.IPAM, error) {
+	raw, err := c.dispense(NameIPAM)
+	if raw == nil {
+		return nil, err
+	}
+
+	return raw.(server.IPAM), nil
+}
+
+// rpcError converts an error into one that can be sent over net/rpc, which
+// only preserves the message.
+func rpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return errors.New(err.Error())
+}
+
+// isUnknownPlugin returns true if err is returned by a plugin when asked for
+// a capability it does not provide.
+func isUnknownPlugin(err error) bool {
+	return strings.HasPrefix(err.Error(), "unknown plugin type")
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+
+	"github.com/jamescun/wg-api/store"
+
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+type storePlugin struct {
+	impl store.Store
+}
+
+func (p *storePlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &storeServer{impl: p.impl}, nil
+}
+
+func (p *storePlugin) Client(b *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &storeClient{client: c}, nil
+}
+
+// storeClient is the Store used by WG-API, calling the plugin.
+type storeClient struct {
+	client *rpc.Client
+}
+
+func (s *storeClient) GetPeer(ctx context.Context, publicKey string) (*store.Peer, error) {
+	peer := new(store.Peer)
+	if err := s.client.Call("Plugin.GetPeer", publicKey, peer); err != nil {
+		return nil, storeErr(err)
+	}
+
+	return peer, nil
+}
+
+func (s *storeClient) ListPeers(ctx context.Context) ([]*store.Peer, error) {
+	var peers []*store.Peer
+	if err := s.client.Call("Plugin.ListPeers", struct{}{}, &peers); err != nil {
+		return nil, storeErr(err)
+	}
+
+	return peers, nil
+}
+
+func (s *storeClient) PutPeer(ctx context.Context, peer *store.Peer) error {
+	return storeErr(s.client.Call("Plugin.PutPeer", peer, new(struct{})))
+}
+
+func (s *storeClient) DeletePeer(ctx context.Context, publicKey string) error {
+	return storeErr(s.client.Call("Plugin.DeletePeer", publicKey, new(struct{})))
+}
+
+// storeErr restores store.ErrNotFound, which is only preserved as a message
+// over net/rpc.
+func storeErr(err error) error {
+	if err != nil && err.Error() == store.ErrNotFound.Error() {
+		return store.ErrNotFound
+	}
+
+	return err
+}
+
+// storeServer is served by the plugin, calling its Store.
+type storeServer struct {
+	impl store.Store
+}
+
+func (s *storeServer) GetPeer(publicKey string, res *store.Peer) error {
+	peer, err := s.impl.GetPeer(context.Background(), publicKey)
+	if err != nil {
+		return rpcError(err)
+	}
+
+	*res = *peer
+
+	return nil
+}
+
+func (s *storeServer) ListPeers(_ struct{}, res *[]*store.Peer) error {
+	peers, err := s.impl.ListPeers(context.Background())
+	if err != nil {
+		return rpcError(err)
+	}
+
+	*res = peers
+
+	return nil
+}
+
+func (s *storeServer) PutPeer(peer *store.Peer, _ *struct{}) error {
+	return rpcError(s.impl.PutPeer(context.Background(), peer))
+}
+
+func (s *storeServer) DeletePeer(publicKey string, _ *struct{}) error {
+	return rpcError(s.impl.DeletePeer(context.Background(), publicKey))
+}
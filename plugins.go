@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jamescun/wg-api/plugin"
+	"github.com/jamescun/wg-api/server"
+)
+
+// loadPlugins launches each plugin executable, returning the options that
+// configure the server with the capabilities they provide and any
+// Authenticators. Only one plugin may provide storage or IP address
+// management.
+func loadPlugins(paths []string) ([]server.Option, []server.Authenticator, error) {
+	var opts []server.Option
+	var authenticators []server.Authenticator
+	var storePlugin, ipamPlugin string
+
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		var provides []string
+
+		auth, err := p.Authenticator()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		} else if auth != nil {
+			authenticators = append(authenticators, auth)
+			provides = append(provides, plugin.NameAuth)
+		}
+
+		st, err := p.Store()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		} else if st != nil {
+			if storePlugin != "" {
+				return nil, nil, fmt.Errorf("%s: store already provided by %s", path, storePlugin)
+			}
+
+			storePlugin = path
+			opts = append(opts, server.WithStore(st))
+			provides = append(provides, plugin.NameStore)
+		}
+
+		sink, err := p.EventSink()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		} else if sink != nil {
+			opts = append(opts, server.WithEventSink(sink))
+			provides = append(provides, plugin.NameEvents)
+		}
+
+		ipam, err := p.IPAM()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		} else if ipam != nil {
+			if ipamPlugin != "" {
+				return nil, nil, fmt.Errorf("%s: ipam already provided by %s", path, ipamPlugin)
+			}
+
+			ipamPlugin = path
+			opts = append(opts, server.WithIPAM(ipam))
+			provides = append(provides, plugin.NameIPAM)
+		}
+
+		if len(provides) == 0 {
+			return nil, nil, fmt.Errorf("%s: plugin provides nothing", path)
+		}
+
+		log.Printf("info: plugin: loaded %s providing %v\n", path, provides)
+	}
+
+	return opts, authenticators, nil
+}
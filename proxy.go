@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/proxy"
+	"github.com/jamescun/wg-api/server"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	flag "github.com/spf13/pflag"
+)
+
+const proxyHelp = `Present many WG-API servers as a single API
+Usage: wg-api proxy [options]
+
+ListPeers, GetPeer and GetDeviceInfo are made of every backend, annotating
+each result with the gateway it came from. Changes are made on a single
+backend, given by the gateway parameter, otherwise the gateway the peer
+belongs to, otherwise the gateway with the fewest peers.
+
+Options:
+  --backend=<[name=]url>  (required) address of a WG-API server, named by its
+                          host unless a name is given. may be specified
+                          multiple times.
+  --listen=<[host:]port>  address where API server will bind
+                          (default localhost:8080)
+  --token                 opaque value provided by the client to authenticate
+                          requests. may be specified multiple times.
+  --parallelism=<n>       maximum number of backends a request is made of at
+                          once (default 16)
+
+Environment Variables:
+  WGAPI_TOKENS         comma seperated list of authentication tokens,
+                       equivalent to calling --token one or more times.
+  WGAPI_BACKEND_TOKEN  authentication token given to every backend
+`
+
+func runProxy(args []string) {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	fs.Usage = func() { fmt.Print(proxyHelp) }
+
+	backendSpecs := fs.StringArray("backend", nil, "")
+	listenAddr := fs.String("listen", "localhost:8080", "")
+	authTokens := fs.StringArray("token", nil, "")
+	parallelism := fs.Int("parallelism", proxy.DefaultParallelism, "")
+
+	fs.Parse(args)
+
+	if len(*backendSpecs) == 0 {
+		exitError("at least one --backend is required")
+	}
+
+	token := os.Getenv("WGAPI_BACKEND_TOKEN")
+
+	var backends []*proxy.Backend
+
+	for _, spec := range *backendSpecs {
+		b, err := parseBackend(spec, token)
+		if err != nil {
+			exitError("invalid backend %q: %s", spec, err)
+		}
+
+		backends = append(backends, b)
+	}
+
+	p, err := proxy.New(backends...)
+	if err != nil {
+		exitError("could not create proxy: %s", err)
+	}
+
+	p.Info = server.BuildInfo(Version, Commit, BuildDate)
+	p.Parallelism = *parallelism
+
+	var handler http.Handler = jsonrpc.HTTP(server.Logger(server.Handler(p)))
+
+	if tokens := envArray("WGAPI_TOKENS"); len(tokens) > 0 {
+		*authTokens = append(*authTokens, tokens...)
+	}
+
+	if len(*authTokens) > 0 {
+		handler = server.AuthTokens(*authTokens...)(handler)
+	}
+
+	handler = server.PreventReferer(handler)
+
+	log.Printf("info: proxy: listening on http://%s for %d backends\n", *listenAddr, len(backends))
+
+	if err := http.ListenAndServe(*listenAddr, handler); err != nil {
+		log.Fatalln("fatal: proxy:", err)
+	}
+}
+
+// parseBackend parses a backend given as a URL, optionally prefixed with its
+// name and an equals sign.
+func parseBackend(spec, token string) (*proxy.Backend, error) {
+	var name string
+
+	rawURL := spec
+	if i := strings.Index(spec, "="); i > 0 && !strings.Contains(spec[:i], "/") {
+		name, rawURL = spec[:i], spec[i+1:]
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme must be http or https")
+	}
+
+	if name == "" {
+		name = u.Host
+	}
+
+	return &proxy.Backend{Name: name, Client: client.NewHTTPClient(rawURL, token)}, nil
+}
@@ -0,0 +1,1347 @@
+// Package proxy presents many WG-API servers, each managing the device of a
+// gateway, as a single Client.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultParallelism is the maximum number of Backends a request is made of
+// at once, unless configured otherwise.
+const DefaultParallelism = 16
+
+// Backend is a WG-API server behind a Proxy.
+type Backend struct {
+	// Name of the gateway, given to clients of the Proxy to identify which
+	// server a Peer belongs to.
+	Name string
+
+	Client client.Client
+}
+
+// Proxy is a Client that fans requests for Peers out to every Backend,
+// annotating each with the gateway it belongs to, and routes changes to a
+// single Backend.
+type Proxy struct {
+	backends []*Backend
+
+	// Info describes the build of the Proxy itself, returned by
+	// GetServerInfo along with every gateway.
+	Info *client.ServerInfo
+
+	// Parallelism is the maximum number of Backends a request is made of at
+	// once, such that a Proxy of many gateways does not dump every device
+	// at the same time. By default DefaultParallelism.
+	Parallelism int
+
+	pings   atomic.Uint64
+	started time.Time
+}
+
+var _ client.Client = (*Proxy)(nil)
+
+// New returns a Proxy of backends, each of which must have a unique name.
+func New(backends ...*Backend) (*Proxy, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("at least one backend is required")
+	}
+
+	seen := make(map[string]bool)
+
+	for _, b := range backends {
+		if b.Name == "" {
+			return nil, fmt.Errorf("backend name is required")
+		} else if seen[b.Name] {
+			return nil, fmt.Errorf("duplicate backend %q", b.Name)
+		}
+
+		seen[b.Name] = true
+	}
+
+	return &Proxy{backends: backends, Parallelism: DefaultParallelism, started: time.Now()}, nil
+}
+
+// invalidParam returns a JSON-RPC Invalid Params error describing the
+// request field at fault and the value given for it.
+func invalidParam(field, value, message string) *jsonrpc.Error {
+	return jsonrpc.InvalidParams(message, &client.ErrorData{Field: field, Value: value})
+}
+
+// gatewayError attributes an error returned by a Backend to its gateway.
+// JSON-RPC errors are passed through with their original code, any other
+// error means the Backend could not be reached.
+func gatewayError(b *Backend, err error) error {
+	var rpcErr *jsonrpc.Error
+	if errors.As(err, &rpcErr) {
+		data := rpcErr.Data
+
+		// the error of a gateway that is not itself a proxy names it.
+		if d, ok := data.(*client.ErrorData); ok && d.Gateway == "" {
+			named := *d
+			named.Gateway = b.Name
+			data = &named
+		}
+
+		return &jsonrpc.Error{
+			Code:    rpcErr.Code,
+			Message: fmt.Sprintf("%s: %s", b.Name, rpcErr.Message),
+			Data:    data,
+		}
+	}
+
+	return jsonrpc.ServerError(client.ErrCodeGateway, fmt.Sprintf("%s: %s", b.Name, err), &client.ErrorData{Gateway: b.Name, Retryable: true})
+}
+
+// backend returns the Backend named name, or an error referencing field if
+// there is no such Backend.
+func (p *Proxy) backend(field, name string) (*Backend, error) {
+	for _, b := range p.backends {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+
+	return nil, invalidParam(field, name, "unknown gateway")
+}
+
+// only returns the Backend named name, which may be omitted if the Proxy
+// has only one Backend.
+func (p *Proxy) only(field, name string) (*Backend, error) {
+	if name == "" {
+		if len(p.backends) == 1 {
+			return p.backends[0], nil
+		}
+
+		return nil, invalidParam(field, "", "gateway is required")
+	}
+
+	return p.backend(field, name)
+}
+
+// each calls fn for every Backend concurrently, at most Parallelism at once,
+// returning the first error.
+func (p *Proxy) each(fn func(i int, b *Backend) error) error {
+	errs := make([]error, len(p.backends))
+
+	parallelism := p.Parallelism
+	if parallelism < 1 {
+		parallelism = len(p.backends)
+	}
+
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+
+	for i, b := range p.backends {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, b *Backend) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(i, b); err != nil {
+				errs[i] = gatewayError(b, err)
+			}
+		}(i, b)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// placement is which gateway every Peer belongs to at the time of a
+// request, used to route changes to Peers.
+type placement struct {
+	backends []*Backend
+	owners   map[string]int
+	numPeers []int
+}
+
+// placement lists the Peers of every Backend.
+func (p *Proxy) placement(ctx context.Context) (*placement, error) {
+	pl := &placement{
+		backends: p.backends,
+		owners:   make(map[string]int),
+		numPeers: make([]int, len(p.backends)),
+	}
+
+	lists := make([][]*client.Peer, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.ListPeers(ctx, &client.ListPeersRequest{})
+		if err != nil {
+			return err
+		}
+
+		lists[i] = res.Peers
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, peers := range lists {
+		for _, peer := range peers {
+			pl.owners[peer.PublicKey] = i
+		}
+
+		pl.numPeers[i] = len(peers)
+	}
+
+	return pl, nil
+}
+
+// index returns the index of the Backend named gateway, or -1 if there is
+// no such Backend.
+func (pl *placement) index(gateway string) int {
+	for i, b := range pl.backends {
+		if b.Name == gateway {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// add returns the Backend that a Peer should be added to, the one named by
+// gateway if given, otherwise the one it already belongs to, otherwise the
+// one with the fewest Peers. The Peer is then considered to belong to it.
+func (pl *placement) add(gateway, publicKey string) (*Backend, error) {
+	owner, ok := pl.owners[publicKey]
+	i := owner
+
+	switch {
+	case gateway != "":
+		if i = pl.index(gateway); i < 0 {
+			return nil, invalidParam("gateway", gateway, "unknown gateway")
+		}
+
+	case !ok:
+		for j := range pl.numPeers {
+			if pl.numPeers[j] < pl.numPeers[i] {
+				i = j
+			}
+		}
+	}
+
+	if !ok || i != owner {
+		if ok {
+			pl.numPeers[owner]--
+		}
+
+		pl.owners[publicKey] = i
+		pl.numPeers[i]++
+	}
+
+	return pl.backends[i], nil
+}
+
+// remove returns the Backend that a Peer should be removed from, the one
+// named by gateway if given, otherwise the one it belongs to, or nil if it
+// belongs to none of them.
+func (pl *placement) remove(gateway, publicKey string) (*Backend, error) {
+	if gateway != "" {
+		i := pl.index(gateway)
+		if i < 0 {
+			return nil, invalidParam("gateway", gateway, "unknown gateway")
+		}
+
+		return pl.backends[i], nil
+	} else if i, ok := pl.owners[publicKey]; ok {
+		return pl.backends[i], nil
+	}
+
+	return nil, nil
+}
+
+// GetDeviceInfo returns the device of every gateway, summarized by the
+// total number of Peers across all of them.
+func (p *Proxy) GetDeviceInfo(ctx context.Context, req *client.GetDeviceInfoRequest) (*client.GetDeviceInfoResponse, error) {
+	gateways := make([]*client.Device, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.GetDeviceInfo(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		res.Device.Gateway = b.Name
+		gateways[i] = res.Device
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &client.Device{Type: "proxy"}
+	for _, dev := range gateways {
+		summary.NumPeers += dev.NumPeers
+	}
+
+	return &client.GetDeviceInfoResponse{
+		Device:   summary,
+		Gateways: gateways,
+	}, nil
+}
+
+// ListDevices returns the devices of every gateway.
+func (p *Proxy) ListDevices(ctx context.Context, req *client.ListDevicesRequest) (*client.ListDevicesResponse, error) {
+	lists := make([][]*client.Device, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.ListDevices(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		for _, dev := range res.Devices {
+			dev.Gateway = b.Name
+		}
+
+		lists[i] = res.Devices
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &client.ListDevicesResponse{Devices: []*client.Device{}}
+	for _, list := range lists {
+		res.Devices = append(res.Devices, list...)
+	}
+
+	return res, nil
+}
+
+// ListPeerKeys retrieves the public keys of the gateway named in the
+// request, which is required if there is more than one, as pages cannot span
+// gateways.
+func (p *Proxy) ListPeerKeys(ctx context.Context, req *client.ListPeerKeysRequest) (*client.ListPeerKeysResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.ListPeerKeys(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	return res, nil
+}
+
+// GetPeerCount returns the number of Peers of every gateway, and their total.
+func (p *Proxy) GetPeerCount(ctx context.Context, req *client.GetPeerCountRequest) (*client.GetPeerCountResponse, error) {
+	gateways := make([]*client.PeerCount, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.GetPeerCount(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		res.Count.Gateway = b.Name
+		gateways[i] = res.Count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := &client.PeerCount{}
+	for _, count := range gateways {
+		total.Peers += count.Peers
+		total.Online += count.Online
+	}
+
+	return &client.GetPeerCountResponse{
+		Count:    total,
+		Gateways: gateways,
+	}, nil
+}
+
+// GetServerInfo describes the Proxy and the WG-API server of every gateway.
+func (p *Proxy) GetServerInfo(ctx context.Context, req *client.GetServerInfoRequest) (*client.GetServerInfoResponse, error) {
+	gateways := make([]*client.ServerInfo, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.GetServerInfo(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		res.Server.Gateway = b.Name
+		gateways[i] = res.Server
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &client.ServerInfo{}
+	if p.Info != nil {
+		*info = *p.Info
+	}
+
+	info.Features = append(info.Features, client.FeatureProxy)
+
+	return &client.GetServerInfoResponse{
+		Server:   info,
+		Gateways: gateways,
+	}, nil
+}
+
+// Ping returns the time of the Proxy, without contacting any gateway.
+func (p *Proxy) Ping(ctx context.Context, req *client.PingRequest) (*client.PingResponse, error) {
+	return &client.PingResponse{Time: time.Now().UTC(), Counter: p.pings.Add(1)}, nil
+}
+
+// GetServerStats returns the runtime statistics of the Proxy itself and of
+// the WG-API server of every gateway.
+func (p *Proxy) GetServerStats(ctx context.Context, req *client.GetServerStatsRequest) (*client.GetServerStatsResponse, error) {
+	gateways := make([]*client.ServerStats, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.GetServerStats(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		res.Stats.Gateway = b.Name
+		gateways[i] = res.Stats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return &client.GetServerStatsResponse{
+		Stats: &client.ServerStats{
+			StartedAt:  p.started.UTC(),
+			Uptime:     time.Since(p.started).Round(time.Second).String(),
+			Goroutines: runtime.NumGoroutine(),
+			Memory: &client.MemoryStats{
+				HeapAllocBytes: mem.HeapAlloc,
+				SysBytes:       mem.Sys,
+				NumGC:          mem.NumGC,
+			},
+		},
+		Gateways: gateways,
+	}, nil
+}
+
+// WatchDevices watches the WireGuard devices of a single gateway, given by
+// Gateway.
+func (p *Proxy) WatchDevices(ctx context.Context, req *client.WatchDevicesRequest) (*client.WatchDevicesResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.WatchDevices(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	for _, dev := range res.Devices {
+		dev.Gateway = b.Name
+	}
+
+	return res, nil
+}
+
+// TopTalkers returns the Peers that have transferred the most across every
+// gateway, annotated with the gateway each belongs to. The window is the
+// longest of any gateway, as each may measure over a different window.
+func (p *Proxy) TopTalkers(ctx context.Context, req *client.TopTalkersRequest) (*client.TopTalkersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	gateways := make([]*client.TopTalkersResponse, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.TopTalkers(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		for _, talker := range res.Talkers {
+			talker.Peer.Gateway = b.Name
+		}
+
+		gateways[i] = res
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &client.TopTalkersResponse{Talkers: []*client.Talker{}}
+
+	var window time.Duration
+
+	for _, gw := range gateways {
+		res.Talkers = append(res.Talkers, gw.Talkers...)
+
+		if d, err := time.ParseDuration(gw.Window); err == nil && d > window {
+			window = d
+			res.Window = gw.Window
+		}
+	}
+
+	sort.SliceStable(res.Talkers, func(i, j int) bool {
+		return res.Talkers[i].Score(req.By) > res.Talkers[j].Score(req.By)
+	})
+
+	// every gateway returns at most limit Peers, so the Peers of all of
+	// them are cut to the same limit.
+	limit := req.Limit
+	if limit == 0 {
+		limit = client.DefaultTopTalkers
+	}
+
+	if len(res.Talkers) > limit {
+		res.Talkers = res.Talkers[:limit]
+	}
+
+	return res, nil
+}
+
+// GetCapabilities returns the capabilities of the WG-API server of every
+// gateway, along with those common to all of them: the methods and features
+// supported by every gateway, and the smallest batch size. The maximum number
+// of Peers is only given for each gateway.
+func (p *Proxy) GetCapabilities(ctx context.Context, req *client.GetCapabilitiesRequest) (*client.GetCapabilitiesResponse, error) {
+	gateways := make([]*client.Capabilities, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.GetCapabilities(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		res.Capabilities.Gateway = b.Name
+		gateways[i] = res.Capabilities
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	common := &client.Capabilities{
+		SchemaVersion: gateways[0].SchemaVersion,
+		Methods:       gateways[0].Methods,
+		Features:      gateways[0].Features,
+		Limits:        &client.Limits{},
+	}
+
+	for _, c := range gateways {
+		common.SchemaVersion = min(common.SchemaVersion, c.SchemaVersion)
+		common.Methods = intersect(common.Methods, c.Methods)
+		common.Features = intersect(common.Features, c.Features)
+
+		if c.Limits != nil {
+			common.Limits.MaxBatchSize = minLimit(common.Limits.MaxBatchSize, c.Limits.MaxBatchSize)
+		}
+	}
+
+	common.Features = append(common.Features, client.FeatureProxy)
+
+	return &client.GetCapabilitiesResponse{
+		Capabilities: common,
+		Gateways:     gateways,
+	}, nil
+}
+
+// intersect returns the elements of a that are also in b.
+func intersect(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+
+	out := []string{}
+	for _, v := range a {
+		if in[v] {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// minLimit returns the smaller of two limits, where zero is unlimited.
+func minLimit(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+// ListPeers retrieves the Peers of every gateway, each annotated with the
+// gateway it belongs to.
+func (p *Proxy) ListPeers(ctx context.Context, req *client.ListPeersRequest) (*client.ListPeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	// every gateway is asked for as many Peers as the page could take from
+	// it, and the offset is applied once they are merged.
+	breq := &client.ListPeersRequest{After: req.After}
+	if req.Limit > 0 {
+		breq.Limit = req.Offset + req.Limit
+	}
+
+	lists := make([][]*client.Peer, len(p.backends))
+	totals := make([]int, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.ListPeers(ctx, breq)
+		if err != nil {
+			return err
+		}
+
+		for _, peer := range res.Peers {
+			peer.Gateway = b.Name
+		}
+
+		lists[i] = res.Peers
+		totals[i] = res.Total
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []*client.Peer
+	var total int
+	for i, list := range lists {
+		peers = append(peers, list...)
+		total += totals[i]
+	}
+
+	if req.Limit == 0 && req.Offset == 0 && req.After == "" {
+		return &client.ListPeersResponse{Peers: peers, Total: total}, nil
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PublicKey < peers[j].PublicKey })
+	peers = peers[min(req.Offset, len(peers)):]
+
+	res := &client.ListPeersResponse{Peers: peers, Total: total}
+
+	if req.Limit > 0 && len(peers) > req.Limit {
+		res.Peers = peers[:req.Limit]
+		res.Next = peers[req.Limit-1].PublicKey
+		res.NextOffset = req.Offset + req.Limit
+	}
+
+	return res, nil
+}
+
+// GetPeer retrieves a specific Peer by their public key from whichever
+// gateway it belongs to.
+func (p *Proxy) GetPeer(ctx context.Context, req *client.GetPeerRequest) (*client.GetPeerResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	peers := make([]*client.Peer, len(p.backends))
+
+	err := p.each(func(i int, b *Backend) error {
+		res, err := b.Client.GetPeer(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		peers[i] = res.Peer
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, peer := range peers {
+		if peer != nil {
+			peer.Gateway = p.backends[i].Name
+			return &client.GetPeerResponse{Peer: peer}, nil
+		}
+	}
+
+	return &client.GetPeerResponse{}, nil
+}
+
+// ProbePeerEndpoint probes the endpoint of a Peer from the gateway named in
+// the request, otherwise the gateway it belongs to.
+func (p *Proxy) ProbePeerEndpoint(ctx context.Context, req *client.ProbePeerEndpointRequest) (*client.ProbePeerEndpointResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.remove(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return nil, jsonrpc.ServerError(client.ErrCodePeerNotFound, "peer not found", &client.ErrorData{Field: "public_key", Value: req.PublicKey})
+	}
+
+	res, err := b.Client.ProbePeerEndpoint(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// DiagnoseMTU probes the path MTU toward the endpoint of a Peer from the
+// gateway named in the request, otherwise the gateway it belongs to.
+func (p *Proxy) DiagnoseMTU(ctx context.Context, req *client.DiagnoseMTURequest) (*client.DiagnoseMTUResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.remove(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return nil, jsonrpc.ServerError(client.ErrCodePeerNotFound, "peer not found", &client.ErrorData{Field: "public_key", Value: req.PublicKey})
+	}
+
+	res, err := b.Client.DiagnoseMTU(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// ClonePeer clones a Peer on the gateway it belongs to, or that named by
+// Gateway.
+func (p *Proxy) ClonePeer(ctx context.Context, req *client.ClonePeerRequest) (*client.ClonePeerResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.remove(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return nil, jsonrpc.ServerError(client.ErrCodePeerNotFound, "peer not found", &client.ErrorData{Field: "public_key", Value: req.PublicKey})
+	}
+
+	res, err := b.Client.ClonePeer(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// UpdatePeer updates a Peer on the gateway it belongs to, or that named by
+// gateway.
+func (p *Proxy) UpdatePeer(ctx context.Context, req *client.UpdatePeerRequest) (*client.UpdatePeerResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.remove(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return nil, jsonrpc.ServerError(client.ErrCodePeerNotFound, "peer not found", &client.ErrorData{Field: "public_key", Value: req.PublicKey})
+	}
+
+	res, err := b.Client.UpdatePeer(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// SetDeviceConfig configures the device of a single gateway, which is
+// required if there is more than one.
+func (p *Proxy) SetDeviceConfig(ctx context.Context, req *client.SetDeviceConfigRequest) (*client.SetDeviceConfigResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.SetDeviceConfig(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+	res.Device.Gateway = b.Name
+
+	return res, nil
+}
+
+// GenerateKeyPair returns a new private key and its public key, generated
+// by the Proxy itself rather than any gateway.
+func (p *Proxy) GenerateKeyPair(ctx context.Context, req *client.GenerateKeyPairRequest) (*client.GenerateKeyPairResponse, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.GenerateKeyPairResponse{PrivateKey: key.String(), PublicKey: key.PublicKey().String()}, nil
+}
+
+// GeneratePresharedKey returns a new preshared key, generated by the Proxy
+// itself rather than any gateway.
+func (p *Proxy) GeneratePresharedKey(ctx context.Context, req *client.GeneratePresharedKeyRequest) (*client.GeneratePresharedKeyResponse, error) {
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.GeneratePresharedKeyResponse{PresharedKey: key.String()}, nil
+}
+
+// SetPeerMetadata sets the metadata of a Peer on the gateway it belongs to,
+// or that named by gateway.
+func (p *Proxy) SetPeerMetadata(ctx context.Context, req *client.SetPeerMetadataRequest) (*client.SetPeerMetadataResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.remove(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return nil, jsonrpc.ServerError(client.ErrCodePeerNotFound, "peer not found", &client.ErrorData{Field: "public_key", Value: req.PublicKey})
+	}
+
+	res, err := b.Client.SetPeerMetadata(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// GenerateClientConfig generates the configuration of a Peer connecting to
+// the gateway it belongs to, or that named by gateway, which is required if
+// there is more than one and the Peer belongs to none of them.
+func (p *Proxy) GenerateClientConfig(ctx context.Context, req *client.GenerateClientConfigRequest) (*client.GenerateClientConfigResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	var b *Backend
+
+	if req.PrivateKey != "" && req.Gateway == "" {
+		key, err := wgtypes.ParseKey(req.PrivateKey)
+		if err != nil {
+			return nil, invalidParam("private_key", "", "invalid private key: "+err.Error())
+		}
+
+		pl, err := p.placement(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if b, err = pl.remove("", key.PublicKey().String()); err != nil {
+			return nil, err
+		}
+	}
+
+	if b == nil {
+		var err error
+		if b, err = p.only("gateway", req.Gateway); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := b.Client.GenerateClientConfig(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// MovePeers moves Peers between the devices of a single gateway, which is
+// required if there is more than one.
+func (p *Proxy) MovePeers(ctx context.Context, req *client.MovePeersRequest) (*client.MovePeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.MovePeers(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	return res, nil
+}
+
+// RenameDevice renames a device of a single gateway, which is required if
+// there is more than one.
+func (p *Proxy) RenameDevice(ctx context.Context, req *client.RenameDeviceRequest) (*client.RenameDeviceResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.RenameDevice(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	return res, nil
+}
+
+// CreateDevice creates a device on a single gateway, which is required if
+// there is more than one.
+func (p *Proxy) CreateDevice(ctx context.Context, req *client.CreateDeviceRequest) (*client.CreateDeviceResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.CreateDevice(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+	res.Device.Gateway = b.Name
+
+	return res, nil
+}
+
+// DeleteDevice deletes a device of a single gateway, which is required if
+// there is more than one.
+func (p *Proxy) DeleteDevice(ctx context.Context, req *client.DeleteDeviceRequest) (*client.DeleteDeviceResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.DeleteDevice(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// AddPeer inserts or updates a Peer on a single gateway, chosen by route.
+func (p *Proxy) AddPeer(ctx context.Context, req *client.AddPeerRequest) (*client.AddPeerResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.add(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.AddPeer(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	res.Gateway = b.Name
+
+	return res, nil
+}
+
+// RemovePeer deletes a Peer from the gateway named in the request, or else
+// from whichever gateway it belongs to.
+func (p *Proxy) RemovePeer(ctx context.Context, req *client.RemovePeerRequest) (*client.RemovePeerResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := pl.remove(req.Gateway, req.PublicKey)
+	if err != nil {
+		return nil, err
+	} else if b == nil {
+		return &client.RemovePeerResponse{OK: true, Changes: []*client.PeerChange{}}, nil
+	}
+
+	res, err := b.Client.RemovePeer(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	return res, nil
+}
+
+// RemoveAllPeers deletes every Peer from the gateway named in the request,
+// which is required if there is more than one, never from every gateway at
+// once.
+func (p *Proxy) RemoveAllPeers(ctx context.Context, req *client.RemoveAllPeersRequest) (*client.RemoveAllPeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.RemoveAllPeers(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	return res, nil
+}
+
+// group is the operations of a batch routed to a single Backend, along with
+// the index of each operation within the batch.
+type group struct {
+	backend *Backend
+	ops     []*client.BatchOperation
+	indexes []int
+}
+
+// routeBatch groups the operations of a batch by the Backend each is routed
+// to, in the order each Backend is first routed to. Invalid operations, and
+// those removing a Peer that belongs to no Backend, are left to the first
+// Backend.
+func (p *Proxy) routeBatch(ctx context.Context, ops []*client.BatchOperation) ([]*group, error) {
+	pl, err := p.placement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*group
+	byName := make(map[string]*group)
+
+	for i, op := range ops {
+		var b *Backend
+		var err error
+
+		switch {
+		case op != nil && op.AddPeer != nil && op.RemovePeer == nil:
+			b, err = pl.add(op.AddPeer.Gateway, op.AddPeer.PublicKey)
+
+		case op != nil && op.RemovePeer != nil && op.AddPeer == nil:
+			b, err = pl.remove(op.RemovePeer.Gateway, op.RemovePeer.PublicKey)
+		}
+		if err != nil {
+			return nil, err
+		} else if b == nil {
+			b = p.backends[0]
+		}
+
+		g, ok := byName[b.Name]
+		if !ok {
+			g = &group{backend: b}
+			byName[b.Name] = g
+			groups = append(groups, g)
+		}
+
+		g.ops = append(g.ops, op)
+		g.indexes = append(g.indexes, i)
+	}
+
+	return groups, nil
+}
+
+// applyBatch applies each group of a batch to its Backend in turn. OnError
+// applies to each group individually, groups already applied to other
+// Backends are never rolled back, but unless onError is continue no further
+// groups are applied after one fails.
+func (p *Proxy) applyBatch(ctx context.Context, req *client.ApplyBatchRequest) (*client.ApplyBatchResponse, error) {
+	groups, err := p.routeBatch(ctx, req.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ExpectedGeneration != 0 && len(groups) > 1 {
+		return nil, invalidParam("expected_generation", fmt.Sprint(req.ExpectedGeneration), "expected generation cannot be given for a batch spanning gateways")
+	}
+
+	out := &client.ApplyBatchResponse{
+		OK:      true,
+		Results: make([]*client.BatchResult, len(req.Operations)),
+		Changes: []*client.PeerChange{},
+	}
+
+	for i, op := range req.Operations {
+		out.Results[i] = &client.BatchResult{Index: i}
+
+		if op != nil && op.AddPeer != nil {
+			out.Results[i].PublicKey = op.AddPeer.PublicKey
+		} else if op != nil && op.RemovePeer != nil {
+			out.Results[i].PublicKey = op.RemovePeer.PublicKey
+		}
+	}
+
+	for _, g := range groups {
+		res, err := g.backend.Client.ApplyBatch(ctx, &client.ApplyBatchRequest{
+			Operations:         g.ops,
+			OnError:            req.OnError,
+			ExpectedGeneration: req.ExpectedGeneration,
+			DryRun:             req.DryRun,
+		})
+		if err != nil {
+			return nil, gatewayError(g.backend, err)
+		}
+
+		for _, result := range res.Results {
+			result.Index = g.indexes[result.Index]
+			result.Gateway = g.backend.Name
+			out.Results[result.Index] = result
+		}
+
+		out.RolledBack = out.RolledBack || res.RolledBack
+		out.DryRun = out.DryRun || res.DryRun
+		out.Changes = append(out.Changes, res.Changes...)
+
+		if !res.OK {
+			out.OK = false
+
+			if req.OnError != client.OnErrorContinue {
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// AddPeers inserts or updates many Peers at once, each routed to a gateway
+// as by AddPeer.
+func (p *Proxy) AddPeers(ctx context.Context, req *client.AddPeersRequest) (*client.AddPeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	ops := make([]*client.BatchOperation, len(req.Peers))
+	for i, peer := range req.Peers {
+		ops[i] = &client.BatchOperation{AddPeer: peer}
+	}
+
+	res, err := p.applyBatch(ctx, &client.ApplyBatchRequest{
+		Operations:         ops,
+		OnError:            req.OnError,
+		ExpectedGeneration: req.ExpectedGeneration,
+		DryRun:             req.DryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.AddPeersResponse{
+		OK:         res.OK,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+	}, nil
+}
+
+// RemovePeers removes many Peers, each from the gateway it belongs to.
+func (p *Proxy) RemovePeers(ctx context.Context, req *client.RemovePeersRequest) (*client.RemovePeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	ops := make([]*client.BatchOperation, len(req.PublicKeys))
+	for i, publicKey := range req.PublicKeys {
+		ops[i] = &client.BatchOperation{RemovePeer: &client.RemovePeerRequest{PublicKey: publicKey}}
+	}
+
+	res, err := p.applyBatch(ctx, &client.ApplyBatchRequest{
+		Operations:         ops,
+		OnError:            req.OnError,
+		ExpectedGeneration: req.ExpectedGeneration,
+		DryRun:             req.DryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.RemovePeersResponse{
+		OK:         res.OK,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+	}, nil
+}
+
+// ApplyBatch applies a sequence of Peer additions and removals, each routed
+// to a gateway as by AddPeer and RemovePeer.
+func (p *Proxy) ApplyBatch(ctx context.Context, req *client.ApplyBatchRequest) (*client.ApplyBatchResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	return p.applyBatch(ctx, req)
+}
+
+// ImportPeers imports Peers into the gateway named in the request.
+func (p *Proxy) ImportPeers(ctx context.Context, req *client.ImportPeersRequest) (*client.ImportPeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.ImportPeers(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	for _, result := range res.Results {
+		result.Gateway = b.Name
+	}
+
+	return res, nil
+}
+
+// SyncPeers syncs the Peers of the gateway named in the request.
+func (p *Proxy) SyncPeers(ctx context.Context, req *client.SyncPeersRequest) (*client.SyncPeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.SyncPeers(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	for _, result := range res.Results {
+		result.Gateway = b.Name
+	}
+
+	return res, nil
+}
+
+// ExportPeers exports the Peers of the gateway named in the request.
+func (p *Proxy) ExportPeers(ctx context.Context, req *client.ExportPeersRequest) (*client.ExportPeersResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	b, err := p.only("gateway", req.Gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.Client.ExportPeers(ctx, req)
+	if err != nil {
+		return nil, gatewayError(b, err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,298 @@
+package qr
+
+// newCode returns an empty Code of version with its function patterns, those
+// that are not data, drawn.
+func newCode(version int) *Code {
+	size := version*4 + 17
+
+	c := &Code{
+		Size:     size,
+		modules:  make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+
+	for i := 0; i < size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(size-4, 3)
+	c.drawFinder(3, size-4)
+
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+
+	for i, x := range positions {
+		for j, y := range positions {
+			// alignment patterns are not drawn over the finder patterns.
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// the format is drawn once the mask is known, its modules are reserved
+	// such that no codeword is drawn over them.
+	c.drawFormat(L, 0)
+	c.drawVersion(version)
+
+	return c
+}
+
+// setFunction sets the module at column x and row y as part of a function
+// pattern.
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+// drawFinder draws a finder pattern, and its separator, centred on x, y.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+
+			d := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centred on x, y.
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the rows and columns of the centres of the
+// alignment patterns of version.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	n := version/7 + 2
+
+	step := (version*4 + n*2 + 1) / (n*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+
+	positions := make([]int, n)
+	positions[0] = 6
+
+	for i, pos := n-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// drawFormat draws both copies of the format information of level and mask,
+// and the dark module beside them.
+func (c *Code) drawFormat(level Level, mask int) {
+	data := formatBits[level]<<3 | mask
+
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version information, which only
+// versions 7 and above have.
+func (c *Code) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+
+	bits := version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := c.Size-11+i%3, i/3
+
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords draws data into the modules that are not part of a function
+// pattern, in pairs of columns zigzagging up and down from the bottom right.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern is skipped over.
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if upward {
+					y = c.Size - 1 - vert
+				}
+
+				if !c.function[y*c.Size+x] && i < len(data)*8 {
+					c.modules[y*c.Size+x] = (data[i/8]>>(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the modules of mask that are not part of a function
+// pattern, such that applying it twice removes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !c.function[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// finderLike are runs of modules that resemble a finder pattern, which are
+// penalized.
+var finderLike = [...][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty scores the Code by the rules of the specification, such that the
+// mask with the fewest patterns that may confuse a reader is chosen.
+func (c *Code) penalty() int {
+	p, dark := 0, 0
+
+	for i := 0; i < c.Size; i++ {
+		row := func(j int) bool { return c.Dark(j, i) }
+		col := func(j int) bool { return c.Dark(i, j) }
+
+		for _, line := range []func(int) bool{row, col} {
+			// runs of five or more modules of the same colour.
+			run := 1
+			for j := 1; j <= c.Size; j++ {
+				if j < c.Size && line(j) == line(j-1) {
+					run++
+					continue
+				}
+
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+
+				run = 1
+			}
+
+			for j := 0; j+11 <= c.Size; j++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, v := range pattern {
+						if line(j+k) != v {
+							match = false
+							break
+						}
+					}
+
+					if match {
+						p += 40
+					}
+				}
+			}
+		}
+	}
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			d := c.Dark(x, y)
+			if d {
+				dark++
+			}
+
+			// blocks of 2x2 modules of the same colour.
+			if x+1 < c.Size && y+1 < c.Size && d == c.Dark(x+1, y) && d == c.Dark(x, y+1) && d == c.Dark(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+
+	// the further the proportion of dark modules is from half.
+	total := c.Size * c.Size
+	p += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+
+	return p
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,272 @@
+// Package qr encodes data as a QR code (ISO/IEC 18004), such that the
+// configuration of a Peer can be scanned by the official WireGuard apps
+// rather than typed. Only byte mode is supported, which encodes any data.
+package qr
+
+import (
+	"errors"
+)
+
+// Level is the error correction level of a Code, the proportion of it that
+// may be damaged while it can still be read.
+type Level int
+
+// Levels of error correction, from least to most.
+const (
+	// L recovers 7% of a Code.
+	L Level = iota
+
+	// M recovers 15% of a Code.
+	M
+
+	// Q recovers 25% of a Code.
+	Q
+
+	// H recovers 30% of a Code.
+	H
+)
+
+// ErrTooLong is returned when data will not fit in even the largest Code of
+// the error correction level.
+var ErrTooLong = errors.New("qr: data too long")
+
+// formatBits are the bits of each Level in the format information.
+var formatBits = [...]int{L: 1, M: 0, Q: 3, H: 2}
+
+// eccPerBlock is the number of error correction codewords of each block of
+// every version, by Level.
+var eccPerBlock = [...][41]int{
+	L: {0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	M: {0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	Q: {0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	H: {0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// eccBlocks is the number of error correction blocks of every version, by
+// Level.
+var eccBlocks = [...][41]int{
+	L: {0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	M: {0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	Q: {0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	H: {0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code is a QR code, a square of dark and light modules.
+type Code struct {
+	// Size is the number of modules along each side, without the quiet
+	// zone that must surround the Code when it is displayed.
+	Size int
+
+	modules  []bool
+	function []bool
+}
+
+// Encode returns the smallest Code of data at the error correction level.
+func Encode(data []byte, level Level) (*Code, error) {
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+countBits(version)+len(data)*8 <= dataCodewords(version, level)*8 {
+			break
+		}
+	}
+
+	if version > 40 {
+		return nil, ErrTooLong
+	}
+
+	var bits bitBuffer
+	bits.append(0x4, 4) // byte mode
+	bits.append(len(data), countBits(version))
+
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := dataCodewords(version, level) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := newCode(version)
+	c.drawCodewords(addECC(bits.bytes(), version, level))
+
+	// the mask with the lowest penalty is chosen, such that the Code has no
+	// patterns that may confuse a reader.
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(level, mask)
+
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+
+		c.applyMask(mask)
+	}
+
+	c.applyMask(best)
+	c.drawFormat(level, best)
+
+	return c, nil
+}
+
+// Dark returns true if the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+
+	return c.modules[y*c.Size+x]
+}
+
+// countBits is the length of the character count of byte mode in version.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+
+	return 16
+}
+
+// rawDataModules is the number of modules of version that hold codewords,
+// both data and error correction.
+func rawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+
+		if version >= 7 {
+			n -= 36
+		}
+	}
+
+	return n
+}
+
+// dataCodewords is the number of codewords of data in version at level.
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// addECC splits data into the blocks of version at level, appends the error
+// correction codewords of each, and interleaves them.
+func addECC(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawDataModules(version) / 8
+
+	// the first blocks are short, the rest have one more data codeword.
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+
+		block := data[k : k+n]
+		k += n
+
+		blocks[i] = append(append([]byte(nil), block...), rsRemainder(block, divisor)...)
+	}
+
+	out := make([]byte, 0, raw)
+
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for j, block := range blocks {
+			// short blocks have no data codeword at the last index.
+			if i < shortLen-eccLen || j >= numShort {
+				out = append(out, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < eccLen; i++ {
+		for _, block := range blocks {
+			out = append(out, block[len(block)-eccLen+i])
+		}
+	}
+
+	return out
+}
+
+// rsDivisor returns the generator polynomial of degree, highest coefficient
+// first, the leading 1 omitted.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+
+		root = gfMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+
+	return result
+}
+
+// gfMultiply multiplies x and y in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+// bitBuffer is a sequence of bits, most significant first.
+type bitBuffer []bool
+
+// append appends the n least significant bits of v.
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (v>>i)&1 != 0)
+	}
+}
+
+// bytes returns the bits packed into bytes, of which there must be a whole
+// number.
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,69 @@
+package qr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// quietZone is the number of light modules that must surround a Code for it
+// to be read.
+const quietZone = 4
+
+// PNG returns the Code as a PNG image, each module scale pixels square,
+// surrounded by its quiet zone.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	if scale < 1 {
+		scale = 1
+	}
+
+	size := (c.Size + quietZone*2) * scale
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Dark(x/scale-quietZone, y/scale-quietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// String returns the Code as text, two rows of modules to each line of half
+// block characters, surrounded by its quiet zone. Light modules are drawn as
+// blocks, such that the Code is read from a terminal with a dark background.
+func (c *Code) String() string {
+	var b strings.Builder
+
+	for y := -quietZone; y < c.Size+quietZone; y += 2 {
+		for x := -quietZone; x < c.Size+quietZone; x++ {
+			top := !c.Dark(x, y)
+			bottom := !c.Dark(x, y+1) && y+1 < c.Size+quietZone
+
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
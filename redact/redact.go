@@ -0,0 +1,185 @@
+// Package redact hashes or truncates the public keys and endpoints of Peers
+// in logs, metrics and Events, such that data leaving WG-API is minimized
+// while one Peer can still be told from another.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// Modes of a Redactor.
+const (
+	// ModeHash replaces public keys and the addresses of endpoints with a
+	// short hash, such that the same Peer always has the same hash.
+	ModeHash = "hash"
+
+	// ModeTruncate keeps only the first characters of public keys, and the
+	// network of endpoints, a /24 for IPv4 and a /48 for IPv6, without port.
+	ModeTruncate = "truncate"
+)
+
+// publicKeyPattern matches a WireGuard key, 32 bytes encoded as base64.
+var publicKeyPattern = regexp.MustCompile(`[A-Za-z0-9+/]{42}[AEIMQUYcgkosw048]=`)
+
+// Redactor redacts public keys and endpoints. A nil Redactor returns
+// everything unchanged.
+type Redactor struct {
+	mode string
+	key  []byte
+}
+
+// New returns a Redactor of mode, either hash or truncate. If key is given,
+// hashes are keyed with it, such that they cannot be reversed by hashing
+// known public keys without it. New returns nil if mode is empty or none.
+func New(mode, key string) (*Redactor, error) {
+	switch mode {
+	case "", "none":
+		return nil, nil
+
+	case ModeHash, ModeTruncate:
+		return &Redactor{mode: mode, key: []byte(key)}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %q, must be one of none, hash or truncate", mode)
+	}
+}
+
+// hash returns a short hash of s.
+func (r *Redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// PublicKey redacts a public key.
+func (r *Redactor) PublicKey(publicKey string) string {
+	if r == nil || publicKey == "" {
+		return publicKey
+	}
+
+	if r.mode == ModeTruncate {
+		if len(publicKey) > 8 {
+			return publicKey[:8] + "..."
+		}
+
+		return publicKey
+	}
+
+	return "h:" + r.hash(publicKey)
+}
+
+// Endpoint redacts the address of an endpoint, such as 203.0.113.7:51820.
+func (r *Redactor) Endpoint(endpoint string) string {
+	if r == nil || endpoint == "" || endpoint == "<nil>" {
+		return endpoint
+	}
+
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = endpoint, ""
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "h:" + r.hash(endpoint)
+	}
+
+	if r.mode == ModeTruncate {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+
+		return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	}
+
+	if port == "" {
+		return "h:" + r.hash(ip.String())
+	}
+
+	return "h:" + r.hash(ip.String()) + ":" + port
+}
+
+// Text redacts every public key within s, such as a log line or message.
+func (r *Redactor) Text(s string) string {
+	if r == nil {
+		return s
+	}
+
+	return publicKeyPattern.ReplaceAllStringFunc(s, r.PublicKey)
+}
+
+// Peer returns a copy of peer with its public key and endpoint redacted.
+func (r *Redactor) Peer(peer *client.Peer) *client.Peer {
+	if r == nil || peer == nil {
+		return peer
+	}
+
+	p := *peer
+	p.PublicKey = r.PublicKey(p.PublicKey)
+	p.Endpoint = r.Endpoint(p.Endpoint)
+
+	return &p
+}
+
+// Event returns a copy of event with the public keys and endpoints of the
+// Peers it describes redacted.
+func (r *Redactor) Event(event *client.Event) *client.Event {
+	if r == nil || event == nil {
+		return event
+	}
+
+	e := *event
+	e.PublicKey = r.PublicKey(e.PublicKey)
+	e.Peer = r.Peer(e.Peer)
+
+	if e.Change != nil {
+		change := *e.Change
+		change.PublicKey = r.PublicKey(change.PublicKey)
+		change.Before = r.Peer(change.Before)
+		change.After = r.Peer(change.After)
+		e.Change = &change
+	}
+
+	if e.Alert != nil {
+		alert := *e.Alert
+		alert.Subject = r.Text(alert.Subject)
+		alert.Message = r.Text(alert.Message)
+		e.Alert = &alert
+	}
+
+	return &e
+}
+
+// writer redacts the public keys of every line written to it.
+type writer struct {
+	r *Redactor
+	w io.Writer
+}
+
+// Writer returns a Writer that redacts every public key written to w, such
+// as the output of a log.Logger which writes each line with a single Write.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+
+	return &writer{r: r, w: w}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(publicKeyPattern.ReplaceAllFunc(p, func(b []byte) []byte {
+		return []byte(w.r.PublicKey(string(b)))
+	})); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// reloadable is a handler that may be replaced while it is served, such that
+// the authentication of a listener changes without closing it.
+type reloadable struct {
+	h atomic.Pointer[http.Handler]
+}
+
+func newReloadable(h http.Handler) *reloadable {
+	r := new(reloadable)
+	r.set(h)
+
+	return r
+}
+
+// set serves h to every request from now on.
+func (r *reloadable) set(h http.Handler) {
+	r.h.Store(&h)
+}
+
+func (r *reloadable) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	(*r.h.Load()).ServeHTTP(w, req)
+}
+
+// certificate is a TLS certificate that may be loaded again from its files
+// while it is served, such that it is renewed without closing the listener.
+type certificate struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// load loads the certificate from certFile and keyFile, which are loaded
+// again by reload. If it cannot be loaded, the previous certificate remains.
+func (c *certificate) load(certFile, keyFile string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.certFile, c.keyFile = certFile, keyFile
+	c.cert.Store(&cert)
+
+	return nil
+}
+
+// reload loads the certificate again from its files.
+func (c *certificate) reload() error {
+	c.mu.Lock()
+	certFile, keyFile := c.certFile, c.keyFile
+	c.mu.Unlock()
+
+	return c.load(certFile, keyFile)
+}
+
+// get returns the certificate, for the GetCertificate of a tls.Config.
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// shutdownServers shuts down every one of servers at once, each waiting for
+// the requests in progress to finish until ctx expires.
+func shutdownServers(ctx context.Context, servers []*http.Server) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Shutdown(ctx)
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// waitForSignals waits for any of errs, which is returned, or for SIGTERM or
+// SIGINT, on which shutdown is called with a context that expires after
+// --shutdown-timeout and its error returned. On SIGHUP, reload is called and
+// any error logged, such that tokens and certificates are rotated without a
+// restart.
+func waitForSignals(errs <-chan error, reload func() error, shutdown func(context.Context) error) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case err := <-errs:
+			return err
+
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				if err := reload(); err != nil {
+					log.Printf("error: server: could not reload: %s\n", err)
+				} else {
+					log.Println("info: server: reloaded tokens and certificates")
+				}
+
+				continue
+			}
+
+			log.Printf("info: server: %s received, shutting down\n", sig)
+
+			ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+			defer cancel()
+
+			return shutdown(ctx)
+		}
+	}
+}
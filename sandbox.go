@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jamescun/wg-api/sandbox"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	enableSandbox = flag.Bool("sandbox", false, "")
+	sandboxPaths  = flag.StringArray("sandbox-path", nil, "")
+)
+
+// sandboxReadOnly are the paths always readable once sandboxed, such as
+// for resolving names and verifying certificates, and for finding the UAPI
+// sockets of userspace devices, such as wireguard-go or boringtun, which are
+// listed on every dump of the devices. Landlock does not restrict connecting
+// to the sockets once found.
+var sandboxReadOnly = []string{"/etc", "/usr/share/zoneinfo", "/usr/share/ca-certificates", wireguardRunDir, "/run/wireguard"}
+
+// applySandbox sandboxes the server, if enabled, allowing it only the files
+// given to its options, and reading those of paths, once everything else has
+// been loaded.
+func applySandbox(paths ...string) error {
+	if !*enableSandbox {
+		return nil
+	}
+
+	opts := sandbox.Options{
+		ReadOnly:  append([]string{}, sandboxReadOnly...),
+		ReadWrite: append([]string{os.TempDir(), "/dev/null"}, *sandboxPaths...),
+	}
+
+	// the config file, certificates and tokens are loaded again on SIGHUP, desired peers are
+	// read as they change, and static files as they are requested.
+	for _, path := range append([]string{*configFile, *tlsKey, *tlsCert, *tokenFile, *listenersFile, *kubeconfig, *peersDir, *staticDir}, paths...) {
+		if path != "" {
+			opts.ReadOnly = append(opts.ReadOnly, path)
+		}
+	}
+
+	// the store keeps its journal beside its database, and names and the
+	// state file are written by replacing their files, so their
+	// directories are writable.
+	if filename, ok := strings.CutPrefix(*storeSpec, "sqlite:"); ok {
+		opts.ReadWrite = append(opts.ReadWrite, filepath.Dir(filename))
+	}
+
+	for _, path := range []string{*hostsFile, *zoneFile, *stateFile} {
+		if path != "" {
+			opts.ReadWrite = append(opts.ReadWrite, filepath.Dir(path))
+		}
+	}
+
+	return sandbox.Apply(opts)
+}
@@ -0,0 +1,20 @@
+// Package sandbox restricts the system calls and files available to WG-API
+// once it has started, such that a compromise of a process that is both
+// networked and holds CAP_NET_ADMIN is contained.
+//
+// System calls are restricted by a seccomp filter allowing only those made by
+// WG-API, the Go runtime and its dependencies, such as for files, sockets,
+// netlink and polling, and denying any other with EPERM, such as loading
+// kernel modules, mounting filesystems, tracing other processes, executing
+// programs or any system call added by a newer kernel. A dependency that
+// begins making another system call must have it added to the filter. Files
+// are restricted by Landlock to the paths given, where the kernel supports
+// it.
+package sandbox
+
+// Options are the paths the process may access once sandboxed, and anything
+// beneath them. Paths that do not exist are ignored.
+type Options struct {
+	ReadOnly  []string
+	ReadWrite []string
+}
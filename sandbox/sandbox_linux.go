@@ -0,0 +1,347 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// allowed are the system calls made by WG-API, the Go runtime and the
+// dependencies of WG-API on every architecture, those of the architecture
+// being in allowedArch. Any other is denied by the seccomp filter.
+var allowed = []uint32{
+	// memory, threads and scheduling of the Go runtime.
+	unix.SYS_BRK,
+	unix.SYS_CLONE,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+	unix.SYS_FUTEX,
+	unix.SYS_GETRANDOM,
+	unix.SYS_GETTID,
+	unix.SYS_MADVISE,
+	unix.SYS_MMAP,
+	unix.SYS_MPROTECT,
+	unix.SYS_MREMAP,
+	unix.SYS_MUNMAP,
+	unix.SYS_RESTART_SYSCALL,
+	unix.SYS_RSEQ,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_SET_TID_ADDRESS,
+
+	// signals, and timers of the runtime and profiling.
+	unix.SYS_GETITIMER,
+	unix.SYS_KILL,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SETITIMER,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_TGKILL,
+	unix.SYS_TIMER_CREATE,
+	unix.SYS_TIMER_DELETE,
+	unix.SYS_TIMER_SETTIME,
+
+	// time.
+	unix.SYS_CLOCK_GETRES,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_GETTIMEOFDAY,
+	unix.SYS_NANOSLEEP,
+
+	// the process, and the plugins started before it was sandboxed.
+	unix.SYS_GETEGID,
+	unix.SYS_GETEUID,
+	unix.SYS_GETGID,
+	unix.SYS_GETGROUPS,
+	unix.SYS_GETPGID,
+	unix.SYS_GETPID,
+	unix.SYS_GETPPID,
+	unix.SYS_GETRLIMIT,
+	unix.SYS_GETRUSAGE,
+	unix.SYS_GETUID,
+	unix.SYS_PIDFD_OPEN,
+	unix.SYS_PIDFD_SEND_SIGNAL,
+	unix.SYS_PRLIMIT64,
+	unix.SYS_SYSINFO,
+	unix.SYS_UNAME,
+	unix.SYS_WAIT4,
+	unix.SYS_WAITID,
+
+	// the network poller.
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EPOLL_PWAIT2,
+	unix.SYS_EVENTFD2,
+	unix.SYS_PIPE2,
+	unix.SYS_PPOLL,
+	unix.SYS_PSELECT6,
+
+	// files, including the store, and watching them for changes.
+	unix.SYS_CLOSE,
+	unix.SYS_CLOSE_RANGE,
+	unix.SYS_COPY_FILE_RANGE,
+	unix.SYS_DUP,
+	unix.SYS_DUP3,
+	unix.SYS_FACCESSAT,
+	unix.SYS_FACCESSAT2,
+	unix.SYS_FADVISE64,
+	unix.SYS_FALLOCATE,
+	unix.SYS_FCHMOD,
+	unix.SYS_FCHMODAT,
+	unix.SYS_FCHOWN,
+	unix.SYS_FCHOWNAT,
+	unix.SYS_FCNTL,
+	unix.SYS_FDATASYNC,
+	unix.SYS_FLOCK,
+	unix.SYS_FSTAT,
+	unix.SYS_FSTATFS,
+	unix.SYS_FSYNC,
+	unix.SYS_FTRUNCATE,
+	unix.SYS_GETCWD,
+	unix.SYS_GETDENTS64,
+	unix.SYS_INOTIFY_ADD_WATCH,
+	unix.SYS_INOTIFY_INIT1,
+	unix.SYS_INOTIFY_RM_WATCH,
+	unix.SYS_IOCTL,
+	unix.SYS_LINKAT,
+	unix.SYS_LSEEK,
+	unix.SYS_MKDIRAT,
+	unix.SYS_OPENAT,
+	unix.SYS_PREAD64,
+	unix.SYS_PREADV,
+	unix.SYS_PWRITE64,
+	unix.SYS_PWRITEV,
+	unix.SYS_READ,
+	unix.SYS_READLINKAT,
+	unix.SYS_READV,
+	unix.SYS_RENAMEAT,
+	unix.SYS_RENAMEAT2,
+	unix.SYS_SENDFILE,
+	unix.SYS_SPLICE,
+	unix.SYS_STATFS,
+	unix.SYS_STATX,
+	unix.SYS_SYMLINKAT,
+	unix.SYS_UNLINKAT,
+	unix.SYS_UTIMENSAT,
+	unix.SYS_WRITE,
+	unix.SYS_WRITEV,
+
+	// sockets, including the netlink of WireGuard, conntrack and links.
+	unix.SYS_ACCEPT,
+	unix.SYS_ACCEPT4,
+	unix.SYS_BIND,
+	unix.SYS_CONNECT,
+	unix.SYS_GETPEERNAME,
+	unix.SYS_GETSOCKNAME,
+	unix.SYS_GETSOCKOPT,
+	unix.SYS_LISTEN,
+	unix.SYS_RECVFROM,
+	unix.SYS_RECVMMSG,
+	unix.SYS_RECVMSG,
+	unix.SYS_SENDMMSG,
+	unix.SYS_SENDMSG,
+	unix.SYS_SENDTO,
+	unix.SYS_SETSOCKOPT,
+	unix.SYS_SHUTDOWN,
+	unix.SYS_SOCKET,
+	unix.SYS_SOCKETPAIR,
+}
+
+// x32SyscallBit is set in the numbers of the system calls of the x32 ABI on
+// amd64, which would otherwise bypass the filter.
+const x32SyscallBit = 0x40000000
+
+const (
+	// landlockV1 are the file accesses known to the first version of
+	// Landlock.
+	landlockV1 = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+	// landlockFile are the accesses that may be given to a file, rather
+	// than a directory.
+	landlockFile = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+	landlockReadOnly = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	// landlockDenied are never given to any path, even if read-write.
+	landlockDenied = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK
+)
+
+// Apply sandboxes every thread of the process, and any it later creates.
+// It cannot be undone. If the kernel does not support Landlock, or the
+// process was built with cgo such that it cannot be applied to every
+// thread, a warning is logged and files are not restricted.
+func Apply(opts Options) error {
+	if err := applySeccomp(); err != nil {
+		return fmt.Errorf("could not apply seccomp filter: %w", err)
+	}
+
+	if err := applyLandlock(opts); err != nil {
+		return fmt.Errorf("could not apply landlock: %w", err)
+	}
+
+	return nil
+}
+
+func applySeccomp() error {
+	// no_new_privs must be set on the thread installing the filter, it is
+	// given to every other thread as the filter is synchronized to them.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+
+	filter := seccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	} else if r != 0 {
+		return fmt.Errorf("thread %d could not be synchronized", r)
+	}
+
+	return nil
+}
+
+// seccompFilter returns a BPF program allowing only the system calls of
+// allowed and allowedArch, denying any other with EPERM, as well as every
+// system call of another architecture whose numbers would differ. clone3
+// is denied with ENOSYS instead, such that the C library of a build with cgo
+// falls back to clone, whose flags the Go runtime never uses to create
+// namespaces.
+func seccompFilter() []unix.SockFilter {
+	arch := uint32(unix.AUDIT_ARCH_AARCH64)
+	if runtime.GOARCH == "amd64" {
+		arch = unix.AUDIT_ARCH_X86_64
+	}
+
+	deny := bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM))
+
+	// the offsets of the architecture and number of the system call in
+	// struct seccomp_data.
+	filter := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		deny,
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0),
+	}
+
+	if runtime.GOARCH == "amd64" {
+		filter = append(filter, bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1), deny)
+	}
+
+	filter = append(filter,
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE3, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+	)
+
+	allow := bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW)
+
+	for _, nr := range append(allowed, allowedArch...) {
+		filter = append(filter, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1), allow)
+	}
+
+	return append(filter, deny)
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+func applyLandlock(opts Options) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		log.Printf("warn: sandbox: landlock is not supported by the kernel, files are not restricted: %s\n", errno)
+		return nil
+	}
+
+	handled := uint64(landlockV1)
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(fd))
+
+	for _, path := range opts.ReadOnly {
+		if err := addRule(int(fd), path, landlockReadOnly&handled); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range opts.ReadWrite {
+		if err := addRule(int(fd), path, handled&^landlockDenied); err != nil {
+			return err
+		}
+	}
+
+	// a Landlock domain applies only to the thread restricting itself, so
+	// must be applied to every thread of the process at once.
+	_, _, errno = syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+	if errno == syscall.ENOTSUP {
+		log.Println("warn: sandbox: landlock requires WG-API to be built with CGO_ENABLED=0, files are not restricted")
+		return nil
+	} else if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// addRule allows access beneath path, if it exists.
+func addRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFile
+	}
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("%s: %w", path, errno)
+	}
+
+	return nil
+}
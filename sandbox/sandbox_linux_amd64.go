@@ -0,0 +1,30 @@
+package sandbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// allowedArch are the system calls made by WG-API only present on amd64,
+// mostly those replaced by their *at variants on newer architectures.
+var allowedArch = []uint32{
+	unix.SYS_ACCESS,
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_CHMOD,
+	unix.SYS_DUP2,
+	unix.SYS_EPOLL_CREATE,
+	unix.SYS_EPOLL_WAIT,
+	unix.SYS_GETDENTS,
+	unix.SYS_LSTAT,
+	unix.SYS_MKDIR,
+	unix.SYS_NEWFSTATAT,
+	unix.SYS_OPEN,
+	unix.SYS_PIPE,
+	unix.SYS_POLL,
+	unix.SYS_READLINK,
+	unix.SYS_RENAME,
+	unix.SYS_RMDIR,
+	unix.SYS_SELECT,
+	unix.SYS_STAT,
+	unix.SYS_TIME,
+	unix.SYS_UNLINK,
+}
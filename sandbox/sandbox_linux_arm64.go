@@ -0,0 +1,10 @@
+package sandbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// allowedArch are the system calls made by WG-API only present on arm64.
+var allowedArch = []uint32{
+	unix.SYS_FSTATAT,
+}
@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64)
+
+package sandbox
+
+import (
+	"errors"
+)
+
+// Apply is only supported on Linux on amd64 and arm64.
+func Apply(opts Options) error {
+	return errors.New("sandboxing is only supported on linux on amd64 and arm64")
+}
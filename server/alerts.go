@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of AlertRule.
+const (
+	// AlertPeerOffline holds while a Peer has not completed a handshake, for
+	// at least as long as For since its last handshake.
+	AlertPeerOffline = "peer_offline"
+
+	// AlertDeviceRate holds while the Peers of the device are transferring
+	// more than Threshold bits per second in total.
+	AlertDeviceRate = "device_rate"
+
+	// AlertPoolUsage holds while at least Threshold, a fraction such as 0.9,
+	// of the addresses of a range of the IPAM pool are leased.
+	AlertPoolUsage = "pool_usage"
+
+	// AlertPeerRateSpike holds while a Peer is transferring at least
+	// Threshold times, such as 10, its rate before the most recent Window.
+	AlertPeerRateSpike = "peer_rate_spike"
+
+	// AlertPeerRate holds while a Peer is transferring more than Threshold
+	// bits per second, such as while it saturates its link.
+	AlertPeerRate = "peer_rate"
+
+	// AlertIdlePeerTraffic holds while a Peer that should be idle, such as
+	// one whose user is known to be away, is transferring more than
+	// Threshold bits per second.
+	AlertIdlePeerTraffic = "idle_peer_traffic"
+)
+
+// alertInterval is how often the conditions of every AlertRule are
+// evaluated.
+const alertInterval = 15 * time.Second
+
+// AlertRule is a condition of the device evaluated by WG-API, such that
+// small deployments without a monitoring system are alerted to problems. An
+// alert.firing Event is published once the condition has held for For, and
+// an alert.resolved Event once it no longer holds.
+type AlertRule struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+
+	// PublicKey, if set, limits a rule of Peers to a single Peer, and
+	// Metadata to the Peers with all of the metadata given, otherwise it
+	// applies to every Peer.
+	PublicKey string            `yaml:"public_key"`
+	Metadata  map[string]string `yaml:"metadata"`
+
+	// Threshold is the bits per second of a device_rate, peer_rate or
+	// idle_peer_traffic rule, the factor of a peer_rate_spike rule or the
+	// fraction of addresses leased of a pool_usage rule.
+	Threshold float64 `yaml:"threshold"`
+
+	// Window is the period the rate of a Peer is averaged over, by default
+	// the rate window, or the recent period compared with the rest of the
+	// rate window by a peer_rate_spike rule, by default a quarter of it.
+	Window time.Duration `yaml:"window"`
+
+	// MinRate is the bits per second a Peer must be transferring for a
+	// peer_rate_spike rule to hold, such that a Peer transferring little is
+	// not alerted to.
+	MinRate float64 `yaml:"min_rate"`
+
+	// For is how long the condition must hold before the alert fires.
+	For time.Duration `yaml:"for"`
+
+	// Message, if set, replaces the message describing the alert.
+	Message string `yaml:"message"`
+}
+
+// Alerts is a set of AlertRules evaluated against the device.
+type Alerts struct {
+	Rules []*AlertRule `yaml:"rules"`
+
+	// active are the alerts whose condition holds, by rule and subject.
+	active map[alertKey]*alertState
+}
+
+type alertKey struct {
+	rule    string
+	subject string
+}
+
+// alertState is an alert whose condition holds, which has fired if it has
+// held for the For of its rule.
+type alertState struct {
+	alert  *client.Alert
+	firing bool
+}
+
+// condition is a subject of an AlertRule whose condition holds.
+type condition struct {
+	subject string
+	value   float64
+	message string
+
+	// since is when the condition began to hold, if known.
+	since time.Time
+}
+
+// LoadAlerts reads AlertRules from a YAML (or JSON) file.
+func LoadAlerts(filename string) (*Alerts, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := new(Alerts)
+	if err := yaml.Unmarshal(data, alerts); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+
+	for i, rule := range alerts.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d: rule is empty", i)
+		} else if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+
+		names[rule.Name] = true
+
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return alerts, nil
+}
+
+func (r *AlertRule) validate() error {
+	if r.For < 0 {
+		return fmt.Errorf("for must not be negative")
+	} else if r.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+
+	if r.PublicKey != "" {
+		if _, err := wgtypes.ParseKey(r.PublicKey); err != nil {
+			return fmt.Errorf("invalid public key: %w", err)
+		}
+	}
+
+	if (r.PublicKey != "" || len(r.Metadata) > 0) && !isPeerAlert(r.Kind) {
+		return fmt.Errorf("public_key and metadata only apply to rules of peers")
+	}
+
+	switch r.Kind {
+	case AlertPeerOffline:
+
+	case AlertPeerRateSpike:
+		if r.Threshold <= 1 {
+			return fmt.Errorf("threshold must be a factor greater than 1")
+		} else if r.MinRate < 0 {
+			return fmt.Errorf("min_rate must not be negative")
+		}
+
+	case AlertPeerRate:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("threshold must be positive")
+		}
+
+	case AlertIdlePeerTraffic:
+		if r.Threshold < 0 {
+			return fmt.Errorf("threshold must not be negative")
+		} else if r.PublicKey == "" && len(r.Metadata) == 0 {
+			return fmt.Errorf("public_key or metadata is required, to select the peers that should be idle")
+		}
+
+	case AlertDeviceRate:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("threshold must be positive")
+		}
+
+	case AlertPoolUsage:
+		if r.Threshold <= 0 || r.Threshold > 1 {
+			return fmt.Errorf("threshold must be a fraction greater than 0 and at most 1")
+		}
+
+	case "":
+		return fmt.Errorf("kind is required")
+
+	default:
+		return fmt.Errorf("unknown kind %q, must be one of %s, %s, %s, %s, %s or %s", r.Kind, AlertPeerOffline, AlertPeerRateSpike, AlertPeerRate, AlertIdlePeerTraffic, AlertDeviceRate, AlertPoolUsage)
+	}
+
+	return nil
+}
+
+// isPeerAlert returns true if rules of kind apply to Peers, whose subject is
+// the public key of a Peer.
+func isPeerAlert(kind string) bool {
+	switch kind {
+	case AlertPeerOffline, AlertPeerRateSpike, AlertPeerRate, AlertIdlePeerTraffic:
+		return true
+	}
+
+	return false
+}
+
+// WithAlerts configures AlertRules evaluated against the device, whose
+// alerts are logged and published as Events.
+func WithAlerts(alerts *Alerts) Option {
+	return func(s *Server) {
+		s.alerts = alerts
+	}
+}
+
+// runAlerts evaluates every AlertRule each alertInterval, until ctx is
+// cancelled.
+func (s *Server) runAlerts(ctx context.Context) {
+	t := time.NewTicker(alertInterval)
+	defer t.Stop()
+
+	s.alerts.active = make(map[alertKey]*alertState)
+
+	for {
+		s.evaluateAlerts(ctx, time.Now().UTC())
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// evaluateAlerts evaluates the condition of every AlertRule, firing those
+// that have held for long enough and resolving those that no longer hold.
+func (s *Server) evaluateAlerts(ctx context.Context, now time.Time) {
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		log.Printf("error: alerts: could not get WireGuard device: %s\n", err)
+		return
+	}
+
+	for _, rule := range s.alerts.Rules {
+		conditions, err := s.conditions(ctx, rule, dev, now)
+		if err != nil {
+			log.Printf("error: alerts: could not evaluate %s: %s\n", rule.Name, err)
+			continue
+		}
+
+		holding := make(map[alertKey]bool, len(conditions))
+
+		for _, c := range conditions {
+			key := alertKey{rule: rule.Name, subject: c.subject}
+			holding[key] = true
+
+			st, ok := s.alerts.active[key]
+			if !ok {
+				since := c.since
+				if since.IsZero() {
+					since = now
+				}
+
+				st = &alertState{alert: &client.Alert{Rule: rule.Name, Kind: rule.Kind, Subject: c.subject, Threshold: rule.Threshold, Since: since}}
+				s.alerts.active[key] = st
+			}
+
+			st.alert.Value = c.value
+			st.alert.Message = c.message
+			if rule.Message != "" {
+				st.alert.Message = rule.Message
+			}
+
+			if !st.firing && now.Sub(st.alert.Since) >= rule.For {
+				st.firing = true
+				st.alert.State = client.AlertFiring
+
+				log.Printf("warn: alerts: %s firing: %s\n", rule.Name, st.alert.Message)
+				s.publishAlert(ctx, rule, st.alert, dev, now)
+			}
+		}
+
+		for key, st := range s.alerts.active {
+			if key.rule != rule.Name || holding[key] {
+				continue
+			}
+
+			delete(s.alerts.active, key)
+
+			if st.firing {
+				st.alert.State = client.AlertResolved
+
+				log.Printf("info: alerts: %s resolved: %s\n", rule.Name, st.alert.Message)
+				s.publishAlert(ctx, rule, st.alert, dev, now)
+			}
+		}
+	}
+}
+
+// publishAlert publishes an alert.firing or alert.resolved Event. The Event of
+// an alert about a Peer includes the Peer, if it is still on dev, along with
+// its metadata and rate of transfer.
+func (s *Server) publishAlert(ctx context.Context, rule *AlertRule, alert *client.Alert, dev *wgtypes.Device, now time.Time) {
+	eventType := client.EventAlertFiring
+	if alert.State == client.AlertResolved {
+		eventType = client.EventAlertResolved
+	}
+
+	a := *alert
+
+	event := &client.Event{Type: eventType, Time: now, Device: s.deviceName, Alert: &a}
+	if isPeerAlert(rule.Kind) {
+		event.PublicKey = alert.Subject
+
+		for _, peer := range dev.Peers {
+			if peer.PublicKey.String() != alert.Subject {
+				continue
+			}
+
+			event.Peer = peer2rpc(peer)
+			if err := s.attachStored(ctx, []*client.Peer{event.Peer}); err != nil {
+				log.Printf("warn: alerts: %s\n", err)
+			}
+
+			s.setRate(event.Peer)
+
+			break
+		}
+	}
+
+	s.publish(event)
+}
+
+// conditions returns every subject of rule whose condition holds.
+func (s *Server) conditions(ctx context.Context, rule *AlertRule, dev *wgtypes.Device, now time.Time) ([]condition, error) {
+	switch rule.Kind {
+	case AlertPeerOffline:
+		peers, err := s.rulePeers(ctx, rule, dev)
+		if err != nil {
+			return nil, err
+		}
+
+		var conditions []condition
+
+		for _, peer := range peers {
+			publicKey := peer.PublicKey.String()
+
+			if peer.LastHandshakeTime.IsZero() {
+				conditions = append(conditions, condition{subject: publicKey, message: fmt.Sprintf("peer %s has never completed a handshake", publicKey)})
+			} else if offline := now.Sub(peer.LastHandshakeTime); offline >= connectedTimeout {
+				conditions = append(conditions, condition{
+					subject: publicKey,
+					value:   offline.Seconds(),
+					message: fmt.Sprintf("peer %s has not completed a handshake for %s", publicKey, offline.Round(time.Second)),
+					since:   peer.LastHandshakeTime,
+				})
+			}
+		}
+
+		return conditions, nil
+
+	case AlertDeviceRate:
+		if s.rates.interval <= 0 {
+			return nil, fmt.Errorf("transfer of peers is not sampled by this server")
+		}
+
+		var bits float64
+		for _, rate := range s.rates.all(s.rates.window) {
+			bits += (rate.ReceiveRate() + rate.TransmitRate()) * 8
+		}
+
+		if bits <= rule.Threshold {
+			return nil, nil
+		}
+
+		return []condition{{value: bits, message: fmt.Sprintf("device %s is transferring %.0f bits per second, above %.0f", s.deviceName, bits, rule.Threshold)}}, nil
+
+	case AlertPoolUsage:
+		pool, ok := s.ipam.(*Pool)
+		if !ok {
+			return nil, fmt.Errorf("ip address management is not configured with a pool")
+		}
+
+		usage, err := pool.Usage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var conditions []condition
+
+		for _, u := range usage {
+			if used := u.Leased / u.Size; used >= rule.Threshold {
+				conditions = append(conditions, condition{
+					subject: u.Range,
+					value:   used,
+					message: fmt.Sprintf("%.0f%% of the addresses of %s are leased", used*100, u.Range),
+				})
+			}
+		}
+
+		return conditions, nil
+
+	case AlertPeerRateSpike, AlertPeerRate, AlertIdlePeerTraffic:
+		return s.rateConditions(ctx, rule, dev)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// rulePeers returns the Peers of dev a rule applies to, those with its public
+// key and metadata if given.
+func (s *Server) rulePeers(ctx context.Context, rule *AlertRule, dev *wgtypes.Device) ([]wgtypes.Peer, error) {
+	if rule.PublicKey == "" && len(rule.Metadata) == 0 {
+		return dev.Peers, nil
+	}
+
+	var peers []wgtypes.Peer
+
+	for _, peer := range dev.Peers {
+		if rule.PublicKey == "" || rule.PublicKey == peer.PublicKey.String() {
+			peers = append(peers, peer)
+		}
+	}
+
+	if len(rule.Metadata) == 0 || len(peers) == 0 {
+		return peers, nil
+	}
+
+	index, err := s.storedIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := peers[:0]
+
+	for _, peer := range peers {
+		stored, ok := index[peer.PublicKey.String()]
+		if !ok {
+			continue
+		}
+
+		matches := true
+		for k, v := range rule.Metadata {
+			if stored.Metadata[k] != v {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			selected = append(selected, peer)
+		}
+	}
+
+	return selected, nil
+}
+
+// rateConditions returns every Peer of a peer_rate_spike, peer_rate or
+// idle_peer_traffic rule whose condition holds, from the samples of their
+// transfer counters.
+func (s *Server) rateConditions(ctx context.Context, rule *AlertRule, dev *wgtypes.Device) ([]condition, error) {
+	if s.rates.interval <= 0 {
+		return nil, fmt.Errorf("transfer of peers is not sampled by this server")
+	}
+
+	peers, err := s.rulePeers(ctx, rule, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	window := s.rates.window
+	if rule.Window > 0 {
+		window = min(rule.Window, window)
+	} else if rule.Kind == AlertPeerRateSpike {
+		window = window / 4
+	}
+
+	var conditions []condition
+
+	for _, peer := range peers {
+		publicKey := peer.PublicKey.String()
+
+		if rule.Kind == AlertPeerRateSpike {
+			before, recent, ok := s.rates.split(peer.PublicKey, window)
+			if !ok {
+				continue
+			}
+
+			bits, prior := rateBits(recent), rateBits(before)
+			if prior <= 0 || bits < rule.MinRate || bits < prior*rule.Threshold {
+				continue
+			}
+
+			conditions = append(conditions, condition{
+				subject: publicKey,
+				value:   bits / prior,
+				message: fmt.Sprintf("peer %s is transferring %.0f bits per second, %.1f times its rate of %.0f before the last %s", publicKey, bits, bits/prior, prior, window),
+			})
+
+			continue
+		}
+
+		rate, ok := s.rates.over(peer.PublicKey, window)
+		if !ok {
+			continue
+		}
+
+		bits := rateBits(rate)
+		if bits <= rule.Threshold {
+			continue
+		}
+
+		message := fmt.Sprintf("peer %s is transferring %.0f bits per second, above %.0f", publicKey, bits, rule.Threshold)
+		if rule.Kind == AlertIdlePeerTraffic {
+			message = fmt.Sprintf("peer %s should be idle, but is transferring %.0f bits per second", publicKey, bits)
+		}
+
+		conditions = append(conditions, condition{subject: publicKey, value: bits, message: message})
+	}
+
+	return conditions, nil
+}
+
+// rateBits returns the bits per second received and transmitted in total.
+func rateBits(rate peerRate) float64 {
+	return (rate.ReceiveRate() + rate.TransmitRate()) * 8
+}
+
+// over returns the transfer of the Peer with publicKey over window, which
+// must not be greater than the rate window, or false if it has not been
+// sampled at least twice.
+func (r *rates) over(publicKey wgtypes.Key, window time.Duration) (peerRate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return rateOver(r.peers[publicKey], window)
+}
+
+// split returns the transfer of the Peer with publicKey over the most recent
+// window, and over the rest of the rate window before it, or false if it has
+// not been sampled over both.
+func (r *rates) split(publicKey wgtypes.Key, window time.Duration) (before, recent peerRate, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	samples := r.peers[publicKey]
+	if len(samples) < 3 {
+		return peerRate{}, peerRate{}, false
+	}
+
+	last := samples[len(samples)-1]
+	cutoff := last.at.Add(-window)
+
+	// the recent window begins at the newest sample at least window before
+	// the last, which must not be the first.
+	i := 0
+	for i+1 < len(samples)-1 && !samples[i+1].at.After(cutoff) {
+		i++
+	}
+
+	if i == 0 {
+		return peerRate{}, peerRate{}, false
+	}
+
+	first, mid := samples[0], samples[i]
+
+	before = peerRate{
+		Period:        mid.at.Sub(first.at),
+		ReceiveBytes:  mid.receiveBytes - first.receiveBytes,
+		TransmitBytes: mid.transmitBytes - first.transmitBytes,
+	}
+
+	recent = peerRate{
+		Period:        last.at.Sub(mid.at),
+		ReceiveBytes:  last.receiveBytes - mid.receiveBytes,
+		TransmitBytes: last.transmitBytes - mid.transmitBytes,
+	}
+
+	return before, recent, true
+}
@@ -0,0 +1,359 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/servertest"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// newServer serves the device wg0 with peers over HTTP until the test ends.
+func newServer(t *testing.T, peers ...wgtypes.Peer) *servertest.Server {
+	t.Helper()
+
+	s, err := servertest.NewServer(servertest.NewWireGuard(servertest.NewDevice("wg0", peers...)), "wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// generation returns the generation of the device served by s.
+func generation(t *testing.T, s *servertest.Server) uint64 {
+	t.Helper()
+
+	res, err := s.Client.GetDeviceInfo(context.Background(), &client.GetDeviceInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.Device.Generation
+}
+
+// peerKeys returns the public keys of the Peers of the device served by s.
+func peerKeys(t *testing.T, s *servertest.Server) map[string]bool {
+	t.Helper()
+
+	dev, err := s.WireGuard.Device("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make(map[string]bool, len(dev.Peers))
+	for _, peer := range dev.Peers {
+		keys[peer.PublicKey.String()] = true
+	}
+
+	return keys
+}
+
+func newPublicKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.PublicKey().String()
+}
+
+// errorCode returns the JSON-RPC error code of err, or zero if it is nil.
+func errorCode(t *testing.T, err error) int {
+	t.Helper()
+
+	if err == nil {
+		return 0
+	}
+
+	code, _, ok := client.ErrorCode(err)
+	if !ok {
+		t.Fatalf("expected JSON-RPC error, got %s", err)
+	}
+
+	return code
+}
+
+func TestAddPeerExpectedGeneration(t *testing.T) {
+	tests := []struct {
+		Name string
+
+		// Change, if set, changes the device outside of WG-API after its
+		// generation is read.
+		Change func(dev *wgtypes.Device)
+
+		// Expected returns the expected_generation of the request, given
+		// the generation of the device when it was read.
+		Expected func(gen uint64) uint64
+		DryRun   bool
+
+		Code  int
+		Added bool
+	}{
+		{
+			Name:     "Unconditional",
+			Expected: func(gen uint64) uint64 { return 0 },
+			Added:    true,
+		},
+		{
+			Name:     "Current",
+			Expected: func(gen uint64) uint64 { return gen },
+			Added:    true,
+		},
+		{
+			Name:     "Stale",
+			Expected: func(gen uint64) uint64 { return gen + 1 },
+			Code:     client.ErrCodeConflict,
+		},
+		{
+			Name: "ChangedExternally",
+			Change: func(dev *wgtypes.Device) {
+				dev.Peers[0].AllowedIPs = append(dev.Peers[0].AllowedIPs, net.IPNet{IP: net.IPv4(10, 1, 0, 0), Mask: net.CIDRMask(24, 32)})
+			},
+			Expected: func(gen uint64) uint64 { return gen },
+			Code:     client.ErrCodeConflict,
+		},
+		{
+			Name: "EndpointChangedExternally",
+			Change: func(dev *wgtypes.Device) {
+				dev.Peers[0].Endpoint = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820}
+			},
+			Expected: func(gen uint64) uint64 { return gen },
+			Code:     client.ErrCodeConflict,
+		},
+		{
+			// a Peer roams to another endpoint once a packet is received
+			// from it, which is not a change of configuration.
+			Name: "Roamed",
+			Change: func(dev *wgtypes.Device) {
+				dev.Peers[0].Endpoint = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820}
+				dev.Peers[0].ReceiveBytes += 148
+			},
+			Expected: func(gen uint64) uint64 { return gen },
+			Added:    true,
+		},
+		{
+			Name:     "DryRun",
+			Expected: func(gen uint64) uint64 { return gen },
+			DryRun:   true,
+		},
+		{
+			Name:     "DryRunStale",
+			Expected: func(gen uint64) uint64 { return gen + 1 },
+			DryRun:   true,
+			Code:     client.ErrCodeConflict,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			s := newServer(t, servertest.NewPeer("10.0.0.1/32"))
+
+			gen := generation(t, s)
+
+			if test.Change != nil {
+				s.WireGuard.Update("wg0", test.Change)
+			}
+
+			publicKey := newPublicKey(t)
+
+			res, err := s.Client.AddPeer(context.Background(), &client.AddPeerRequest{
+				PublicKey:          publicKey,
+				AllowedIPs:         []string{"10.0.0.2/32"},
+				ExpectedGeneration: test.Expected(gen),
+				DryRun:             test.DryRun,
+			})
+
+			if code := errorCode(t, err); code != test.Code {
+				t.Fatalf("expected code %d, got %d (%v)", test.Code, code, err)
+			}
+
+			if added := peerKeys(t, s)[publicKey]; added != test.Added {
+				t.Errorf("expected added %t, got %t", test.Added, added)
+			}
+
+			if err != nil {
+				_, data, _ := client.ErrorCode(err)
+				if data == nil || data.CurrentGeneration == 0 {
+					t.Errorf("expected current generation in error, got %+v", data)
+				}
+
+				return
+			}
+
+			if res.DryRun != test.DryRun {
+				t.Errorf("expected dry run %t, got %t", test.DryRun, res.DryRun)
+			}
+
+			if len(res.Changes) != 1 || res.Changes[0].PublicKey != publicKey {
+				t.Errorf("expected a single change of %s, got %+v", publicKey, res.Changes)
+			}
+
+			if test.Added && res.Generation == gen {
+				t.Error("expected generation to change")
+			} else if !test.Added && res.Generation != gen {
+				t.Errorf("expected generation %d, got %d", gen, res.Generation)
+			}
+		})
+	}
+}
+
+// failConfigure fails the ConfigureDevice calls numbered in fail, counting
+// from one, as the WireGuard device would if it rejected them.
+func failConfigure(fail ...int) func(method, name string) error {
+	var mu sync.Mutex
+	calls := 0
+
+	return func(method, name string) error {
+		if method != "ConfigureDevice" {
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		for _, n := range fail {
+			if n == calls {
+				return errors.New("injected failure")
+			}
+		}
+
+		return nil
+	}
+}
+
+func TestApplyBatchConfigureEach(t *testing.T) {
+	tests := []struct {
+		OnError string
+
+		// OK is the outcome of each of the three Peers added.
+		OK         []bool
+		Added      []bool
+		RolledBack bool
+	}{
+		{
+			OnError: client.OnErrorContinue,
+			OK:      []bool{true, false, true},
+			Added:   []bool{true, false, true},
+		},
+		{
+			OnError: client.OnErrorAbort,
+			OK:      []bool{true, false, false},
+			Added:   []bool{true, false, false},
+		},
+		{
+			OnError:    client.OnErrorRollback,
+			OK:         []bool{false, false, false},
+			Added:      []bool{false, false, false},
+			RolledBack: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.OnError, func(t *testing.T) {
+			existing := servertest.NewPeer("10.0.0.1/32")
+			s := newServer(t, existing)
+
+			req := &client.ApplyBatchRequest{OnError: test.OnError}
+			var keys []string
+
+			for _, allowedIP := range []string{"10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32"} {
+				publicKey := newPublicKey(t)
+				keys = append(keys, publicKey)
+
+				req.Operations = append(req.Operations, &client.BatchOperation{
+					AddPeer: &client.AddPeerRequest{PublicKey: publicKey, AllowedIPs: []string{allowedIP}},
+				})
+			}
+
+			// the batch given at once fails, as does the second Peer given
+			// alone once the batch falls back to configuring each.
+			s.WireGuard.SetFailure(failConfigure(1, 3))
+
+			res, err := s.Client.ApplyBatch(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.OK {
+				t.Error("expected batch to fail")
+			}
+
+			if res.RolledBack != test.RolledBack {
+				t.Errorf("expected rolled back %t, got %t", test.RolledBack, res.RolledBack)
+			}
+
+			if len(res.Results) != len(keys) {
+				t.Fatalf("expected %d results, got %d", len(keys), len(res.Results))
+			}
+
+			if res.Results[1].Error == nil || res.Results[1].Error.Code != client.ErrCodeDevice {
+				t.Errorf("expected device error for peer 1, got %+v", res.Results[1].Error)
+			}
+
+			peers := peerKeys(t, s)
+
+			for i, publicKey := range keys {
+				if res.Results[i].OK != test.OK[i] {
+					t.Errorf("peer %d: expected ok %t, got %t", i, test.OK[i], res.Results[i].OK)
+				}
+
+				if peers[publicKey] != test.Added[i] {
+					t.Errorf("peer %d: expected added %t, got %t", i, test.Added[i], peers[publicKey])
+				}
+			}
+
+			if !peers[existing.PublicKey.String()] {
+				t.Error("existing peer was removed")
+			}
+		})
+	}
+}
+
+func TestRemoveAllPeers(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Confirm string
+		DryRun  bool
+
+		Code    int
+		Removed bool
+	}{
+		{Name: "Unconfirmed", Code: -32602},
+		{Name: "OtherDevice", Confirm: "wg1", Code: -32602},
+		{Name: "Confirmed", Confirm: "wg0", Removed: true},
+		{Name: "DryRun", Confirm: "wg0", DryRun: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			s := newServer(t, servertest.NewPeer("10.0.0.1/32"), servertest.NewPeer("10.0.0.2/32"))
+
+			res, err := s.Client.RemoveAllPeers(context.Background(), &client.RemoveAllPeersRequest{Confirm: test.Confirm, DryRun: test.DryRun})
+			if code := errorCode(t, err); code != test.Code {
+				t.Fatalf("expected code %d, got %d (%v)", test.Code, code, err)
+			}
+
+			remaining := len(peerKeys(t, s))
+			if test.Removed && remaining != 0 {
+				t.Errorf("expected every peer removed, %d remain", remaining)
+			} else if !test.Removed && remaining != 2 {
+				t.Errorf("expected no peer removed, %d remain", remaining)
+			}
+
+			if err == nil && (res.Removed != 2 || len(res.Changes) != 2) {
+				t.Errorf("expected 2 peers removed, got %d with %d changes", res.Removed, len(res.Changes))
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// applyResult describes the outcome of configuring the device.
+type applyResult struct {
+	Changes    []*client.PeerChange
+	Generation uint64
+	DryRun     bool
+}
+
+// apply configures the device with cfg, returning the changes made to its
+// Peers. If dryRun is set, or the Server is in dry-run mode, the changes that
+// would have been made are returned without configuring the device. If
+// expectedGeneration is non-zero and does not match the generation of the
+// device, a Conflict error is returned. Each Peer of cfg with createOnly of
+// the same index set must not already be on the device, createOnly may be
+// nil. If ctx is done before the device is configured, it is not configured.
+// The caller must hold s.mu.
+func (s *Server) apply(ctx context.Context, cfg wgtypes.Config, createOnly []bool, expectedGeneration uint64, dryRun bool) (*applyResult, error) {
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	gen := s.gen.observe(dev)
+	if expectedGeneration != 0 && expectedGeneration != gen {
+		return nil, conflictError(expectedGeneration, gen)
+	}
+
+	if err := s.checkMaxPeers(dev.Peers, cfg.Peers); err != nil {
+		return nil, err
+	}
+
+	for _, err := range presenceErrors(dev.Peers, cfg.Peers, createOnly) {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := diffPeers(dev.Peers, simulateConfig(dev.Peers, cfg))
+
+	if dryRun || s.dryRun {
+		return &applyResult{Changes: changes, Generation: gen, DryRun: true}, nil
+	}
+
+	// once begun, configuring the device is not abandoned, such that a
+	// request never times out having changed the device.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = s.configure(cfg)
+	if err != nil {
+		return nil, deviceError("could not configure WireGuard device", err)
+	}
+
+	s.conntrackFlush(changes)
+	s.publishChanges(changes)
+
+	gen, err = s.syncGeneration()
+	if err != nil {
+		return nil, err
+	}
+
+	return &applyResult{Changes: changes, Generation: gen}, nil
+}
+
+// presenceErrors returns the error of each of configs that requires its Peer
+// to already be on the device, such as with UpdateOnly, and it would not be
+// once the configs before it are applied to peers, or that requires its Peer
+// not to be, as with createOnly of the same index, and it would be. Otherwise
+// the error is nil. createOnly may be nil.
+func presenceErrors(peers []wgtypes.Peer, configs []wgtypes.PeerConfig, createOnly []bool) []error {
+	present := make(map[wgtypes.Key]bool, len(peers))
+	for _, peer := range peers {
+		present[peer.PublicKey] = true
+	}
+
+	errs := make([]error, len(configs))
+
+	for i, pc := range configs {
+		switch {
+		case pc.Remove:
+			delete(present, pc.PublicKey)
+
+		case pc.UpdateOnly && !present[pc.PublicKey]:
+			errs[i] = peerNotFoundError(pc.PublicKey.String())
+
+		case i < len(createOnly) && createOnly[i] && present[pc.PublicKey]:
+			errs[i] = peerExistsError(pc.PublicKey.String())
+
+		default:
+			present[pc.PublicKey] = true
+		}
+	}
+
+	return errs
+}
+
+// conflictError returns the error given when the expected generation of a
+// device does not match its current generation.
+func conflictError(expected, current uint64) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeConflict, "device has been modified since expected generation", &client.ErrorData{
+		Field:             "expected_generation",
+		Value:             strconv.FormatUint(expected, 10),
+		CurrentGeneration: current,
+	})
+}
+
+// simulateConfig returns the Peers of a device as they would be after cfg
+// has been applied, following the semantics of the WireGuard kernel module.
+func simulateConfig(peers []wgtypes.Peer, cfg wgtypes.Config) []wgtypes.Peer {
+	next := make([]wgtypes.Peer, 0, len(peers)+len(cfg.Peers))
+
+	if !cfg.ReplacePeers {
+		for _, peer := range peers {
+			peer.AllowedIPs = append([]net.IPNet(nil), peer.AllowedIPs...)
+			next = append(next, peer)
+		}
+	}
+
+	index := indexPeers(next)
+	removed := make(map[int]bool)
+
+	// owners maps each allowed ip to the public key of the peer it belongs
+	// to, as an allowed ip may only belong to a single peer.
+	owners := make(map[string]wgtypes.Key)
+	for _, peer := range next {
+		for _, allowedIP := range peer.AllowedIPs {
+			owners[allowedIP.String()] = peer.PublicKey
+		}
+	}
+
+	for _, pc := range cfg.Peers {
+		i, ok := index[pc.PublicKey]
+
+		if pc.Remove {
+			if ok {
+				removed[i] = true
+				delete(index, pc.PublicKey)
+			}
+
+			continue
+		}
+
+		if !ok {
+			if pc.UpdateOnly {
+				continue
+			}
+
+			next = append(next, wgtypes.Peer{PublicKey: pc.PublicKey, ProtocolVersion: 1})
+			i = len(next) - 1
+			index[pc.PublicKey] = i
+		}
+
+		peer := &next[i]
+
+		if pc.PresharedKey != nil {
+			peer.PresharedKey = *pc.PresharedKey
+		}
+
+		if pc.Endpoint != nil {
+			peer.Endpoint = pc.Endpoint
+		}
+
+		if pc.PersistentKeepaliveInterval != nil {
+			peer.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+
+		if pc.ReplaceAllowedIPs {
+			peer.AllowedIPs = nil
+		}
+
+		for _, allowedIP := range pc.AllowedIPs {
+			// the kernel silently removes an allowed ip from any other peer
+			// it previously belonged to.
+			if owner, ok := owners[allowedIP.String()]; ok && owner != pc.PublicKey {
+				if j, ok := index[owner]; ok {
+					next[j].AllowedIPs = removeIPNet(next[j].AllowedIPs, allowedIP)
+				}
+			}
+
+			owners[allowedIP.String()] = pc.PublicKey
+
+			if !containsIPNet(peer.AllowedIPs, allowedIP) {
+				peer.AllowedIPs = append(peer.AllowedIPs, allowedIP)
+			}
+		}
+	}
+
+	out := next[:0]
+	for i, peer := range next {
+		if !removed[i] {
+			out = append(out, peer)
+		}
+	}
+
+	return out
+}
+
+// rpcEqual returns true if two Peers would be converted to equal client.Peers
+// by peer2rpc, without converting them.
+func rpcEqual(a, b wgtypes.Peer) bool {
+	if a.PublicKey != b.PublicKey || (a.PresharedKey == wgtypes.Key{}) != (b.PresharedKey == wgtypes.Key{}) {
+		return false
+	}
+
+	if a.PersistentKeepaliveInterval != b.PersistentKeepaliveInterval || a.ProtocolVersion != b.ProtocolVersion {
+		return false
+	}
+
+	if a.ReceiveBytes != b.ReceiveBytes || a.TransmitBytes != b.TransmitBytes || !a.LastHandshakeTime.Equal(b.LastHandshakeTime) {
+		return false
+	}
+
+	if (a.Endpoint == nil) != (b.Endpoint == nil) {
+		return false
+	} else if a.Endpoint != nil && (!a.Endpoint.IP.Equal(b.Endpoint.IP) || a.Endpoint.Port != b.Endpoint.Port || a.Endpoint.Zone != b.Endpoint.Zone) {
+		return false
+	}
+
+	if len(a.AllowedIPs) != len(b.AllowedIPs) {
+		return false
+	}
+
+	for i := range a.AllowedIPs {
+		aOnes, aBits := a.AllowedIPs[i].Mask.Size()
+		bOnes, bBits := b.AllowedIPs[i].Mask.Size()
+
+		if !a.AllowedIPs[i].IP.Equal(b.AllowedIPs[i].IP) || aOnes != bOnes || aBits != bBits {
+			return false
+		}
+	}
+
+	return true
+}
+
+// diffPeers returns the changes between two sets of Peers, ordered by public
+// key.
+func diffPeers(before, after []wgtypes.Peer) []*client.PeerChange {
+	var changes []*client.PeerChange
+
+	beforeIndex := indexPeers(before)
+	afterIndex := indexPeers(after)
+
+	for _, a := range after {
+		i, ok := beforeIndex[a.PublicKey]
+		if !ok {
+			changes = append(changes, &client.PeerChange{
+				Action:    client.ChangeAdd,
+				PublicKey: a.PublicKey.String(),
+				After:     peer2rpc(a),
+			})
+			continue
+		}
+
+		// most Peers are unchanged, so are compared before they are
+		// converted.
+		if !rpcEqual(before[i], a) {
+			changes = append(changes, &client.PeerChange{
+				Action:    client.ChangeUpdate,
+				PublicKey: a.PublicKey.String(),
+				Before:    peer2rpc(before[i]),
+				After:     peer2rpc(a),
+			})
+		}
+	}
+
+	for _, b := range before {
+		if _, ok := afterIndex[b.PublicKey]; !ok {
+			changes = append(changes, &client.PeerChange{
+				Action:    client.ChangeRemove,
+				PublicKey: b.PublicKey.String(),
+				Before:    peer2rpc(b),
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].PublicKey < changes[j].PublicKey
+	})
+
+	return changes
+}
+
+// indexPeers maps the public key of each Peer to its index.
+func indexPeers(peers []wgtypes.Peer) map[wgtypes.Key]int {
+	index := make(map[wgtypes.Key]int, len(peers))
+
+	for i, peer := range peers {
+		index[peer.PublicKey] = i
+	}
+
+	return index
+}
+
+func containsIPNet(nets []net.IPNet, n net.IPNet) bool {
+	for _, v := range nets {
+		if ipNetEqual(v, n) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeIPNet(nets []net.IPNet, n net.IPNet) []net.IPNet {
+	out := nets[:0]
+
+	for _, v := range nets {
+		if !ipNetEqual(v, n) {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+func ipNetEqual(a, b net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+
+	return a.IP.Equal(b.IP) && aOnes == bOnes && aBits == bBits
+}
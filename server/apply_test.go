@@ -0,0 +1,229 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// testKey returns a public key distinguished by n, such that Peers of a test
+// can be told apart by name.
+func testKey(n byte) wgtypes.Key {
+	return wgtypes.Key{n}
+}
+
+func testIPNet(s string) net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return *ipNet
+}
+
+func testPeer(n byte, allowedIPs ...string) wgtypes.Peer {
+	peer := wgtypes.Peer{PublicKey: testKey(n), ProtocolVersion: 1}
+	for _, allowedIP := range allowedIPs {
+		peer.AllowedIPs = append(peer.AllowedIPs, testIPNet(allowedIP))
+	}
+
+	return peer
+}
+
+// allowedIPsOf returns the allowed ips of each of peers by the first byte of
+// its public key.
+func allowedIPsOf(peers []wgtypes.Peer) map[byte][]string {
+	m := make(map[byte][]string, len(peers))
+
+	for _, peer := range peers {
+		ips := []string{}
+		for _, allowedIP := range peer.AllowedIPs {
+			ips = append(ips, allowedIP.String())
+		}
+
+		sort.Strings(ips)
+		m[peer.PublicKey[0]] = ips
+	}
+
+	return m
+}
+
+func TestPresenceErrors(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Configs    []wgtypes.PeerConfig
+		CreateOnly []bool
+		Codes      []int
+	}{
+		{
+			Name:    "UpdateExisting",
+			Configs: []wgtypes.PeerConfig{{PublicKey: testKey(1), UpdateOnly: true}},
+			Codes:   []int{0},
+		},
+		{
+			Name:    "UpdateMissing",
+			Configs: []wgtypes.PeerConfig{{PublicKey: testKey(2), UpdateOnly: true}},
+			Codes:   []int{client.ErrCodePeerNotFound},
+		},
+		{
+			Name: "UpdateRemoved",
+			Configs: []wgtypes.PeerConfig{
+				{PublicKey: testKey(1), Remove: true},
+				{PublicKey: testKey(1), UpdateOnly: true},
+			},
+			Codes: []int{0, client.ErrCodePeerNotFound},
+		},
+		{
+			Name: "UpdateAdded",
+			Configs: []wgtypes.PeerConfig{
+				{PublicKey: testKey(2)},
+				{PublicKey: testKey(2), UpdateOnly: true},
+			},
+			Codes: []int{0, 0},
+		},
+		{
+			Name:       "CreateExisting",
+			Configs:    []wgtypes.PeerConfig{{PublicKey: testKey(1)}},
+			CreateOnly: []bool{true},
+			Codes:      []int{client.ErrCodeConflict},
+		},
+		{
+			Name: "CreateRemoved",
+			Configs: []wgtypes.PeerConfig{
+				{PublicKey: testKey(1), Remove: true},
+				{PublicKey: testKey(1)},
+			},
+			CreateOnly: []bool{false, true},
+			Codes:      []int{0, 0},
+		},
+		{
+			Name: "CreateTwice",
+			Configs: []wgtypes.PeerConfig{
+				{PublicKey: testKey(2)},
+				{PublicKey: testKey(2)},
+			},
+			CreateOnly: []bool{true, true},
+			Codes:      []int{0, client.ErrCodeConflict},
+		},
+	}
+
+	peers := []wgtypes.Peer{testPeer(1, "10.0.0.1/32")}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			errs := presenceErrors(peers, test.Configs, test.CreateOnly)
+			if len(errs) != len(test.Codes) {
+				t.Fatalf("expected %d errors, got %d", len(test.Codes), len(errs))
+			}
+
+			for i, err := range errs {
+				code, _, _ := client.ErrorCode(err)
+				if code != test.Codes[i] {
+					t.Errorf("config %d: expected code %d, got %d (%v)", i, test.Codes[i], code, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulateConfig(t *testing.T) {
+	keepAlive := 25 * time.Second
+
+	tests := []struct {
+		Name   string
+		Config wgtypes.Config
+		Peers  map[byte][]string
+	}{
+		{
+			Name:   "Empty",
+			Config: wgtypes.Config{},
+			Peers:  map[byte][]string{1: {"10.0.0.1/32"}, 2: {"10.0.0.2/32"}},
+		},
+		{
+			Name:   "Add",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(3), AllowedIPs: []net.IPNet{testIPNet("10.0.0.3/32")}}}},
+			Peers:  map[byte][]string{1: {"10.0.0.1/32"}, 2: {"10.0.0.2/32"}, 3: {"10.0.0.3/32"}},
+		},
+		{
+			Name:   "UpdateOnlyMissing",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(3), UpdateOnly: true}}},
+			Peers:  map[byte][]string{1: {"10.0.0.1/32"}, 2: {"10.0.0.2/32"}},
+		},
+		{
+			Name:   "Remove",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(1), Remove: true}}},
+			Peers:  map[byte][]string{2: {"10.0.0.2/32"}},
+		},
+		{
+			Name:   "RemoveMissing",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(3), Remove: true}}},
+			Peers:  map[byte][]string{1: {"10.0.0.1/32"}, 2: {"10.0.0.2/32"}},
+		},
+		{
+			Name:   "ReplacePeers",
+			Config: wgtypes.Config{ReplacePeers: true, Peers: []wgtypes.PeerConfig{{PublicKey: testKey(3)}}},
+			Peers:  map[byte][]string{3: {}},
+		},
+		{
+			Name:   "AddAllowedIP",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(1), AllowedIPs: []net.IPNet{testIPNet("10.1.0.0/24")}}}},
+			Peers:  map[byte][]string{1: {"10.0.0.1/32", "10.1.0.0/24"}, 2: {"10.0.0.2/32"}},
+		},
+		{
+			Name:   "ReplaceAllowedIPs",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(1), ReplaceAllowedIPs: true, AllowedIPs: []net.IPNet{testIPNet("10.1.0.0/24")}}}},
+			Peers:  map[byte][]string{1: {"10.1.0.0/24"}, 2: {"10.0.0.2/32"}},
+		},
+		{
+			// the kernel moves an allowed ip to the Peer it is given to.
+			Name:   "MoveAllowedIP",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: testKey(3), AllowedIPs: []net.IPNet{testIPNet("10.0.0.2/32")}}}},
+			Peers:  map[byte][]string{1: {"10.0.0.1/32"}, 2: {}, 3: {"10.0.0.2/32"}},
+		},
+		{
+			Name: "RemoveThenAdd",
+			Config: wgtypes.Config{Peers: []wgtypes.PeerConfig{
+				{PublicKey: testKey(1), Remove: true},
+				{PublicKey: testKey(1), PersistentKeepaliveInterval: &keepAlive},
+			}},
+			Peers: map[byte][]string{1: {}, 2: {"10.0.0.2/32"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			peers := []wgtypes.Peer{testPeer(1, "10.0.0.1/32"), testPeer(2, "10.0.0.2/32")}
+
+			got := allowedIPsOf(simulateConfig(peers, test.Config))
+			if len(got) != len(test.Peers) {
+				t.Fatalf("expected peers %v, got %v", test.Peers, got)
+			}
+
+			for key, want := range test.Peers {
+				ips, ok := got[key]
+				if !ok {
+					t.Errorf("peer %d: missing", key)
+				} else if len(ips) != len(want) {
+					t.Errorf("peer %d: expected allowed ips %v, got %v", key, want, ips)
+				} else {
+					for i := range want {
+						if ips[i] != want[i] {
+							t.Errorf("peer %d: expected allowed ips %v, got %v", key, want, ips)
+							break
+						}
+					}
+				}
+			}
+
+			// the Peers given must not be changed.
+			if after := allowedIPsOf(peers); len(after[1]) != 1 || len(after[2]) != 1 {
+				t.Errorf("peers were changed: %v", after)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// AuthRequest describes an HTTP request to be authenticated.
+type AuthRequest struct {
+	Method     string
+	Path       string
+	RemoteAddr string
+	Header     map[string][]string
+
+	// ClientCertificates contains the subject of each certificate presented
+	// by the client, if TLS is enabled.
+	ClientCertificates []string
+}
+
+// Authenticator is implemented by providers of authentication, such as
+// plugins.
+type Authenticator interface {
+	// Authenticate returns true if the request may continue.
+	Authenticate(ctx context.Context, req *AuthRequest) (bool, error)
+}
+
+// Authenticate only allows a request to continue if it is permitted by the
+// Authenticator, otherwise a HTTP 403 Forbidden is returned and the request
+// terminated.
+func Authenticate(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := &AuthRequest{
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				RemoteAddr: r.RemoteAddr,
+				Header:     r.Header,
+			}
+
+			if r.TLS != nil {
+				for _, cert := range r.TLS.PeerCertificates {
+					req.ClientCertificates = append(req.ClientCertificates, cert.Subject.String())
+				}
+			}
+
+			ok, err := a.Authenticate(r.Context(), req)
+			if err != nil {
+				log.Printf("error: auth: %s\n", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			} else if !ok {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
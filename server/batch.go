@@ -0,0 +1,497 @@
+package server
+
+import (
+	"context"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// batchItem is a single item of a batch request, converted into the
+// configuration given to the WireGuard device. If the item could not be
+// converted, err is set.
+type batchItem struct {
+	publicKey string
+	config    wgtypes.PeerConfig
+	err       error
+
+	// req and tmpl are set for items adding a Peer, such that information
+	// about the Peer can be stored once applied.
+	req  *client.AddPeerRequest
+	tmpl *Template
+
+	// allocated are the allowed ips assigned to the Peer by the IPAM, which
+	// are released if the item does not remain applied.
+	allocated []string
+}
+
+// batchResult describes the outcome of applying a batch to the device.
+type batchResult struct {
+	OK         bool
+	RolledBack bool
+	Results    []*client.BatchResult
+	DryRun     bool
+	Changes    []*client.PeerChange
+	Generation uint64
+}
+
+func validateOnError(onError string) error {
+	switch onError {
+	case "", client.OnErrorAbort, client.OnErrorContinue, client.OnErrorRollback:
+		return nil
+
+	default:
+		return invalidParam("on_error", onError, "on_error must be one of abort, continue or rollback")
+	}
+}
+
+// applyBatch configures the device with the items of a batch, identifying
+// the failure of an individual item, with the behaviour on failure
+// determined by onError. Invalid items are never
+// applied, and unless onError is continue, cause no items to be applied.
+// The metadata of each applied item is stored once the batch is complete.
+// The caller must hold s.mu.
+func (s *Server) applyBatch(ctx context.Context, items []batchItem, onError string, expectedGeneration uint64, dryRun bool) (res *batchResult, err error) {
+	defer func() {
+		for i, item := range items {
+			if item.allocated != nil && (err != nil || res.DryRun || !res.Results[i].OK) {
+				s.releaseIPs(ctx, item.publicKey)
+			}
+		}
+	}()
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	gen := s.gen.observe(dev)
+	if expectedGeneration != 0 && expectedGeneration != gen {
+		return nil, conflictError(expectedGeneration, gen)
+	}
+
+	res = &batchResult{
+		Results:    make([]*client.BatchResult, len(items)),
+		DryRun:     dryRun || s.dryRun,
+		Generation: gen,
+	}
+
+	// items requiring their Peer to already be on the device are invalid if
+	// it would not be once the items before them are applied.
+	var checked []int
+	var configs []wgtypes.PeerConfig
+	var createOnly, overlaps []bool
+
+	for i, item := range items {
+		if item.err == nil {
+			checked = append(checked, i)
+			configs = append(configs, item.config)
+			createOnly = append(createOnly, item.req != nil && item.req.CreateOnly)
+			overlaps = append(overlaps, s.rejectOverlaps && item.req != nil && !item.req.Force)
+		}
+	}
+
+	for j, err := range presenceErrors(dev.Peers, configs, createOnly) {
+		if err != nil {
+			items[checked[j]].err = err
+
+			if isPeerExists(err) {
+				items[checked[j]].allocated = nil
+			}
+		}
+	}
+
+	// items adding a Peer are invalid if its allowed ips would overlap those
+	// of another Peer, when overlaps are rejected.
+	if s.rejectOverlaps {
+		for j, err := range overlapErrors(dev.Peers, configs, overlaps) {
+			if err != nil && items[checked[j]].err == nil {
+				items[checked[j]].err = err
+			}
+		}
+	}
+
+	configs = configs[:0]
+	invalid := false
+
+	for i, item := range items {
+		res.Results[i] = &client.BatchResult{Index: i, PublicKey: item.publicKey, AllocatedIPs: item.allocated}
+
+		if item.err != nil {
+			res.Results[i].Error = rpcError(item.err)
+			invalid = true
+		} else {
+			configs = append(configs, item.config)
+		}
+	}
+
+	if invalid && onError != client.OnErrorContinue {
+		return res, nil
+	}
+
+	if err := s.checkMaxPeers(dev.Peers, configs); err != nil {
+		return nil, err
+	}
+
+	if res.DryRun {
+		for _, result := range res.Results {
+			result.OK = result.Error == nil
+		}
+
+		res.OK = !invalid
+		res.Changes = diffPeers(dev.Peers, simulateConfig(dev.Peers, wgtypes.Config{Peers: configs}))
+
+		return res, nil
+	}
+
+	// once begun, a batch is not abandoned, such that a request never times
+	// out having changed the device.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	failed := false
+
+	// the batch is given to the device at once, as configuring it once per
+	// Peer is slow with thousands of Peers. Only if that fails is the device
+	// restored and each item given to it in turn, such that the failing item
+	// is identified.
+	if len(configs) > 0 {
+		if err := s.configure(wgtypes.Config{Peers: configs}); err != nil {
+			if err := s.restorePeers(dev.Peers); err != nil {
+				return nil, deviceError("could not roll back WireGuard device", err)
+			}
+
+			failed = s.configureEach(items, res.Results, onError)
+		} else {
+			for i, item := range items {
+				res.Results[i].OK = item.err == nil
+			}
+		}
+	}
+
+	if failed && onError == client.OnErrorRollback {
+		if err := s.restorePeers(dev.Peers); err != nil {
+			return nil, deviceError("could not roll back WireGuard device", err)
+		}
+
+		for _, result := range res.Results {
+			result.OK = false
+		}
+
+		res.RolledBack = true
+	}
+
+	for i, item := range items {
+		if !res.Results[i].OK {
+			continue
+		}
+
+		if item.config.Remove {
+			if err := s.forgetPeer(ctx, item.publicKey); err != nil {
+				return nil, err
+			}
+		} else if item.req != nil {
+			if err := s.recordPeer(ctx, item.req, item.tmpl); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	after, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	res.OK = !invalid && !failed
+	res.Changes = diffPeers(dev.Peers, after.Peers)
+	res.Generation = s.gen.observe(after)
+
+	s.conntrackFlush(res.Changes)
+	s.publishChanges(res.Changes)
+
+	return res, nil
+}
+
+// configureEach configures the device with each valid item in turn, setting
+// the outcome of each in results, until an item fails unless onError is
+// continue. It returns true if any item failed.
+func (s *Server) configureEach(items []batchItem, results []*client.BatchResult, onError string) bool {
+	failed := false
+
+	for i, item := range items {
+		if item.err != nil {
+			continue
+		}
+
+		err := s.configure(wgtypes.Config{Peers: []wgtypes.PeerConfig{item.config}})
+		if err != nil {
+			results[i].Error = deviceError("could not configure WireGuard device", err)
+			failed = true
+
+			if onError == client.OnErrorContinue {
+				continue
+			}
+
+			break
+		}
+
+		results[i].OK = true
+	}
+
+	return failed
+}
+
+// restorePeers configures the device such that its Peers match those of
+// snapshot, only reconfiguring Peers that have been changed.
+func (s *Server) restorePeers(snapshot []wgtypes.Peer) error {
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return err
+	}
+
+	var removals, restores []wgtypes.PeerConfig
+
+	before := indexPeers(snapshot)
+	current := indexPeers(dev.Peers)
+
+	for _, peer := range dev.Peers {
+		if _, ok := before[peer.PublicKey]; !ok {
+			removals = append(removals, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+		}
+	}
+
+	for _, peer := range snapshot {
+		if i, ok := current[peer.PublicKey]; !ok || !peerConfigEqual(peer, dev.Peers[i]) {
+			restores = append(restores, peerConfigOf(peer))
+		}
+	}
+
+	if len(removals) == 0 && len(restores) == 0 {
+		return nil
+	}
+
+	// removals are applied first, such that any allowed ips taken from
+	// existing peers may be restored to them.
+	return s.configure(wgtypes.Config{Peers: append(removals, restores...)})
+}
+
+// peerConfigEqual returns true if the configuration of two Peers is equal,
+// ignoring their endpoints which may change as the Peer roams.
+func peerConfigEqual(a, b wgtypes.Peer) bool {
+	if a.PresharedKey != b.PresharedKey || a.PersistentKeepaliveInterval != b.PersistentKeepaliveInterval {
+		return false
+	} else if len(a.AllowedIPs) != len(b.AllowedIPs) {
+		return false
+	}
+
+	for _, allowedIP := range a.AllowedIPs {
+		if !containsIPNet(b.AllowedIPs, allowedIP) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// peerConfigOf returns the configuration that recreates peer exactly.
+func peerConfigOf(peer wgtypes.Peer) wgtypes.PeerConfig {
+	psk := peer.PresharedKey
+	keepAlive := peer.PersistentKeepaliveInterval
+
+	return wgtypes.PeerConfig{
+		PublicKey:                   peer.PublicKey,
+		PresharedKey:                &psk,
+		Endpoint:                    peer.Endpoint,
+		PersistentKeepaliveInterval: &keepAlive,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  peer.AllowedIPs,
+	}
+}
+
+// addPeerItem converts an AddPeerRequest into a batch item, applying its
+// Template if any and allocating allowed ips if requested.
+func (s *Server) addPeerItem(ctx context.Context, req *client.AddPeerRequest) batchItem {
+	item := batchItem{publicKey: req.PublicKey}
+
+	item.req, item.tmpl, item.err = s.resolveTemplate(req)
+	if item.err != nil {
+		return item
+	}
+
+	item.config, item.err = addPeerConfig(item.req)
+	if item.err != nil {
+		return item
+	}
+
+	// items are each given to the device as a single Peer, which cannot
+	// unset an endpoint.
+	if item.req.ClearEndpoint {
+		item.err = invalidParam("clear_endpoint", "true", "clear_endpoint is only supported by AddPeer")
+		return item
+	}
+
+	item.err = s.checkAddPeer(item.req)
+	if item.err != nil {
+		return item
+	}
+
+	item.allocated, item.err = s.allocateIPs(ctx, item.req, &item.config)
+
+	return item
+}
+
+func validateAddPeersRequest(req *client.AddPeersRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	return validateOnError(req.OnError)
+}
+
+// AddPeers inserts or updates many Peers at once, with the behaviour on
+// failure of any one Peer controlled by OnError.
+func (s *Server) AddPeers(ctx context.Context, req *client.AddPeersRequest) (*client.AddPeersResponse, error) {
+	if err := validateAddPeersRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize("peers", len(req.Peers)); err != nil {
+		return nil, err
+	}
+
+	items := make([]batchItem, len(req.Peers))
+
+	for i, peer := range req.Peers {
+		if peer == nil {
+			items[i].err = invalidParam("peers", "", "peer is required")
+			continue
+		}
+
+		items[i] = s.addPeerItem(ctx, peer)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.applyBatch(ctx, items, req.OnError, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.AddPeersResponse{
+		OK:         res.OK,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
+
+func validateRemovePeersRequest(req *client.RemovePeersRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	return validateOnError(req.OnError)
+}
+
+// RemovePeers deletes many Peers at once, with the behaviour on failure of
+// any one Peer controlled by OnError.
+func (s *Server) RemovePeers(ctx context.Context, req *client.RemovePeersRequest) (*client.RemovePeersResponse, error) {
+	if err := validateRemovePeersRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize("public_keys", len(req.PublicKeys)); err != nil {
+		return nil, err
+	}
+
+	items := make([]batchItem, len(req.PublicKeys))
+
+	for i, publicKey := range req.PublicKeys {
+		remove := &client.RemovePeerRequest{PublicKey: publicKey}
+
+		items[i].publicKey = publicKey
+		items[i].config, items[i].err = removePeerConfig(remove)
+		if items[i].err == nil {
+			items[i].err = s.checkRemovePeer(ctx, remove)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.applyBatch(ctx, items, req.OnError, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.RemovePeersResponse{
+		OK:         res.OK,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
+
+func validateApplyBatchRequest(req *client.ApplyBatchRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	return validateOnError(req.OnError)
+}
+
+// ApplyBatch applies a sequence of Peer additions and removals to the
+// device, with the behaviour on failure of any one operation controlled by
+// OnError.
+func (s *Server) ApplyBatch(ctx context.Context, req *client.ApplyBatchRequest) (*client.ApplyBatchResponse, error) {
+	if err := validateApplyBatchRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize("operations", len(req.Operations)); err != nil {
+		return nil, err
+	}
+
+	items := make([]batchItem, len(req.Operations))
+
+	for i, op := range req.Operations {
+		switch {
+		case op != nil && op.AddPeer != nil && op.RemovePeer == nil:
+			items[i] = s.addPeerItem(ctx, op.AddPeer)
+
+		case op != nil && op.RemovePeer != nil && op.AddPeer == nil:
+			items[i].publicKey = op.RemovePeer.PublicKey
+			items[i].config, items[i].err = removePeerConfig(op.RemovePeer)
+			if items[i].err == nil {
+				items[i].err = s.checkRemovePeer(ctx, op.RemovePeer)
+			}
+
+		default:
+			items[i].err = invalidParam("operations", "", "exactly one of add_peer or remove_peer is required")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.applyBatch(ctx, items, req.OnError, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ApplyBatchResponse{
+		OK:         res.OK,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
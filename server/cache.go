@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultPeerCacheTTL is how long a dump of the device is used to look up
+// Peers, unless configured otherwise.
+const defaultPeerCacheTTL = time.Second
+
+// peerCache holds the last dump of the device, indexed such that a Peer can
+// be found by its public key or an address in constant time, rather than by
+// dumping and scanning every Peer of the device on every request.
+type peerCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	index  *peerIndex
+	dumped time.Time
+}
+
+// peerIndex is a dump of the device indexed by public key and allowed ips.
+// It must not be modified once built, as it is shared between requests.
+type peerIndex struct {
+	dev   *wgtypes.Device
+	byKey map[wgtypes.Key]int
+	byIP  ipTrie
+}
+
+// WithPeerCacheTTL configures how long a dump of the device is used to look
+// up Peers by GetPeer and the self-service API before the device is dumped
+// again, by default 1 second. The cache is always invalidated when the device
+// is configured by WG-API. Zero disables the cache.
+func WithPeerCacheTTL(d time.Duration) Option {
+	return func(s *Server) {
+		s.cache.ttl = d
+	}
+}
+
+// peerIndex returns the Peers of the device indexed for lookup, dumping the
+// device if the cache has expired.
+func (s *Server) peerIndex(ctx context.Context) (*peerIndex, error) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	if s.cache.index != nil && time.Since(s.cache.dumped) < s.cache.ttl {
+		return s.cache.index, nil
+	}
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	index := &peerIndex{dev: dev, byKey: indexPeers(dev.Peers)}
+
+	for i, peer := range dev.Peers {
+		for _, allowedIP := range peer.AllowedIPs {
+			index.byIP.insert(allowedIP, i)
+		}
+	}
+
+	s.cache.index = index
+	s.cache.dumped = time.Now()
+
+	return index, nil
+}
+
+// invalidate discards the cached dump of the device, such that the next
+// lookup sees changes just made.
+func (c *peerCache) invalidate() {
+	c.mu.Lock()
+	c.index = nil
+	c.mu.Unlock()
+}
+
+// privateKeyCache holds the private key of the device, such that verifying
+// a signed request does not dump the device. It is only got from the device
+// again once WG-API changes it.
+type privateKeyCache struct {
+	mu  sync.Mutex
+	key *wgtypes.Key
+}
+
+// privateKey returns the private key of the device, getting it from the
+// device if it is not known.
+func (s *Server) privateKey() (wgtypes.Key, error) {
+	s.key.mu.Lock()
+	defer s.key.mu.Unlock()
+
+	if s.key.key == nil {
+		dev, err := s.wg.Device(s.deviceName)
+		if err != nil {
+			return wgtypes.Key{}, err
+		}
+
+		s.key.key = &dev.PrivateKey
+	}
+
+	return *s.key.key, nil
+}
+
+// invalidate discards the private key of the device, such that it is got
+// again once changed.
+func (k *privateKeyCache) invalidate() {
+	k.mu.Lock()
+	k.key = nil
+	k.mu.Unlock()
+}
+
+// configure configures the device, invalidating the cache of its Peers, and
+// of its private key if changed, and saves its Peers to the state file, if
+// any.
+func (s *Server) configure(cfg wgtypes.Config) error {
+	defer s.cache.invalidate()
+
+	if cfg.PrivateKey != nil {
+		defer s.key.invalidate()
+	}
+
+	if err := s.wg.ConfigureDevice(s.deviceName, cfg); err != nil {
+		return err
+	}
+
+	s.gen.configured(cfg)
+
+	if s.state.path != "" {
+		if err := s.saveState(); err != nil {
+			log.Printf("error: state: could not save peers: %s\n", err)
+		}
+	}
+
+	return nil
+}
+
+// peer returns the Peer with publicKey, or nil if there is none.
+func (idx *peerIndex) peer(publicKey wgtypes.Key) *wgtypes.Peer {
+	if i, ok := idx.byKey[publicKey]; ok {
+		return &idx.dev.Peers[i]
+	}
+
+	return nil
+}
+
+// peerByAddress returns the Peer whose allowed ips most specifically contain
+// ip, which is the only Peer the device would accept a packet from ip from.
+func (idx *peerIndex) peerByAddress(ip net.IP) *wgtypes.Peer {
+	if i, ok := idx.byIP.lookup(ip); ok {
+		return &idx.dev.Peers[i]
+	}
+
+	return nil
+}
+
+// ipTrie is a binary trie of IP prefixes, for longest prefix matching of an
+// address to the Peer whose allowed ips contain it.
+type ipTrie struct {
+	v4, v6 *trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+
+	// peer is the index of the Peer with this prefix plus one, or zero if
+	// the prefix is not an allowed ip of any Peer.
+	peer int
+}
+
+// root returns the root of the trie for addresses the length of ip,
+// creating it if required.
+func (t *ipTrie) root(ip net.IP, create bool) (*trieNode, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		if t.v4 == nil && create {
+			t.v4 = new(trieNode)
+		}
+
+		return t.v4, ip4
+	}
+
+	if t.v6 == nil && create {
+		t.v6 = new(trieNode)
+	}
+
+	return t.v6, ip.To16()
+}
+
+// insert adds the prefix n of the Peer at index peer.
+func (t *ipTrie) insert(n net.IPNet, peer int) {
+	node, ip := t.root(n.IP, true)
+	if ip == nil {
+		return
+	}
+
+	ones, _ := n.Mask.Size()
+
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+
+		if node.children[bit] == nil {
+			node.children[bit] = new(trieNode)
+		}
+
+		node = node.children[bit]
+	}
+
+	node.peer = peer + 1
+}
+
+// lookup returns the index of the Peer with the longest prefix containing ip.
+func (t *ipTrie) lookup(ip net.IP) (int, bool) {
+	node, ip := t.root(ip, false)
+	if node == nil || ip == nil {
+		return 0, false
+	}
+
+	match := node.peer
+
+	for i := 0; i < len(ip)*8 && node != nil; i++ {
+		node = node.children[ip[i/8]>>(7-uint(i%8))&1]
+
+		if node != nil && node.peer != 0 {
+			match = node.peer
+		}
+	}
+
+	return match - 1, match != 0
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/qr"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultClientAllowedIPs are routed through the device by a Peer unless the
+// request gives others.
+var defaultClientAllowedIPs = []string{"0.0.0.0/0", "::/0"}
+
+// clientDefaults are the settings of the configurations generated for Peers
+// that requests need not give.
+type clientDefaults struct {
+	endpoint string
+	dns      []string
+}
+
+// clientQRScale is the size in pixels of each module of the PNG QR codes of
+// configurations, large enough to be scanned from a screen.
+const clientQRScale = 8
+
+// WithClientDefaults configures the endpoint, as host[:port], Peers connect
+// to the device at, and their DNS servers, in the configurations returned by
+// GenerateClientConfig when the request does not give them.
+func WithClientDefaults(endpoint string, dns []string) Option {
+	return func(s *Server) {
+		s.clientDefaults = clientDefaults{endpoint: endpoint, dns: dns}
+	}
+}
+
+// GenerateClientConfig returns the wg-quick configuration of a Peer, such
+// that every consumer of the API need not template it themselves. Settings
+// not given by the request are those of the Peer on the device, if it is,
+// and of the device itself. Nothing is changed, nor any generated key
+// retained.
+func (s *Server) GenerateClientConfig(ctx context.Context, req *client.GenerateClientConfigRequest) (*client.GenerateClientConfigResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	switch req.Format {
+	case "", client.QRFormatPNG, client.QRFormatASCII:
+	default:
+		return nil, invalidParam("format", req.Format, "format must be one of png or ascii")
+	}
+
+	res := new(client.GenerateClientConfigResponse)
+
+	var privateKey wgtypes.Key
+	if req.PrivateKey == "" {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey = key
+		res.PrivateKey = key.String()
+	} else {
+		key, err := wgtypes.ParseKey(req.PrivateKey)
+		if err != nil {
+			return nil, invalidParam("private_key", "", "invalid private key: "+err.Error())
+		}
+
+		privateKey = key
+	}
+
+	res.PublicKey = privateKey.PublicKey().String()
+
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &clientConfig{
+		privateKey:   privateKey.String(),
+		address:      req.Address,
+		dns:          req.DNS,
+		mtu:          req.MTU,
+		publicKey:    idx.dev.PublicKey.String(),
+		presharedKey: req.PresharedKey,
+		allowedIPs:   req.AllowedIPs,
+	}
+
+	if len(cfg.dns) == 0 {
+		cfg.dns = s.clientDefaults.dns
+	}
+
+	if len(cfg.allowedIPs) == 0 {
+		cfg.allowedIPs = defaultClientAllowedIPs
+	}
+
+	var keepAlive time.Duration
+	if req.PersistentKeepAlive != "" {
+		if err := validateKeepAlive(req.PersistentKeepAlive); err != nil {
+			return nil, invalidParam("persistent_keep_alive", req.PersistentKeepAlive, "invalid keepalive: "+err.Error())
+		}
+
+		keepAlive, _ = time.ParseDuration(req.PersistentKeepAlive)
+	}
+
+	if peer := idx.peer(privateKey.PublicKey()); peer != nil {
+		if len(cfg.address) == 0 {
+			for _, aip := range peer.AllowedIPs {
+				cfg.address = append(cfg.address, aip.String())
+			}
+		}
+
+		if cfg.presharedKey == "" && peer.PresharedKey != (wgtypes.Key{}) {
+			cfg.presharedKey = peer.PresharedKey.String()
+		}
+
+		if req.PersistentKeepAlive == "" {
+			keepAlive = peer.PersistentKeepaliveInterval
+		}
+	}
+
+	cfg.keepAlive = int(keepAlive / time.Second)
+
+	endpoint := req.Endpoint
+	if endpoint == "" {
+		endpoint = s.clientDefaults.endpoint
+	}
+
+	if cfg.endpoint, err = clientEndpoint(endpoint, idx.dev.ListenPort); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	res.Config = cfg.String()
+
+	if req.Format != "" {
+		if res.QRCode, err = clientQRCode(res.Config, req.Format); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// clientQRCode returns config as a QR code in format, either a base64 PNG or
+// text.
+func clientQRCode(config, format string) (string, error) {
+	code, err := qr.Encode([]byte(config), qr.M)
+	if err != nil {
+		return "", invalidParam("format", format, "configuration too long for a qr code")
+	}
+
+	if format == client.QRFormatASCII {
+		return code.String(), nil
+	}
+
+	img, err := code.PNG(clientQRScale)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(img), nil
+}
+
+// clientEndpoint returns endpoint as host:port, with the port of the device
+// if it has none.
+func clientEndpoint(endpoint string, listenPort int) (string, error) {
+	if endpoint == "" {
+		return "", invalidParam("endpoint", "", "endpoint is required, as none is configured on the server")
+	}
+
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]"), strconv.Itoa(listenPort)
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", invalidParam("endpoint", endpoint, "invalid port "+port)
+	} else if host == "" || strings.ContainsAny(host, " \t\r\n[]") {
+		return "", invalidParam("endpoint", endpoint, "invalid host")
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// clientConfig is the wg-quick configuration of a Peer.
+type clientConfig struct {
+	privateKey string
+	address    []string
+	dns        []string
+	mtu        int
+
+	publicKey    string
+	presharedKey string
+	endpoint     string
+	allowedIPs   []string
+	keepAlive    int
+}
+
+// validate returns an error if any setting of c is invalid, or would alter
+// the lines of the configuration.
+func (c *clientConfig) validate() error {
+	if len(c.address) == 0 {
+		return invalidParam("address", "", "address is required, as the peer is not on the device")
+	}
+
+	for _, addr := range c.address {
+		if _, _, err := net.ParseCIDR(addr); err != nil && net.ParseIP(addr) == nil {
+			return invalidParam("address", addr, fmt.Sprintf("address %q is not valid", addr))
+		}
+	}
+
+	for _, dns := range c.dns {
+		if dns == "" || strings.ContainsAny(dns, ", \t\r\n") {
+			return invalidParam("dns", dns, fmt.Sprintf("dns server %q is not valid", dns))
+		}
+	}
+
+	for _, allowedIP := range c.allowedIPs {
+		if _, _, err := net.ParseCIDR(allowedIP); err != nil {
+			return invalidParam("allowed_ips", allowedIP, fmt.Sprintf("range %q is not valid: %s", allowedIP, err))
+		}
+	}
+
+	if c.presharedKey != "" {
+		if _, err := wgtypes.ParseKey(c.presharedKey); err != nil {
+			return invalidParam("preshared_key", "", "invalid preshared key: "+err.Error())
+		}
+	}
+
+	if c.mtu < 0 || c.mtu > 65535 {
+		return invalidParam("mtu", strconv.Itoa(c.mtu), "invalid mtu")
+	}
+
+	return nil
+}
+
+// String returns the configuration in the format of wg-quick.
+func (c *clientConfig) String() string {
+	var b strings.Builder
+
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", c.privateKey)
+	fmt.Fprintf(&b, "Address = %s\n", strings.Join(c.address, ", "))
+
+	if len(c.dns) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(c.dns, ", "))
+	}
+
+	if c.mtu > 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", c.mtu)
+	}
+
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", c.publicKey)
+
+	if c.presharedKey != "" {
+		fmt.Fprintf(&b, "PresharedKey = %s\n", c.presharedKey)
+	}
+
+	fmt.Fprintf(&b, "Endpoint = %s\n", c.endpoint)
+	fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(c.allowedIPs, ", "))
+
+	if c.keepAlive > 0 {
+		fmt.Fprintf(&b, "PersistentKeepalive = %d\n", c.keepAlive)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func validateClonePeerRequest(req *client.ClonePeerRequest) (wgtypes.Key, error) {
+	if req == nil {
+		return wgtypes.Key{}, invalidParam("", "", "request body required")
+	}
+
+	publicKey, err := wgtypes.ParseKey(req.PublicKey)
+	if err != nil {
+		return wgtypes.Key{}, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
+	}
+
+	switch {
+	case req.NewPublicKey == "" && !req.GenerateKey:
+		return wgtypes.Key{}, invalidParam("new_public_key", "", "new public key is required, unless generate_key is set")
+
+	case req.NewPublicKey != "" && req.GenerateKey:
+		return wgtypes.Key{}, invalidParam("new_public_key", req.NewPublicKey, "new public key cannot be given with generate_key")
+
+	case req.NewPublicKey == req.PublicKey:
+		return wgtypes.Key{}, invalidParam("new_public_key", req.NewPublicKey, "new public key must differ from that of the peer")
+	}
+
+	return publicKey, nil
+}
+
+// ClonePeer adds a Peer with the configuration of an existing Peer under a new
+// public key, such as to issue replacement credentials to a user who has
+// lost a device. The clone is given the persistent keepalive, metadata and
+// template of the Peer, a new preshared key if the Peer has one, and new
+// allowed ips from IP address management in place of those assigned to the
+// Peer. Allowed ips of the Peer not assigned by IP address management are
+// only ever routed to a single Peer, so must be replaced by AllowedIPs.
+func (s *Server) ClonePeer(ctx context.Context, req *client.ClonePeerRequest) (*client.ClonePeerResponse, error) {
+	publicKey, err := validateClonePeerRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := idx.peer(publicKey)
+	if peer == nil {
+		return nil, peerNotFoundError(req.PublicKey)
+	}
+
+	add := &client.AddPeerRequest{
+		PublicKey:          req.NewPublicKey,
+		AllowedIPs:         req.AllowedIPs,
+		Metadata:           req.Metadata,
+		CreateOnly:         true,
+		ExpectedGeneration: req.ExpectedGeneration,
+		DryRun:             req.DryRun,
+	}
+
+	if peer.PersistentKeepaliveInterval > 0 {
+		add.PersistentKeepAlive = peer.PersistentKeepaliveInterval.String()
+	}
+
+	stored, err := s.store.GetPeer(ctx, req.PublicKey)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, storeError("could not get peer metadata", err)
+	} else if stored != nil {
+		add.Template = stored.Template
+
+		if add.Metadata == nil {
+			add.Metadata = stored.Metadata
+		}
+	}
+
+	var leased []string
+	if lister, ok := s.ipam.(LeaseLister); ok {
+		if leased, err = lister.Leased(ctx, req.PublicKey); err != nil {
+			return nil, ipamError("could not list allowed ips", err)
+		}
+	}
+
+	add.AllocateAllowedIPs = len(leased) > 0
+
+	if len(req.AllowedIPs) == 0 {
+		var static []string
+		for _, allowedIP := range peer.AllowedIPs {
+			if !stringInSlice(allowedIP.String(), leased) {
+				static = append(static, allowedIP.String())
+			}
+		}
+
+		if len(static) > 0 {
+			return nil, invalidParam("allowed_ips", strings.Join(static, ","), "allowed ips of the peer not assigned by ip address management would be taken from it, allowed_ips must be given")
+		}
+	}
+
+	res := &client.ClonePeerResponse{}
+
+	if req.GenerateKey {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		add.PublicKey = key.PublicKey().String()
+		res.PrivateKey = key.String()
+	}
+
+	if peer.PresharedKey != (wgtypes.Key{}) {
+		psk, err := wgtypes.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		add.PresharedKey = psk.String()
+		res.PresharedKey = add.PresharedKey
+	}
+
+	added, err := s.AddPeer(ctx, add)
+	if err != nil {
+		return nil, err
+	}
+
+	res.OK = true
+	res.PublicKey = add.PublicKey
+	res.AllocatedIPs = added.AllocatedIPs
+	res.DryRun = added.DryRun
+	res.Changes = added.Changes
+	res.Generation = added.Generation
+
+	return res, nil
+}
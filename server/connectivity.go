@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// connectivityInterval is how often the handshakes of Peers are checked
+	// to derive connectivity Events.
+	connectivityInterval = 15 * time.Second
+
+	// connectedTimeout is how recently a Peer must have completed a handshake
+	// to be considered connected. WireGuard rejects sessions older than
+	// three minutes.
+	connectedTimeout = 3 * time.Minute
+)
+
+// observed is the state of a Peer when its handshakes were last checked.
+type observed struct {
+	connected bool
+	handshake time.Time
+	endpoint  string
+}
+
+// runConnectivity publishes peer.connected and peer.disconnected Events as
+// Peers complete or stop completing handshakes, until ctx is cancelled.
+func (s *Server) runConnectivity(ctx context.Context) {
+	t := time.NewTicker(connectivityInterval)
+	defer t.Stop()
+
+	var connected map[wgtypes.Key]observed
+
+	for {
+		dev, err := s.wg.Device(s.deviceName)
+		if err != nil {
+			log.Printf("error: events: could not get WireGuard device: %s\n", err)
+		} else {
+			connected = s.publishConnectivity(connected, dev.Peers)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// publishConnectivity publishes an Event for each Peer whose connectivity
+// differs from previous, returning the current state of each Peer. With the
+// event stream, an Event is also published for each Peer that has completed
+// a handshake or whose endpoint has changed. If previous is nil, no Events
+// are published.
+func (s *Server) publishConnectivity(previous map[wgtypes.Key]observed, peers []wgtypes.Peer) map[wgtypes.Key]observed {
+	now := time.Now().UTC()
+	current := make(map[wgtypes.Key]observed, len(peers))
+
+	for _, peer := range peers {
+		state := observed{
+			connected: now.Sub(peer.LastHandshakeTime) < connectedTimeout,
+			handshake: peer.LastHandshakeTime,
+		}
+
+		if peer.Endpoint != nil {
+			state.endpoint = peer.Endpoint.String()
+		}
+
+		current[peer.PublicKey] = state
+
+		if previous == nil {
+			continue
+		}
+
+		prev := previous[peer.PublicKey]
+
+		var eventTypes []string
+
+		if prev.connected != state.connected {
+			eventType := client.EventPeerDisconnected
+			if state.connected {
+				eventType = client.EventPeerConnected
+			}
+
+			eventTypes = append(eventTypes, eventType)
+		}
+
+		if s.subscribers.enabled {
+			if state.handshake.After(prev.handshake) {
+				eventTypes = append(eventTypes, client.EventPeerHandshake)
+			}
+
+			if state.endpoint != prev.endpoint && state.endpoint != "" {
+				eventTypes = append(eventTypes, client.EventPeerEndpointChanged)
+			}
+		}
+
+		if len(eventTypes) == 0 {
+			continue
+		}
+
+		rpcPeer := peer2rpc(peer)
+
+		for _, eventType := range eventTypes {
+			s.publish(&client.Event{
+				Type:      eventType,
+				Time:      now,
+				Device:    s.deviceName,
+				PublicKey: rpcPeer.PublicKey,
+				Peer:      rpcPeer,
+			})
+		}
+	}
+
+	return current
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"log"
+	"net"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// WithConntrackFlush configures the Server to delete the connection tracking
+// entries of the addresses Peers lose, as they are removed or their allowed
+// ips shrink, such that established flows end at once rather than lingering
+// until they time out.
+func WithConntrackFlush() Option {
+	return func(s *Server) {
+		s.flushConntrack = true
+	}
+}
+
+// lostAddrs are the ranges a Peer no longer has, except those it kept, such
+// as when one of its allowed ips is narrowed.
+type lostAddrs struct {
+	lost, kept []*net.IPNet
+}
+
+// conntrackFlush deletes the connection tracking entries of the addresses
+// lost by changes, if enabled. The device has already been configured, so
+// failure is only logged.
+func (s *Server) conntrackFlush(changes []*client.PeerChange) {
+	if !s.flushConntrack {
+		return
+	}
+
+	var lost []lostAddrs
+
+	for _, change := range changes {
+		if change.Before == nil {
+			continue
+		}
+
+		var after []string
+		if change.After != nil {
+			after = change.After.AllowedIPs
+		}
+
+		var addrs lostAddrs
+
+		for _, allowedIP := range change.Before.AllowedIPs {
+			if _, n, err := net.ParseCIDR(allowedIP); err == nil && !stringInSlice(allowedIP, after) {
+				addrs.lost = append(addrs.lost, n)
+			}
+		}
+
+		for _, allowedIP := range after {
+			if _, n, err := net.ParseCIDR(allowedIP); err == nil {
+				addrs.kept = append(addrs.kept, n)
+			}
+		}
+
+		if len(addrs.lost) > 0 {
+			lost = append(lost, addrs)
+		}
+	}
+
+	if len(lost) == 0 {
+		return
+	}
+
+	n, err := deleteConntrack(func(ip net.IP) bool {
+		for _, addrs := range lost {
+			if containsIP(addrs.lost, ip) && !containsIP(addrs.kept, ip) {
+				return true
+			}
+		}
+
+		return false
+	})
+	if err != nil {
+		log.Printf("error: conntrack: could not flush entries of removed addresses: %s\n", err)
+	} else if n > 0 {
+		log.Printf("info: conntrack: flushed %d entries of removed addresses\n", n)
+	}
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
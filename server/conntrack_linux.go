@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"net"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// the messages and attributes of ctnetlink, from
+// linux/netfilter/nfnetlink_conntrack.h.
+const (
+	ipctnlMsgCtGet    = 1
+	ipctnlMsgCtDelete = 2
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaZone       = 18
+
+	ctaTupleIP = 1
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+)
+
+// deleteConntrack deletes every connection tracking entry with an address
+// of either direction of its flow matching match, returning the number
+// deleted.
+func deleteConntrack(match func(net.IP) bool) (int, error) {
+	c, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	entries, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8 | ipctnlMsgCtGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: nfgenmsg(unix.AF_UNSPEC),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+
+	for _, entry := range entries {
+		if len(entry.Data) < 4 {
+			continue
+		}
+
+		// an entry is deleted by its original tuple, and its zone if it
+		// has one, as given by the kernel.
+		var ips []net.IP
+		ae := netlink.NewAttributeEncoder()
+
+		ad, err := netlink.NewAttributeDecoder(entry.Data[4:])
+		if err != nil {
+			return n, err
+		}
+
+		for ad.Next() {
+			switch ad.Type() {
+			case ctaTupleOrig:
+				ae.Bytes(netlink.Nested|ctaTupleOrig, ad.Bytes())
+				ips = append(ips, tupleIPs(ad.Bytes())...)
+
+			case ctaTupleReply:
+				ips = append(ips, tupleIPs(ad.Bytes())...)
+
+			case ctaZone:
+				ae.Bytes(netlink.NetByteOrder|ctaZone, ad.Bytes())
+			}
+		}
+
+		if err := ad.Err(); err != nil {
+			return n, err
+		}
+
+		if !matchAny(ips, match) {
+			continue
+		}
+
+		attrs, err := ae.Encode()
+		if err != nil {
+			return n, err
+		}
+
+		_, err = c.Execute(netlink.Message{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8 | ipctnlMsgCtDelete),
+				Flags: netlink.Request | netlink.Acknowledge,
+			},
+			Data: append(nfgenmsg(entry.Data[0]), attrs...),
+		})
+		if errors.Is(err, unix.ENOENT) {
+			// the entry has expired since it was dumped.
+			continue
+		} else if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// nfgenmsg returns the header of a nfnetlink message of family.
+func nfgenmsg(family uint8) []byte {
+	return []byte{family, unix.NFNETLINK_V0, 0, 0}
+}
+
+// tupleIPs returns the source and destination addresses of a tuple.
+func tupleIPs(b []byte) []net.IP {
+	var ips []net.IP
+
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil
+	}
+
+	for ad.Next() {
+		if ad.Type() != ctaTupleIP {
+			continue
+		}
+
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			for nad.Next() {
+				switch nad.Type() {
+				case ctaIPv4Src, ctaIPv4Dst, ctaIPv6Src, ctaIPv6Dst:
+					ips = append(ips, net.IP(nad.Bytes()))
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return ips
+}
+
+func matchAny(ips []net.IP, match func(net.IP) bool) bool {
+	for _, ip := range ips {
+		if match(ip) {
+			return true
+		}
+	}
+
+	return false
+}
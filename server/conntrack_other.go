@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// deleteConntrack is only supported on Linux.
+func deleteConntrack(match func(net.IP) bool) (int, error) {
+	return 0, errors.New("flushing conntrack is only supported on linux")
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// createDevice is a validated CreateDeviceRequest.
+type createDevice struct {
+	name       string
+	addresses  []netip.Prefix
+	listenPort int
+	privateKey wgtypes.Key
+	mtu        int
+}
+
+func validateCreateDeviceRequest(req *client.CreateDeviceRequest) (*createDevice, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	if req.Name == "" {
+		return nil, invalidParam("name", "", "name is required")
+	} else if err := validateDeviceName("name", req.Name); err != nil {
+		return nil, err
+	}
+
+	cd := &createDevice{name: req.Name, listenPort: req.ListenPort, mtu: req.MTU}
+
+	for _, addr := range req.Addresses {
+		prefix, err := netip.ParsePrefix(addr)
+		if err != nil {
+			ip, ierr := netip.ParseAddr(addr)
+			if ierr != nil {
+				return nil, invalidParam("addresses", addr, fmt.Sprintf("address %q is not valid", addr))
+			}
+
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+
+		cd.addresses = append(cd.addresses, prefix)
+	}
+
+	if req.ListenPort < 0 || req.ListenPort > math.MaxUint16 {
+		return nil, invalidParam("listen_port", strconv.Itoa(req.ListenPort), "listen port must be between 0 and 65535")
+	}
+
+	if req.MTU < 0 || req.MTU > math.MaxUint16 {
+		return nil, invalidParam("mtu", strconv.Itoa(req.MTU), "invalid mtu")
+	}
+
+	if req.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(req.PrivateKey)
+		if err != nil {
+			return nil, invalidParam("private_key", "", "invalid private key: "+err.Error())
+		}
+
+		cd.privateKey = key
+	} else {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		cd.privateKey = key
+	}
+
+	return cd, nil
+}
+
+// NewDevice creates the WireGuard device of req on the host, by the kernel
+// module, assigns its addresses and brings it up, such that WG-API can
+// bootstrap the device of a fresh host. The device is deleted again if it
+// could not be configured.
+func NewDevice(wg WireGuard, req *client.CreateDeviceRequest) (*wgtypes.Device, error) {
+	cd, err := validateCreateDeviceRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cd.create(wg); err != nil {
+		return nil, err
+	}
+
+	return wg.Device(cd.name)
+}
+
+// create creates the device of cd, deleting it again if it could not be
+// configured.
+func (cd *createDevice) create(wg WireGuard) error {
+	if err := createLink(cd.name, cd.mtu); err != nil {
+		return err
+	}
+
+	cfg := wgtypes.Config{PrivateKey: &cd.privateKey}
+	if cd.listenPort != 0 {
+		cfg.ListenPort = &cd.listenPort
+	}
+
+	err := wg.ConfigureDevice(cd.name, cfg)
+	if err == nil {
+		err = upLink(cd.name, cd.addresses)
+	}
+
+	if err != nil {
+		if derr := deleteLink(cd.name); derr != nil {
+			return fmt.Errorf("%w, and could not delete it again: %s", err, derr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// CreateDevice creates a WireGuard device on the host, other than that of
+// the Server, assigns its addresses and brings it up. The device is not
+// served, it is only listed by WatchDevices and may be the destination of
+// MovePeers.
+func (s *Server) CreateDevice(ctx context.Context, req *client.CreateDeviceRequest) (*client.CreateDeviceResponse, error) {
+	cd, err := validateCreateDeviceRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.wg.Device(cd.name); err == nil {
+		return nil, invalidParam("name", req.Name, "device already exists")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, deviceError("could not get WireGuard device "+cd.name, err)
+	}
+
+	if req.DryRun || s.dryRun {
+		device := &client.Device{
+			Name:       cd.name,
+			Type:       wgtypes.LinuxKernel.String(),
+			PublicKey:  cd.privateKey.PublicKey().String(),
+			ListenPort: cd.listenPort,
+		}
+
+		return &client.CreateDeviceResponse{OK: true, DryRun: true, Device: device}, nil
+	}
+
+	if err := cd.create(s.wg); errors.Is(err, os.ErrExist) {
+		return nil, invalidParam("name", req.Name, "a network interface with this name already exists")
+	} else if err != nil {
+		return nil, deviceError("could not create device "+cd.name, err)
+	}
+
+	if err := s.scanDevices(); err != nil {
+		return nil, deviceError("could not list WireGuard devices", err)
+	}
+
+	dev, err := s.wg.Device(cd.name)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device "+cd.name, err)
+	}
+
+	s.devices.mu.Lock()
+	aliases := s.deviceAliases(dev.Name)
+	s.devices.mu.Unlock()
+
+	return &client.CreateDeviceResponse{
+		OK: true,
+		Device: &client.Device{
+			Name:         dev.Name,
+			Type:         dev.Type.String(),
+			PublicKey:    dev.PublicKey.String(),
+			ListenPort:   dev.ListenPort,
+			FirewallMark: dev.FirewallMark,
+			NumPeers:     len(dev.Peers),
+			Aliases:      aliases,
+		},
+	}, nil
+}
+
+func validateDeleteDeviceRequest(req *client.DeleteDeviceRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Name == "" {
+		return invalidParam("name", "", "device is required")
+	}
+
+	return nil
+}
+
+// DeleteDevice deletes a WireGuard device on the host other than that of the
+// Server, disconnecting every one of its Peers. Any aliases of the device are
+// kept, such that they address it again if it is created once more.
+func (s *Server) DeleteDevice(ctx context.Context, req *client.DeleteDeviceRequest) (*client.DeleteDeviceResponse, error) {
+	if err := validateDeleteDeviceRequest(req); err != nil {
+		return nil, err
+	}
+
+	name := s.resolveDevice(req.Name)
+	if name == s.deviceName {
+		return nil, invalidParam("name", req.Name, "the device served cannot be deleted")
+	}
+
+	if _, err := s.wg.Device(name); errors.Is(err, os.ErrNotExist) {
+		return nil, invalidParam("name", req.Name, "device not found")
+	} else if err != nil {
+		return nil, deviceError("could not get WireGuard device "+name, err)
+	}
+
+	if req.DryRun || s.dryRun {
+		return &client.DeleteDeviceResponse{OK: true, DryRun: true}, nil
+	}
+
+	if err := deleteLink(name); err != nil {
+		return nil, deviceError("could not delete device "+name, err)
+	}
+
+	if err := s.scanDevices(); err != nil {
+		return nil, deviceError("could not list WireGuard devices", err)
+	}
+
+	return &client.DeleteDeviceResponse{OK: true}, nil
+}
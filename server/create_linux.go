@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// createLink creates the WireGuard network interface name by rtnetlink, with
+// mtu if non-zero. It fails with os.ErrExist if the interface already exists.
+func createLink(name string, mtu int) error {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, name)
+
+	if mtu > 0 {
+		ae.Uint32(unix.IFLA_MTU, uint32(mtu))
+	}
+
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.IFLA_INFO_KIND, "wireguard")
+		return nil
+	})
+
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_NEWLINK,
+			Flags: netlink.Request | netlink.Acknowledge | netlink.Create | netlink.Excl,
+		},
+		Data: append(make([]byte, unix.SizeofIfInfomsg), attrs...),
+	})
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return errors.New("the wireguard kernel module is not loaded")
+	}
+
+	return err
+}
+
+// upLink assigns addresses to the network interface name by rtnetlink, and
+// brings it up.
+func upLink(name string, addresses []netip.Prefix) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for _, addr := range addresses {
+		if err := addAddress(c, iface.Index, addr); err != nil {
+			return err
+		}
+	}
+
+	return setLink(c, iface.Index, unix.IFF_UP, unix.IFF_UP, nil)
+}
+
+// addAddress assigns addr to the link with index.
+func addAddress(c *netlink.Conn, index int, addr netip.Prefix) error {
+	family := unix.AF_INET
+	if addr.Addr().Is6() {
+		family = unix.AF_INET6
+	}
+
+	ip := addr.Addr().AsSlice()
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.IFA_LOCAL, ip)
+	ae.Bytes(unix.IFA_ADDRESS, ip)
+
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	// struct ifaddrmsg, from linux/if_addr.h.
+	msg := make([]byte, unix.SizeofIfAddrmsg, unix.SizeofIfAddrmsg+len(attrs))
+	msg[0] = byte(family)
+	msg[1] = byte(addr.Bits())
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+
+	_, err = c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_NEWADDR,
+			Flags: netlink.Request | netlink.Acknowledge | netlink.Create | netlink.Replace,
+		},
+		Data: append(msg, attrs...),
+	})
+
+	return err
+}
+
+// deleteLink deletes the network interface name by rtnetlink.
+func deleteLink(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:], uint32(iface.Index))
+
+	_, err = c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_DELLINK,
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: msg,
+	})
+
+	return err
+}
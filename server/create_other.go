@@ -0,0 +1,27 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// errLinksUnsupported is returned by the functions creating and deleting
+// network interfaces, which are only supported on Linux.
+var errLinksUnsupported = errors.New("creating and deleting devices is only supported on linux")
+
+// createLink is only supported on Linux.
+func createLink(name string, mtu int) error {
+	return errLinksUnsupported
+}
+
+// upLink is only supported on Linux.
+func upLink(name string, addresses []netip.Prefix) error {
+	return errLinksUnsupported
+}
+
+// deleteLink is only supported on Linux.
+func deleteLink(name string) error {
+	return errLinksUnsupported
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// setDeviceConfig validates a SetDeviceConfigRequest and converts it into
+// the configuration given to the WireGuard device.
+func setDeviceConfig(req *client.SetDeviceConfigRequest) (wgtypes.Config, error) {
+	if req == nil {
+		return wgtypes.Config{}, invalidParam("", "", "request body required")
+	}
+
+	var cfg wgtypes.Config
+
+	if req.ListenPort != nil {
+		if *req.ListenPort < 0 || *req.ListenPort > math.MaxUint16 {
+			return cfg, invalidParam("listen_port", strconv.Itoa(*req.ListenPort), "listen port must be between 0 and 65535")
+		}
+
+		cfg.ListenPort = req.ListenPort
+	}
+
+	if req.FirewallMark != nil {
+		if *req.FirewallMark < 0 || int64(*req.FirewallMark) > math.MaxUint32 {
+			return cfg, invalidParam("firewall_mark", strconv.Itoa(*req.FirewallMark), "firewall mark must be between 0 and 4294967295")
+		}
+
+		cfg.FirewallMark = req.FirewallMark
+	}
+
+	switch {
+	case req.PrivateKey != "" && req.GeneratePrivateKey:
+		return cfg, invalidParam("private_key", "", "private_key and generate_private_key cannot both be given")
+
+	case req.PrivateKey != "":
+		key, err := wgtypes.ParseKey(req.PrivateKey)
+		if err != nil {
+			return cfg, invalidParam("private_key", "", "invalid private key: "+err.Error())
+		}
+
+		cfg.PrivateKey = &key
+
+	case req.GeneratePrivateKey:
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return cfg, err
+		}
+
+		cfg.PrivateKey = &key
+	}
+
+	if cfg.ListenPort == nil && cfg.FirewallMark == nil && cfg.PrivateKey == nil {
+		return cfg, invalidParam("", "", "at least one of listen_port, firewall_mark, private_key or generate_private_key is required")
+	}
+
+	return cfg, nil
+}
+
+// SetDeviceConfig changes the settings of the device itself, its listen
+// port, firewall mark and private key, leaving its Peers unchanged.
+func (s *Server) SetDeviceConfig(ctx context.Context, req *client.SetDeviceConfigRequest) (*client.SetDeviceConfigResponse, error) {
+	cfg, err := setDeviceConfig(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	gen := s.gen.observe(dev)
+	if req.ExpectedGeneration != 0 && req.ExpectedGeneration != gen {
+		return nil, conflictError(req.ExpectedGeneration, gen)
+	}
+
+	if req.DryRun || s.dryRun {
+		device := &client.Device{
+			Name:         dev.Name,
+			Type:         dev.Type.String(),
+			PublicKey:    dev.PublicKey.String(),
+			ListenPort:   dev.ListenPort,
+			FirewallMark: dev.FirewallMark,
+			NumPeers:     len(dev.Peers),
+			Aliases:      s.Aliases(),
+			Generation:   gen,
+		}
+
+		if cfg.ListenPort != nil {
+			device.ListenPort = *cfg.ListenPort
+		}
+
+		if cfg.FirewallMark != nil {
+			device.FirewallMark = *cfg.FirewallMark
+		}
+
+		if cfg.PrivateKey != nil {
+			device.PublicKey = cfg.PrivateKey.PublicKey().String()
+		}
+
+		return &client.SetDeviceConfigResponse{OK: true, DryRun: true, Device: device}, nil
+	}
+
+	// once begun, configuring the device is not abandoned, such that a
+	// request never times out having changed the device.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.configure(cfg); err != nil {
+		return nil, deviceError("could not configure WireGuard device", err)
+	}
+
+	if _, err := s.syncGeneration(); err != nil {
+		return nil, err
+	}
+
+	info, err := s.GetDeviceInfo(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.SetDeviceConfigResponse{OK: true, Device: info.Device}, nil
+}
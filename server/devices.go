@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+const (
+	// devicesInterval is how often the WireGuard devices of the host are
+	// listed, in case changes to links are missed or cannot be watched.
+	devicesInterval = 30 * time.Second
+
+	// defaultWatchTimeout and maxWatchTimeout bound how long WatchDevices
+	// waits for the devices to change.
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+)
+
+// devices are the WireGuard devices of the host, as last listed.
+type devices struct {
+	mu         sync.Mutex
+	list       []*client.Device
+	generation uint64
+
+	// aliases are the names of devices by their alias.
+	aliases map[string]string
+
+	// changed is closed, and replaced, whenever the devices change.
+	changed chan struct{}
+}
+
+// runDevices lists the WireGuard devices of the host whenever a link is added
+// or removed, and every devicesInterval, publishing device.added and
+// device.removed Events as they appear and disappear, until ctx is
+// cancelled.
+func (s *Server) runDevices(ctx context.Context) {
+	links, err := linkChanges(ctx)
+	if err != nil {
+		log.Printf("warn: devices: could not watch links, polling instead: %s\n", err)
+	}
+
+	t := time.NewTicker(devicesInterval)
+	defer t.Stop()
+
+	for {
+		if err := s.scanDevices(); err != nil {
+			log.Printf("error: devices: could not list WireGuard devices: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case _, ok := <-links:
+			if !ok {
+				links = nil
+			}
+
+		case <-t.C:
+		}
+	}
+}
+
+// scanDevices lists the WireGuard devices of the host, publishing an Event
+// for each that has appeared or disappeared since they were last listed.
+func (s *Server) scanDevices() error {
+	devs, err := s.wg.Devices()
+	if err != nil {
+		return err
+	}
+
+	list := make([]*client.Device, len(devs))
+	for i, dev := range devs {
+		list[i] = &client.Device{
+			Name:         dev.Name,
+			Type:         dev.Type.String(),
+			PublicKey:    dev.PublicKey.String(),
+			ListenPort:   dev.ListenPort,
+			FirewallMark: dev.FirewallMark,
+			NumPeers:     len(dev.Peers),
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	s.devices.mu.Lock()
+	defer s.devices.mu.Unlock()
+
+	for _, dev := range list {
+		dev.Aliases = s.deviceAliases(dev.Name)
+	}
+
+	before := make(map[string]bool, len(s.devices.list))
+	for _, dev := range s.devices.list {
+		before[dev.Name] = true
+	}
+
+	after := make(map[string]bool, len(list))
+	for _, dev := range list {
+		after[dev.Name] = true
+	}
+
+	first := s.devices.generation == 0
+	changed := first || len(before) != len(after)
+	now := time.Now().UTC()
+
+	for _, dev := range list {
+		if !before[dev.Name] {
+			changed = true
+
+			if !first {
+				s.publish(&client.Event{Type: client.EventDeviceAdded, Time: now, Device: dev.Name})
+			}
+		}
+	}
+
+	for name := range before {
+		if !after[name] {
+			s.publish(&client.Event{Type: client.EventDeviceRemoved, Time: now, Device: name})
+		}
+	}
+
+	s.devices.list = list
+
+	if changed {
+		s.devices.generation++
+
+		if s.devices.changed != nil {
+			close(s.devices.changed)
+		}
+
+		s.devices.changed = make(chan struct{})
+	}
+
+	return nil
+}
+
+// WatchDevices returns every WireGuard device on the host, waiting until they
+// change if the generation of the devices given is current.
+func (s *Server) WatchDevices(ctx context.Context, req *client.WatchDevicesRequest) (*client.WatchDevicesResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	timeout := defaultWatchTimeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d < 0 {
+			return nil, invalidParam("timeout", req.Timeout, "timeout must be a positive duration")
+		}
+
+		timeout = min(d, maxWatchTimeout)
+	}
+
+	if err := s.scanDevices(); err != nil {
+		return nil, deviceError("could not list WireGuard devices", err)
+	}
+
+	s.devices.mu.Lock()
+	generation, changed := s.devices.generation, s.devices.changed
+	s.devices.mu.Unlock()
+
+	if req.Generation == generation {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		case <-changed:
+		}
+	}
+
+	s.devices.mu.Lock()
+	defer s.devices.mu.Unlock()
+
+	return &client.WatchDevicesResponse{
+		Devices:    s.devices.list,
+		Generation: s.devices.generation,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// linkChanges returns a channel that receives whenever a network link is
+// added, removed or changed on the host, as notified by rtnetlink. It is
+// closed if the notifications can no longer be read.
+func linkChanges(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	// the socket is non-blocking, so reads are made through the runtime
+	// poller and unblocked when it is closed.
+	f := os.NewFile(uintptr(fd), "rtnetlink")
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		defer close(changes)
+
+		buf := make([]byte, os.Getpagesize()*4)
+
+		for {
+			n, err := f.Read(buf)
+			if errors.Is(err, unix.ENOBUFS) {
+				// notifications were dropped, so the links must be listed
+				// again regardless.
+				notify(changes)
+				continue
+			} else if err != nil {
+				return
+			}
+
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			for _, msg := range msgs {
+				if msg.Header.Type == unix.RTM_NEWLINK || msg.Header.Type == unix.RTM_DELLINK {
+					notify(changes)
+					break
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"context"
+	"errors"
+)
+
+// linkChanges is only supported on Linux, elsewhere devices are polled.
+func linkChanges(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errors.New("watching links is only supported on linux")
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// invalidParam returns a JSON-RPC Invalid Params error describing the
+// request field at fault and the value given for it.
+func invalidParam(field, value, message string) *jsonrpc.Error {
+	return jsonrpc.InvalidParams(message, &client.ErrorData{Field: field, Value: value})
+}
+
+// deviceError wraps an error returned by the WireGuard client as a JSON-RPC
+// Server Error, flagging whether the operation may succeed if retried.
+func deviceError(message string, err error) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeDevice, fmt.Sprintf("%s: %s", message, err), &client.ErrorData{Retryable: isRetryable(err)})
+}
+
+// storeError wraps an error returned by the Store as a JSON-RPC Server Error.
+func storeError(message string, err error) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeStore, fmt.Sprintf("%s: %s", message, err), &client.ErrorData{Retryable: isRetryable(err)})
+}
+
+// ipamError wraps an error returned by the IPAM as a JSON-RPC Server Error.
+func ipamError(message string, err error) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeIPAM, fmt.Sprintf("%s: %s", message, err), &client.ErrorData{Retryable: isRetryable(err)})
+}
+
+// peerNotFoundError returns the error given when a Peer that must already be
+// on the device is not.
+func peerNotFoundError(publicKey string) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodePeerNotFound, "peer not found", &client.ErrorData{Field: "public_key", Value: publicKey})
+}
+
+// peerExistsMessage is the message of peerExistsError.
+const peerExistsMessage = "peer already exists"
+
+// peerExistsError returns the error given when a Peer that must not already
+// be on the device is.
+func peerExistsError(publicKey string) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeConflict, peerExistsMessage, &client.ErrorData{Field: "public_key", Value: publicKey, ConflictingPublicKey: publicKey})
+}
+
+// isPeerExists returns true if err was returned by peerExistsError, where
+// the allowed ips allocated to the Peer are those it already had, which must
+// not be released.
+func isPeerExists(err error) bool {
+	var rpcErr *jsonrpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.Code == client.ErrCodeConflict && rpcErr.Message == peerExistsMessage
+}
+
+// isRetryable returns true if err is known to be transient.
+func isRetryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// rpcError converts any error returned by a method of Server into a JSON-RPC
+// error, such that a Data payload is always present.
+func rpcError(err error) *jsonrpc.Error {
+	var rpcErr *jsonrpc.Error
+	if errors.As(err, &rpcErr) {
+		if rpcErr.Data == nil {
+			rpcErr.Data = &client.ErrorData{}
+		}
+
+		return rpcErr
+	}
+
+	return jsonrpc.ServerError(client.ErrCodeDevice, err.Error(), &client.ErrorData{Retryable: isRetryable(err)})
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+	"github.com/jamescun/wg-api/store"
+)
+
+// eventQueueSize is the number of Events that may be waiting to be published
+// before further Events are dropped.
+const eventQueueSize = 1024
+
+// EventSink is implemented by destinations of Events, such as message
+// queues.
+type EventSink interface {
+	Publish(ctx context.Context, event *client.Event) error
+}
+
+// changeEventTypes maps the action of a PeerChange to the type of Event.
+var changeEventTypes = map[string]string{
+	client.ChangeAdd:    client.EventPeerAdded,
+	client.ChangeUpdate: client.EventPeerUpdated,
+	client.ChangeRemove: client.EventPeerRemoved,
+}
+
+// auditedMethods are the methods that may change the device, for which an
+// audit Event is published.
+var auditedMethods = map[string]bool{
+	"AddPeer":         true,
+	"RemovePeer":      true,
+	"RemoveAllPeers":  true,
+	"AddPeers":        true,
+	"ApplyBatch":      true,
+	"ImportPeers":     true,
+	"MovePeers":       true,
+	"RenameDevice":    true,
+	"ClonePeer":       true,
+	"UpdatePeer":      true,
+	"RemovePeers":     true,
+	"SetDeviceConfig": true,
+	"SetPeerMetadata": true,
+	"CreateDevice":    true,
+	"DeleteDevice":    true,
+	"SyncPeers":       true,
+}
+
+// audit records a request in the AuditLog of the Store, if supported, and
+// publishes an audit Event, if the request may have changed the device.
+func (s *Server) audit(r *jsonrpc.Request, d time.Duration, err error) {
+	if !auditedMethods[r.Method] {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	audit := &client.Audit{
+		Method:     r.Method,
+		RemoteAddr: r.RemoteAddr(),
+		Duration:   d.String(),
+	}
+
+	if err != nil {
+		audit.Error = err.Error()
+	}
+
+	if auditLog, ok := s.store.(store.AuditLog); ok {
+		err := auditLog.AppendAudit(r.Context(), &store.AuditRecord{
+			Time:       now,
+			Method:     audit.Method,
+			RemoteAddr: audit.RemoteAddr,
+			Duration:   audit.Duration,
+			Error:      audit.Error,
+		})
+		if err != nil {
+			log.Printf("error: audit: could not record request: %s\n", err)
+		}
+	}
+
+	s.publish(&client.Event{
+		Type:   client.EventAudit,
+		Time:   now,
+		Device: s.deviceName,
+		Audit:  audit,
+	})
+}
+
+// publishChanges publishes an Event for every change made to the Peers of
+// the device.
+func (s *Server) publishChanges(changes []*client.PeerChange) {
+	now := time.Now().UTC()
+
+	for _, change := range changes {
+		s.publish(&client.Event{
+			Type:      changeEventTypes[change.Action],
+			Time:      now,
+			Device:    s.deviceName,
+			PublicKey: change.PublicKey,
+			Change:    change,
+		})
+	}
+}
+
+// publish queues an Event to be published to every EventSink, and writes it
+// to every subscriber of the event stream, without blocking. If the queue is
+// full, the Event is dropped.
+func (s *Server) publish(event *client.Event) {
+	if s.subscribers.enabled {
+		s.subscribers.publish(event)
+	}
+
+	if len(s.sinks) == 0 {
+		return
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.stats.droppedEvents.Add(1)
+		log.Printf("warn: events: queue full, dropped %s event\n", event.Type)
+	}
+}
+
+// runEvents publishes queued Events to every EventSink until ctx is
+// cancelled.
+func (s *Server) runEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event := <-s.events:
+			for _, sink := range s.sinks {
+				if err := sink.Publish(ctx, event); err != nil {
+					log.Printf("error: events: could not publish %s event: %s\n", event.Type, err)
+				}
+			}
+		}
+	}
+}
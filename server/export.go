@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// CSV columns only written when exporting Peers, they are ignored when
+// importing.
+const (
+	columnHasPresharedKey = "has_preshared_key"
+	columnLastHandshake   = "last_handshake"
+	columnReceiveBytes    = "receive_bytes"
+	columnTransmitBytes   = "transmit_bytes"
+	columnProtocolVersion = "protocol_version"
+	columnExpiresAt       = "expires_at"
+)
+
+var exportColumns = []string{
+	columnPublicKey,
+	columnHasPresharedKey,
+	columnEndpoint,
+	columnPersistentKeepAlive,
+	columnAllowedIPs,
+	columnLastHandshake,
+	columnReceiveBytes,
+	columnTransmitBytes,
+	columnProtocolVersion,
+	columnTemplate,
+	columnExpiresAt,
+}
+
+func isExportColumn(column string) bool {
+	for _, c := range exportColumns {
+		if c == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportPeers writes every Peer of the device to w in the given format. Each
+// Peer is written as it is encoded, such that w may be streamed.
+func (s *Server) exportPeers(ctx context.Context, w io.Writer, format string) error {
+	ps, err := s.streamPeers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if format == client.FormatJSONL {
+		enc := json.NewEncoder(w)
+
+		return ps.each(func(peer *client.Peer) error {
+			return enc.Encode(peer)
+		})
+	}
+
+	// every peer must be known before the columns of their metadata can be
+	// written.
+	peers := make([]*client.Peer, 0, len(ps.dev.Peers))
+	if err := ps.each(func(peer *client.Peer) error {
+		peers = append(peers, peer)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// the metadata of every peer is written as additional columns,
+	// excluding any that would collide with the standard columns.
+	keys := make(map[string]bool)
+	for _, peer := range peers {
+		for key := range peer.Metadata {
+			if !isExportColumn(key) {
+				keys[key] = true
+			}
+		}
+	}
+
+	metadataColumns := make([]string, 0, len(keys))
+	for key := range keys {
+		metadataColumns = append(metadataColumns, key)
+	}
+	sort.Strings(metadataColumns)
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(append(append([]string(nil), exportColumns...), metadataColumns...)); err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		var lastHandshake string
+		if !peer.LastHandshake.IsZero() {
+			lastHandshake = peer.LastHandshake.UTC().Format(time.RFC3339)
+		}
+
+		var expiresAt string
+		if peer.ExpiresAt != nil {
+			expiresAt = peer.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+
+		record := []string{
+			peer.PublicKey,
+			strconv.FormatBool(peer.HasPresharedKey),
+			peer.Endpoint,
+			peer.PersistentKeepAlive,
+			strings.Join(peer.AllowedIPs, " "),
+			lastHandshake,
+			strconv.FormatInt(peer.ReceiveBytes, 10),
+			strconv.FormatInt(peer.TransmitBytes, 10),
+			strconv.Itoa(peer.ProtocolVersion),
+			peer.Template,
+			expiresAt,
+		}
+
+		for _, key := range metadataColumns {
+			record = append(record, peer.Metadata[key])
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func validateExportPeersRequest(req *client.ExportPeersRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Format != client.FormatCSV && req.Format != client.FormatJSONL {
+		return invalidParam("format", req.Format, "format must be one of csv or jsonl")
+	}
+
+	return nil
+}
+
+// ExportPeers returns every Peer of the device, including their metadata and
+// usage stats, in CSV or JSON Lines format.
+func (s *Server) ExportPeers(ctx context.Context, req *client.ExportPeersRequest) (*client.ExportPeersResponse, error) {
+	if err := validateExportPeersRequest(req); err != nil {
+		return nil, err
+	}
+
+	var buf strings.Builder
+
+	if err := s.exportPeers(ctx, &buf, req.Format); err != nil {
+		return nil, err
+	}
+
+	return &client.ExportPeersResponse{
+		Format: req.Format,
+		Data:   buf.String(),
+	}, nil
+}
+
+// exportWriter is the response of ExportHandler, which records whether any
+// of the export has been written to it, after which its status can no longer
+// be changed.
+type exportWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// ExportHandler streams every Peer of the device in the format given by the
+// format query parameter, either csv (default) or jsonl, rather than
+// buffering them into a single JSON-RPC response. An error before any of the
+// export is written fails the response, otherwise it is given in the
+// Wg-Api-Error trailer.
+func ExportHandler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.enabled("ExportPeers") {
+			http.NotFound(w, r)
+			return
+		} else if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = client.FormatCSV
+		}
+
+		var contentType string
+
+		switch format {
+		case client.FormatCSV:
+			contentType = "text/csv"
+		case client.FormatJSONL:
+			contentType = "application/x-ndjson"
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "peers."+format))
+		w.Header().Set("Trailer", client.StreamErrorTrailer)
+
+		ew := &exportWriter{ResponseWriter: w}
+		bw := bufio.NewWriter(ew)
+
+		err := s.exportPeers(r.Context(), bw, format)
+		if err == nil {
+			err = bw.Flush()
+		}
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("error: export: %s\n", err)
+
+		if !ew.wrote {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Trailer")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// once the response has begun its status can no longer be changed,
+		// so the error is given to the client in a trailer.
+		w.Header().Set(client.StreamErrorTrailer, err.Error())
+	})
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// generation tracks a monotonically increasing counter that is incremented
+// every time the configuration of a device is observed to have changed,
+// whether through the API or externally, such as with `wg set`.
+type generation struct {
+	mu  sync.Mutex
+	n   uint64
+	sum [sha256.Size]byte
+
+	// endpoints are those configured through the API or externally, by
+	// public key, which are part of the configuration of the device, unlike
+	// those Peers roam to.
+	endpoints map[wgtypes.Key]string
+
+	// seen are the endpoint and bytes received of each Peer when the device
+	// was last observed.
+	seen map[wgtypes.Key]seenEndpoint
+}
+
+// seenEndpoint is the endpoint of a Peer when it was observed, and the bytes
+// received from it by then.
+type seenEndpoint struct {
+	endpoint     string
+	receiveBytes int64
+}
+
+// observe compares the configuration of dev to the last time it was seen,
+// incrementing the generation if it has changed, and returns the current
+// generation.
+//
+// The generation is seeded with the current time in milliseconds, rather
+// than zero, so that it continues to increase across restarts of WG-API.
+func (g *generation) observe(dev *wgtypes.Device) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.observeEndpoints(dev.Peers)
+
+	sum := deviceFingerprint(dev, g.endpoints)
+
+	if g.n == 0 {
+		g.n = uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		g.sum = sum
+	} else if sum != g.sum {
+		g.n++
+		g.sum = sum
+	}
+
+	return g.n
+}
+
+// observeEndpoints records the endpoint of each of peers that was changed
+// externally since they were last observed, such as with `wg set`. A Peer
+// only roams to another endpoint once a packet is received from it, so an
+// endpoint that changed without any bytes received was configured. The
+// caller must hold g.mu.
+func (g *generation) observeEndpoints(peers []wgtypes.Peer) {
+	if g.seen == nil {
+		g.seen = make(map[wgtypes.Key]seenEndpoint, len(peers))
+	}
+
+	for _, peer := range peers {
+		var endpoint string
+		if peer.Endpoint != nil {
+			endpoint = peer.Endpoint.String()
+		}
+
+		if prev, ok := g.seen[peer.PublicKey]; ok && prev.endpoint != endpoint && prev.receiveBytes == peer.ReceiveBytes {
+			if g.endpoints == nil {
+				g.endpoints = make(map[wgtypes.Key]string)
+			}
+
+			g.endpoints[peer.PublicKey] = endpoint
+		}
+
+		g.seen[peer.PublicKey] = seenEndpoint{endpoint: endpoint, receiveBytes: peer.ReceiveBytes}
+	}
+
+	// Peers removed from the device are forgotten.
+	if len(g.seen) > len(peers) {
+		present := indexPeers(peers)
+
+		for key := range g.seen {
+			if _, ok := present[key]; !ok {
+				delete(g.seen, key)
+				delete(g.endpoints, key)
+			}
+		}
+	}
+}
+
+// configured records the endpoints of the Peers of cfg once the device has
+// been configured with it, such that changing them through the API changes
+// the generation.
+func (g *generation) configured(cfg wgtypes.Config) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if cfg.ReplacePeers {
+		g.endpoints = nil
+	}
+
+	for _, peer := range cfg.Peers {
+		switch {
+		case peer.Remove:
+			delete(g.endpoints, peer.PublicKey)
+
+		case peer.Endpoint != nil:
+			if g.endpoints == nil {
+				g.endpoints = make(map[wgtypes.Key]string)
+			}
+
+			g.endpoints[peer.PublicKey] = peer.Endpoint.String()
+		}
+	}
+}
+
+// deviceFingerprint hashes the configuration of a device, including the
+// configured endpoints of its Peers. The endpoints Peers roam to, handshakes
+// and transfer counters are excluded as they change constantly during normal
+// operation.
+func deviceFingerprint(dev *wgtypes.Device, endpoints map[wgtypes.Key]string) [sha256.Size]byte {
+	h := sha256.New()
+
+	var buf [8]byte
+	writeInt := func(n int64) {
+		binary.BigEndian.PutUint64(buf[:], uint64(n))
+		h.Write(buf[:])
+	}
+
+	h.Write(dev.PublicKey[:])
+	writeInt(int64(dev.ListenPort))
+	writeInt(int64(dev.FirewallMark))
+
+	peers := make([]wgtypes.Peer, len(dev.Peers))
+	copy(peers, dev.Peers)
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i].PublicKey[:], peers[j].PublicKey[:]) < 0
+	})
+
+	for _, peer := range peers {
+		h.Write(peer.PublicKey[:])
+		h.Write(peer.PresharedKey[:])
+		writeInt(int64(peer.PersistentKeepaliveInterval))
+		writeInt(int64(len(peer.AllowedIPs)))
+
+		for _, allowedIP := range peer.AllowedIPs {
+			h.Write(allowedIP.IP)
+			h.Write(allowedIP.Mask)
+		}
+
+		endpoint := endpoints[peer.PublicKey]
+		writeInt(int64(len(endpoint)))
+		h.Write([]byte(endpoint))
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+// syncGeneration observes the device after it has been configured, returning
+// the new generation.
+func (s *Server) syncGeneration() (uint64, error) {
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return 0, deviceError("could not get WireGuard device", err)
+	}
+
+	return s.gen.observe(dev), nil
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ha is the state of a Server running highly available, where only the
+// leader elected between instances sharing a Store manages the device.
+type ha struct {
+	elector store.Elector
+	id      string
+	ttl     time.Duration
+
+	mu     sync.RWMutex
+	leader string
+}
+
+// WithHA configures the Server to run highly available with other instances
+// sharing the same Store, identified by id. Only the elected leader makes
+// changes to its device, standbys reject them. Leadership is held for ttl,
+// and renewed every third of it.
+func WithHA(elector store.Elector, id string, ttl time.Duration) Option {
+	return func(s *Server) {
+		s.ha = &ha{elector: elector, id: id, ttl: ttl}
+	}
+}
+
+// isLeader returns true if the Server may make changes to its device, which
+// is always the case if it is not highly available.
+func (s *Server) isLeader() bool {
+	if s.ha == nil {
+		return true
+	}
+
+	s.ha.mu.RLock()
+	defer s.ha.mu.RUnlock()
+
+	return s.ha.leader == s.ha.id
+}
+
+func (h *ha) setLeader(leader string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.leader = leader
+}
+
+func (h *ha) currentLeader() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.leader
+}
+
+// notLeaderError returns the error given when a change is requested of a
+// standby.
+func (s *Server) notLeaderError() *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeNotLeader, "server is standby, changes must be made to the leader", &client.ErrorData{
+		Leader:    s.ha.currentLeader(),
+		Retryable: true,
+	})
+}
+
+// runHA campaigns for leadership until ctx is cancelled. On becoming leader,
+// the Peers last snapshotted by the previous leader are restored onto the
+// device, and while leader, the Peers of the device are snapshotted into the
+// Store.
+func (s *Server) runHA(ctx context.Context) {
+	t := time.NewTicker(s.ha.ttl / 3)
+	defer t.Stop()
+
+	var renewed time.Time
+
+	for {
+		leader, err := s.ha.elector.Campaign(ctx, s.ha.id, s.ha.ttl)
+
+		switch {
+		case err != nil:
+			log.Printf("error: ha: could not campaign for leadership: %s\n", err)
+
+			// leadership is given up once it can no longer be renewed, as
+			// another instance may take over once it expires.
+			if s.isLeader() && time.Since(renewed) > s.ha.ttl*2/3 {
+				log.Printf("warn: ha: stepping down as leader\n")
+				s.ha.setLeader("")
+			}
+
+		case leader == s.ha.id:
+			renewed = time.Now()
+
+			if !s.isLeader() {
+				log.Printf("info: ha: elected leader, restoring peers\n")
+
+				if err := s.takeover(ctx); err != nil {
+					log.Printf("error: ha: could not restore peers: %s\n", err)
+					s.ha.elector.Resign(ctx, s.ha.id)
+					break
+				}
+
+				s.ha.setLeader(leader)
+			}
+
+			if err := s.snapshot(ctx); err != nil {
+				log.Printf("error: ha: could not snapshot peers: %s\n", err)
+			}
+
+		default:
+			if s.isLeader() {
+				log.Printf("warn: ha: leadership lost to %s\n", leader)
+			}
+
+			s.ha.setLeader(leader)
+		}
+
+		select {
+		case <-ctx.Done():
+			if s.isLeader() {
+				s.ha.elector.Resign(context.Background(), s.ha.id)
+			}
+
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// takeover restores the Peers snapshotted into the Store onto the device.
+// If no Peers have been snapshotted, such as when first started, the device
+// is left unchanged.
+func (s *Server) takeover(ctx context.Context) error {
+	stored, err := s.store.ListPeers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var snapshot []wgtypes.Peer
+	byKey := make(map[wgtypes.Key]*store.Peer)
+
+	for _, sp := range stored {
+		if sp.Config == nil {
+			continue
+		}
+
+		peer, err := storedPeer(sp)
+		if err != nil {
+			log.Printf("warn: ha: not restoring peer %s: %s\n", sp.PublicKey, err)
+			continue
+		}
+
+		snapshot = append(snapshot, peer)
+		byKey[peer.PublicKey] = sp
+	}
+
+	if len(snapshot) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.restorePeers(snapshot); err != nil {
+		return err
+	}
+
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return err
+	}
+
+	// the counters of the device do not include traffic through the
+	// previous leader, which is kept as an offset to enforce quotas.
+	for _, peer := range dev.Peers {
+		sp, ok := byKey[peer.PublicKey]
+		if !ok {
+			continue
+		}
+
+		offset := sp.Config.TransferBytes - (peer.ReceiveBytes + peer.TransmitBytes)
+		if offset < 0 {
+			offset = 0
+		}
+
+		err := s.updateStored(ctx, sp.PublicKey, func(p *store.Peer) {
+			p.TransferOffset = offset
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.syncGeneration()
+
+	return err
+}
+
+// storedPeer converts the snapshotted configuration of a Peer.
+func storedPeer(sp *store.Peer) (wgtypes.Peer, error) {
+	publicKey, err := wgtypes.ParseKey(sp.PublicKey)
+	if err != nil {
+		return wgtypes.Peer{}, err
+	}
+
+	peer := wgtypes.Peer{
+		PublicKey:                   publicKey,
+		PersistentKeepaliveInterval: sp.Config.PersistentKeepAlive,
+	}
+
+	if sp.Config.PresharedKey != "" {
+		peer.PresharedKey, err = wgtypes.ParseKey(sp.Config.PresharedKey)
+		if err != nil {
+			return wgtypes.Peer{}, fmt.Errorf("invalid preshared key: %w", err)
+		}
+	}
+
+	if sp.Config.Endpoint != "" {
+		peer.Endpoint, err = net.ResolveUDPAddr("udp", sp.Config.Endpoint)
+		if err != nil {
+			return wgtypes.Peer{}, fmt.Errorf("invalid endpoint: %w", err)
+		}
+	}
+
+	for _, allowedIP := range sp.Config.AllowedIPs {
+		_, aip, err := net.ParseCIDR(allowedIP)
+		if err != nil {
+			return wgtypes.Peer{}, fmt.Errorf("invalid allowed ip: %w", err)
+		}
+
+		peer.AllowedIPs = append(peer.AllowedIPs, *aip)
+	}
+
+	return peer, nil
+}
+
+// snapshotConfig returns the configuration of peer, as snapshotted.
+func snapshotConfig(peer wgtypes.Peer) *store.PeerConfig {
+	config := &store.PeerConfig{
+		PersistentKeepAlive: peer.PersistentKeepaliveInterval,
+	}
+
+	if peer.PresharedKey != (wgtypes.Key{}) {
+		config.PresharedKey = peer.PresharedKey.String()
+	}
+
+	if peer.Endpoint != nil {
+		config.Endpoint = peer.Endpoint.String()
+	}
+
+	for _, allowedIP := range peer.AllowedIPs {
+		config.AllowedIPs = append(config.AllowedIPs, allowedIP.String())
+	}
+
+	return config
+}
+
+// snapshot keeps the configuration of every Peer of the device in the
+// Store, and forgets snapshotted Peers that have since been removed.
+func (s *Server) snapshot(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return err
+	}
+
+	onDevice := make(map[string]bool, len(dev.Peers))
+
+	for _, peer := range dev.Peers {
+		publicKey := peer.PublicKey.String()
+		onDevice[publicKey] = true
+
+		config := snapshotConfig(peer)
+
+		err := s.updateStored(ctx, publicKey, func(p *store.Peer) {
+			config.TransferBytes = peer.ReceiveBytes + peer.TransmitBytes + p.TransferOffset
+			p.Config = config
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	stored, err := s.store.ListPeers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, sp := range stored {
+		if sp.Config != nil && !onDevice[sp.PublicKey] {
+			if err := s.store.DeletePeer(ctx, sp.PublicKey); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jamescun/wg-api/store"
+)
+
+// readyTimeout is how long checking readiness may take.
+const readyTimeout = 5 * time.Second
+
+// HealthHandler serves /healthz, which succeeds while the server is running,
+// and /readyz, which only succeeds while the WireGuard device and the store
+// can be read. Neither requires authentication, such that they may be used
+// by container health checks and probes.
+func HealthHandler(s *Server) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		if err := s.ready(ctx); err != nil {
+			log.Printf("warn: health: not ready: %s\n", err)
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok\n"))
+	})
+
+	return mux
+}
+
+// ready returns an error if the WireGuard device or the store cannot be read.
+func (s *Server) ready(ctx context.Context) error {
+	if _, err := s.device(ctx); err != nil {
+		return err
+	}
+
+	if _, err := s.store.GetPeer(ctx, ""); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// CSV columns that map to fields of a Peer, any other column is metadata.
+const (
+	columnPublicKey           = "public_key"
+	columnPresharedKey        = "preshared_key"
+	columnEndpoint            = "endpoint"
+	columnPersistentKeepAlive = "persistent_keep_alive"
+	columnAllowedIPs          = "allowed_ips"
+	columnTemplate            = "template"
+)
+
+// importRow is a Peer read from an imported file, or the error encountered
+// reading it.
+type importRow struct {
+	line int
+	req  *client.AddPeerRequest
+	err  error
+}
+
+// readPeersCSV reads Peers from CSV with a header row.
+func readPeersCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	hasPublicKey := false
+	for i, column := range header {
+		header[i] = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(column)), " ", "_")
+		hasPublicKey = hasPublicKey || header[i] == columnPublicKey
+	}
+
+	if !hasPublicKey {
+		return nil, fmt.Errorf("header does not contain %s column", columnPublicKey)
+	}
+
+	var rows []importRow
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+			rows = append(rows, importRow{line: parseErr.Line, err: invalidParam("data", "", err.Error())})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		req := &client.AddPeerRequest{}
+
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+
+			switch header[i] {
+			case columnPublicKey:
+				req.PublicKey = value
+			case columnPresharedKey:
+				req.PresharedKey = value
+			case columnEndpoint:
+				req.Endpoint = value
+			case columnPersistentKeepAlive:
+				req.PersistentKeepAlive = value
+			case columnAllowedIPs:
+				req.AllowedIPs = strings.FieldsFunc(value, func(r rune) bool {
+					return r == ',' || r == ';' || r == ' '
+				})
+			case columnTemplate:
+				req.Template = value
+			default:
+				if value != "" && !isExportColumn(header[i]) {
+					if req.Metadata == nil {
+						req.Metadata = make(map[string]string)
+					}
+
+					req.Metadata[header[i]] = value
+				}
+			}
+		}
+
+		rows = append(rows, importRow{line: line, req: req})
+	}
+
+	return rows, nil
+}
+
+// readPeersJSONL reads Peers from JSON Lines, blank lines are ignored.
+func readPeersJSONL(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		req := &client.AddPeerRequest{}
+		if err := json.Unmarshal([]byte(text), req); err != nil {
+			rows = append(rows, importRow{line: line, err: invalidParam("data", "", fmt.Sprintf("line %d: %s", line, err))})
+			continue
+		}
+
+		rows = append(rows, importRow{line: line, req: req})
+	}
+
+	return rows, scanner.Err()
+}
+
+func validateImportPeersRequest(req *client.ImportPeersRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Format != client.FormatCSV && req.Format != client.FormatJSONL {
+		return invalidParam("format", req.Format, "format must be one of csv or jsonl")
+	}
+
+	return validateOnError(req.OnError)
+}
+
+// ImportPeers adds Peers described in CSV or JSON Lines format, such as
+// those exported from a spreadsheet or another VPN manager.
+func (s *Server) ImportPeers(ctx context.Context, req *client.ImportPeersRequest) (*client.ImportPeersResponse, error) {
+	if err := validateImportPeersRequest(req); err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	var err error
+
+	if req.Format == client.FormatCSV {
+		rows, err = readPeersCSV(strings.NewReader(req.Data))
+	} else {
+		rows, err = readPeersJSONL(strings.NewReader(req.Data))
+	}
+	if err != nil {
+		return nil, invalidParam("data", "", "could not read peers: "+err.Error())
+	}
+
+	if err := s.checkBatchSize("data", len(rows)); err != nil {
+		return nil, err
+	}
+
+	items := make([]batchItem, len(rows))
+
+	for i, row := range rows {
+		if row.err != nil {
+			items[i].err = row.err
+			continue
+		}
+
+		items[i] = s.addPeerItem(ctx, row.req)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.applyBatch(ctx, items, req.OnError, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := 0
+	for i, result := range res.Results {
+		result.Line = rows[i].line
+
+		if result.OK {
+			imported++
+		}
+	}
+
+	return &client.ImportPeersResponse{
+		OK:         res.OK,
+		Total:      len(rows),
+		Imported:   imported,
+		Failed:     len(rows) - imported,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		DryRun:     res.DryRun,
+		Generation: res.Generation,
+	}, nil
+}
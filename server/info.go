@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// BuildInfo describes a build of WG-API. If commit or buildDate are empty,
+// they are taken from the version control information embedded by go build.
+func BuildInfo(version, commit, buildDate string) *client.ServerInfo {
+	info := &client.ServerInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// GetServerInfo returns the version and build of the server, the
+// implementation of WireGuard of its device and its enabled features.
+func (s *Server) GetServerInfo(ctx context.Context, req *client.GetServerInfoRequest) (*client.GetServerInfoResponse, error) {
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	info := *s.info
+	info.Backend = dev.Type.String()
+	info.Features = s.features()
+
+	return &client.GetServerInfoResponse{Server: &info}, nil
+}
+
+// GetCapabilities returns the methods supported by the server, its enabled
+// features, limits and version of the API.
+func (s *Server) GetCapabilities(ctx context.Context, req *client.GetCapabilitiesRequest) (*client.GetCapabilitiesResponse, error) {
+	methods := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		methods = append(methods, name)
+	}
+
+	sort.Strings(methods)
+
+	limits := s.limits
+
+	return &client.GetCapabilitiesResponse{
+		Capabilities: &client.Capabilities{
+			SchemaVersion: client.SchemaVersion,
+			Methods:       methods,
+			Features:      s.features(),
+			Limits:        &limits,
+		},
+	}, nil
+}
+
+// features returns the optional features enabled on the server.
+func (s *Server) features() []string {
+	var features []string
+
+	if s.dryRun {
+		features = append(features, client.FeatureDryRun)
+	}
+
+	if len(s.templates) > 0 {
+		features = append(features, client.FeatureTemplates)
+	}
+
+	if s.policy != nil {
+		features = append(features, client.FeaturePolicy)
+	}
+
+	if s.ipam != nil {
+		features = append(features, client.FeatureIPAM)
+	}
+
+	if s.publishing() {
+		features = append(features, client.FeatureEvents)
+	}
+
+	if s.usageInterval > 0 {
+		features = append(features, client.FeatureUsage)
+	}
+
+	if s.ha != nil {
+		features = append(features, client.FeatureHA)
+	}
+
+	if s.rates.interval > 0 {
+		features = append(features, client.FeatureRates)
+	}
+
+	return features
+}
+
+// Ping returns the time of the server, such that clients may cheaply verify
+// they can reach and authenticate with it. The device is not read.
+func (s *Server) Ping(ctx context.Context, req *client.PingRequest) (*client.PingResponse, error) {
+	return &client.PingResponse{Time: time.Now().UTC(), Counter: s.pings.Add(1)}, nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// IPAM is implemented by providers of IP address management, which assign
+// allowed ips to Peers when requested.
+type IPAM interface {
+	// Allocate assigns allowed ips to a Peer.
+	Allocate(ctx context.Context, publicKey string) ([]string, error)
+
+	// Release frees any allowed ips assigned to a Peer, it is not an error if
+	// none are assigned.
+	Release(ctx context.Context, publicKey string) error
+}
+
+// LeaseLister is implemented by IPAMs that can list the allowed ips assigned
+// to a Peer.
+type LeaseLister interface {
+	// Leased returns the allowed ips assigned to a Peer.
+	Leased(ctx context.Context, publicKey string) ([]string, error)
+}
+
+// ExcludingIPAM is implemented by IPAMs that can avoid allocating addresses
+// already routed to other Peers of the device.
+type ExcludingIPAM interface {
+	// AllocateExcluding assigns allowed ips to a Peer, none of which are
+	// any of exclude.
+	AllocateExcluding(ctx context.Context, publicKey string, exclude []net.IPNet) ([]string, error)
+}
+
+// allocateIPs assigns allowed ips to the Peer being added if requested,
+// adding them to the configuration of peer.
+func (s *Server) allocateIPs(ctx context.Context, req *client.AddPeerRequest, peer *wgtypes.PeerConfig) ([]string, error) {
+	if !req.AllocateAllowedIPs {
+		return nil, nil
+	} else if s.ipam == nil {
+		return nil, invalidParam("allocate_allowed_ips", "true", "ip address management is not configured")
+	}
+
+	var allocated []string
+
+	if excluding, ok := s.ipam.(ExcludingIPAM); ok {
+		// addresses given to other Peers without the IPAM, such as those
+		// added before it was configured, must not be allocated again, as
+		// the device would take them from those Peers.
+		inUse, err := s.allowedIPsInUse(ctx, req.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		allocated, err = excluding.AllocateExcluding(ctx, req.PublicKey, inUse)
+		if err != nil {
+			return nil, ipamError("could not allocate allowed ips", err)
+		}
+	} else {
+		var err error
+
+		allocated, err = s.ipam.Allocate(ctx, req.PublicKey)
+		if err != nil {
+			return nil, ipamError("could not allocate allowed ips", err)
+		}
+	}
+
+	for _, allowedIP := range allocated {
+		_, aip, err := net.ParseCIDR(allowedIP)
+		if err != nil {
+			s.releaseIPs(ctx, req.PublicKey)
+			return nil, ipamError("could not allocate allowed ips", fmt.Errorf("range %q is not valid: %w", allowedIP, err))
+		}
+
+		peer.AllowedIPs = append(peer.AllowedIPs, *aip)
+	}
+
+	return allocated, nil
+}
+
+// allowedIPsInUse returns the allowed ips of every Peer of the device other
+// than that with publicKey.
+func (s *Server) allowedIPsInUse(ctx context.Context, publicKey string) ([]net.IPNet, error) {
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	var inUse []net.IPNet
+	for _, peer := range dev.Peers {
+		if peer.PublicKey.String() != publicKey {
+			inUse = append(inUse, peer.AllowedIPs...)
+		}
+	}
+
+	return inUse, nil
+}
+
+// releaseIPs frees any allowed ips assigned to a Peer. Errors are logged as
+// there is nothing further the caller can do.
+func (s *Server) releaseIPs(ctx context.Context, publicKey string) {
+	if s.ipam == nil {
+		return
+	}
+
+	if err := s.ipam.Release(ctx, publicKey); err != nil {
+		log.Printf("error: ipam: could not release allowed ips of %s: %s\n", publicKey, err)
+	}
+}
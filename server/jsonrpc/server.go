@@ -3,9 +3,13 @@ package jsonrpc
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Handler responds to JSON-RPC requests.
@@ -28,8 +32,13 @@ type Request struct {
 	Params  json.RawMessage `json:"params,omitempty"`
 	ID      json.RawMessage `json:"id"`
 
-	ctx   context.Context
-	raddr string
+	// TimeoutMS, if non-zero, is the number of milliseconds the request may
+	// take, after which its context is cancelled.
+	TimeoutMS int64 `json:"timeout_ms,omitempty"`
+
+	ctx    context.Context
+	raddr  string
+	header http.Header
 }
 
 // Context returns the execution context of the request, or the background
@@ -47,6 +56,16 @@ func (r *Request) RemoteAddr() string {
 	return r.raddr
 }
 
+// Header returns the headers of the HTTP request the request was made with,
+// which are empty if it was not made over HTTP.
+func (r *Request) Header() http.Header {
+	if r.header == nil {
+		return http.Header{}
+	}
+
+	return r.header
+}
+
 // ResponseWriter marshals the JSON-RPC response to the client.
 type ResponseWriter interface {
 	// Write marshals anything given to it as the Result of the JSON-RPC
@@ -78,6 +97,15 @@ func (r *response) Write(res interface{}) error {
 	return nil
 }
 
+// errorData is the Data of errors returned before a request reaches its
+// Handler, encoded as the ErrorData of every other error of WG-API, which is
+// declared by the client package that imports this one.
+type errorData struct {
+	Field     string `json:"field,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
 // ContentType is the MIME Type expected of clients and returned by the server.
 const ContentType = "application/json"
 
@@ -86,32 +114,82 @@ const ContentType = "application/json"
 func HTTP(hf Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, InvalidRequest("method not allowed", &errorData{Value: r.Method}))
 			return
 		}
 
 		if hdr := r.Header.Get("Content-Type"); !strings.HasPrefix(hdr, ContentType) {
-			http.Error(w, fmt.Sprintf("unknown content type %q", hdr), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, InvalidRequest(fmt.Sprintf("unknown content type %q", hdr), &errorData{Value: hdr}))
 			return
 		}
 
 		req := new(Request)
 		err := json.NewDecoder(r.Body).Decode(req)
 		if err != nil {
-			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, decodeError(err))
 			return
 		}
+		req.ctx = r.Context()
 		req.raddr = r.RemoteAddr
+		req.header = r.Header
 
 		res := &response{Version: "2.0", ID: req.ID}
 
-		hf.ServeJSONRPC(res, req)
+		if req.TimeoutMS < 0 {
+			res.Write(InvalidRequest("timeout_ms must not be negative", &errorData{Field: "timeout_ms", Value: strconv.FormatInt(req.TimeoutMS, 10)}))
+		} else {
+			if req.TimeoutMS > 0 {
+				var cancel context.CancelFunc
+				req.ctx, cancel = context.WithTimeout(req.ctx, time.Duration(req.TimeoutMS)*time.Millisecond)
+				defer cancel()
+			}
+
+			hf.ServeJSONRPC(res, req)
+		}
 
 		w.Header().Set("Content-Type", ContentType)
 		json.NewEncoder(w).Encode(res)
 	})
 }
 
+// writeError responds to a request that could not be read with err, whose
+// id is null, and the HTTP status code.
+func writeError(w http.ResponseWriter, code int, err *Error) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&response{Version: "2.0", Error: err})
+}
+
+// decodeError returns the error of a request that could not be decoded, a
+// Parse Error if it is not valid JSON, otherwise an Invalid Request naming
+// the member at fault.
+func decodeError(err error) *Error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return ParseError("invalid request: "+err.Error(), &errorData{})
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return InvalidRequest("invalid request: "+err.Error(), &errorData{Field: typeErr.Field})
+	}
+
+	return InvalidRequest("invalid request: "+err.Error(), &errorData{})
+}
+
+// Call calls method of hf with params on behalf of the HTTP request r, such
+// as one of another protocol served by the same Handler, returning its result
+// or error.
+func Call(hf Handler, r *http.Request, method string, params json.RawMessage) (interface{}, *Error) {
+	req := &Request{Version: "2.0", Method: method, Params: params, ctx: r.Context(), raddr: r.RemoteAddr, header: r.Header}
+	res := &response{Version: "2.0"}
+
+	hf.ServeJSONRPC(res, req)
+
+	return res.Result, res.Error
+}
+
 // Error implements a top-level JSON-RPC error.
 type Error struct {
 	Code    int    `json:"code"`
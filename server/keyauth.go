@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// maxSignedBodySize is the largest body of a signed request, which is read
+// in full to verify its signature before the request is authenticated.
+const maxSignedBodySize = 32 << 20
+
+// AuthKeys only allows a request to continue if it is signed by the private
+// key of one of the WireGuard public keys registered as admin identities,
+// otherwise a HTTP 403 Forbidden is returned and the request terminated.
+// Signatures are verified with the private key of the device, so operators
+// authenticate with the key material they already manage.
+func AuthKeys(s *Server, publicKeys ...string) (func(http.Handler) http.Handler, error) {
+	keys := make(map[wgtypes.Key]bool, len(publicKeys))
+
+	for _, publicKey := range publicKeys {
+		key, err := wgtypes.ParseKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[key] = true
+	}
+
+	seen := &nonces{seen: make(map[nonce]time.Time)}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), client.AuthSchemeWireGuard+" ") {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+			if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+				http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			privateKey, err := s.privateKey()
+			if err != nil {
+				log.Printf("error: auth: could not get WireGuard device: %s\n", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			signed, err := client.VerifyRequest(r, body, privateKey)
+			if err != nil || !keys[signed.PublicKey] || !seen.add(signed) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// nonce identifies a signed request, which may only be made once.
+type nonce struct {
+	publicKey wgtypes.Key
+	nonce     string
+}
+
+// nonces are those of the signed requests accepted, each kept until its
+// signature expires, such that a request cannot be replayed while it would
+// still be accepted.
+type nonces struct {
+	mu     sync.Mutex
+	seen   map[nonce]time.Time
+	pruned time.Time
+}
+
+// add records the nonce of req, returning false if it has already been seen.
+func (n *nonces) add(req *client.SignedRequest) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// expired nonces are only pruned occasionally, a request replayed
+	// with one is refused as its signature has expired.
+	if now := time.Now(); now.Sub(n.pruned) > client.MaxSignatureAge {
+		for key, expires := range n.seen {
+			if now.After(expires) {
+				delete(n.seen, key)
+			}
+		}
+
+		n.pruned = now
+	}
+
+	key := nonce{publicKey: req.PublicKey, nonce: req.Nonce}
+	if _, ok := n.seen[key]; ok {
+		return false
+	}
+
+	n.seen[key] = req.SignedAt.Add(client.MaxSignatureAge)
+
+	return true
+}
+
+// AuthAny only allows a request to continue if it is allowed by any of auths,
+// such as when clients may authenticate with either a token or a key,
+// otherwise a HTTP 403 Forbidden is returned and the request terminated.
+func AuthAny(auths ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, auth := range auths {
+				var allowed *http.Request
+
+				auth(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					allowed = r
+				})).ServeHTTP(discardResponse{}, r)
+
+				if allowed != nil {
+					next.ServeHTTP(w, allowed)
+					return
+				}
+			}
+
+			http.Error(w, "forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+// discardResponse is written the response of a rejected authentication, which
+// is replaced by the response of AuthAny.
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponse) WriteHeader(int)             {}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// GenerateKeyPair returns a new private key and its public key, such that
+// clients provisioning Peers need not generate keys themselves. The keys are
+// not retained.
+func (s *Server) GenerateKeyPair(ctx context.Context, req *client.GenerateKeyPairRequest) (*client.GenerateKeyPairResponse, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.GenerateKeyPairResponse{PrivateKey: key.String(), PublicKey: key.PublicKey().String()}, nil
+}
+
+// GeneratePresharedKey returns a new preshared key, which is not retained.
+func (s *Server) GeneratePresharedKey(ctx context.Context, req *client.GeneratePresharedKeyRequest) (*client.GeneratePresharedKeyResponse, error) {
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.GeneratePresharedKeyResponse{PresharedKey: key.String()}, nil
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultLatencyWindow is the period the latency of Peers is averaged over,
+// unless configured otherwise.
+const defaultLatencyWindow = 5 * time.Minute
+
+// latencySample is the reply to an ICMP echo request sent to a Peer.
+type latencySample struct {
+	at      time.Time
+	rtt     time.Duration
+	replied bool
+}
+
+// peerProbes are the samples of a Peer within the latency window, oldest
+// first, and the address they were sent to.
+type peerProbes struct {
+	addr    net.IP
+	samples []latencySample
+}
+
+// latency measures the round trip time of every connected Peer through the
+// tunnel, by ICMP echo requests to the first of its allowed ips that is a
+// single address, such that a degraded path can be seen where the age of
+// its handshake would not reveal it.
+type latency struct {
+	mu       sync.Mutex
+	interval time.Duration
+	window   time.Duration
+
+	peers map[wgtypes.Key]*peerProbes
+}
+
+// WithLatency configures how often every connected Peer is sent an ICMP echo
+// request through the tunnel, and the window its round trip time and loss
+// are averaged over, given by ListPeers and GetPeer. By default it is not
+// measured.
+func WithLatency(interval, window time.Duration) Option {
+	return func(s *Server) {
+		s.latency.interval = interval
+		s.latency.window = window
+	}
+}
+
+// runLatency probes every connected Peer each latency interval, until ctx is
+// cancelled.
+func (s *Server) runLatency(ctx context.Context) {
+	t := time.NewTicker(s.latency.interval)
+	defer t.Stop()
+
+	for {
+		dev, err := s.wg.Device(s.deviceName)
+		if err != nil {
+			log.Printf("error: latency: could not get WireGuard device: %s\n", err)
+		} else {
+			s.latency.probe(dev.Peers)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// probeTarget is a Peer sent an ICMP echo request.
+type probeTarget struct {
+	publicKey wgtypes.Key
+	addr      net.IP
+	sent      time.Time
+}
+
+// probe sends an ICMP echo request to every connected Peer at once, and
+// records the replies received within the probe timeout.
+func (l *latency) probe(peers []wgtypes.Peer) {
+	now := time.Now()
+	var v4, v6 []*probeTarget
+
+	for _, peer := range peers {
+		if peer.LastHandshakeTime.IsZero() || now.Sub(peer.LastHandshakeTime) >= connectedTimeout {
+			continue
+		}
+
+		for _, allowedIP := range peer.AllowedIPs {
+			if ones, bits := allowedIP.Mask.Size(); ones != bits {
+				continue
+			}
+
+			target := &probeTarget{publicKey: peer.PublicKey, addr: allowedIP.IP}
+			if ip4 := allowedIP.IP.To4(); ip4 != nil {
+				target.addr = ip4
+				v4 = append(v4, target)
+			} else {
+				v6 = append(v6, target)
+			}
+
+			break
+		}
+	}
+
+	samples := make(map[*probeTarget]latencySample)
+
+	for i, targets := range [][]*probeTarget{v4, v6} {
+		if len(targets) == 0 {
+			continue
+		}
+
+		if err := l.ping(i == 1, targets, samples); err != nil {
+			log.Printf("error: latency: could not probe peers: %s\n", err)
+		}
+	}
+
+	l.record(now, append(v4, v6...), samples)
+}
+
+// ping sends an ICMP echo request to each of targets of a single family, in
+// batches of the sequences available, adding the sample of each to samples.
+func (l *latency) ping(v6 bool, targets []*probeTarget, samples map[*probeTarget]latencySample) error {
+	c, err := listenICMP(v6)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	const batch = 1 << 16
+
+	for len(targets) > 0 {
+		n := min(len(targets), batch)
+
+		for i, target := range targets[:n] {
+			target.sent = time.Now()
+			samples[target] = latencySample{at: target.sent}
+
+			if err := c.send(target.addr, uint16(i), 0); err != nil {
+				return err
+			}
+		}
+
+		deadline := time.Now().Add(probeTimeout)
+
+		for replies := 0; replies < n; {
+			seq, ok, err := c.receive(deadline)
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+
+			if int(seq) >= n || samples[targets[seq]].replied {
+				continue
+			}
+
+			target := targets[seq]
+			samples[target] = latencySample{at: target.sent, rtt: time.Since(target.sent), replied: true}
+			replies++
+		}
+
+		targets = targets[n:]
+	}
+
+	return nil
+}
+
+// record adds the sample of each of targets, discarding samples outside of
+// the window and the samples of Peers not probed within it.
+func (l *latency) record(now time.Time, targets []*probeTarget, samples map[*probeTarget]latencySample) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-l.window)
+	current := make(map[wgtypes.Key]*peerProbes, len(l.peers))
+
+	for publicKey, probes := range l.peers {
+		var i int
+		for i < len(probes.samples) && !probes.samples[i].at.After(cutoff) {
+			i++
+		}
+
+		if i < len(probes.samples) {
+			probes.samples = append(probes.samples[:0], probes.samples[i:]...)
+			current[publicKey] = probes
+		}
+	}
+
+	for _, target := range targets {
+		sample, ok := samples[target]
+		if !ok {
+			continue
+		}
+
+		probes := current[target.publicKey]
+
+		// the samples of a different address are of a different path.
+		if probes == nil || !probes.addr.Equal(target.addr) {
+			probes = &peerProbes{addr: target.addr}
+			current[target.publicKey] = probes
+		}
+
+		probes.samples = append(probes.samples, sample)
+	}
+
+	l.peers = current
+}
+
+// get returns the latency of the Peer with publicKey over the window, or nil
+// if it has not been probed within it.
+func (l *latency) get(publicKey wgtypes.Key) *client.PeerLatency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	probes, ok := l.peers[publicKey]
+	if !ok || len(probes.samples) == 0 {
+		return nil
+	}
+
+	res := &client.PeerLatency{Address: probes.addr.String(), Sent: len(probes.samples)}
+
+	var replied int
+	var total time.Duration
+
+	for _, sample := range probes.samples {
+		if sample.replied {
+			replied++
+			total += sample.rtt
+		}
+	}
+
+	res.Loss = float64(res.Sent-replied) / float64(res.Sent)
+
+	if replied > 0 {
+		res.RTTMS = float64((total / time.Duration(replied)).Microseconds()) / 1000
+	}
+
+	return res
+}
+
+// attachLatency sets the latency of each Peer, if it is known.
+func (s *Server) attachLatency(peers []*client.Peer) {
+	for _, peer := range peers {
+		s.setLatency(peer)
+	}
+}
+
+// setLatency sets the latency of peer, if it is known.
+func (s *Server) setLatency(peer *client.Peer) {
+	if s.latency.interval <= 0 {
+		return
+	}
+
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return
+	}
+
+	peer.Latency = s.latency.get(publicKey)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// limitError returns the error given when a request would exceed a limit of
+// the Server.
+func limitError(field string, value int, message string) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeLimit, message, &client.ErrorData{Field: field, Value: strconv.Itoa(value)})
+}
+
+// checkMaxPeers returns an error if configuring the device with peers would
+// take it beyond the maximum number of Peers. Devices already beyond it may
+// still have their Peers updated or removed.
+func (s *Server) checkMaxPeers(current []wgtypes.Peer, peers []wgtypes.PeerConfig) error {
+	if s.limits.MaxPeers <= 0 {
+		return nil
+	}
+
+	n := len(simulateConfig(current, wgtypes.Config{Peers: peers}))
+	if n > s.limits.MaxPeers && n > len(current) {
+		return limitError("max_peers", n, fmt.Sprintf("device would have %d peers, more than the maximum of %d", n, s.limits.MaxPeers))
+	}
+
+	return nil
+}
+
+// checkBatchSize returns an error if a batch of n Peers or operations, given
+// in field, is larger than the maximum size of batches.
+func (s *Server) checkBatchSize(field string, n int) error {
+	if s.limits.MaxBatchSize > 0 && n > s.limits.MaxBatchSize {
+		return limitError(field, n, fmt.Sprintf("batch of %d is larger than the maximum of %d", n, s.limits.MaxBatchSize))
+	}
+
+	return nil
+}
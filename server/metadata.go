@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// attachStored sets the information kept about each Peer in the Store, such
+// as its metadata, on the Peer.
+func (s *Server) attachStored(ctx context.Context, peers []*client.Peer) error {
+	switch len(peers) {
+	case 0:
+		return nil
+
+	case 1:
+		stored, err := s.store.GetPeer(ctx, peers[0].PublicKey)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return storeError("could not get peer metadata", err)
+		}
+
+		setStored(peers[0], stored)
+
+		return nil
+	}
+
+	index, err := s.storedIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		if stored, ok := index[peer.PublicKey]; ok {
+			setStored(peer, stored)
+		}
+	}
+
+	return nil
+}
+
+// storedIndex returns the metadata of every stored Peer by public key.
+func (s *Server) storedIndex(ctx context.Context) (map[string]*store.Peer, error) {
+	list, err := s.store.ListPeers(ctx)
+	if err != nil {
+		return nil, storeError("could not list peer metadata", err)
+	}
+
+	index := make(map[string]*store.Peer, len(list))
+	for _, stored := range list {
+		index[stored.PublicKey] = stored
+	}
+
+	return index, nil
+}
+
+func setStored(peer *client.Peer, stored *store.Peer) {
+	peer.Metadata = stored.Metadata
+	peer.Template = stored.Template
+
+	if !stored.ExpiresAt.IsZero() {
+		expiresAt := stored.ExpiresAt
+		peer.ExpiresAt = &expiresAt
+	}
+}
+
+// updateStored applies fn to the information kept about a Peer in the Store,
+// creating it if it does not exist.
+func (s *Server) updateStored(ctx context.Context, publicKey string, fn func(*store.Peer)) error {
+	peer, err := s.store.GetPeer(ctx, publicKey)
+	if errors.Is(err, store.ErrNotFound) {
+		peer = &store.Peer{PublicKey: publicKey}
+	} else if err != nil {
+		return storeError("could not get peer metadata", err)
+	}
+
+	fn(peer)
+
+	if err := s.store.PutPeer(ctx, peer); err != nil {
+		return storeError("could not put peer metadata", err)
+	}
+
+	return nil
+}
+
+// recordPeer keeps the information about a Peer that has been added to the
+// device in the Store, such as its metadata, expiry and the Template it was
+// added with, if any.
+func (s *Server) recordPeer(ctx context.Context, req *client.AddPeerRequest, tmpl *Template) error {
+	expiresAt := peerExpiry(req, time.Now())
+
+	if req.Metadata == nil && expiresAt.IsZero() && tmpl == nil {
+		return nil
+	}
+
+	return s.updateStored(ctx, req.PublicKey, func(peer *store.Peer) {
+		if req.Metadata != nil {
+			peer.Metadata = req.Metadata
+		}
+
+		if !expiresAt.IsZero() {
+			peer.ExpiresAt = expiresAt
+		}
+
+		if tmpl != nil {
+			peer.Template = req.Template
+			peer.QuotaBytes = tmpl.QuotaBytes
+
+			// the expiry is only set when the peer is first added with the
+			// template, such that updating the peer does not extend it.
+			if tmpl.Expiry > 0 && peer.ExpiresAt.IsZero() {
+				peer.ExpiresAt = time.Now().Add(tmpl.Expiry).UTC()
+			}
+		}
+	})
+}
+
+// forgetPeer removes the information kept about a Peer that has been removed
+// from the device, and releases any allowed ips allocated to it.
+func (s *Server) forgetPeer(ctx context.Context, publicKey string) error {
+	s.releaseIPs(ctx, publicKey)
+
+	if err := s.store.DeletePeer(ctx, publicKey); err != nil {
+		return storeError("could not delete peer metadata", err)
+	}
+
+	return nil
+}
+
+// SetPeerMetadata replaces or merges the metadata of a Peer already on the
+// device, without changing its configuration, such that other systems can
+// keep the names, owners and labels of Peers without adding them again. It
+// is evaluated by the Policy as SetPeerMetadata with the metadata the Peer
+// would have, and publishes a peer.updated Event if the metadata changed.
+func (s *Server) SetPeerMetadata(ctx context.Context, req *client.SetPeerMetadataRequest) (*client.SetPeerMetadataResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	if err := validateGetPeerRequest(&client.GetPeerRequest{PublicKey: req.PublicKey}); err != nil {
+		return nil, err
+	}
+
+	publicKey, _ := wgtypes.ParseKey(req.PublicKey)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the cached dump of the device may be older than the generation
+	// expected, so the device is dumped again to compare them.
+	if req.ExpectedGeneration != 0 {
+		dev, err := s.device(ctx)
+		if err != nil {
+			return nil, deviceError("could not get WireGuard device", err)
+		}
+
+		if gen := s.gen.observe(dev); req.ExpectedGeneration != gen {
+			return nil, conflictError(req.ExpectedGeneration, gen)
+		}
+	}
+
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := idx.peer(publicKey)
+	if peer == nil {
+		return nil, peerNotFoundError(req.PublicKey)
+	}
+
+	stored, err := s.store.GetPeer(ctx, req.PublicKey)
+	if errors.Is(err, store.ErrNotFound) {
+		stored = &store.Peer{PublicKey: req.PublicKey}
+	} else if err != nil {
+		return nil, storeError("could not get peer metadata", err)
+	}
+
+	metadata := make(map[string]string, len(stored.Metadata)+len(req.Metadata))
+	if req.Merge {
+		for k, v := range stored.Metadata {
+			metadata[k] = v
+		}
+	}
+
+	for k, v := range req.Metadata {
+		if v == "" && req.Merge {
+			delete(metadata, k)
+		} else {
+			metadata[k] = v
+		}
+	}
+
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+
+	if err := s.checkSetPeerMetadata(peer, stored, metadata); err != nil {
+		return nil, err
+	}
+
+	res := &client.SetPeerMetadataResponse{OK: true, Metadata: metadata}
+
+	if req.DryRun || s.dryRun {
+		res.DryRun = true
+		return res, nil
+	} else if maps.Equal(metadata, stored.Metadata) {
+		return res, nil
+	}
+
+	before := peer2rpc(*peer)
+	setStored(before, stored)
+
+	stored.Metadata = metadata
+	if err := s.store.PutPeer(ctx, stored); err != nil {
+		return nil, storeError("could not put peer metadata", err)
+	}
+
+	after := peer2rpc(*peer)
+	setStored(after, stored)
+
+	s.publishChanges([]*client.PeerChange{{
+		Action:    client.ChangeUpdate,
+		PublicKey: req.PublicKey,
+		Before:    before,
+		After:     after,
+	}})
+
+	return res, nil
+}
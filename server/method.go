@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// method adapts a method of a Client, which must have the signature
+// func(context.Context, *Request) (*Response, error), into one that can be
+// called with the raw JSON-RPC parameters.
+type method struct {
+	fn  reflect.Value
+	req reflect.Type
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func newMethod(fn interface{}) method {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 2 ||
+		t.In(0) != contextType || t.In(1).Kind() != reflect.Ptr || t.Out(1) != errorType {
+		panic(fmt.Sprintf("server: invalid method signature %s", t))
+	}
+
+	return method{fn: v, req: t.In(1).Elem()}
+}
+
+// call decodes params into the request type of the method before calling it.
+// If params are not given, a zero request is used.
+func (m method) call(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	req := reflect.New(m.req)
+
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, req.Interface()); err != nil {
+			return nil, jsonrpc.InvalidParams("invalid params: "+err.Error(), &client.ErrorData{})
+		}
+	}
+
+	out := m.fn.Call([]reflect.Value{reflect.ValueOf(ctx), req})
+
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+
+	return out[0].Interface(), nil
+}
+
+// clientMethods returns the JSON-RPC methods of the WG-API implemented by c.
+func clientMethods(c client.Client) map[string]method {
+	return map[string]method{
+		"GetDeviceInfo":        newMethod(c.GetDeviceInfo),
+		"ListPeers":            newMethod(c.ListPeers),
+		"ListPeerKeys":         newMethod(c.ListPeerKeys),
+		"GetPeer":              newMethod(c.GetPeer),
+		"GetPeerCount":         newMethod(c.GetPeerCount),
+		"AddPeer":              newMethod(c.AddPeer),
+		"RemovePeer":           newMethod(c.RemovePeer),
+		"RemoveAllPeers":       newMethod(c.RemoveAllPeers),
+		"AddPeers":             newMethod(c.AddPeers),
+		"ApplyBatch":           newMethod(c.ApplyBatch),
+		"ImportPeers":          newMethod(c.ImportPeers),
+		"ExportPeers":          newMethod(c.ExportPeers),
+		"GetServerInfo":        newMethod(c.GetServerInfo),
+		"GetCapabilities":      newMethod(c.GetCapabilities),
+		"Ping":                 newMethod(c.Ping),
+		"GetServerStats":       newMethod(c.GetServerStats),
+		"TopTalkers":           newMethod(c.TopTalkers),
+		"WatchDevices":         newMethod(c.WatchDevices),
+		"ProbePeerEndpoint":    newMethod(c.ProbePeerEndpoint),
+		"DiagnoseMTU":          newMethod(c.DiagnoseMTU),
+		"MovePeers":            newMethod(c.MovePeers),
+		"RenameDevice":         newMethod(c.RenameDevice),
+		"ClonePeer":            newMethod(c.ClonePeer),
+		"UpdatePeer":           newMethod(c.UpdatePeer),
+		"RemovePeers":          newMethod(c.RemovePeers),
+		"ListDevices":          newMethod(c.ListDevices),
+		"SetDeviceConfig":      newMethod(c.SetDeviceConfig),
+		"GenerateKeyPair":      newMethod(c.GenerateKeyPair),
+		"GeneratePresharedKey": newMethod(c.GeneratePresharedKey),
+		"SetPeerMetadata":      newMethod(c.SetPeerMetadata),
+		"GenerateClientConfig": newMethod(c.GenerateClientConfig),
+		"CreateDevice":         newMethod(c.CreateDevice),
+		"DeleteDevice":         newMethod(c.DeleteDevice),
+		"SyncPeers":            newMethod(c.SyncPeers),
+	}
+}
+
+// Handler serves the WG-API over JSON-RPC from any Client, such as one that
+// proxies requests to other WG-API servers.
+func Handler(c client.Client) jsonrpc.Handler {
+	methods := clientMethods(c)
+
+	return jsonrpc.HandlerFunc(func(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+		m, ok := methods[r.Method]
+		if !ok {
+			w.Write(jsonrpc.MethodNotFound("method not found", &client.ErrorData{Field: "method", Value: r.Method}))
+			return
+		}
+
+		res, err := m.call(r.Context(), r.Params)
+		if err != nil {
+			// an error caused by the request timing out or the client
+			// disconnecting is reported as such, however it was wrapped.
+			if ctxErr := r.Context().Err(); ctxErr != nil {
+				err = timeoutError(ctxErr)
+			}
+
+			w.Write(rpcError(err))
+			return
+		}
+
+		w.Write(res)
+	})
+}
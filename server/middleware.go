@@ -1,11 +1,17 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jamescun/wg-api/client"
 	"github.com/jamescun/wg-api/server/jsonrpc"
 )
 
@@ -23,6 +29,49 @@ func PreventReferer(next http.Handler) http.Handler {
 	})
 }
 
+// PreventCrossOrigin blocks any request whose Referer or Origin header is of
+// another host than the request was made to, such that a frontend served by
+// this server may make requests of it, but no other web page.
+func PreventCrossOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, key := range []string{"Referer", "Origin"} {
+			if _, ok := r.Header[key]; !ok {
+				continue
+			}
+
+			if u, err := url.Parse(r.Header.Get(key)); err != nil || u.Host != r.Host {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireDevice refuses any request naming a device other than device, or one
+// of its aliases, in the Wg-Api-Device header with HTTP 421 Misdirected
+// Request, such that a client intending to configure another device cannot
+// configure this one.
+func RequireDevice(device string, aliases ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := r.Header.Get(client.DeviceHeader)
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			} else if name != device && !slices.Contains(aliases, name) {
+				http.Error(w, "device "+strconv.Quote(name)+" is not served", http.StatusMisdirectedRequest)
+				return
+			}
+
+			// the device is routed to by a Mux, if the request is served
+			// by one.
+			next.ServeHTTP(w, r.WithContext(withRequestedDevice(r.Context(), name)))
+		})
+	}
+}
+
 func headersExist(h http.Header, keys ...string) bool {
 	for _, key := range keys {
 		if _, ok := h[key]; ok {
@@ -33,14 +82,128 @@ func headersExist(h http.Header, keys ...string) bool {
 	return false
 }
 
-// Logger logs JSON-RPC requests.
+// Logger logs JSON-RPC requests, except for Ping which may be made often by
+// health checks.
 func Logger(next jsonrpc.Handler) jsonrpc.Handler {
+	return logger(next, false)
+}
+
+// LoggerWithParams logs JSON-RPC requests as Logger does, along with the
+// params of those that may change the device, sanitized of preshared and
+// private keys, such that what a client sent can be seen without capturing
+// its requests.
+func LoggerWithParams(next jsonrpc.Handler) jsonrpc.Handler {
+	return logger(next, true)
+}
+
+func logger(next jsonrpc.Handler, params bool) jsonrpc.Handler {
 	return jsonrpc.HandlerFunc(func(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+		if r.Method == "Ping" {
+			next.ServeJSONRPC(w, r)
+			return
+		}
+
 		t1 := time.Now()
 		next.ServeJSONRPC(w, r)
 		t2 := time.Now()
 
-		log.Printf("info: request: method=%q remote_addr=%s duration=%s\n", r.Method, r.RemoteAddr(), t2.Sub(t1))
+		if params && auditedMethods[r.Method] {
+			log.Printf("info: request: method=%q remote_addr=%s duration=%s params=%s\n", r.Method, r.RemoteAddr(), t2.Sub(t1), sanitizeParams(r.Params))
+		} else {
+			log.Printf("info: request: method=%q remote_addr=%s duration=%s\n", r.Method, r.RemoteAddr(), t2.Sub(t1))
+		}
+	})
+}
+
+// maxLoggedParams is the most bytes of the params of a request logged, such
+// that batches of thousands of Peers are not logged in full.
+const maxLoggedParams = 4096
+
+// secretParams are the substrings of the names of params whose values are
+// never logged.
+var secretParams = []string{"private", "preshared", "secret", "token", "password"}
+
+// sanitizeParams returns params as JSON with the values of secret params
+// replaced, truncated to maxLoggedParams bytes.
+func sanitizeParams(params json.RawMessage) string {
+	if len(params) == 0 {
+		return "{}"
+	}
+
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+
+	if err := dec.Decode(&v); err != nil {
+		return `"<invalid>"`
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(sanitizeParam("", v)); err != nil {
+		return `"<invalid>"`
+	}
+
+	out := bytes.TrimSpace(buf.Bytes())
+	if len(out) > maxLoggedParams {
+		return string(out[:maxLoggedParams]) + "...(truncated)"
+	}
+
+	return string(out)
+}
+
+// sanitizeParam replaces v with [redacted] if it is the value of a secret
+// param named name, or any of its values if it is an object or array. Flags
+// such as generate_preshared_key are not secret.
+func sanitizeParam(name string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = sanitizeParam(key, value)
+		}
+
+	case []interface{}:
+		for i, value := range v {
+			v[i] = sanitizeParam(name, value)
+		}
+
+	case bool, nil:
+
+	default:
+		// the data of ImportPeers may include preshared keys.
+		if name == "data" {
+			return "[redacted]"
+		}
+
+		for _, secret := range secretParams {
+			if strings.Contains(strings.ToLower(name), secret) {
+				return "[redacted]"
+			}
+		}
+	}
+
+	return v
+}
+
+// AllowMethods only serves the JSON-RPC methods given, requests of any other
+// fail with Method Not Found as though it did not exist. If no methods are
+// given, every method is served.
+func AllowMethods(next jsonrpc.Handler, methods ...string) jsonrpc.Handler {
+	if len(methods) == 0 {
+		return next
+	}
+
+	return jsonrpc.HandlerFunc(func(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+		if !stringInSlice(r.Method, methods) {
+			w.Write(jsonrpc.MethodNotFound("method not found", &client.ErrorData{Field: "method", Value: r.Method}))
+			return
+		}
+
+		next.ServeJSONRPC(w, r)
 	})
 }
 
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func validateMovePeersRequest(req *client.MovePeersRequest) ([]wgtypes.Key, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	if len(req.PublicKeys) < 1 {
+		return nil, invalidParam("public_keys", "", "at least one public key is required")
+	}
+
+	if req.ToDevice == "" {
+		return nil, invalidParam("to_device", "", "device is required")
+	}
+
+	keys := make([]wgtypes.Key, len(req.PublicKeys))
+	seen := make(map[wgtypes.Key]bool, len(req.PublicKeys))
+
+	for i, publicKey := range req.PublicKeys {
+		key, err := wgtypes.ParseKey(publicKey)
+		if err != nil {
+			return nil, invalidParam("public_keys", publicKey, "invalid public key: "+err.Error())
+		} else if seen[key] {
+			return nil, invalidParam("public_keys", publicKey, "public key given more than once")
+		}
+
+		keys[i], seen[key] = key, true
+	}
+
+	return keys, nil
+}
+
+// MovePeers moves Peers from the device to another WireGuard device on the
+// same host, keeping their configuration. The Peers are added to the other
+// device before they are removed from this one, and are removed from the
+// other device again if they could not be removed from this one, such that
+// they are never on neither device.
+func (s *Server) MovePeers(ctx context.Context, req *client.MovePeersRequest) (*client.MovePeersResponse, error) {
+	keys, err := validateMovePeersRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	toDevice := s.resolveDevice(req.ToDevice)
+	if toDevice == s.deviceName {
+		return nil, invalidParam("to_device", req.ToDevice, "peers cannot be moved to the device they are on")
+	}
+
+	if err := s.checkBatchSize("public_keys", len(keys)); err != nil {
+		return nil, err
+	}
+
+	for _, publicKey := range req.PublicKeys {
+		if err := s.checkRemovePeer(ctx, &client.RemovePeerRequest{PublicKey: publicKey}); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	target, err := s.wg.Device(toDevice)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, invalidParam("to_device", req.ToDevice, "device not found")
+	} else if err != nil {
+		return nil, deviceError("could not get WireGuard device "+toDevice, err)
+	}
+
+	owners := make(map[string]bool)
+	for _, peer := range target.Peers {
+		for _, allowedIP := range peer.AllowedIPs {
+			owners[allowedIP.String()] = true
+		}
+	}
+
+	index, onTarget := indexPeers(dev.Peers), indexPeers(target.Peers)
+
+	var (
+		moved   []wgtypes.Peer
+		removes []wgtypes.PeerConfig
+	)
+
+	for _, key := range keys {
+		i, ok := index[key]
+		if !ok {
+			return nil, peerNotFoundError(key.String())
+		}
+
+		if _, ok := onTarget[key]; ok {
+			return nil, invalidParam("public_keys", key.String(), "peer is already on device "+req.ToDevice)
+		}
+
+		peer := dev.Peers[i]
+
+		for _, allowedIP := range peer.AllowedIPs {
+			if owners[allowedIP.String()] {
+				return nil, invalidParam("public_keys", key.String(), "allowed ip "+allowedIP.String()+" is already routed to a peer of device "+req.ToDevice)
+			}
+		}
+
+		moved = append(moved, peer)
+		removes = append(removes, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+
+	peers := peers2rpc(moved)
+	if err := s.attachStored(ctx, peers); err != nil {
+		return nil, err
+	}
+
+	adds, psks, err := s.moveConfigs(ctx, moved, req.RegeneratePresharedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// the Peers are removed from the device without configuring it first,
+	// such that the other device is not configured if they cannot be.
+	res, err := s.apply(ctx, wgtypes.Config{Peers: removes}, nil, req.ExpectedGeneration, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.DryRun && !s.dryRun {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := s.wg.ConfigureDevice(toDevice, wgtypes.Config{Peers: adds}); err != nil {
+			return nil, deviceError("could not configure WireGuard device "+toDevice, err)
+		}
+
+		res, err = s.apply(context.WithoutCancel(ctx), wgtypes.Config{Peers: removes}, nil, 0, false)
+		if err != nil {
+			if err := s.wg.ConfigureDevice(toDevice, wgtypes.Config{Peers: removes}); err != nil {
+				log.Printf("error: move: could not remove peers from %s after failing to remove them from %s: %s\n", toDevice, s.deviceName, err)
+			}
+
+			return nil, err
+		}
+
+		for _, key := range keys {
+			if err := s.forgetPeer(ctx, key.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &client.MovePeersResponse{
+		OK:            true,
+		DryRun:        res.DryRun,
+		Peers:         peers,
+		PresharedKeys: psks,
+		Changes:       res.Changes,
+		Generation:    res.Generation,
+	}, nil
+}
+
+// moveConfigs returns the configuration recreating each of peers on another
+// device. A Peer whose preshared key cannot be read from the device keeps
+// that kept in the Store, if any, unless regenerate is set, in which case
+// each Peer with a preshared key is given a new one, returned by public key.
+func (s *Server) moveConfigs(ctx context.Context, peers []wgtypes.Peer, regenerate bool) ([]wgtypes.PeerConfig, map[string]string, error) {
+	configs := make([]wgtypes.PeerConfig, len(peers))
+
+	var psks map[string]string
+
+	for i, peer := range peers {
+		if peer.PresharedKey == (wgtypes.Key{}) {
+			stored, err := s.store.GetPeer(ctx, peer.PublicKey.String())
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return nil, nil, storeError("could not get peer metadata", err)
+			}
+
+			if stored != nil && stored.Config != nil && stored.Config.PresharedKey != "" {
+				if peer.PresharedKey, err = wgtypes.ParseKey(stored.Config.PresharedKey); err != nil {
+					return nil, nil, storeError("invalid stored preshared key", err)
+				}
+			}
+		}
+
+		if regenerate && peer.PresharedKey != (wgtypes.Key{}) {
+			psk, err := wgtypes.GenerateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if psks == nil {
+				psks = make(map[string]string)
+			}
+
+			peer.PresharedKey = psk
+			psks[peer.PublicKey.String()] = psk.String()
+		}
+
+		configs[i] = peerConfigOf(peer)
+	}
+
+	return configs, psks, nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// maxPathMTU is the largest path MTU probed, that of Ethernet.
+	maxPathMTU = 1500
+
+	// wireguardOverhead is the bytes WireGuard adds to each packet besides
+	// the outer IP header: the UDP header, the message header and the
+	// authentication tag.
+	wireguardOverhead = 8 + 16 + 16
+)
+
+// DiagnoseMTU probes the path MTU toward the endpoint of a Peer with ICMP
+// echo requests that may not be fragmented, recommending the MTU of the
+// device whose packets fit within it once encapsulated.
+func (s *Server) DiagnoseMTU(ctx context.Context, req *client.DiagnoseMTURequest) (*client.DiagnoseMTUResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	publicKey, err := wgtypes.ParseKey(req.PublicKey)
+	if err != nil {
+		return nil, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
+	}
+
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := idx.peer(publicKey)
+	if peer == nil {
+		return nil, peerNotFoundError(req.PublicKey)
+	} else if peer.Endpoint == nil {
+		return nil, invalidParam("public_key", req.PublicKey, "peer has no endpoint")
+	}
+
+	diagnosis := &client.MTUDiagnosis{Endpoint: peer.Endpoint.String()}
+
+	if iface, err := net.InterfaceByName(s.deviceName); err == nil {
+		diagnosis.InterfaceMTU = iface.MTU
+	}
+
+	// the outer IP header of encapsulated packets is of the family of the
+	// endpoint, and the minimum MTU of IPv6 exceeds that of IPv4.
+	ip, header, minimum := peer.Endpoint.IP, 20, 576
+	if ip.To4() == nil {
+		header, minimum = 40, 1280
+	}
+
+	diagnosis.PathMTU, err = pathMTU(ctx, ip, header, minimum)
+	if err != nil {
+		diagnosis.PathMTU = 0
+		diagnosis.Error = err.Error()
+	} else {
+		diagnosis.RecommendedMTU = diagnosis.PathMTU - header - wireguardOverhead
+		diagnosis.Blackhole = diagnosis.InterfaceMTU > diagnosis.RecommendedMTU
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &client.DiagnoseMTUResponse{Diagnosis: diagnosis}, nil
+}
+
+// pathMTU returns the size of the largest packet between minimum and
+// maxPathMTU bytes answered by ip, by a binary search of ICMP echo requests
+// that may not be fragmented, where header is the size of its IP header.
+func pathMTU(ctx context.Context, ip net.IP, header, minimum int) (int, error) {
+	v6 := ip.To4() == nil
+
+	c, err := listenICMP(v6)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	if err := dontFragment(c, v6); err != nil {
+		return 0, err
+	}
+
+	var seq uint16
+
+	// answered sends up to two echo requests of size bytes, returning
+	// whether either was replied to.
+	answered := func(size int) (bool, error) {
+		for attempt := 0; attempt < 2 && ctx.Err() == nil; attempt++ {
+			seq++
+
+			ok, err := c.ping(ip, seq, size-header-8-len(c.data), probeDeadline(ctx))
+			if errors.Is(err, syscall.EMSGSIZE) {
+				// larger than the MTU of the route known to the server.
+				return false, nil
+			} else if err != nil || ok {
+				return ok, err
+			}
+		}
+
+		return false, nil
+	}
+
+	if ok, err := answered(maxPathMTU); err != nil {
+		return 0, err
+	} else if ok {
+		return maxPathMTU, nil
+	}
+
+	if ok, err := answered(minimum); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, errors.New("host of endpoint did not answer ICMP echo requests")
+	}
+
+	// lo is always answered, and hi never.
+	lo, hi := minimum, maxPathMTU
+
+	for hi-lo > 1 && ctx.Err() == nil {
+		mid := (lo + hi) / 2
+
+		ok, err := answered(mid)
+		if err != nil {
+			return 0, err
+		} else if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, nil
+}
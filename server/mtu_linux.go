@@ -0,0 +1,42 @@
+package server
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dontFragment forbids the echo requests of c from being fragmented, ignoring
+// the path MTU the host has cached for their destination.
+func dontFragment(c *icmpConn, v6 bool) error {
+	var conn syscall.Conn
+	level, opt, val := unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE
+
+	if v6 {
+		conn, _ = c.IPv6PacketConn().PacketConn.(syscall.Conn)
+		level, opt, val = unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE
+	} else {
+		conn, _ = c.IPv4PacketConn().PacketConn.(syscall.Conn)
+	}
+
+	if conn == nil {
+		return errors.New("socket does not support forbidding fragmentation")
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), level, opt, val)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
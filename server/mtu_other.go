@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// dontFragment is only supported on Linux.
+func dontFragment(c *icmpConn, v6 bool) error {
+	return errors.New("diagnosing the path mtu is only supported on linux")
+}
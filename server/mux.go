@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// muxParallelism is the maximum number of devices queried at once by
+// requests of every device, such as ListDevices.
+const muxParallelism = 16
+
+// Mux serves the WG-API of many devices of a host from a single process,
+// each managed by its own Server. Requests are routed to the device named by
+// the device member of their params, otherwise that named in the
+// Wg-Api-Device header, otherwise the first device.
+type Mux struct {
+	servers []*Server
+}
+
+// NewMux returns a Mux serving the device of each of servers, of which there
+// must be at least one.
+func NewMux(servers ...*Server) *Mux {
+	if len(servers) == 0 {
+		panic("server: mux requires at least one server")
+	}
+
+	return &Mux{servers: servers}
+}
+
+// Names returns the name and aliases of every device served.
+func (m *Mux) Names() []string {
+	var names []string
+	for _, s := range m.servers {
+		names = append(names, s.DeviceName())
+		names = append(names, s.Aliases()...)
+	}
+
+	return names
+}
+
+// server returns the Server of the device name, which may be an alias, or
+// nil if it is not served. An empty name is the first device.
+func (m *Mux) server(name string) *Server {
+	if name == "" {
+		return m.servers[0]
+	}
+
+	for _, s := range m.servers {
+		if s.resolveDevice(name) == s.DeviceName() {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// each calls fn for the Server of every device concurrently, at most
+// muxParallelism at once, returning the error of the first device that
+// failed, naming it.
+func (m *Mux) each(fn func(i int, s *Server) error) error {
+	errs := make([]error, len(m.servers))
+	sem := make(chan struct{}, muxParallelism)
+
+	var wg sync.WaitGroup
+
+	for i, s := range m.servers {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(i, s); err != nil {
+				errs[i] = deviceNamedError(s.DeviceName(), err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deviceNamedError names the device name in the message of err, such that
+// the device at fault is known when many were queried.
+func deviceNamedError(name string, err error) error {
+	var rpcErr *jsonrpc.Error
+	if errors.As(err, &rpcErr) {
+		return &jsonrpc.Error{
+			Code:    rpcErr.Code,
+			Message: fmt.Sprintf("%s: %s", name, rpcErr.Message),
+			Data:    rpcErr.Data,
+		}
+	}
+
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// ListDevices returns every device served, in order, each dumped
+// concurrently such that a host of many devices is not listed at the sum of
+// their latencies.
+func (m *Mux) ListDevices(ctx context.Context, req *client.ListDevicesRequest) (*client.ListDevicesResponse, error) {
+	res := &client.ListDevicesResponse{Devices: make([]*client.Device, len(m.servers))}
+
+	err := m.each(func(i int, s *Server) error {
+		info, err := s.GetDeviceInfo(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		res.Devices[i] = info.Device
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ServeJSONRPC routes a request to the Server of the device it names.
+func (m *Mux) ServeJSONRPC(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+	// every device is listed by the Mux itself, unless the method has been
+	// disabled.
+	if _, ok := m.servers[0].methods[r.Method]; r.Method == "ListDevices" && ok {
+		res, err := newMethod(m.ListDevices).call(r.Context(), r.Params)
+		if err != nil {
+			w.Write(rpcError(err))
+			return
+		}
+
+		w.Write(res)
+		return
+	}
+
+	name := paramDevice(r.Params)
+	if name == "" {
+		name = requestedDevice(r.Context())
+	}
+
+	s := m.server(name)
+	if s == nil {
+		w.Write(jsonrpc.InvalidParams("device is not served", &client.ErrorData{Field: "device", Value: name}))
+		return
+	}
+
+	s.ServeJSONRPC(w, r)
+}
+
+// paramDevice returns the device member of params, if they are an object.
+func paramDevice(params json.RawMessage) string {
+	var p struct {
+		Device string `json:"device"`
+	}
+
+	if len(params) > 0 && params[0] == '{' {
+		json.Unmarshal(params, &p)
+	}
+
+	return p.Device
+}
+
+type deviceKey struct{}
+
+// withRequestedDevice returns a copy of ctx naming the device a request is
+// intended for, as given in the Wg-Api-Device header.
+func withRequestedDevice(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, deviceKey{}, name)
+}
+
+// requestedDevice returns the device named by withRequestedDevice, if any.
+func requestedDevice(ctx context.Context) string {
+	name, _ := ctx.Value(deviceKey{}).(string)
+	return name
+}
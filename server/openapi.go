@@ -0,0 +1,179 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// openAPIObject is a JSON object of an OpenAPI document.
+type openAPIObject = map[string]interface{}
+
+// NewOpenAPI returns the OpenAPI 3 document of the resources served by
+// RESTHandler, whose schemas are those of client.NewSchema, such that clients
+// may be generated from it by any OpenAPI generator.
+func NewOpenAPI() map[string]interface{} {
+	schema := client.NewSchema()
+
+	schemas := make(openAPIObject, len(schema.Types)+1)
+	for _, obj := range schema.Types {
+		schemas[obj.Name] = openAPISchema(obj)
+	}
+
+	schemas["Error"] = openAPIObject{
+		"type":     "object",
+		"required": []string{"error"},
+		"properties": openAPIObject{
+			"error": openAPIObject{
+				"type":     "object",
+				"required": []string{"code", "message", "data"},
+				"properties": openAPIObject{
+					"code":    openAPIObject{"type": "integer"},
+					"message": openAPIObject{"type": "string"},
+					"data":    openAPIObject{"type": "object"},
+				},
+			},
+		},
+	}
+
+	publicKey := openAPIObject{
+		"name":        "public_key",
+		"in":          "path",
+		"required":    true,
+		"description": "public key of the peer, as base64url or percent-encoded base64",
+		"schema":      openAPIObject{"type": "string"},
+	}
+
+	return openAPIObject{
+		"openapi": "3.0.3",
+		"info": openAPIObject{
+			"title":   "WG-API",
+			"version": strconv.Itoa(client.SchemaVersion),
+		},
+		"paths": openAPIObject{
+			"/v1/device": openAPIObject{
+				"get": openAPIOperation("getDevice", "GetDeviceInfo", nil, nil, "Device"),
+			},
+			"/v1/peers": openAPIObject{
+				"get": openAPIOperation("listPeers", "ListPeers", []openAPIObject{
+					openAPIQuery("limit", "integer"),
+					openAPIQuery("offset", "integer"),
+					openAPIQuery("after", "string"),
+				}, nil, "ListPeersResponse"),
+			},
+			"/v1/peers/{public_key}": openAPIObject{
+				"get": openAPIOperation("getPeer", "GetPeer", []openAPIObject{publicKey}, nil, "Peer"),
+				"put": openAPIOperation("putPeer", "AddPeer", []openAPIObject{publicKey}, openAPIObject{
+					"required": true,
+					"content": openAPIObject{
+						"application/json": openAPIObject{"schema": openAPIRef("AddPeerRequest")},
+					},
+				}, "AddPeerResponse"),
+				"delete": openAPIOperation("deletePeer", "RemovePeer", []openAPIObject{
+					publicKey,
+					openAPIQuery("expected_generation", "integer"),
+					openAPIQuery("dry_run", "boolean"),
+				}, nil, "RemovePeerResponse"),
+			},
+		},
+		"components": openAPIObject{
+			"schemas": schemas,
+			"securitySchemes": openAPIObject{
+				"token": openAPIObject{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": "Token <token>",
+				},
+			},
+		},
+		"security": []openAPIObject{{"token": []string{}}},
+	}
+}
+
+// openAPIOperation returns the operation equivalent to method, whose
+// successful response is the schema result.
+func openAPIOperation(id, method string, params []openAPIObject, body openAPIObject, result string) openAPIObject {
+	op := openAPIObject{
+		"operationId": id,
+		"summary":     "equivalent to the " + method + " method",
+		"responses": openAPIObject{
+			"200":     openAPIResponse("OK", result),
+			"default": openAPIResponse("the JSON-RPC error of the equivalent method", "Error"),
+		},
+	}
+
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if body != nil {
+		op["requestBody"] = body
+	}
+
+	return op
+}
+
+// openAPIResponse returns a response of the schema name.
+func openAPIResponse(description, name string) openAPIObject {
+	return openAPIObject{
+		"description": description,
+		"content": openAPIObject{
+			"application/json": openAPIObject{"schema": openAPIRef(name)},
+		},
+	}
+}
+
+// openAPIQuery returns an optional query parameter of the type typ.
+func openAPIQuery(name, typ string) openAPIObject {
+	return openAPIObject{"name": name, "in": "query", "schema": openAPIObject{"type": typ}}
+}
+
+// openAPIRef returns a reference to the schema name.
+func openAPIRef(name string) openAPIObject {
+	return openAPIObject{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPISchema returns the schema of obj, whose fields that are not optional
+// are required.
+func openAPISchema(obj *client.SchemaObject) openAPIObject {
+	properties := make(openAPIObject, len(obj.Fields))
+	required := []string{}
+
+	for _, f := range obj.Fields {
+		properties[f.Name] = openAPIType(f.Type)
+
+		if !f.Optional {
+			required = append(required, f.Name)
+		}
+	}
+
+	schema := openAPIObject{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// openAPIType returns the schema of t.
+func openAPIType(t *client.SchemaType) openAPIObject {
+	switch t.Kind {
+	case client.KindString, client.KindInteger, client.KindNumber, client.KindBoolean:
+		return openAPIObject{"type": t.Kind}
+
+	case client.KindTime:
+		return openAPIObject{"type": "string", "format": "date-time"}
+
+	case client.KindObject:
+		return openAPIRef(t.Ref)
+
+	case client.KindArray:
+		return openAPIObject{"type": "array", "items": openAPIType(t.Elem)}
+
+	case client.KindMap:
+		return openAPIObject{"type": "object", "additionalProperties": openAPIType(t.Elem)}
+	}
+
+	return openAPIObject{}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// WithOverlapCheck configures the Server to reject adding or updating a Peer
+// whose allowed ips would overlap those of another Peer, unless forced, as
+// WireGuard would otherwise silently take any identical allowed ips from the
+// other Peer.
+func WithOverlapCheck() Option {
+	return func(s *Server) {
+		s.rejectOverlaps = true
+	}
+}
+
+// overlapError returns the error given when allowedIP of a Peer overlaps
+// that of the Peer with publicKey.
+func overlapError(allowedIP net.IPNet, publicKey wgtypes.Key) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeConflict, "allowed ip overlaps that of another peer", &client.ErrorData{
+		Field:                "allowed_ips",
+		Value:                allowedIP.String(),
+		ConflictingPublicKey: publicKey.String(),
+	})
+}
+
+// overlapErrors returns the error of each of configs with check of the same
+// index set whose allowed ips would overlap those of another Peer, once the
+// configs before it are applied to peers. Otherwise the error is nil.
+func overlapErrors(peers []wgtypes.Peer, configs []wgtypes.PeerConfig, check []bool) []error {
+	owners := make(map[wgtypes.Key][]net.IPNet, len(peers))
+	for _, peer := range peers {
+		owners[peer.PublicKey] = peer.AllowedIPs
+	}
+
+	errs := make([]error, len(configs))
+
+	for i, pc := range configs {
+		if pc.Remove {
+			delete(owners, pc.PublicKey)
+			continue
+		}
+
+		if i < len(check) && check[i] {
+		search:
+			for _, allowedIP := range pc.AllowedIPs {
+				for publicKey, owned := range owners {
+					if publicKey == pc.PublicKey {
+						continue
+					}
+
+					for _, other := range owned {
+						if other.Contains(allowedIP.IP) || allowedIP.Contains(other.IP) {
+							errs[i] = overlapError(allowedIP, publicKey)
+							break search
+						}
+					}
+				}
+			}
+
+			if errs[i] != nil {
+				continue
+			}
+		}
+
+		// identical allowed ips are taken from any other Peer.
+		for _, allowedIP := range pc.AllowedIPs {
+			for publicKey, owned := range owners {
+				if publicKey != pc.PublicKey {
+					owners[publicKey] = withoutIPNet(owned, allowedIP)
+				}
+			}
+		}
+
+		if pc.ReplaceAllowedIPs {
+			owners[pc.PublicKey] = nil
+		}
+
+		owners[pc.PublicKey] = append(owners[pc.PublicKey], pc.AllowedIPs...)
+	}
+
+	return errs
+}
+
+// withoutIPNet returns nets without any identical to n. nets is not
+// modified.
+func withoutIPNet(nets []net.IPNet, n net.IPNet) []net.IPNet {
+	for i, other := range nets {
+		if other.String() == n.String() {
+			return append(append([]net.IPNet(nil), nets[:i]...), nets[i+1:]...)
+		}
+	}
+
+	return nets
+}
+
+// checkOverlaps returns the first error of overlapErrors for configs, none
+// of which are checked if force is set or the Server does not reject
+// overlapping allowed ips. The caller must hold s.mu.
+func (s *Server) checkOverlaps(ctx context.Context, configs []wgtypes.PeerConfig, force bool) error {
+	if !s.rejectOverlaps || force {
+		return nil
+	}
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return deviceError("could not get WireGuard device", err)
+	}
+
+	check := make([]bool, len(configs))
+	for i := range check {
+		check[i] = true
+	}
+
+	for _, err := range overlapErrors(dev.Peers, configs, check) {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// PeerCred are the credentials of the process connected to a unix socket.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+type peerCredKey struct{}
+
+// PeerCredContext returns a copy of ctx with the credentials of the process
+// connected by c, if it is a unix socket, for the ConnContext of an
+// http.Server whose requests may be authenticated by AuthPeerCred.
+func PeerCredContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	cred, err := peerCredentials(uc)
+	if err != nil {
+		log.Printf("warn: server: could not get credentials of %s: %s\n", c.RemoteAddr(), err)
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// peerCred returns the credentials of the process that made a request, if
+// it was made over a unix socket.
+func peerCred(ctx context.Context) *PeerCred {
+	cred, _ := ctx.Value(peerCredKey{}).(*PeerCred)
+	return cred
+}
+
+// AuthPeerCred only allows a request to continue if it was made over a unix
+// socket served with PeerCredContext, by a process running as one of uids or
+// in one of gids, such that local automation need not be given a token.
+// Otherwise a HTTP 403 Forbidden is returned and the request terminated.
+// Peer credentials are only supported on Linux.
+func AuthPeerCred(uids, gids []uint32) (func(http.Handler) http.Handler, error) {
+	if err := peerCredSupported(); err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cred := peerCred(r.Context())
+			if cred == nil || !(slices.Contains(uids, cred.UID) || slices.Contains(gids, cred.GID)) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
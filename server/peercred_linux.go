@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredSupported returns nil, as peer credentials are supported on Linux.
+func peerCredSupported() error {
+	return nil
+}
+
+// peerCredentials returns the credentials of the process connected by c,
+// as given by SO_PEERCRED.
+func peerCredentials(c *net.UnixConn) (*PeerCred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	} else if credErr != nil {
+		return nil, credErr
+	}
+
+	return &PeerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+var errPeerCredUnsupported = errors.New("peer credentials are only supported on linux")
+
+// peerCredSupported returns an error, as peer credentials are only supported
+// on Linux.
+func peerCredSupported() error {
+	return errPeerCredUnsupported
+}
+
+// peerCredentials is only supported on Linux.
+func peerCredentials(c *net.UnixConn) (*PeerCred, error) {
+	return nil, errPeerCredUnsupported
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+	"github.com/jamescun/wg-api/store"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a CEL expression evaluated against every mutation of the Peers of
+// the device, the mutation is rejected unless the expression is true.
+//
+// Expressions are given the name of the method as method, and the Peer as
+// peer with the fields public_key, has_preshared_key, endpoint,
+// persistent_keep_alive (a duration), allowed_ips, metadata and template.
+// The function cidr_within(ip, range) returns true if ip, an address or
+// range, is entirely within range. A Rule that cannot be evaluated, such as
+// one referencing metadata the Peer does not have, rejects the mutation.
+type Rule struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+
+	// Message is returned to the client when the rule rejects a mutation.
+	Message string `yaml:"message"`
+
+	// Methods, if set, limits the rule to these methods, such as AddPeer or
+	// RemovePeer. Operations of a batch are evaluated as the method they
+	// describe, and each Peer removed by RemoveAllPeers as RemovePeer.
+	Methods []string `yaml:"methods"`
+
+	program cel.Program
+}
+
+// Policy is a set of Rules that every mutation must satisfy.
+type Policy struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// LoadPolicy reads and compiles a Policy from a YAML (or JSON) file.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(Policy)
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+
+	env, err := policyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range policy.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d: rule is empty", i)
+		} else if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+
+		if err := rule.compile(env); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// policyEnv returns the CEL environment Rules are compiled in.
+func policyEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.OptionalTypes(),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("peer", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Function("cidr_within",
+			cel.Overload("cidr_within_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(cidrWithin),
+			),
+		),
+	)
+}
+
+func (r *Rule) compile(env *cel.Env) error {
+	if strings.TrimSpace(r.Expression) == "" {
+		return fmt.Errorf("expression is required")
+	}
+
+	ast, iss := env.Compile(r.Expression)
+	if iss.Err() != nil {
+		return iss.Err()
+	} else if ast.OutputType() != cel.BoolType {
+		return fmt.Errorf("expression must return bool, not %s", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return err
+	}
+
+	r.program = program
+
+	return nil
+}
+
+// cidrWithin implements the CEL function cidr_within.
+func cidrWithin(lhs, rhs ref.Val) ref.Val {
+	ip, ok := lhs.Value().(string)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(lhs)
+	}
+
+	within, ok := rhs.Value().(string)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(rhs)
+	}
+
+	n, err := parseIPOrCIDR(ip)
+	if err != nil {
+		return types.NewErr("cidr_within: %s", err)
+	}
+
+	_, parent, err := net.ParseCIDR(within)
+	if err != nil {
+		return types.NewErr("cidr_within: %s", err)
+	}
+
+	return types.Bool(netWithinAny(n, []*net.IPNet{parent}))
+}
+
+// parseIPOrCIDR parses either an address or a range, an address is treated
+// as a range containing only itself.
+func parseIPOrCIDR(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// check evaluates every Rule that applies to method against peer, returning
+// an error for the first Rule that is not satisfied.
+func (p *Policy) check(method string, peer map[string]interface{}) error {
+	vars := map[string]interface{}{"method": method, "peer": peer}
+
+	for _, rule := range p.Rules {
+		if len(rule.Methods) > 0 && !stringInSlice(method, rule.Methods) {
+			continue
+		}
+
+		out, _, err := rule.program.Eval(vars)
+		if err != nil {
+			return policyError(rule, fmt.Sprintf("policy rule %q could not be evaluated: %s", rule.Name, err))
+		}
+
+		if ok, _ := out.Value().(bool); !ok {
+			message := rule.Message
+			if message == "" {
+				message = "request rejected by policy rule " + rule.Name
+			}
+
+			return policyError(rule, message)
+		}
+	}
+
+	return nil
+}
+
+// policyError returns the error given when a mutation is rejected by rule.
+func policyError(rule *Rule, message string) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodePolicy, message, &client.ErrorData{Policy: rule.Name})
+}
+
+// checkAddPeer evaluates the Policy of the Server, if any, against a Peer
+// being added. The request must already be valid.
+func (s *Server) checkAddPeer(req *client.AddPeerRequest) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	var keepAlive time.Duration
+	if req.PersistentKeepAlive != "" {
+		keepAlive, _ = time.ParseDuration(req.PersistentKeepAlive)
+	}
+
+	return s.policy.check("AddPeer", map[string]interface{}{
+		"public_key":            req.PublicKey,
+		"has_preshared_key":     req.PresharedKey != "" && req.PresharedKey != client.NoPresharedKey,
+		"endpoint":              req.Endpoint,
+		"persistent_keep_alive": keepAlive,
+		"allowed_ips":           nonNilStrings(req.AllowedIPs),
+		"metadata":              nonNilMap(req.Metadata),
+		"template":              req.Template,
+	})
+}
+
+// checkRemovePeer evaluates the Policy of the Server, if any, against a Peer
+// being removed, including the information stored about it.
+func (s *Server) checkRemovePeer(ctx context.Context, req *client.RemovePeerRequest) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	stored, err := s.store.GetPeer(ctx, req.PublicKey)
+	if errors.Is(err, store.ErrNotFound) {
+		stored = &store.Peer{PublicKey: req.PublicKey}
+	} else if err != nil {
+		return storeError("could not get peer metadata", err)
+	}
+
+	return s.policy.check("RemovePeer", map[string]interface{}{
+		"public_key":            req.PublicKey,
+		"has_preshared_key":     false,
+		"endpoint":              "",
+		"persistent_keep_alive": time.Duration(0),
+		"allowed_ips":           []string{},
+		"metadata":              nonNilMap(stored.Metadata),
+		"template":              stored.Template,
+	})
+}
+
+// checkSetPeerMetadata evaluates the Policy of the Server, if any, against
+// the metadata of peer being set, along with its configuration on the device.
+func (s *Server) checkSetPeerMetadata(peer *wgtypes.Peer, stored *store.Peer, metadata map[string]string) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	var endpoint string
+	if peer.Endpoint != nil {
+		endpoint = peer.Endpoint.String()
+	}
+
+	allowedIPs := make([]string, len(peer.AllowedIPs))
+	for i, aip := range peer.AllowedIPs {
+		allowedIPs[i] = aip.String()
+	}
+
+	return s.policy.check("SetPeerMetadata", map[string]interface{}{
+		"public_key":            peer.PublicKey.String(),
+		"has_preshared_key":     peer.PresharedKey != wgtypes.Key{},
+		"endpoint":              endpoint,
+		"persistent_keep_alive": peer.PersistentKeepaliveInterval,
+		"allowed_ips":           allowedIPs,
+		"metadata":              nonNilMap(metadata),
+		"template":              stored.Template,
+	})
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
+
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+
+	return m
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"sync"
+
+	"github.com/jamescun/wg-api/store"
+)
+
+// Pool is an IPAM that allocates a single address to each Peer from each of
+// its ranges, keeping Leases in a Store. The network address, the first
+// address, conventionally used by the device itself, and for IPv4 the
+// broadcast address of each range are never allocated.
+type Pool struct {
+	mu     sync.Mutex
+	leases store.Leases
+	ranges []*net.IPNet
+}
+
+var (
+	_ IPAM          = (*Pool)(nil)
+	_ LeaseLister   = (*Pool)(nil)
+	_ ExcludingIPAM = (*Pool)(nil)
+)
+
+// NewPool returns an IPAM allocating from each of ranges.
+func NewPool(leases store.Leases, ranges ...string) (*Pool, error) {
+	p := &Pool{leases: leases}
+
+	for _, r := range ranges {
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("range %q is not valid: %w", r, err)
+		}
+
+		if ones, bits := n.Mask.Size(); bits-ones < 2 {
+			return nil, fmt.Errorf("range %q is too small", r)
+		}
+
+		p.ranges = append(p.ranges, n)
+	}
+
+	return p, nil
+}
+
+// Allocate returns the addresses leased to a Peer, allocating one from each
+// range it does not yet hold a lease in.
+func (p *Pool) Allocate(ctx context.Context, publicKey string) ([]string, error) {
+	return p.AllocateExcluding(ctx, publicKey, nil)
+}
+
+// AllocateExcluding is Allocate, but never allocates any of the single
+// addresses of exclude, such as the allowed ips of Peers added without the
+// Pool. Wider ranges of exclude are ignored, as the device routes a single
+// address to its own Peer rather than the Peer of a wider range containing
+// it.
+func (p *Pool) AllocateExcluding(ctx context.Context, publicKey string, exclude []net.IPNet) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	leases, err := p.leases.ListLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leased := make(map[string]bool, len(leases))
+	held := make(map[string]bool)
+
+	for _, lease := range leases {
+		leased[lease.Prefix] = true
+
+		if lease.PublicKey == publicKey {
+			held[lease.Prefix] = true
+		}
+	}
+
+	for _, n := range exclude {
+		if ones, bits := n.Mask.Size(); ones == bits {
+			leased[n.String()] = true
+		}
+	}
+
+	var allocated []string
+
+	for _, r := range p.ranges {
+		prefix, ok := heldWithin(held, r)
+		if !ok {
+			prefix, err = nextFree(r, leased)
+			if err == nil {
+				err = p.leases.PutLease(ctx, &store.Lease{Prefix: prefix, PublicKey: publicKey})
+			}
+			if err != nil {
+				// leases taken from other ranges are only released if the
+				// peer held none before, such that its existing addresses
+				// are not lost.
+				if len(held) == 0 && len(allocated) > 0 {
+					p.leases.DeleteLeases(ctx, publicKey)
+				}
+
+				return nil, err
+			}
+
+			leased[prefix] = true
+		}
+
+		allocated = append(allocated, prefix)
+	}
+
+	return allocated, nil
+}
+
+// Release removes every lease of a Peer.
+func (p *Pool) Release(ctx context.Context, publicKey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.leases.DeleteLeases(ctx, publicKey)
+}
+
+// Leased returns the addresses leased to a Peer.
+func (p *Pool) Leased(ctx context.Context, publicKey string) ([]string, error) {
+	leases, err := p.leases.ListLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var held []string
+	for _, lease := range leases {
+		if lease.PublicKey == publicKey {
+			held = append(held, lease.Prefix)
+		}
+	}
+
+	return held, nil
+}
+
+// heldWithin returns the prefix of a lease held within r, if any.
+func heldWithin(held map[string]bool, r *net.IPNet) (string, bool) {
+	for prefix := range held {
+		ip, _, err := net.ParseCIDR(prefix)
+		if err == nil && r.Contains(ip) {
+			return prefix, true
+		}
+	}
+
+	return "", false
+}
+
+// errPoolExhausted is returned when every address of a range is leased.
+var errPoolExhausted = errors.New("no addresses available")
+
+// nextFree returns the lowest address of r that is not leased, as a single
+// address prefix.
+func nextFree(r *net.IPNet, leased map[string]bool) (string, error) {
+	ones, bits := r.Mask.Size()
+
+	base := new(big.Int).SetBytes(r.IP)
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+
+	last := new(big.Int).Sub(size, big.NewInt(1))
+	if bits == 32 {
+		// broadcast address
+		last.Sub(last, big.NewInt(1))
+	}
+
+	for i := big.NewInt(2); i.Cmp(last) <= 0; i.Add(i, big.NewInt(1)) {
+		ip := make(net.IP, bits/8)
+		new(big.Int).Add(base, i).FillBytes(ip)
+
+		prefix := (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String()
+		if !leased[prefix] {
+			return prefix, nil
+		}
+	}
+
+	return "", fmt.Errorf("range %s: %w", r, errPoolExhausted)
+}
+
+// RangeUsage is how many of the addresses of a range of a Pool are leased.
+type RangeUsage struct {
+	Range string
+
+	// Leased and Size are the number of addresses leased and that may be
+	// allocated. They are floats, as an IPv6 range may have more addresses
+	// than any integer.
+	Leased float64
+	Size   float64
+}
+
+// Usage returns how many of the addresses of each range are leased.
+func (p *Pool) Usage(ctx context.Context) ([]RangeUsage, error) {
+	leases, err := p.leases.ListLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]RangeUsage, len(p.ranges))
+
+	for i, r := range p.ranges {
+		ones, bits := r.Mask.Size()
+
+		// the network and first addresses, and for IPv4 the broadcast
+		// address, are never allocated.
+		size := math.Exp2(float64(bits-ones)) - 2
+		if bits == 32 {
+			size--
+		}
+
+		usage[i] = RangeUsage{Range: r.String(), Size: size}
+
+		for _, lease := range leases {
+			if ip, _, err := net.ParseCIDR(lease.Prefix); err == nil && r.Contains(ip) {
+				usage[i].Leased++
+			}
+		}
+	}
+
+	return usage, nil
+}
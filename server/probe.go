@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// probeTimeout is how long each probe of an endpoint waits for a reply.
+const probeTimeout = time.Second
+
+func validateProbePeerEndpointRequest(req *client.ProbePeerEndpointRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Count < 0 || req.Count > client.MaxProbeCount {
+		return invalidParam("count", strconv.Itoa(req.Count), "count must be between 0 and "+strconv.Itoa(client.MaxProbeCount))
+	}
+
+	return nil
+}
+
+// ProbePeerEndpoint sends ICMP echo requests to the host of the endpoint of
+// a Peer, and a datagram to its port, reporting whether they were answered.
+func (s *Server) ProbePeerEndpoint(ctx context.Context, req *client.ProbePeerEndpointRequest) (*client.ProbePeerEndpointResponse, error) {
+	if err := validateProbePeerEndpointRequest(req); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := wgtypes.ParseKey(req.PublicKey)
+	if err != nil {
+		return nil, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
+	}
+
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := idx.peer(publicKey)
+	if peer == nil {
+		return nil, peerNotFoundError(req.PublicKey)
+	} else if peer.Endpoint == nil {
+		return nil, invalidParam("public_key", req.PublicKey, "peer has no endpoint")
+	}
+
+	count := req.Count
+	if count == 0 {
+		count = client.DefaultProbeCount
+	}
+
+	probe := &client.EndpointProbe{
+		Endpoint:  peer.Endpoint.String(),
+		Connected: !peer.LastHandshakeTime.IsZero() && time.Since(peer.LastHandshakeTime) < connectedTimeout,
+		Sent:      count,
+	}
+
+	unreachable := make(chan bool, 1)
+	go func() { unreachable <- probePort(ctx, peer.Endpoint) }()
+
+	var rtt time.Duration
+
+	probe.Received, rtt, err = pingHost(ctx, peer.Endpoint.IP, count)
+	if err != nil {
+		probe.Sent = 0
+		probe.Error = err.Error()
+	} else if probe.Received > 0 {
+		probe.RTTMS = float64(rtt.Microseconds()) / 1000
+	}
+
+	probe.PortUnreachable = <-unreachable
+	probe.Reachable = probe.Received > 0 || probe.PortUnreachable
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &client.ProbePeerEndpointResponse{Probe: probe}, nil
+}
+
+// probePort sends a datagram that WireGuard discards to endpoint, returning
+// whether its host replied that nothing is listening on its port.
+func probePort(ctx context.Context, endpoint *net.UDPAddr) bool {
+	conn, err := net.DialUDP("udp", nil, endpoint)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(probeDeadline(ctx))
+
+	// a message of type zero is not a WireGuard message.
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// probeDeadline returns when a probe stops waiting for a reply.
+func probeDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(probeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	return deadline
+}
+
+// icmpConn is a socket sending ICMP echo requests of a single family. Its
+// requests carry random data, such that replies to other sockets are ignored.
+type icmpConn struct {
+	*icmp.PacketConn
+
+	unprivileged bool
+	proto        int
+	echo         icmp.Type
+	reply        icmp.Type
+	data         []byte
+}
+
+// listenICMP opens a socket sending ICMP echo requests to IPv4 hosts, or to
+// IPv6 hosts if v6 is set, unprivileged if the host permits it, otherwise raw
+// requiring CAP_NET_RAW.
+func listenICMP(v6 bool) (*icmpConn, error) {
+	c := &icmpConn{proto: 1, echo: ipv4.ICMPTypeEcho, reply: ipv4.ICMPTypeEchoReply, data: make([]byte, 16)}
+	network, raw, addr := "udp4", "ip4:icmp", "0.0.0.0"
+
+	if v6 {
+		c.proto, c.echo, c.reply = 58, ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		network, raw, addr = "udp6", "ip6:ipv6-icmp", "::"
+	}
+
+	rand.Read(c.data)
+
+	var err error
+
+	c.PacketConn, err = icmp.ListenPacket(network, addr)
+	if err == nil {
+		c.unprivileged = true
+		return c, nil
+	}
+
+	c.PacketConn, err = icmp.ListenPacket(raw, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// send sends an ICMP echo request of seq to ip, its data padded by pad
+// bytes.
+func (c *icmpConn) send(ip net.IP, seq uint16, pad int) error {
+	msg, err := (&icmp.Message{
+		Type: c.echo,
+		Body: &icmp.Echo{ID: int(seq), Seq: int(seq), Data: append(c.data, make([]byte, pad)...)},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if c.unprivileged {
+		dst = &net.UDPAddr{IP: ip}
+	}
+
+	_, err = c.WriteTo(msg, dst)
+	return err
+}
+
+// receive returns the sequence of the next reply to an echo request of the
+// socket, or false once deadline passes.
+func (c *icmpConn) receive(deadline time.Time) (uint16, bool, error) {
+	c.SetReadDeadline(deadline)
+	buf := make([]byte, 1<<16)
+
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if isTimeout(err) {
+			return 0, false, nil
+		} else if err != nil {
+			return 0, false, err
+		}
+
+		reply, err := icmp.ParseMessage(c.proto, buf[:n])
+		if err != nil || reply.Type != c.reply {
+			continue
+		}
+
+		// the identifier of unprivileged echo requests is chosen by the
+		// host, so replies are matched by their sequence and data.
+		if echo, ok := reply.Body.(*icmp.Echo); ok && bytes.HasPrefix(echo.Data, c.data) {
+			return uint16(echo.Seq), true, nil
+		}
+	}
+}
+
+// ping sends an ICMP echo request of seq to ip, padded by pad bytes, waiting
+// for its reply until deadline, returning whether it was replied to.
+func (c *icmpConn) ping(ip net.IP, seq uint16, pad int, deadline time.Time) (bool, error) {
+	if err := c.send(ip, seq, pad); err != nil {
+		return false, err
+	}
+
+	for {
+		replied, ok, err := c.receive(deadline)
+		if err != nil || !ok {
+			return false, err
+		} else if replied == seq {
+			return true, nil
+		}
+	}
+}
+
+// pingHost sends count ICMP echo requests to ip one at a time, returning how
+// many were replied to and their average round trip time.
+func pingHost(ctx context.Context, ip net.IP, count int) (int, time.Duration, error) {
+	c, err := listenICMP(ip.To4() == nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer c.Close()
+
+	var received int
+	var total time.Duration
+
+	for seq := 1; seq <= count && ctx.Err() == nil; seq++ {
+		start := time.Now()
+
+		ok, err := c.ping(ip, uint16(seq), 0, probeDeadline(ctx))
+		if err != nil {
+			return 0, 0, err
+		} else if ok {
+			received++
+			total += time.Since(start)
+		}
+	}
+
+	if received == 0 {
+		return 0, 0, nil
+	}
+
+	return received, total / time.Duration(received), nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
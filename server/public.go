@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// PublicStatsHandler serves the aggregate statistics of the Peers of the
+// device as JSON, such as how many are connected and the total transferred
+// with them. They identify no Peer, so they require no authentication and
+// may be fetched by status pages from any origin.
+func PublicStatsHandler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		stats, err := s.publicStats(r.Context(), time.Now().UTC())
+		if err != nil {
+			log.Printf("error: stats: %s\n", err)
+			http.Error(w, "could not get stats", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
+
+// publicStats aggregates the Peers of the device, from the cache of the
+// device such that frequent requests do not each dump it.
+func (s *Server) publicStats(ctx context.Context, now time.Time) (*client.PublicStats, error) {
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &client.PublicStats{Peers: len(idx.dev.Peers), Time: now}
+
+	var ages []float64
+
+	for _, peer := range idx.dev.Peers {
+		stats.ReceiveBytes += peer.ReceiveBytes
+		stats.TransmitBytes += peer.TransmitBytes
+
+		if peer.LastHandshakeTime.IsZero() {
+			continue
+		}
+
+		age := now.Sub(peer.LastHandshakeTime)
+		if age < connectedTimeout {
+			stats.ConnectedPeers++
+		}
+
+		ages = append(ages, math.Max(age.Seconds(), 0))
+	}
+
+	if len(ages) > 0 {
+		sort.Float64s(ages)
+
+		stats.HandshakeAge = &client.Percentiles{
+			P50: percentile(ages, 0.5),
+			P90: percentile(ages, 0.9),
+			P99: percentile(ages, 0.99),
+		}
+	}
+
+	if s.rates.interval > 0 {
+		for _, rate := range s.rates.all(s.rates.window) {
+			stats.ReceiveRate += rate.ReceiveRate()
+			stats.TransmitRate += rate.TransmitRate()
+		}
+	}
+
+	return stats, nil
+}
+
+// percentile returns the nearest rank percentile p of sorted, which must not
+// be empty.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
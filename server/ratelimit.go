@@ -0,0 +1,195 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitSweepInterval is how often the buckets of clients that have not
+// made a request for long enough to refill them are forgotten.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter is a token bucket for each source ip and each token requests
+// are made with, such that a misbehaving client cannot hammer the device
+// with configuration changes.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing each client n requests every
+// period, of which all n may be made at once.
+func NewRateLimiter(n int, period time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:     rate.Every(period / time.Duration(n)),
+		burst:     n,
+		buckets:   make(map[string]*rate.Limiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// ParseRateLimit returns the RateLimiter of s, a number of requests per
+// period, one of s (second), min (minute) or h (hour), such as 100/min.
+func ParseRateLimit(s string) (*RateLimiter, error) {
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, fmt.Errorf("rate limit must be requests per period, such as 100/min")
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid number of requests %q", count)
+	}
+
+	var period time.Duration
+	switch unit {
+	case "s", "sec", "second":
+		period = time.Second
+	case "m", "min", "minute":
+		period = time.Minute
+	case "h", "hour":
+		period = time.Hour
+	default:
+		return nil, fmt.Errorf("unknown period %q, must be one of s, min or h", unit)
+	}
+
+	return NewRateLimiter(n, period), nil
+}
+
+// allow takes a request from the bucket of every one of keys, only if each
+// has one available, otherwise none are taken and how long until they all
+// do is returned. A client refused by one bucket therefore does not drain
+// the others, such as that of an ip shared with other clients.
+func (l *RateLimiter) allow(keys []string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	reservations := make([]*rate.Reservation, len(keys))
+	var wait time.Duration
+
+	for i, key := range keys {
+		bucket, ok := l.buckets[key]
+		if !ok {
+			bucket = rate.NewLimiter(l.limit, l.burst)
+			l.buckets[key] = bucket
+		}
+
+		reservations[i] = bucket.ReserveN(now, 1)
+		wait = max(wait, reservations[i].DelayFrom(now))
+	}
+
+	if wait > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+
+		return false, wait
+	}
+
+	return true, 0
+}
+
+// rateLimitKeys returns the buckets of a request made from remoteAddr with
+// header, that of its source ip and that of its token, if any.
+func rateLimitKeys(remoteAddr string, header http.Header) []string {
+	keys := []string{"ip:" + remoteHost(remoteAddr)}
+
+	if token := strings.TrimSpace(strings.TrimPrefix(header.Get("Authorization"), "Token ")); token != "" {
+		keys = append(keys, fmt.Sprintf("token:%x", sha256.Sum256([]byte(token))))
+	}
+
+	return keys
+}
+
+// sweep forgets the buckets that have refilled, which are the same as new
+// ones, such that clients seen once are not kept forever. l.mu must be held.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.TokensAt(now) >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+// RateLimit refuses requests of next with a RateLimited error once the
+// source ip, or the token in the Authorization header, of the request has
+// exhausted its bucket of l, such that neither many clients sharing a token
+// nor one client cycling through tokens may exceed it.
+func RateLimit(next jsonrpc.Handler, l *RateLimiter) jsonrpc.Handler {
+	if l == nil {
+		return next
+	}
+
+	return jsonrpc.HandlerFunc(func(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+		if ok, delay := l.allow(rateLimitKeys(r.RemoteAddr(), r.Header()), time.Now()); !ok {
+			w.Write(rateLimitedError(delay))
+			return
+		}
+
+		next.ServeJSONRPC(w, r)
+	})
+}
+
+// RateLimitHTTP refuses requests of next with 429 Too Many Requests once
+// they have exhausted the buckets of l, as RateLimit, for the endpoints
+// served alongside the JSON-RPC API such as /export, /peers and /events.
+// Retry-After is the number of seconds until another is allowed.
+func RateLimitHTTP(next http.Handler, l *RateLimiter) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, delay := l.allow(rateLimitKeys(r.RemoteAddr, r.Header), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, rateLimitedError(delay).Message, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitedError returns the error given to a client that must wait delay
+// before making another request.
+func rateLimitedError(delay time.Duration) *jsonrpc.Error {
+	delay = delay.Round(time.Millisecond)
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+
+	return jsonrpc.ServerError(client.ErrCodeRateLimited, fmt.Sprintf("rate limit exceeded, retry in %s", delay), &client.ErrorData{Retryable: true})
+}
+
+// remoteHost returns the host of addr, or addr itself if it has no port,
+// such as the address of a unix socket.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
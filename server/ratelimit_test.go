@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		Name string
+
+		// Keys are those of each request made at once, in turn.
+		Keys    [][]string
+		Allowed []bool
+	}{
+		{
+			Name:    "Burst",
+			Keys:    [][]string{{"ip:a"}, {"ip:a"}, {"ip:a"}},
+			Allowed: []bool{true, true, false},
+		},
+		{
+			Name:    "SeparateClients",
+			Keys:    [][]string{{"ip:a"}, {"ip:a"}, {"ip:b"}},
+			Allowed: []bool{true, true, true},
+		},
+		{
+			// a client refused for its token does not use up the allowance
+			// of its ip, which other tokens behind it share.
+			Name: "TokenRefusedKeepsIP",
+			Keys: [][]string{
+				{"ip:a", "token:x"},
+				{"ip:b", "token:x"},
+				{"ip:a", "token:x"},
+				{"ip:a", "token:y"},
+			},
+			Allowed: []bool{true, true, false, true},
+		},
+		{
+			Name: "IPRefusedKeepsToken",
+			Keys: [][]string{
+				{"ip:a", "token:x"},
+				{"ip:a", "token:y"},
+				{"ip:a", "token:z"},
+				{"ip:b", "token:z"},
+			},
+			Allowed: []bool{true, true, false, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			l := NewRateLimiter(2, time.Minute)
+			now := time.Now()
+
+			for i, keys := range test.Keys {
+				ok, delay := l.allow(keys, now)
+				if ok != test.Allowed[i] {
+					t.Errorf("request %d: expected allowed %t, got %t", i, test.Allowed[i], ok)
+				} else if !ok && delay <= 0 {
+					t.Errorf("request %d: expected delay, got %s", i, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitHTTP(t *testing.T) {
+	h := RateLimitHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), NewRateLimiter(1, time.Minute))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+
+		if w.Code != want {
+			t.Errorf("request %d: expected status %d, got %d", i, want, w.Code)
+		}
+
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Errorf("request %d: expected Retry-After 60, got %q", i, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// defaultRateInterval is how often the transfer counters of every Peer
+	// are sampled, unless configured otherwise.
+	defaultRateInterval = 10 * time.Second
+
+	// defaultRateWindow is the period transfer rates are averaged over,
+	// unless configured otherwise.
+	defaultRateWindow = time.Minute
+)
+
+// rateSample is the transfer counters of a Peer at a point in time.
+type rateSample struct {
+	at            time.Time
+	receiveBytes  int64
+	transmitBytes int64
+}
+
+// rates samples the transfer counters of every Peer, such that the rate of
+// transfer of each can be given without clients computing it themselves.
+type rates struct {
+	mu       sync.Mutex
+	interval time.Duration
+	window   time.Duration
+
+	// peers are the samples of each Peer within the window, oldest first.
+	// The first sample may be older than the window, such that rates are
+	// averaged over the whole window.
+	peers map[wgtypes.Key][]rateSample
+}
+
+// peerRate is the transfer of a Peer over the rate window.
+type peerRate struct {
+	// Period is the time between the first and last samples.
+	Period time.Duration
+
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// ReceiveRate returns the bytes per second received from the Peer.
+func (r peerRate) ReceiveRate() float64 {
+	return float64(r.ReceiveBytes) / r.Period.Seconds()
+}
+
+// TransmitRate returns the bytes per second transmitted to the Peer.
+func (r peerRate) TransmitRate() float64 {
+	return float64(r.TransmitBytes) / r.Period.Seconds()
+}
+
+// WithRates configures how often the transfer counters of every Peer are
+// sampled, and the window the rate of transfer is averaged over, given by
+// ListPeers and GetPeer. By default every 10 seconds over 1 minute. A zero
+// interval disables sampling.
+func WithRates(interval, window time.Duration) Option {
+	return func(s *Server) {
+		s.rates.interval = interval
+		s.rates.window = window
+	}
+}
+
+// runRates samples the transfer counters of every Peer each rate interval,
+// until ctx is cancelled.
+func (s *Server) runRates(ctx context.Context) {
+	t := time.NewTicker(s.rates.interval)
+	defer t.Stop()
+
+	for {
+		dev, err := s.wg.Device(s.deviceName)
+		if err != nil {
+			log.Printf("error: rates: could not get WireGuard device: %s\n", err)
+		} else {
+			s.rates.record(time.Now(), dev.Peers)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// record adds a sample of the counters of each Peer, discarding samples
+// outside of the window and the samples of Peers no longer on the device.
+func (r *rates) record(now time.Time, peers []wgtypes.Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := make(map[wgtypes.Key][]rateSample, len(peers))
+	cutoff := now.Add(-r.window)
+
+	for _, peer := range peers {
+		next := rateSample{at: now, receiveBytes: peer.ReceiveBytes, transmitBytes: peer.TransmitBytes}
+		samples := r.peers[peer.PublicKey]
+
+		// counters are reset if the peer is removed and added again.
+		if n := len(samples); n > 0 && (next.receiveBytes < samples[n-1].receiveBytes || next.transmitBytes < samples[n-1].transmitBytes) {
+			samples = samples[:0]
+		}
+
+		samples = append(samples, next)
+
+		var i int
+		for i < len(samples)-1 && !samples[i+1].at.After(cutoff) {
+			i++
+		}
+
+		if i > 0 {
+			samples = append(samples[:0], samples[i:]...)
+		}
+
+		current[peer.PublicKey] = samples
+	}
+
+	r.peers = current
+}
+
+// rate returns the transfer of the Peer with publicKey over the window, or
+// false if it has not been sampled at least twice.
+func (r *rates) rate(publicKey wgtypes.Key) (peerRate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return rateOver(r.peers[publicKey], r.window)
+}
+
+// all returns the transfer of every Peer sampled at least twice over window,
+// which must not be greater than the rate window.
+func (r *rates) all(window time.Duration) map[wgtypes.Key]peerRate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make(map[wgtypes.Key]peerRate, len(r.peers))
+
+	for publicKey, samples := range r.peers {
+		if rate, ok := rateOver(samples, window); ok {
+			all[publicKey] = rate
+		}
+	}
+
+	return all
+}
+
+// rateOver returns the transfer between the last sample and the newest sample
+// at least window before it, or the oldest sample if there is none.
+func rateOver(samples []rateSample, window time.Duration) (peerRate, bool) {
+	if len(samples) < 2 {
+		return peerRate{}, false
+	}
+
+	last := samples[len(samples)-1]
+	cutoff := last.at.Add(-window)
+
+	first := samples[0]
+	for _, sample := range samples[1 : len(samples)-1] {
+		if sample.at.After(cutoff) {
+			break
+		}
+
+		first = sample
+	}
+
+	return peerRate{
+		Period:        last.at.Sub(first.at),
+		ReceiveBytes:  last.receiveBytes - first.receiveBytes,
+		TransmitBytes: last.transmitBytes - first.transmitBytes,
+	}, true
+}
+
+// attachRates sets the rate of transfer of each Peer, if it is known.
+func (s *Server) attachRates(peers []*client.Peer) {
+	for _, peer := range peers {
+		s.setRate(peer)
+	}
+}
+
+// setRate sets the rate of transfer of peer, if it is known.
+func (s *Server) setRate(peer *client.Peer) {
+	if s.rates.interval <= 0 {
+		return
+	}
+
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return
+	}
+
+	if r, ok := s.rates.rate(publicKey); ok {
+		peer.ReceiveRate = r.ReceiveRate()
+		peer.TransmitRate = r.TransmitRate()
+	}
+}
+
+func validateTopTalkersRequest(req *client.TopTalkersRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Limit < 0 {
+		return invalidParam("limit", strconv.Itoa(req.Limit), "limit must not be negative")
+	}
+
+	switch req.By {
+	case "", client.TopTalkersByRate, client.TopTalkersByBytes:
+	default:
+		return invalidParam("by", req.By, "by must be one of rate or bytes")
+	}
+
+	if req.Window != "" {
+		if d, err := time.ParseDuration(req.Window); err != nil {
+			return invalidParam("window", req.Window, "invalid duration: "+err.Error())
+		} else if d <= 0 {
+			return invalidParam("window", req.Window, "window must be positive")
+		}
+	}
+
+	return nil
+}
+
+// TopTalkers returns the Peers that have transferred the most over a recent
+// window, from the samples of their transfer counters.
+func (s *Server) TopTalkers(ctx context.Context, req *client.TopTalkersRequest) (*client.TopTalkersResponse, error) {
+	if err := validateTopTalkersRequest(req); err != nil {
+		return nil, err
+	}
+
+	if s.rates.interval <= 0 {
+		return nil, jsonrpc.MethodNotFound("transfer of peers is not sampled by this server", &client.ErrorData{Field: "method", Value: "TopTalkers"})
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = client.DefaultTopTalkers
+	}
+
+	window := s.rates.window
+	if req.Window != "" {
+		d, _ := time.ParseDuration(req.Window)
+		window = min(d, window)
+	}
+
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type ranked struct {
+		peer   *wgtypes.Peer
+		talker *client.Talker
+	}
+
+	var all []ranked
+
+	for publicKey, rate := range s.rates.all(window) {
+		peer := idx.peer(publicKey)
+		if peer == nil {
+			continue
+		}
+
+		all = append(all, ranked{peer: peer, talker: &client.Talker{
+			ReceiveBytes:  rate.ReceiveBytes,
+			TransmitBytes: rate.TransmitBytes,
+			ReceiveRate:   rate.ReceiveRate(),
+			TransmitRate:  rate.TransmitRate(),
+		}})
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if a, b := all[i].talker.Score(req.By), all[j].talker.Score(req.By); a != b {
+			return a > b
+		}
+
+		return bytes.Compare(all[i].peer.PublicKey[:], all[j].peer.PublicKey[:]) < 0
+	})
+
+	if len(all) > limit {
+		all = all[:limit]
+	}
+
+	// only the Peers returned are converted, rather than every Peer of the
+	// device.
+	talkers := make([]*client.Talker, len(all))
+	peers := make([]*client.Peer, len(all))
+
+	for i, r := range all {
+		r.talker.Peer = peer2rpc(*r.peer)
+		talkers[i], peers[i] = r.talker, r.talker.Peer
+	}
+
+	if err := s.attachStored(ctx, peers); err != nil {
+		return nil, err
+	}
+
+	s.attachRates(peers)
+
+	return &client.TopTalkersResponse{Talkers: talkers, Window: window.String()}, nil
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// reapInterval is how often Peers are checked for expiry or exceeding their
+// quota.
+const reapInterval = time.Minute
+
+// Run performs the background tasks of the Server, such as removing expired
+// Peers and publishing Events, until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	go s.runEvents(ctx)
+	go s.runDevices(ctx)
+
+	if s.rates.interval > 0 {
+		go s.runRates(ctx)
+	}
+
+	if s.latency.interval > 0 {
+		go s.runLatency(ctx)
+	}
+
+	if s.alerts != nil && len(s.alerts.Rules) > 0 {
+		go s.runAlerts(ctx)
+	}
+
+	if s.ha != nil {
+		go s.runHA(ctx)
+	}
+
+	if s.publishing() {
+		go s.runConnectivity(ctx)
+	}
+
+	if compactor, ok := s.store.(store.Compactor); ok && s.retention != nil {
+		go s.runCompaction(ctx, compactor)
+	}
+
+	_, accounting := s.store.(store.Accounting)
+	if (s.publishing() && s.usageInterval > 0) || accounting {
+		go s.runUsage(ctx)
+	}
+
+	t := time.NewTicker(reapInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-t.C:
+			if !s.isLeader() {
+				continue
+			}
+
+			if err := s.reap(ctx); err != nil {
+				log.Printf("error: reaper: %s\n", err)
+			}
+		}
+	}
+}
+
+// reap removes any Peer that has expired or exceeded its quota, publishing a
+// peer.expired Event for each that expired.
+func (s *Server) reap(ctx context.Context) error {
+	stored, err := s.store.ListPeers(ctx)
+	if err != nil {
+		return err
+	}
+
+	limited := stored[:0]
+	for _, peer := range stored {
+		if !peer.ExpiresAt.IsZero() || peer.QuotaBytes > 0 {
+			limited = append(limited, peer)
+		}
+	}
+
+	if len(limited) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return err
+	}
+
+	index := indexPeers(dev.Peers)
+	now := time.Now()
+
+	var remove []wgtypes.PeerConfig
+	reasons := make(map[string]string)
+	expired := make(map[string]*client.Peer)
+
+	for _, peer := range limited {
+		publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+		if err != nil {
+			continue
+		}
+
+		i, ok := index[publicKey]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !peer.ExpiresAt.IsZero() && now.After(peer.ExpiresAt):
+			reasons[peer.PublicKey] = "expired"
+
+			expired[peer.PublicKey] = peer2rpc(dev.Peers[i])
+			setStored(expired[peer.PublicKey], peer)
+
+		case peer.QuotaBytes > 0 && dev.Peers[i].ReceiveBytes+dev.Peers[i].TransmitBytes+peer.TransferOffset >= peer.QuotaBytes:
+			reasons[peer.PublicKey] = "quota exceeded"
+
+		default:
+			continue
+		}
+
+		remove = append(remove, wgtypes.PeerConfig{PublicKey: publicKey, Remove: true})
+	}
+
+	if len(remove) == 0 {
+		return nil
+	}
+
+	res, err := s.apply(ctx, wgtypes.Config{Peers: remove}, nil, 0, false)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range res.Changes {
+		if res.DryRun {
+			log.Printf("info: reaper: would remove peer %s: %s (dry run)\n", change.PublicKey, reasons[change.PublicKey])
+			continue
+		}
+
+		log.Printf("info: reaper: removed peer %s: %s\n", change.PublicKey, reasons[change.PublicKey])
+
+		if peer, ok := expired[change.PublicKey]; ok {
+			s.publish(&client.Event{
+				Type:      client.EventPeerExpired,
+				Time:      time.Now().UTC(),
+				Device:    s.deviceName,
+				PublicKey: change.PublicKey,
+				Peer:      peer,
+			})
+		}
+
+		if err := s.forgetPeer(ctx, change.PublicKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// maxDeviceName is the longest name of a network interface, IFNAMSIZ on
+// Linux less its terminating NUL.
+const maxDeviceName = 15
+
+// WithDeviceAliases configures the Server to accept each alias in place of
+// the name of the device it maps to in requests, such that devices may be
+// addressed by stable logical names rather than the names of their network
+// interfaces.
+func WithDeviceAliases(aliases map[string]string) Option {
+	return func(s *Server) {
+		s.devices.aliases = make(map[string]string, len(aliases))
+		for alias, name := range aliases {
+			s.devices.aliases[alias] = name
+		}
+	}
+}
+
+// resolveDevice returns the name of the device name is an alias of, or name
+// if it is not an alias.
+func (s *Server) resolveDevice(name string) string {
+	s.devices.mu.Lock()
+	defer s.devices.mu.Unlock()
+
+	if device, ok := s.devices.aliases[name]; ok {
+		return device
+	}
+
+	return name
+}
+
+// deviceAliases returns the aliases of the device name, sorted. The caller
+// must hold s.devices.mu.
+func (s *Server) deviceAliases(name string) []string {
+	var aliases []string
+	for alias, device := range s.devices.aliases {
+		if device == name {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	sort.Strings(aliases)
+
+	return aliases
+}
+
+// Aliases returns the aliases of the device of the Server.
+func (s *Server) Aliases() []string {
+	s.devices.mu.Lock()
+	defer s.devices.mu.Unlock()
+
+	return s.deviceAliases(s.deviceName)
+}
+
+func validateRenameDeviceRequest(req *client.RenameDeviceRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Name == "" {
+		return invalidParam("name", "", "device is required")
+	}
+
+	if req.NewName == "" {
+		return invalidParam("new_name", "", "new name is required")
+	}
+
+	return validateDeviceName("new_name", req.NewName)
+}
+
+// validateDeviceName returns an error if name, of field, is not a valid name
+// of a network interface.
+func validateDeviceName(field, name string) error {
+	switch {
+	case len(name) > maxDeviceName:
+		return invalidParam(field, name, "name must be at most 15 characters")
+	case name == "." || name == ".." || strings.ContainsAny(name, "/: \t\n"):
+		return invalidParam(field, name, "name is not a valid interface name")
+	}
+
+	return nil
+}
+
+// RenameDevice renames a WireGuard device on the host other than that of the
+// Server, which is known by its name to the rest of WG-API. A device that is
+// up is brought down to be renamed, and up again afterwards. Any aliases of
+// the device are kept, such that it may still be addressed by them.
+func (s *Server) RenameDevice(ctx context.Context, req *client.RenameDeviceRequest) (*client.RenameDeviceResponse, error) {
+	if err := validateRenameDeviceRequest(req); err != nil {
+		return nil, err
+	}
+
+	name := s.resolveDevice(req.Name)
+	if name == s.deviceName {
+		return nil, invalidParam("name", req.Name, "the device served cannot be renamed, address it by an alias instead")
+	} else if _, err := s.wg.Device(req.NewName); err == nil {
+		return nil, invalidParam("new_name", req.NewName, "device already exists")
+	}
+
+	if _, err := s.wg.Device(name); errors.Is(err, os.ErrNotExist) {
+		return nil, invalidParam("name", req.Name, "device not found")
+	} else if err != nil {
+		return nil, deviceError("could not get WireGuard device "+name, err)
+	}
+
+	if req.DryRun || s.dryRun {
+		return &client.RenameDeviceResponse{OK: true, DryRun: true, Name: req.NewName}, nil
+	}
+
+	if err := renameLink(name, req.NewName); errors.Is(err, os.ErrExist) {
+		return nil, invalidParam("new_name", req.NewName, "a network interface with this name already exists")
+	} else if err != nil {
+		return nil, deviceError("could not rename device "+name, err)
+	}
+
+	s.devices.mu.Lock()
+	for alias, device := range s.devices.aliases {
+		if device == name {
+			s.devices.aliases[alias] = req.NewName
+		}
+	}
+	aliases := s.deviceAliases(req.NewName)
+	s.devices.mu.Unlock()
+
+	if err := s.scanDevices(); err != nil {
+		return nil, deviceError("could not list WireGuard devices", err)
+	}
+
+	return &client.RenameDeviceResponse{OK: true, Name: req.NewName, Aliases: aliases}, nil
+}
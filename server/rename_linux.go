@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// renameLink renames the network interface name to newName by rtnetlink. An
+// interface cannot be renamed while it is up, so one that is up is brought
+// down first and up again afterwards, even if it could not be renamed.
+func renameLink(name, newName string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	up := iface.Flags&net.FlagUp != 0
+
+	if up {
+		if err := setLink(c, iface.Index, 0, unix.IFF_UP, nil); err != nil {
+			return err
+		}
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, newName)
+
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	err = setLink(c, iface.Index, 0, 0, attrs)
+
+	if up {
+		if uerr := setLink(c, iface.Index, unix.IFF_UP, unix.IFF_UP, nil); err == nil {
+			err = uerr
+		}
+	}
+
+	return err
+}
+
+// setLink sets the flags of the link with index that are set in change to
+// those of flags, and any attributes given.
+func setLink(c *netlink.Conn, index int, flags, change uint32, attrs []byte) error {
+	// struct ifinfomsg, from linux/rtnetlink.h.
+	msg := make([]byte, unix.SizeofIfInfomsg, unix.SizeofIfInfomsg+len(attrs))
+	msg[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+	binary.NativeEndian.PutUint32(msg[8:], flags)
+	binary.NativeEndian.PutUint32(msg[12:], change)
+
+	_, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_NEWLINK,
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: append(msg, attrs...),
+	})
+
+	return err
+}
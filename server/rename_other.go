@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// renameLink is only supported on Linux.
+func renameLink(name, newName string) error {
+	return errors.New("renaming devices is only supported on linux")
+}
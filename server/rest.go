@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// restKey converts a public key given in a URL path as base64url, which
+// needs no escaping, to the standard base64 of WireGuard.
+var restKey = strings.NewReplacer("-", "+", "_", "/")
+
+// RESTHandler serves the peers and device of the JSON-RPC API h as resources
+// under /v1/, for clients that would rather not speak JSON-RPC. Each request
+// is made of h as the equivalent JSON-RPC request, such that it is subject to
+// the same middleware, such as AllowMethods and EnforceScopes, and errors are
+// returned as the JSON-RPC error of an HTTP status. The OpenAPI document of
+// the resources is served on /v1/openapi.json.
+func RESTHandler(h jsonrpc.Handler) http.Handler {
+	openapi, _ := json.Marshal(NewOpenAPI())
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openapi)
+	})
+
+	mux.HandleFunc("GET /v1/device", func(w http.ResponseWriter, r *http.Request) {
+		var res client.GetDeviceInfoResponse
+		if restCall(w, r, h, "GetDeviceInfo", &client.GetDeviceInfoRequest{}, &res) {
+			writeREST(w, http.StatusOK, res.Device)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/peers", func(w http.ResponseWriter, r *http.Request) {
+		req := new(client.ListPeersRequest)
+		req.After = r.URL.Query().Get("after")
+
+		for field, v := range map[string]*int{"limit": &req.Limit, "offset": &req.Offset} {
+			if !restQueryInt(w, r, field, v) {
+				return
+			}
+		}
+
+		var res client.ListPeersResponse
+		if restCall(w, r, h, "ListPeers", req, &res) {
+			writeREST(w, http.StatusOK, &res)
+		}
+	})
+
+	mux.HandleFunc("GET /v1/peers/{public_key}", func(w http.ResponseWriter, r *http.Request) {
+		req := &client.GetPeerRequest{PublicKey: restKey.Replace(r.PathValue("public_key"))}
+
+		var res client.GetPeerResponse
+		if !restCall(w, r, h, "GetPeer", req, &res) {
+			return
+		} else if res.Peer == nil {
+			writeRESTError(w, peerNotFoundError(req.PublicKey))
+			return
+		}
+
+		writeREST(w, http.StatusOK, res.Peer)
+	})
+
+	mux.HandleFunc("PUT /v1/peers/{public_key}", func(w http.ResponseWriter, r *http.Request) {
+		if hdr := r.Header.Get("Content-Type"); !strings.HasPrefix(hdr, jsonrpc.ContentType) {
+			http.Error(w, fmt.Sprintf("unknown content type %q", hdr), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		req := new(client.AddPeerRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+			writeRESTError(w, jsonrpc.InvalidParams("invalid request: "+err.Error(), &client.ErrorData{}))
+			return
+		}
+
+		// the public key of the body, if any, must be that of the path, such
+		// that a body copied from another Peer does not silently apply to it.
+		publicKey := restKey.Replace(r.PathValue("public_key"))
+		if req.PublicKey != "" && req.PublicKey != publicKey {
+			writeRESTError(w, invalidParam("public_key", req.PublicKey, "public key does not match that of the path"))
+			return
+		}
+
+		req.PublicKey = publicKey
+
+		var res client.AddPeerResponse
+		if restCall(w, r, h, "AddPeer", req, &res) {
+			writeREST(w, http.StatusOK, &res)
+		}
+	})
+
+	mux.HandleFunc("DELETE /v1/peers/{public_key}", func(w http.ResponseWriter, r *http.Request) {
+		req := &client.RemovePeerRequest{PublicKey: restKey.Replace(r.PathValue("public_key"))}
+
+		if v := r.URL.Query().Get("expected_generation"); v != "" {
+			gen, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeRESTError(w, invalidParam("expected_generation", v, "invalid expected generation"))
+				return
+			}
+
+			req.ExpectedGeneration = gen
+		}
+
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			dryRun, err := strconv.ParseBool(v)
+			if err != nil {
+				writeRESTError(w, invalidParam("dry_run", v, "invalid dry run"))
+				return
+			}
+
+			req.DryRun = dryRun
+		}
+
+		var res client.RemovePeerResponse
+		if restCall(w, r, h, "RemovePeer", req, &res) {
+			writeREST(w, http.StatusOK, &res)
+		}
+	})
+
+	return mux
+}
+
+// restQueryInt sets v to the integer of the query parameter field of r, if
+// given. If it is invalid, the error is written to w and false returned.
+func restQueryInt(w http.ResponseWriter, r *http.Request, field string, v *int) bool {
+	s := r.URL.Query().Get(field)
+	if s == "" {
+		return true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		writeRESTError(w, invalidParam(field, s, "invalid "+field))
+		return false
+	}
+
+	*v = n
+
+	return true
+}
+
+// restCall calls method of h with params, decoding its result into res. If
+// it fails, the error is written to w and false returned.
+func restCall(w http.ResponseWriter, r *http.Request, h jsonrpc.Handler, method string, params, res interface{}) bool {
+	data, err := json.Marshal(params)
+	if err != nil {
+		writeRESTError(w, jsonrpc.InternalError(err.Error(), &client.ErrorData{}))
+		return false
+	}
+
+	result, rpcErr := jsonrpc.Call(h, r, method, data)
+	if rpcErr != nil {
+		writeRESTError(w, rpcErr)
+		return false
+	}
+
+	// the result is only known to be of the type of res once encoded, as it
+	// may be of any handler, such as that of a proxy.
+	if data, err = json.Marshal(result); err == nil {
+		err = json.Unmarshal(data, res)
+	}
+
+	if err != nil {
+		writeRESTError(w, jsonrpc.InternalError(err.Error(), &client.ErrorData{}))
+		return false
+	}
+
+	return true
+}
+
+// writeREST writes v to w as JSON with status.
+func writeREST(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error: rest: %s\n", err)
+	}
+}
+
+// writeRESTError writes err to w as the error member of an object, with the
+// HTTP status of its code.
+func writeRESTError(w http.ResponseWriter, err *jsonrpc.Error) {
+	if err.Data == nil {
+		err.Data = &client.ErrorData{}
+	}
+
+	writeREST(w, restStatus(err.Code), map[string]interface{}{"error": err})
+}
+
+// restStatus returns the HTTP status of a JSON-RPC error code.
+func restStatus(code int) int {
+	switch code {
+	case -32600, -32602, -32700:
+		return http.StatusBadRequest
+
+	case -32601, client.ErrCodePeerNotFound:
+		return http.StatusNotFound
+
+	case client.ErrCodeConflict:
+		return http.StatusConflict
+
+	case client.ErrCodeUnauthorized, client.ErrCodeForbidden:
+		return http.StatusForbidden
+
+	case client.ErrCodePolicy, client.ErrCodeLimit:
+		return http.StatusUnprocessableEntity
+
+	case client.ErrCodeRateLimited:
+		return http.StatusTooManyRequests
+
+	case client.ErrCodeNotLeader:
+		return http.StatusServiceUnavailable
+
+	case client.ErrCodeGateway:
+		return http.StatusBadGateway
+
+	case client.ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusInternalServerError
+}
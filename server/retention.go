@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jamescun/wg-api/store"
+)
+
+// compactInterval is how often the usage and audit records of the Store are
+// compacted.
+const compactInterval = time.Hour
+
+// WithRetention configures how long the usage and audit records of the Store
+// are kept, if it is a Compactor, rolling up and deleting them as they age.
+// By default they are kept forever.
+func WithRetention(retention *store.Retention) Option {
+	return func(s *Server) {
+		s.retention = retention
+	}
+}
+
+// runCompaction compacts the Store every compact interval, while this
+// instance is leader, until ctx is cancelled.
+func (s *Server) runCompaction(ctx context.Context, compactor store.Compactor) {
+	t := time.NewTicker(compactInterval)
+	defer t.Stop()
+
+	for {
+		if s.isLeader() {
+			res, err := compactor.Compact(ctx, time.Now(), s.retention)
+			if err != nil {
+				log.Printf("error: compaction: could not compact store: %s\n", err)
+			} else if res.RolledUp > 0 || res.UsageDeleted > 0 || res.AuditDeleted > 0 {
+				log.Printf("info: compaction: rolled up %d and deleted %d usage records, deleted %d audit records\n", res.RolledUp, res.UsageDeleted, res.AuditDeleted)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
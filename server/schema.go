@@ -0,0 +1,24 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// SchemaHandler serves the client.Schema of every method of the API as JSON,
+// from which clients in other languages may be generated by genclient.
+func SchemaHandler() http.Handler {
+	schema, _ := json.Marshal(client.NewSchema())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(schema)
+	})
+}
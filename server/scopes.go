@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// readOnlyMethods are the methods that may be called by a read-only token,
+// which only read the device and never change it or the network.
+var readOnlyMethods = map[string]bool{
+	"GetDeviceInfo":   true,
+	"ListPeers":       true,
+	"ListPeerKeys":    true,
+	"GetPeer":         true,
+	"GetPeerCount":    true,
+	"ExportPeers":     true,
+	"GetServerInfo":   true,
+	"GetCapabilities": true,
+	"Ping":            true,
+	"GetServerStats":  true,
+	"TopTalkers":      true,
+	"WatchDevices":    true,
+	"ListDevices":     true,
+}
+
+type readOnlyKey struct{}
+
+// withReadOnly returns a copy of ctx marking the request as authenticated
+// by a read-only token.
+func withReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// isReadOnly returns true if the request of ctx was authenticated by a
+// read-only token.
+func isReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// AuthReadOnlyTokens only allows a request to continue if one of the
+// pre-configured tokens is provided by the client in the Authorization
+// header, as AuthTokens does, but limits it to the methods that only read
+// the device when served by EnforceScopes.
+func AuthReadOnlyTokens(tokens ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Token "))
+
+			if !stringInSlice(token, tokens) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(withReadOnly(r.Context())))
+		})
+	}
+}
+
+// EnforceScopes refuses requests authenticated by a read-only token of any
+// method that may change the device with a Forbidden error.
+func EnforceScopes(next jsonrpc.Handler) jsonrpc.Handler {
+	return jsonrpc.HandlerFunc(func(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+		if isReadOnly(r.Context()) && !readOnlyMethods[r.Method] {
+			w.Write(jsonrpc.ServerError(client.ErrCodeForbidden, "token is read-only", &client.ErrorData{Field: "method", Value: r.Method}))
+			return
+		}
+
+		next.ServeJSONRPC(w, r)
+	})
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// challengeTTL is how long a self-service challenge may be proven for.
+const challengeTTL = time.Minute
+
+// selfService is the self-service API of a Server, through which a Peer may
+// query only its own record.
+type selfService struct {
+	s *Server
+
+	// key signs challenges, such that they need not be remembered. It is
+	// random, so challenges do not survive a restart.
+	key []byte
+
+	// sourceIP allows a Peer to be identified by the source address of the
+	// request, when it is made through the tunnel.
+	sourceIP bool
+}
+
+var _ client.SelfService = (*selfService)(nil)
+
+// remoteAddrKey is the context key of the remote address of a self-service
+// request.
+type remoteAddrKey struct{}
+
+// SelfHandler serves the self-service API of a Server over JSON-RPC, which
+// must not require an authentication token. Peers authenticate by proving
+// possession of their private key, or if sourceIP is true, by making the
+// request from one of their allowed ips through the tunnel.
+func SelfHandler(s *Server, sourceIP bool) (jsonrpc.Handler, error) {
+	ss := &selfService{s: s, key: make([]byte, 32), sourceIP: sourceIP}
+
+	if _, err := rand.Read(ss.key); err != nil {
+		return nil, err
+	}
+
+	methods := map[string]method{
+		"GetChallenge": newMethod(ss.GetChallenge),
+		"GetSelf":      newMethod(ss.GetSelf),
+	}
+
+	return jsonrpc.HandlerFunc(func(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+		m, ok := methods[r.Method]
+		if !ok {
+			w.Write(jsonrpc.MethodNotFound("method not found", &client.ErrorData{Field: "method", Value: r.Method}))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), remoteAddrKey{}, r.RemoteAddr())
+
+		res, err := m.call(ctx, r.Params)
+		if err != nil {
+			w.Write(rpcError(err))
+			return
+		}
+
+		w.Write(res)
+	}), nil
+}
+
+// unauthorizedError is returned when the Peer making a self-service request
+// could not be identified.
+func unauthorizedError(message string) *jsonrpc.Error {
+	return jsonrpc.ServerError(client.ErrCodeUnauthorized, message, &client.ErrorData{})
+}
+
+// sign returns the MAC of a challenge.
+func (ss *selfService) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, ss.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// GetChallenge returns a challenge that must be proven within a short time
+// to authenticate GetSelf by possession of a private key. A challenge is its
+// expiry and a random nonce, signed by the server.
+func (ss *selfService) GetChallenge(ctx context.Context, req *client.GetChallengeRequest) (*client.GetChallengeResponse, error) {
+	expiresAt := time.Now().Add(challengeTTL).UTC()
+
+	data := make([]byte, 8+16)
+	binary.BigEndian.PutUint64(data, uint64(expiresAt.Unix()))
+
+	if _, err := rand.Read(data[8:]); err != nil {
+		return nil, err
+	}
+
+	return &client.GetChallengeResponse{
+		Challenge: base64.RawURLEncoding.EncodeToString(append(data, ss.sign(data)...)),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// verifyChallenge returns true if challenge was issued by GetChallenge and
+// has not expired.
+func (ss *selfService) verifyChallenge(challenge string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(raw) != 8+16+sha256.Size {
+		return false
+	}
+
+	data, sig := raw[:8+16], raw[8+16:]
+	if !hmac.Equal(sig, ss.sign(data)) {
+		return false
+	}
+
+	return time.Now().Unix() <= int64(binary.BigEndian.Uint64(data))
+}
+
+// GetSelf returns the Peer making the request, along with its usage.
+func (ss *selfService) GetSelf(ctx context.Context, req *client.GetSelfRequest) (*client.GetSelfResponse, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	idx, err := ss.s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var peer *wgtypes.Peer
+
+	if req.PublicKey != "" {
+		publicKey, err := wgtypes.ParseKey(req.PublicKey)
+		if err != nil {
+			return nil, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
+		}
+
+		if !ss.verifyChallenge(req.Challenge) {
+			return nil, unauthorizedError("invalid or expired challenge")
+		} else if !client.VerifySelfProof(idx.dev.PrivateKey, publicKey, req.Challenge, req.Proof) {
+			return nil, unauthorizedError("invalid proof")
+		}
+
+		peer = idx.peer(publicKey)
+	} else if ss.sourceIP {
+		addr, _ := ctx.Value(remoteAddrKey{}).(string)
+
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			peer = idx.peerByAddress(ip)
+		}
+
+		if peer == nil {
+			return nil, unauthorizedError("request was not made from the allowed ips of a peer")
+		}
+	} else {
+		return nil, unauthorizedError("public key, challenge and proof are required")
+	}
+
+	if peer == nil {
+		return nil, unauthorizedError("peer does not exist")
+	}
+
+	res := &client.GetSelfResponse{
+		Peer:  peer2rpc(*peer),
+		Usage: &client.SelfUsage{TransferBytes: peer.ReceiveBytes + peer.TransmitBytes},
+	}
+
+	stored, err := ss.s.store.GetPeer(ctx, res.Peer.PublicKey)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, storeError("could not get peer metadata", err)
+	} else if stored != nil {
+		setStored(res.Peer, stored)
+
+		res.Usage.TransferBytes += stored.TransferOffset
+
+		if stored.QuotaBytes > 0 {
+			res.Usage.QuotaBytes = stored.QuotaBytes
+			res.Usage.RemainingBytes = max(stored.QuotaBytes-res.Usage.TransferBytes, 0)
+		}
+	}
+
+	return res, nil
+}
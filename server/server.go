@@ -2,36 +2,220 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jamescun/wg-api/client"
 	"github.com/jamescun/wg-api/server/jsonrpc"
+	"github.com/jamescun/wg-api/store"
 
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+// WireGuard reads and configures WireGuard devices, implemented by the
+// wgctrl Client, or by a privileged helper process such that the Server may
+// run unprivileged.
+type WireGuard interface {
+	Devices() ([]*wgtypes.Device, error)
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
 // Server is the host-side implementation of the WG-API Client. It supports
 // both Kernel and Userland implementations of WireGuard.
 type Server struct {
-	wg         *wgctrl.Client
+	wg         WireGuard
 	deviceName string
+
+	// mu serializes changes to the device, such that preconditions checked
+	// before configuring it still hold.
+	mu  sync.Mutex
+	gen generation
+
+	dryRun    bool
+	store     store.Store
+	templates map[string]*Template
+	policy    *Policy
+	alerts    *Alerts
+	ipam      IPAM
+
+	flushConntrack bool
+	rejectOverlaps bool
+
+	sinks         []EventSink
+	events        chan *client.Event
+	subscribers   subscribers
+	usageInterval time.Duration
+	retention     *store.Retention
+
+	ha    *ha
+	state state
+
+	info   *client.ServerInfo
+	limits client.Limits
+
+	cache   peerCache
+	key     privateKeyCache
+	rates   rates
+	latency latency
+
+	pings   atomic.Uint64
+	stats   *stats
+	devices devices
+
+	methods  map[string]method
+	disabled []string
+
+	clientDefaults clientDefaults
+}
+
+var _ client.Client = (*Server)(nil)
+
+// Option configures optional behaviour of a Server.
+type Option func(*Server)
+
+// WithDryRun configures the Server to never make changes to the device, all
+// mutating methods behave as though dry run had been requested.
+func WithDryRun() Option {
+	return func(s *Server) {
+		s.dryRun = true
+	}
+}
+
+// WithStore configures where information about Peers that cannot be kept on
+// the device, such as their metadata, is stored. By default it is kept in
+// memory.
+func WithStore(st store.Store) Option {
+	return func(s *Server) {
+		s.store = st
+	}
+}
+
+// WithTemplates configures the named Templates that may be referenced when
+// adding Peers.
+func WithTemplates(templates map[string]*Template) Option {
+	return func(s *Server) {
+		s.templates = templates
+	}
+}
+
+// WithPolicy configures rules that every mutation of the Peers of the device
+// must satisfy.
+func WithPolicy(p *Policy) Option {
+	return func(s *Server) {
+		s.policy = p
+	}
+}
+
+// WithEventSink configures destinations that Events are published to when
+// the Peers of the device are changed.
+func WithEventSink(sinks ...EventSink) Option {
+	return func(s *Server) {
+		s.sinks = append(s.sinks, sinks...)
+	}
+}
+
+// WithUsageInterval configures how often peer.usage Events are published
+// for every Peer. By default they are not published.
+func WithUsageInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.usageInterval = d
+	}
+}
+
+// WithIPAM configures IP address management, used to allocate allowed ips to
+// Peers on request.
+func WithIPAM(ipam IPAM) Option {
+	return func(s *Server) {
+		s.ipam = ipam
+	}
+}
+
+// WithServerInfo configures the version and build of the Server returned by
+// GetServerInfo.
+func WithServerInfo(info *client.ServerInfo) Option {
+	return func(s *Server) {
+		s.info = info
+	}
+}
+
+// WithLimits configures the maximum number of Peers of the device and the
+// size of batches. Zero limits are unlimited.
+func WithLimits(maxPeers, maxBatchSize int) Option {
+	return func(s *Server) {
+		s.limits = client.Limits{MaxPeers: maxPeers, MaxBatchSize: maxBatchSize}
+	}
+}
+
+// WithDisabledMethods configures methods that are not served, such as
+// RemoveAllPeers on production gateways, requests of them fail with Method
+// Not Found as though they did not exist.
+func WithDisabledMethods(methods ...string) Option {
+	return func(s *Server) {
+		s.disabled = append(s.disabled, methods...)
+	}
 }
 
 // NewServer initializes a Server with a WireGuard client.
-func NewServer(wg *wgctrl.Client, deviceName string) (*Server, error) {
-	return &Server{wg: wg, deviceName: deviceName}, nil
+func NewServer(wg WireGuard, deviceName string, opts ...Option) (*Server, error) {
+	s := &Server{
+		wg:         wg,
+		deviceName: deviceName,
+		store:      store.NewMemory(),
+		events:     make(chan *client.Event, eventQueueSize),
+		info:       BuildInfo("", "", ""),
+		cache:      peerCache{ttl: defaultPeerCacheTTL},
+		rates:      rates{interval: defaultRateInterval, window: defaultRateWindow},
+		latency:    latency{window: defaultLatencyWindow},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.methods = clientMethods(s)
+
+	for _, name := range s.disabled {
+		if _, ok := s.methods[name]; !ok {
+			return nil, fmt.Errorf("cannot disable unknown method %q", name)
+		}
+
+		delete(s.methods, name)
+	}
+
+	s.stats = newStats(s.methods)
+
+	// a Server in dry run mode never changes the device, even to restore it.
+	if s.state.path != "" && !s.dryRun {
+		if err := s.restoreState(); err != nil {
+			return nil, fmt.Errorf("could not restore peers from state file: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// DeviceName returns the name of the device of the Server.
+func (s *Server) DeviceName() string {
+	return s.deviceName
 }
 
 // GetDeviceInfo returns information such as the public key and type of
-// interface for the currently configured device.
+// interface for the currently configured device, or that named by the
+// request, which must be the same device.
 func (s *Server) GetDeviceInfo(ctx context.Context, req *client.GetDeviceInfoRequest) (*client.GetDeviceInfoResponse, error) {
-	dev, err := s.wg.Device(s.deviceName)
+	if req != nil && req.Device != "" && s.resolveDevice(req.Device) != s.deviceName {
+		return nil, invalidParam("device", req.Device, "device is not served")
+	}
+
+	dev, err := s.device(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not get WireGuard device: %w", err)
+		return nil, deviceError("could not get WireGuard device", err)
 	}
 
 	return &client.GetDeviceInfoResponse{
@@ -42,19 +226,38 @@ func (s *Server) GetDeviceInfo(ctx context.Context, req *client.GetDeviceInfoReq
 			ListenPort:   dev.ListenPort,
 			FirewallMark: dev.FirewallMark,
 			NumPeers:     len(dev.Peers),
+			Aliases:      s.Aliases(),
+			Generation:   s.gen.observe(dev),
 		},
 	}, nil
 }
 
+// ListDevices returns the device of the Server, as the only device it
+// serves.
+func (s *Server) ListDevices(ctx context.Context, req *client.ListDevicesRequest) (*client.ListDevicesResponse, error) {
+	info, err := s.GetDeviceInfo(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ListDevicesResponse{Devices: []*client.Device{info.Device}}, nil
+}
+
 func validateListPeersRequest(req *client.ListPeersRequest) error {
 	if req == nil {
-		return jsonrpc.InvalidParams("request body required", nil)
+		return invalidParam("", "", "request body required")
 	}
 
 	if req.Limit < 0 {
-		return jsonrpc.InvalidParams("limit must be positive integer", nil)
+		return invalidParam("limit", strconv.Itoa(req.Limit), "limit must be positive integer")
 	} else if req.Offset < 0 {
-		return jsonrpc.InvalidParams("offset must be positive integer", nil)
+		return invalidParam("offset", strconv.Itoa(req.Offset), "offset must be positive integer")
+	}
+
+	if req.After != "" {
+		if _, err := wgtypes.ParseKey(req.After); err != nil {
+			return invalidParam("after", req.After, "invalid public key: "+err.Error())
+		}
 	}
 
 	return nil
@@ -68,36 +271,160 @@ func (s *Server) ListPeers(ctx context.Context, req *client.ListPeersRequest) (*
 		return nil, err
 	}
 
-	dev, err := s.wg.Device(s.deviceName)
+	dev, err := s.device(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not get WireGuard device: %w", err)
+		return nil, deviceError("could not get WireGuard device", err)
 	}
 
-	var peers []*client.Peer
+	peers, next, nextOffset := paginatePeers(peers2rpc(dev.Peers), req)
 
-	for _, peer := range dev.Peers {
-		peers = append(peers, peer2rpc(peer))
+	if err := s.attachStored(ctx, peers); err != nil {
+		return nil, err
 	}
 
-	// TODO(jc): pagination
+	s.attachRates(peers)
+	s.attachLatency(peers)
 
 	return &client.ListPeersResponse{
-		Peers: peers,
+		Peers:      peers,
+		Next:       next,
+		NextOffset: nextOffset,
+		Total:      len(dev.Peers),
+		Generation: s.gen.observe(dev),
 	}, nil
 }
 
+// paginatePeers returns the page of peers requested, and the Next and
+// NextOffset of the page if there are more. Paginated Peers are sorted by
+// public key such that pages stay consistent while Peers are added and
+// removed.
+func paginatePeers(peers []*client.Peer, req *client.ListPeersRequest) ([]*client.Peer, string, int) {
+	if req.Limit == 0 && req.Offset == 0 && req.After == "" {
+		return peers, "", 0
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PublicKey < peers[j].PublicKey })
+
+	i := sort.Search(len(peers), func(i int) bool { return peers[i].PublicKey > req.After })
+	peers = peers[min(i+req.Offset, len(peers)):]
+
+	if req.Limit > 0 && len(peers) > req.Limit {
+		return peers[:req.Limit], peers[req.Limit-1].PublicKey, req.Offset + req.Limit
+	}
+
+	return peers, "", 0
+}
+
+func validateListPeerKeysRequest(req *client.ListPeerKeysRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Limit < 0 {
+		return invalidParam("limit", strconv.Itoa(req.Limit), "limit must not be negative")
+	}
+
+	if req.After != "" {
+		if _, err := wgtypes.ParseKey(req.After); err != nil {
+			return invalidParam("after", req.After, "invalid public key: "+err.Error())
+		}
+	}
+
+	return nil
+}
+
+// ListPeerKeys retrieves only the public keys of the Peers of the device,
+// sorted such that pages are stable while Peers are added and removed.
+func (s *Server) ListPeerKeys(ctx context.Context, req *client.ListPeerKeysRequest) (*client.ListPeerKeysResponse, error) {
+	if err := validateListPeerKeysRequest(req); err != nil {
+		return nil, err
+	}
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	keys := make([]string, 0, len(dev.Peers))
+	for _, peer := range dev.Peers {
+		if publicKey := peer.PublicKey.String(); publicKey > req.After {
+			keys = append(keys, publicKey)
+		}
+	}
+
+	sort.Strings(keys)
+
+	res := &client.ListPeerKeysResponse{PublicKeys: keys, Generation: s.gen.observe(dev)}
+
+	if req.Limit > 0 && len(keys) > req.Limit {
+		res.PublicKeys = keys[:req.Limit]
+		res.Next = keys[req.Limit-1]
+	}
+
+	return res, nil
+}
+
 func peer2rpc(peer wgtypes.Peer) *client.Peer {
+	var allowedIPs []string
+	if len(peer.AllowedIPs) > 0 {
+		allowedIPs = make([]string, len(peer.AllowedIPs))
+		for i, allowedIP := range peer.AllowedIPs {
+			allowedIPs[i] = allowedIP.String()
+		}
+	}
+
+	rpcPeer := new(client.Peer)
+	setPeer(rpcPeer, peer, allowedIPs)
+
+	return rpcPeer
+}
+
+// peers2rpc converts every Peer of a device, allocating the Peers and their
+// allowed ips together rather than individually, as devices of thousands of
+// Peers are converted on every ListPeers.
+func peers2rpc(peers []wgtypes.Peer) []*client.Peer {
+	if len(peers) == 0 {
+		return nil
+	}
+
+	var n int
+	for _, peer := range peers {
+		n += len(peer.AllowedIPs)
+	}
+
+	rpcPeers := make([]*client.Peer, len(peers))
+	values := make([]client.Peer, len(peers))
+	allowedIPs := make([]string, n)
+
+	for i, peer := range peers {
+		var ips []string
+		if len(peer.AllowedIPs) > 0 {
+			// the capacity of each slice of allowedIPs is limited, such that
+			// appending to the allowed ips of one Peer cannot overwrite those
+			// of the next.
+			ips, allowedIPs = allowedIPs[:len(peer.AllowedIPs):len(peer.AllowedIPs)], allowedIPs[len(peer.AllowedIPs):]
+
+			for j, allowedIP := range peer.AllowedIPs {
+				ips[j] = allowedIP.String()
+			}
+		}
+
+		setPeer(&values[i], peer, ips)
+		rpcPeers[i] = &values[i]
+	}
+
+	return rpcPeers
+}
+
+// setPeer sets the fields of rpcPeer from peer, whose allowed ips have
+// already been formatted.
+func setPeer(rpcPeer *client.Peer, peer wgtypes.Peer, allowedIPs []string) {
 	var keepAlive string
 	if peer.PersistentKeepaliveInterval > 0 {
 		keepAlive = peer.PersistentKeepaliveInterval.String()
 	}
 
-	var allowedIPs []string
-	for _, allowedIP := range peer.AllowedIPs {
-		allowedIPs = append(allowedIPs, allowedIP.String())
-	}
-
-	return &client.Peer{
+	*rpcPeer = client.Peer{
 		PublicKey:           peer.PublicKey.String(),
 		HasPresharedKey:     peer.PresharedKey != wgtypes.Key{},
 		Endpoint:            peer.Endpoint.String(),
@@ -112,18 +439,18 @@ func peer2rpc(peer wgtypes.Peer) *client.Peer {
 
 func validateGetPeerRequest(req *client.GetPeerRequest) error {
 	if req == nil {
-		return jsonrpc.InvalidParams("request body required", nil)
+		return invalidParam("", "", "request body required")
 	}
 
 	if req.PublicKey == "" {
-		return jsonrpc.InvalidParams("public key is required", nil)
+		return invalidParam("public_key", "", "public key is required")
 	} else if len(req.PublicKey) != 44 {
-		return jsonrpc.InvalidParams("malformed public key", nil)
+		return invalidParam("public_key", req.PublicKey, "malformed public key")
 	}
 
 	_, err := wgtypes.ParseKey(req.PublicKey)
 	if err != nil {
-		return jsonrpc.InvalidParams("invalid public key: "+err.Error(), nil)
+		return invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
 	}
 
 	return nil
@@ -135,98 +462,183 @@ func (s *Server) GetPeer(ctx context.Context, req *client.GetPeerRequest) (*clie
 		return nil, err
 	}
 
-	dev, err := s.wg.Device(s.deviceName)
+	publicKey, err := wgtypes.ParseKey(req.PublicKey)
 	if err != nil {
-		return nil, fmt.Errorf("could not get WireGuard device: %w", err)
+		return nil, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
 	}
 
-	publicKey, err := wgtypes.ParseKey(req.PublicKey)
+	idx, err := s.peerIndex(ctx)
 	if err != nil {
-		return nil, jsonrpc.InvalidParams("invalid public key: "+err.Error(), nil)
+		return nil, err
 	}
 
-	for _, peer := range dev.Peers {
-		if peer.PublicKey == publicKey {
-			return &client.GetPeerResponse{
-				Peer: peer2rpc(peer),
-			}, nil
+	peer := idx.peer(publicKey)
+	if peer == nil {
+		return &client.GetPeerResponse{}, nil
+	}
+
+	res := &client.GetPeerResponse{
+		Peer: peer2rpc(*peer),
+	}
+
+	if err := s.attachStored(ctx, []*client.Peer{res.Peer}); err != nil {
+		return nil, err
+	}
+
+	s.setRate(res.Peer)
+	s.setLatency(res.Peer)
+
+	return res, nil
+}
+
+// GetPeerCount returns the number of Peers of the device, and how many have
+// completed a handshake recently, from the cache of the device such that it
+// may be polled often without converting every Peer.
+func (s *Server) GetPeerCount(ctx context.Context, req *client.GetPeerCountRequest) (*client.GetPeerCountResponse, error) {
+	idx, err := s.peerIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	count := &client.PeerCount{Peers: len(idx.dev.Peers)}
+
+	now := time.Now()
+	for _, peer := range idx.dev.Peers {
+		if !peer.LastHandshakeTime.IsZero() && now.Sub(peer.LastHandshakeTime) < connectedTimeout {
+			count.Online++
 		}
 	}
 
-	return &client.GetPeerResponse{}, nil
+	return &client.GetPeerCountResponse{Count: count}, nil
 }
 
 func validateAddPeerRequest(req *client.AddPeerRequest) error {
 	if req == nil {
-		return jsonrpc.InvalidParams("request body required", nil)
+		return invalidParam("", "", "request body required")
 	}
 
 	if req.PublicKey == "" {
-		return jsonrpc.InvalidParams("public key is required", nil)
+		return invalidParam("public_key", "", "public key is required")
 	} else if len(req.PublicKey) != 44 {
-		return jsonrpc.InvalidParams("malformed public key", nil)
+		return invalidParam("public_key", req.PublicKey, "malformed public key")
 	}
 
 	_, err := wgtypes.ParseKey(req.PublicKey)
 	if err != nil {
-		return jsonrpc.InvalidParams("invalid public key: "+err.Error(), nil)
+		return invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
 	}
 
 	if req.PresharedKey != "" {
 		if len(req.PresharedKey) != 44 {
-			return jsonrpc.InvalidParams("malformed preshared key", nil)
+			return invalidParam("preshared_key", "", "malformed preshared key")
 		}
 
 		_, err := wgtypes.ParseKey(req.PresharedKey)
 		if err != nil {
-			return jsonrpc.InvalidParams("invalid preshared key: "+err.Error(), nil)
+			return invalidParam("preshared_key", "", "invalid preshared key: "+err.Error())
 		}
 	}
 
 	if req.Endpoint != "" {
 		_, err := net.ResolveUDPAddr("udp", req.Endpoint)
 		if err != nil {
-			return jsonrpc.InvalidParams("invalid endpoint: "+err.Error(), nil)
+			return invalidParam("endpoint", req.Endpoint, "invalid endpoint: "+err.Error())
 		}
 	}
 
 	if req.PersistentKeepAlive != "" {
-		_, err := time.ParseDuration(req.PersistentKeepAlive)
-		if err != nil {
-			return jsonrpc.InvalidParams("invalid keepalive: "+err.Error(), nil)
+		if err := validateKeepAlive(req.PersistentKeepAlive); err != nil {
+			return invalidParam("persistent_keep_alive", req.PersistentKeepAlive, "invalid keepalive: "+err.Error())
 		}
 	}
 
 	for _, allowedIP := range req.AllowedIPs {
 		_, _, err := net.ParseCIDR(allowedIP)
 		if err != nil {
-			return jsonrpc.InvalidParams(fmt.Sprintf("range %q is not valid: %s", allowedIP, err), nil)
+			return invalidParam("allowed_ips", allowedIP, fmt.Sprintf("range %q is not valid: %s", allowedIP, err))
+		}
+	}
+
+	if req.UpdateOnly && req.CreateOnly {
+		return invalidParam("create_only", "true", "update_only and create_only cannot both be given")
+	}
+
+	if req.ClearEndpoint && req.Endpoint != "" {
+		return invalidParam("clear_endpoint", "true", "endpoint and clear_endpoint cannot both be given")
+	}
+
+	if req.ExpiresAt != nil && req.TTL != "" {
+		return invalidParam("ttl", req.TTL, "expires_at and ttl cannot both be given")
+	} else if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return invalidParam("expires_at", req.ExpiresAt.Format(time.RFC3339), "expiry must be in the future")
+	}
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return invalidParam("ttl", req.TTL, "invalid ttl: "+err.Error())
+		} else if ttl <= 0 {
+			return invalidParam("ttl", req.TTL, "invalid ttl: must be positive")
 		}
 	}
 
 	return nil
 }
 
-// AddPeer inserts a new Peer into the WireGuard interfaces table, multiple
-// calls to AddPeer can be used to update details of the Peer.
-func (s *Server) AddPeer(ctx context.Context, req *client.AddPeerRequest) (*client.AddPeerResponse, error) {
+// peerExpiry returns when the Peer of req expires, given as either ExpiresAt
+// or TTL from now, or zero if neither is given. The request must already be
+// valid.
+func peerExpiry(req *client.AddPeerRequest, now time.Time) time.Time {
+	if req.ExpiresAt != nil {
+		return req.ExpiresAt.UTC()
+	} else if req.TTL != "" {
+		ttl, _ := time.ParseDuration(req.TTL)
+		return now.Add(ttl).UTC()
+	}
+
+	return time.Time{}
+}
+
+// maxKeepAlive is the longest persistent keepalive WireGuard supports.
+const maxKeepAlive = 65535 * time.Second
+
+// validateKeepAlive returns an error if s is not a persistent keepalive
+// WireGuard supports. Zero is valid, and disables the keepalive.
+func validateKeepAlive(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	if d < 0 {
+		return fmt.Errorf("must not be negative")
+	} else if d > maxKeepAlive {
+		return fmt.Errorf("must be at most %s", maxKeepAlive)
+	} else if d%time.Second != 0 {
+		return fmt.Errorf("must be a whole number of seconds")
+	}
+
+	return nil
+}
+
+// addPeerConfig validates an AddPeerRequest and converts it into the
+// configuration given to the WireGuard device.
+func addPeerConfig(req *client.AddPeerRequest) (wgtypes.PeerConfig, error) {
 	if err := validateAddPeerRequest(req); err != nil {
-		return nil, err
-	} else if req.ValidateOnly {
-		return &client.AddPeerResponse{}, nil
+		return wgtypes.PeerConfig{}, err
 	}
 
 	publicKey, err := wgtypes.ParseKey(req.PublicKey)
 	if err != nil {
-		return nil, jsonrpc.InvalidParams("invalid public key: "+err.Error(), nil)
+		return wgtypes.PeerConfig{}, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
 	}
 
-	peer := wgtypes.PeerConfig{PublicKey: publicKey}
+	peer := wgtypes.PeerConfig{PublicKey: publicKey, UpdateOnly: req.UpdateOnly}
 
 	if req.PresharedKey != "" {
 		pk, err := wgtypes.ParseKey(req.PresharedKey)
 		if err != nil {
-			return nil, jsonrpc.InvalidParams("invalid preshared key: "+err.Error(), nil)
+			return wgtypes.PeerConfig{}, invalidParam("preshared_key", "", "invalid preshared key: "+err.Error())
 		}
 
 		peer.PresharedKey = &pk
@@ -235,7 +647,7 @@ func (s *Server) AddPeer(ctx context.Context, req *client.AddPeerRequest) (*clie
 	if req.Endpoint != "" {
 		addr, err := net.ResolveUDPAddr("udp", req.Endpoint)
 		if err != nil {
-			return nil, jsonrpc.InvalidParams("invalid endpoint: "+err.Error(), nil)
+			return wgtypes.PeerConfig{}, invalidParam("endpoint", req.Endpoint, "invalid endpoint: "+err.Error())
 		}
 
 		peer.Endpoint = addr
@@ -244,7 +656,7 @@ func (s *Server) AddPeer(ctx context.Context, req *client.AddPeerRequest) (*clie
 	if req.PersistentKeepAlive != "" {
 		d, err := time.ParseDuration(req.PersistentKeepAlive)
 		if err != nil {
-			return nil, jsonrpc.InvalidParams("invalid keepalive: "+err.Error(), nil)
+			return wgtypes.PeerConfig{}, invalidParam("persistent_keep_alive", req.PersistentKeepAlive, "invalid keepalive: "+err.Error())
 		}
 
 		peer.PersistentKeepaliveInterval = &d
@@ -253,130 +665,271 @@ func (s *Server) AddPeer(ctx context.Context, req *client.AddPeerRequest) (*clie
 	for _, allowedIP := range req.AllowedIPs {
 		_, aip, err := net.ParseCIDR(allowedIP)
 		if err != nil {
-			return nil, jsonrpc.InvalidParams(fmt.Sprintf("range %q is not valid: %s", allowedIP, err), nil)
+			return wgtypes.PeerConfig{}, invalidParam("allowed_ips", allowedIP, fmt.Sprintf("range %q is not valid: %s", allowedIP, err))
 		}
 
 		peer.AllowedIPs = append(peer.AllowedIPs, *aip)
 	}
 
-	err = s.wg.ConfigureDevice(s.deviceName, wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}})
+	return peer, nil
+}
+
+// withoutEndpoint returns the configuration of peer preceded by the removal
+// of the Peer and its recreation without its endpoint, if it is on the device
+// with an endpoint, as WireGuard cannot unset the endpoint of a Peer. Both
+// are given to the device at once, such that the Peer is never missing, but
+// its recreation drops its last handshake and session, such that it must
+// handshake again before traffic flows, and resets its transfer counters. A
+// Peer without an endpoint is left alone.
+func (s *Server) withoutEndpoint(ctx context.Context, peer wgtypes.PeerConfig) ([]wgtypes.PeerConfig, error) {
+	dev, err := s.device(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not configure WireGuard device: %w", err)
+		return nil, deviceError("could not get WireGuard device", err)
 	}
 
-	return &client.AddPeerResponse{OK: true}, nil
+	for _, current := range dev.Peers {
+		if current.PublicKey != peer.PublicKey || current.Endpoint == nil {
+			continue
+		}
+
+		restore := peerConfigOf(current)
+		restore.Endpoint = nil
+
+		return []wgtypes.PeerConfig{{PublicKey: current.PublicKey, Remove: true}, restore, peer}, nil
+	}
+
+	return []wgtypes.PeerConfig{peer}, nil
+}
+
+// AddPeer inserts a new Peer into the WireGuard interfaces table, multiple
+// calls to AddPeer can be used to update details of the Peer.
+func (s *Server) AddPeer(ctx context.Context, req *client.AddPeerRequest) (*client.AddPeerResponse, error) {
+	req, tmpl, err := s.resolveTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := addPeerConfig(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAddPeer(req); err != nil {
+		return nil, err
+	}
+
+	allocated, err := s.allocateIPs(ctx, req, &peer)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res *applyResult
+
+	peers := []wgtypes.PeerConfig{peer}
+	if req.ClearEndpoint {
+		peers, err = s.withoutEndpoint(ctx, peer)
+	}
+
+	if err == nil {
+		err = s.checkOverlaps(ctx, peers, req.Force)
+	}
+
+	if err == nil {
+		// only the configuration of the request itself may be create only.
+		createOnly := make([]bool, len(peers))
+		createOnly[len(peers)-1] = req.CreateOnly
+
+		res, err = s.apply(ctx, wgtypes.Config{Peers: peers}, createOnly, req.ExpectedGeneration, req.DryRun || req.ValidateOnly)
+	}
+
+	if err != nil {
+		if req.AllocateAllowedIPs && !isPeerExists(err) {
+			s.releaseIPs(ctx, req.PublicKey)
+		}
+
+		return nil, err
+	}
+
+	if res.DryRun {
+		if req.AllocateAllowedIPs {
+			s.releaseIPs(ctx, req.PublicKey)
+		}
+	} else if err := s.recordPeer(ctx, req, tmpl); err != nil {
+		return nil, err
+	}
+
+	return &client.AddPeerResponse{
+		OK:           true,
+		AllocatedIPs: allocated,
+		DryRun:       res.DryRun,
+		Changes:      res.Changes,
+		Generation:   res.Generation,
+	}, nil
 }
 
 func validateRemovePeerRequest(req *client.RemovePeerRequest) error {
 	if req == nil {
-		return jsonrpc.InvalidParams("request body required", nil)
+		return invalidParam("", "", "request body required")
 	}
 
 	if req.PublicKey == "" {
-		return jsonrpc.InvalidParams("public key is required", nil)
+		return invalidParam("public_key", "", "public key is required")
 	} else if len(req.PublicKey) != 44 {
-		return jsonrpc.InvalidParams("malformed public key", nil)
+		return invalidParam("public_key", req.PublicKey, "malformed public key")
 	}
 
 	_, err := wgtypes.ParseKey(req.PublicKey)
 	if err != nil {
-		return jsonrpc.InvalidParams("invalid public key: "+err.Error(), nil)
+		return invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
 	}
 
 	return nil
 }
 
+// removePeerConfig validates a RemovePeerRequest and converts it into the
+// configuration given to the WireGuard device.
+func removePeerConfig(req *client.RemovePeerRequest) (wgtypes.PeerConfig, error) {
+	if err := validateRemovePeerRequest(req); err != nil {
+		return wgtypes.PeerConfig{}, err
+	}
+
+	publicKey, err := wgtypes.ParseKey(req.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, invalidParam("public_key", req.PublicKey, "invalid public key: "+err.Error())
+	}
+
+	return wgtypes.PeerConfig{PublicKey: publicKey, Remove: true}, nil
+}
+
 // RemovePeer deletes a Peer from the WireGuard interfaces table by their
 // public key,
 func (s *Server) RemovePeer(ctx context.Context, req *client.RemovePeerRequest) (*client.RemovePeerResponse, error) {
-	if err := validateRemovePeerRequest(req); err != nil {
+	peer, err := removePeerConfig(req)
+	if err != nil {
 		return nil, err
-	} else if req.ValidateOnly {
-		return &client.RemovePeerResponse{}, nil
 	}
 
-	publicKey, err := wgtypes.ParseKey(req.PublicKey)
+	if err := s.checkRemovePeer(ctx, req); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.apply(ctx, wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}}, nil, req.ExpectedGeneration, req.DryRun || req.ValidateOnly)
 	if err != nil {
-		return nil, fmt.Errorf("invalid public key: %w", err)
+		return nil, err
 	}
 
-	peer := wgtypes.PeerConfig{
-		PublicKey: publicKey,
-		Remove:    true,
+	if !res.DryRun {
+		if err := s.forgetPeer(ctx, req.PublicKey); err != nil {
+			return nil, err
+		}
 	}
 
-	err = s.wg.ConfigureDevice(s.deviceName, wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}})
-	if err != nil {
-		return nil, fmt.Errorf("could not configure WireGuard device: %w", err)
+	return &client.RemovePeerResponse{
+		OK:         true,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
+
+func validateRemoveAllPeersRequest(req *client.RemoveAllPeersRequest, deviceName string) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	if req.Confirm != deviceName {
+		return invalidParam("confirm", req.Confirm, "confirm must be the name of the device, "+deviceName)
 	}
 
-	return &client.RemovePeerResponse{OK: true}, nil
+	return nil
 }
 
-// ServeJSONRPC handles incoming WG-API requests.
-func (s *Server) ServeJSONRPC(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
-	var res interface{}
+// RemoveAllPeers deletes every Peer from the WireGuard interfaces table in a
+// single configuration of the device, such that it is never left with only
+// some of its Peers. The name of the device must be given to confirm.
+func (s *Server) RemoveAllPeers(ctx context.Context, req *client.RemoveAllPeersRequest) (*client.RemoveAllPeersResponse, error) {
+	if err := validateRemoveAllPeersRequest(req, s.deviceName); err != nil {
+		return nil, err
+	}
 
-	// TODO(jc): must be a way to make this generic, reflection maybe?
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	switch r.Method {
-	case "GetDeviceInfo":
-		var err error
-		res, err = s.GetDeviceInfo(r.Context(), &client.GetDeviceInfoRequest{})
-		if err != nil {
-			res = jsonrpc.ServerError(-32000, err.Error(), nil)
-		}
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
 
-	case "ListPeers":
-		var arg client.ListPeersRequest
-		err := json.Unmarshal(r.Params, &arg)
-		if err != nil {
-			res = jsonrpc.ParseError(err.Error(), nil)
-		} else {
-			res, err = s.ListPeers(r.Context(), &arg)
-			if err != nil {
-				res = jsonrpc.ServerError(-32000, err.Error(), nil)
-			}
+	for _, peer := range dev.Peers {
+		if err := s.checkRemovePeer(ctx, &client.RemovePeerRequest{PublicKey: peer.PublicKey.String()}); err != nil {
+			return nil, err
 		}
+	}
 
-	case "GetPeer":
-		var arg client.GetPeerRequest
-		err := json.Unmarshal(r.Params, &arg)
-		if err != nil {
-			res = jsonrpc.ParseError(err.Error(), nil)
-		} else {
-			res, err = s.GetPeer(r.Context(), &arg)
-			if err != nil {
-				res = jsonrpc.ServerError(-32000, err.Error(), nil)
-			}
-		}
+	res, err := s.apply(ctx, wgtypes.Config{ReplacePeers: true}, nil, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
 
-	case "AddPeer":
-		var arg client.AddPeerRequest
-		err := json.Unmarshal(r.Params, &arg)
-		if err != nil {
-			res = jsonrpc.ParseError(err.Error(), nil)
-		} else {
-			res, err = s.AddPeer(r.Context(), &arg)
-			if err != nil {
-				res = jsonrpc.ServerError(-32000, err.Error(), nil)
+	if !res.DryRun {
+		for _, change := range res.Changes {
+			if err := s.forgetPeer(ctx, change.PublicKey); err != nil {
+				return nil, err
 			}
 		}
+	}
 
-	case "RemovePeer":
-		var arg client.RemovePeerRequest
-		err := json.Unmarshal(r.Params, &arg)
-		if err != nil {
-			res = jsonrpc.ParseError(err.Error(), nil)
-		} else {
-			res, err = s.RemovePeer(r.Context(), &arg)
-			if err != nil {
-				res = jsonrpc.ServerError(-32000, err.Error(), nil)
-			}
+	return &client.RemoveAllPeersResponse{
+		OK:         true,
+		Removed:    len(res.Changes),
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
+
+// enabled returns true if method is served, such that the HTTP endpoints
+// equivalent to a method may be disabled with it.
+func (s *Server) enabled(method string) bool {
+	_, ok := s.methods[method]
+	return ok
+}
+
+// ServeJSONRPC handles incoming WG-API requests.
+func (s *Server) ServeJSONRPC(w jsonrpc.ResponseWriter, r *jsonrpc.Request) {
+	m, ok := s.methods[r.Method]
+	if !ok {
+		w.Write(jsonrpc.MethodNotFound("method not found", &client.ErrorData{Field: "method", Value: r.Method}))
+		return
+	}
+
+	if auditedMethods[r.Method] && !s.isLeader() {
+		s.stats.record(r.Method, true, 0)
+		w.Write(s.notLeaderError())
+		return
+	}
+
+	t1 := time.Now()
+	res, err := m.call(r.Context(), r.Params)
+	d := time.Since(t1)
+	s.audit(r, d, err)
+	s.stats.record(r.Method, err != nil, d)
+
+	if err != nil {
+		// an error caused by the request timing out or the client
+		// disconnecting is reported as such, however it was wrapped.
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			err = timeoutError(ctxErr)
 		}
 
-	default:
-		res = jsonrpc.MethodNotFound("method not found", nil)
+		w.Write(rpcError(err))
+		return
 	}
 
 	w.Write(res)
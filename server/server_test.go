@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// benchmarkPeers is the number of Peers of the device benchmarked, that of a
+// large deployment.
+const benchmarkPeers = 10000
+
+// fakeWireGuard is a WireGuard with a single device, which is never changed.
+type fakeWireGuard struct {
+	dev *wgtypes.Device
+}
+
+func (wg *fakeWireGuard) Devices() ([]*wgtypes.Device, error) {
+	return []*wgtypes.Device{wg.dev}, nil
+}
+
+func (wg *fakeWireGuard) Device(name string) (*wgtypes.Device, error) {
+	if name != wg.dev.Name {
+		return nil, os.ErrNotExist
+	}
+
+	return wg.dev, nil
+}
+
+func (wg *fakeWireGuard) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	return nil
+}
+
+// newFakeDevice returns a device named wg0 with n Peers, each with an
+// endpoint, a preshared key and usage stats as they would be in production.
+func newFakeDevice(n int) *wgtypes.Device {
+	peers := make([]wgtypes.Peer, n)
+
+	for i := range peers {
+		var key wgtypes.Key
+		binary.BigEndian.PutUint32(key[:], uint32(i+1))
+
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, 0x0a000000+uint32(i+1))
+
+		peers[i] = wgtypes.Peer{
+			PublicKey:                   key,
+			PresharedKey:                key,
+			Endpoint:                    &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 51820 + i%1000},
+			PersistentKeepaliveInterval: 25 * time.Second,
+			LastHandshakeTime:           time.Unix(1700000000, 0),
+			ReceiveBytes:                int64(i) * 1024,
+			TransmitBytes:               int64(i) * 2048,
+			AllowedIPs:                  []net.IPNet{{IP: ip, Mask: net.CIDRMask(32, 32)}},
+			ProtocolVersion:             1,
+		}
+	}
+
+	return &wgtypes.Device{
+		Name:       "wg0",
+		Type:       wgtypes.LinuxKernel,
+		ListenPort: 51820,
+		Peers:      peers,
+	}
+}
+
+func TestPaginatePeers(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Request    client.ListPeersRequest
+		Peers      []string
+		Next       string
+		NextOffset int
+	}{
+		{
+			Name:  "All",
+			Peers: []string{"c", "a", "e", "b", "d"},
+		},
+		{
+			Name:       "Limit",
+			Request:    client.ListPeersRequest{Limit: 2},
+			Peers:      []string{"a", "b"},
+			Next:       "b",
+			NextOffset: 2,
+		},
+		{
+			Name:    "LimitLastPage",
+			Request: client.ListPeersRequest{Limit: 5},
+			Peers:   []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			Name:    "After",
+			Request: client.ListPeersRequest{After: "b"},
+			Peers:   []string{"c", "d", "e"},
+		},
+		{
+			// a key that is not on the device still pages from where it
+			// would be.
+			Name:    "AfterRemoved",
+			Request: client.ListPeersRequest{After: "bb"},
+			Peers:   []string{"c", "d", "e"},
+		},
+		{
+			Name:       "AfterLimit",
+			Request:    client.ListPeersRequest{After: "a", Limit: 2},
+			Peers:      []string{"b", "c"},
+			Next:       "c",
+			NextOffset: 2,
+		},
+		{
+			Name:    "Offset",
+			Request: client.ListPeersRequest{Offset: 3},
+			Peers:   []string{"d", "e"},
+		},
+		{
+			Name:       "AfterOffsetLimit",
+			Request:    client.ListPeersRequest{After: "a", Offset: 1, Limit: 2},
+			Peers:      []string{"c", "d"},
+			Next:       "d",
+			NextOffset: 3,
+		},
+		{
+			Name:    "OffsetBeyond",
+			Request: client.ListPeersRequest{Offset: 10, Limit: 2},
+			Peers:   []string{},
+		},
+		{
+			Name:    "AfterLast",
+			Request: client.ListPeersRequest{After: "e", Limit: 2},
+			Peers:   []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var peers []*client.Peer
+			for _, key := range []string{"c", "a", "e", "b", "d"} {
+				peers = append(peers, &client.Peer{PublicKey: key})
+			}
+
+			page, next, nextOffset := paginatePeers(peers, &test.Request)
+
+			keys := []string{}
+			for _, peer := range page {
+				keys = append(keys, peer.PublicKey)
+			}
+
+			if strings.Join(keys, ",") != strings.Join(test.Peers, ",") {
+				t.Errorf("expected peers %v, got %v", test.Peers, keys)
+			}
+
+			if next != test.Next {
+				t.Errorf("expected next %q, got %q", test.Next, next)
+			}
+
+			if nextOffset != test.NextOffset {
+				t.Errorf("expected next offset %d, got %d", test.NextOffset, nextOffset)
+			}
+		})
+	}
+}
+
+func TestValidateKeepAlive(t *testing.T) {
+	tests := []struct {
+		KeepAlive string
+		Valid     bool
+	}{
+		{"0", true},
+		{"0s", true},
+		{"25s", true},
+		{"1m", true},
+		{"65535s", true},
+		{"18h12m15s", true},
+		{"65536s", false},
+		{"24h", false},
+		{"-1s", false},
+		{"1500ms", false},
+		{"25", false},
+		{"", false},
+		{"forever", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.KeepAlive, func(t *testing.T) {
+			err := validateKeepAlive(test.KeepAlive)
+			if test.Valid && err != nil {
+				t.Errorf("expected valid, got %s", err)
+			} else if !test.Valid && err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestValidateRemoveAllPeersRequest(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Request *client.RemoveAllPeersRequest
+		Valid   bool
+	}{
+		{"Nil", nil, false},
+		{"Empty", &client.RemoveAllPeersRequest{}, false},
+		{"OtherDevice", &client.RemoveAllPeersRequest{Confirm: "wg1"}, false},
+		{"Case", &client.RemoveAllPeersRequest{Confirm: "WG0"}, false},
+		{"Device", &client.RemoveAllPeersRequest{Confirm: "wg0"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := validateRemoveAllPeersRequest(test.Request, "wg0")
+			if test.Valid && err != nil {
+				t.Errorf("expected valid, got %s", err)
+			} else if !test.Valid && err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func BenchmarkPeers2rpc(b *testing.B) {
+	dev := newFakeDevice(benchmarkPeers)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		peers2rpc(dev.Peers)
+	}
+}
+
+func BenchmarkDiffPeers(b *testing.B) {
+	before := newFakeDevice(benchmarkPeers).Peers
+
+	// a change to a single Peer, the most common, amongst many that are
+	// unchanged.
+	after := make([]wgtypes.Peer, len(before))
+	copy(after, before)
+	after[len(after)/2].PersistentKeepaliveInterval = 0
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if changes := diffPeers(before, after); len(changes) != 1 {
+			b.Fatalf("expected 1 change, got %d", len(changes))
+		}
+	}
+}
+
+func BenchmarkListPeersPage(b *testing.B) {
+	dev := newFakeDevice(benchmarkPeers)
+
+	s, err := NewServer(&fakeWireGuard{dev: dev}, "wg0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// a page from the middle of the device, as a client listing every page
+	// would request.
+	req := &client.ListPeersRequest{Limit: 100, After: dev.Peers[len(dev.Peers)/2].PublicKey.String()}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		res, err := s.ListPeers(ctx, req)
+		if err != nil {
+			b.Fatal(err)
+		} else if len(res.Peers) != req.Limit {
+			b.Fatalf("expected %d peers, got %d", req.Limit, len(res.Peers))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+)
+
+// Server is a WG-API server serving the device of a WireGuard over HTTP on a
+// loopback address, as wg-api serves it by default, for end-to-end tests.
+type Server struct {
+	// URL of the server, such as http://127.0.0.1:41234.
+	URL string
+
+	// Client makes requests of the server.
+	Client *client.HTTPClient
+
+	// Server and WireGuard are those the server is serving, such that a
+	// test may inspect or change them directly.
+	Server    *server.Server
+	WireGuard *WireGuard
+
+	http   *httptest.Server
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewServer starts a Server serving the device of wg named device, with
+// opts, running its background tasks until it is closed. A device that does
+// not exist is added to wg.
+func NewServer(wg *WireGuard, device string, opts ...server.Option) (*Server, error) {
+	if _, err := wg.Device(device); err != nil {
+		wg.AddDevice(NewDevice(device))
+	}
+
+	svc, err := server.NewServer(wg, device, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		Server:    svc,
+		WireGuard: wg,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		svc.Run(ctx)
+	}()
+
+	s.http = httptest.NewServer(Handler(svc, device))
+	s.URL = s.http.URL
+	s.Client = client.NewHTTPClient(s.URL, "")
+
+	return s, nil
+}
+
+// Handler returns the handler of the HTTP API of svc serving device, as
+// served by wg-api without authentication.
+func Handler(svc *server.Server, device string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/export", server.ExportHandler(svc))
+	mux.Handle("/peers", server.PeersHandler(svc))
+	mux.Handle("/schema", server.SchemaHandler())
+	mux.Handle("/", jsonrpc.HTTP(svc))
+
+	health := server.HealthHandler(svc)
+
+	outer := http.NewServeMux()
+	outer.Handle("/healthz", health)
+	outer.Handle("/readyz", health)
+	outer.Handle("/", server.RequireDevice(device, svc.Aliases()...)(mux))
+
+	return server.PreventReferer(outer)
+}
+
+// Close stops the Server, waiting for its background tasks and any requests
+// in progress to finish.
+func (s *Server) Close() {
+	s.http.Close()
+	s.cancel()
+	<-s.done
+}
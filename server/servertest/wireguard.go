@@ -0,0 +1,305 @@
+// Package servertest provides utilities for end-to-end testing of WG-API,
+// serving the whole HTTP and JSON-RPC stack in-process against WireGuard
+// devices held in memory, without root or a real network interface.
+package servertest
+
+import (
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/server"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// WireGuard is a fake of the WireGuard devices of a host held in memory,
+// configured as the kernel would configure them. Every call may be delayed
+// by a latency, or failed, to test how they are handled.
+type WireGuard struct {
+	mu      sync.Mutex
+	devices map[string]*wgtypes.Device
+	latency time.Duration
+	fail    func(method, name string) error
+}
+
+var _ server.WireGuard = (*WireGuard)(nil)
+
+// NewWireGuard returns a WireGuard with devices.
+func NewWireGuard(devices ...*wgtypes.Device) *WireGuard {
+	wg := &WireGuard{devices: make(map[string]*wgtypes.Device)}
+
+	for _, dev := range devices {
+		wg.AddDevice(dev)
+	}
+
+	return wg
+}
+
+// NewDevice returns a device named name with a new private key and peers,
+// listening on port 51820.
+func NewDevice(name string, peers ...wgtypes.Peer) *wgtypes.Device {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		panic("servertest: could not generate private key: " + err.Error())
+	}
+
+	return &wgtypes.Device{
+		Name:       name,
+		Type:       wgtypes.Userspace,
+		PrivateKey: key,
+		PublicKey:  key.PublicKey(),
+		ListenPort: 51820,
+		Peers:      peers,
+	}
+}
+
+// NewPeer returns a Peer with a new public key, routed allowedIPs, each of
+// which must be an address or network in CIDR notation.
+func NewPeer(allowedIPs ...string) wgtypes.Peer {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		panic("servertest: could not generate private key: " + err.Error())
+	}
+
+	peer := wgtypes.Peer{PublicKey: key.PublicKey(), ProtocolVersion: 1}
+
+	for _, allowedIP := range allowedIPs {
+		_, ipNet, err := net.ParseCIDR(allowedIP)
+		if err != nil {
+			panic("servertest: invalid allowed ip: " + err.Error())
+		}
+
+		peer.AllowedIPs = append(peer.AllowedIPs, *ipNet)
+	}
+
+	return peer
+}
+
+// AddDevice adds a copy of dev, replacing any device of the same name.
+func (wg *WireGuard) AddDevice(dev *wgtypes.Device) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	wg.devices[dev.Name] = cloneDevice(dev)
+}
+
+// RemoveDevice removes the device name, if it exists.
+func (wg *WireGuard) RemoveDevice(name string) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	delete(wg.devices, name)
+}
+
+// Update calls fn with the device name, if it exists, such as to simulate a
+// handshake or traffic of its Peers. It returns false if it does not exist.
+func (wg *WireGuard) Update(name string, fn func(*wgtypes.Device)) bool {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	dev, ok := wg.devices[name]
+	if ok {
+		fn(dev)
+	}
+
+	return ok
+}
+
+// SetLatency delays every call by d.
+func (wg *WireGuard) SetLatency(d time.Duration) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	wg.latency = d
+}
+
+// SetFailure calls fn before every call with the name of its method, such as
+// ConfigureDevice, and that of the device, if any. If fn returns an error,
+// the call fails with it. A nil fn restores every call to succeed.
+func (wg *WireGuard) SetFailure(fn func(method, name string) error) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	wg.fail = fn
+}
+
+// begin delays and fails a call as configured.
+func (wg *WireGuard) begin(method, name string) error {
+	wg.mu.Lock()
+	latency, fail := wg.latency, wg.fail
+	wg.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if fail != nil {
+		return fail(method, name)
+	}
+
+	return nil
+}
+
+// Devices returns a copy of every device, ordered by name.
+func (wg *WireGuard) Devices() ([]*wgtypes.Device, error) {
+	if err := wg.begin("Devices", ""); err != nil {
+		return nil, err
+	}
+
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	devs := make([]*wgtypes.Device, 0, len(wg.devices))
+	for _, dev := range wg.devices {
+		devs = append(devs, cloneDevice(dev))
+	}
+
+	sort.Slice(devs, func(i, j int) bool { return devs[i].Name < devs[j].Name })
+
+	return devs, nil
+}
+
+// Device returns a copy of the device name, or an error satisfying
+// os.IsNotExist if it does not exist.
+func (wg *WireGuard) Device(name string) (*wgtypes.Device, error) {
+	if err := wg.begin("Device", name); err != nil {
+		return nil, err
+	}
+
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	dev, ok := wg.devices[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return cloneDevice(dev), nil
+}
+
+// ConfigureDevice configures the device name with cfg, as the kernel would.
+func (wg *WireGuard) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if err := wg.begin("ConfigureDevice", name); err != nil {
+		return err
+	}
+
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	dev, ok := wg.devices[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if cfg.PrivateKey != nil {
+		dev.PrivateKey = *cfg.PrivateKey
+		dev.PublicKey = cfg.PrivateKey.PublicKey()
+	}
+
+	if cfg.ListenPort != nil {
+		dev.ListenPort = *cfg.ListenPort
+	}
+
+	if cfg.FirewallMark != nil {
+		dev.FirewallMark = *cfg.FirewallMark
+	}
+
+	if cfg.ReplacePeers {
+		dev.Peers = nil
+	}
+
+	for _, pc := range cfg.Peers {
+		configurePeer(dev, pc)
+	}
+
+	return nil
+}
+
+// configurePeer configures a Peer of dev with pc.
+func configurePeer(dev *wgtypes.Device, pc wgtypes.PeerConfig) {
+	i := -1
+	for j := range dev.Peers {
+		if dev.Peers[j].PublicKey == pc.PublicKey {
+			i = j
+			break
+		}
+	}
+
+	switch {
+	case pc.Remove:
+		if i >= 0 {
+			dev.Peers = append(dev.Peers[:i], dev.Peers[i+1:]...)
+		}
+
+		return
+
+	case i < 0 && pc.UpdateOnly:
+		return
+
+	case i < 0:
+		dev.Peers = append(dev.Peers, wgtypes.Peer{PublicKey: pc.PublicKey, ProtocolVersion: 1})
+		i = len(dev.Peers) - 1
+	}
+
+	peer := &dev.Peers[i]
+
+	if pc.PresharedKey != nil {
+		peer.PresharedKey = *pc.PresharedKey
+	}
+
+	if pc.Endpoint != nil {
+		endpoint := *pc.Endpoint
+		peer.Endpoint = &endpoint
+	}
+
+	if pc.PersistentKeepaliveInterval != nil {
+		peer.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+	}
+
+	if pc.ReplaceAllowedIPs {
+		peer.AllowedIPs = nil
+	}
+
+	// an allowed ip is routed to a single Peer, so is taken from any other
+	// Peer it was routed to.
+	for _, allowedIP := range pc.AllowedIPs {
+		for j := range dev.Peers {
+			if j != i {
+				dev.Peers[j].AllowedIPs = withoutIPNet(dev.Peers[j].AllowedIPs, allowedIP)
+			}
+		}
+
+		peer.AllowedIPs = append(withoutIPNet(peer.AllowedIPs, allowedIP), allowedIP)
+	}
+}
+
+func withoutIPNet(ipNets []net.IPNet, ipNet net.IPNet) []net.IPNet {
+	out := ipNets[:0]
+	for _, n := range ipNets {
+		if n.String() != ipNet.String() {
+			out = append(out, n)
+		}
+	}
+
+	return out
+}
+
+func cloneDevice(dev *wgtypes.Device) *wgtypes.Device {
+	c := *dev
+
+	c.Peers = make([]wgtypes.Peer, len(dev.Peers))
+	for i, peer := range dev.Peers {
+		c.Peers[i] = peer
+		c.Peers[i].AllowedIPs = append([]net.IPNet(nil), peer.AllowedIPs...)
+
+		if peer.Endpoint != nil {
+			endpoint := *peer.Endpoint
+			c.Peers[i].Endpoint = &endpoint
+		}
+	}
+
+	return &c
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// state is the file the Peers of the device are saved to whenever it is
+// configured, and restored from when the Server is created.
+type state struct {
+	path string
+
+	// mu serializes saving, such that the file is always written with the
+	// latest Peers of the device.
+	mu sync.Mutex
+}
+
+// stateFile is the contents of the state file.
+type stateFile struct {
+	Device string       `json:"device"`
+	Peers  []*statePeer `json:"peers"`
+}
+
+// statePeer is the configuration of a Peer in the state file.
+type statePeer struct {
+	PublicKey string `json:"public_key"`
+	store.PeerConfig
+}
+
+// WithStateFile configures the Server to save the configuration of every
+// Peer of the device to path whenever it changes, and to restore the device
+// to match it when created, such that Peers survive a reboot of the host.
+// If path does not yet exist, the device is left unchanged and its Peers
+// saved. It is ignored in dry run mode.
+func WithStateFile(path string) Option {
+	return func(s *Server) {
+		s.state.path = path
+	}
+}
+
+// restoreState configures the device to have exactly the Peers of the state
+// file, adding, updating and removing Peers as necessary.
+func (s *Server) restoreState() error {
+	data, err := os.ReadFile(s.state.path)
+	if os.IsNotExist(err) {
+		return s.saveState()
+	} else if err != nil {
+		return err
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return fmt.Errorf("invalid state file: %w", err)
+	}
+
+	snapshot := make([]wgtypes.Peer, 0, len(sf.Peers))
+
+	for _, sp := range sf.Peers {
+		peer, err := storedPeer(&store.Peer{PublicKey: sp.PublicKey, Config: &sp.PeerConfig})
+		if err != nil {
+			log.Printf("warn: state: not restoring peer %s: %s\n", sp.PublicKey, err)
+			continue
+		}
+
+		snapshot = append(snapshot, peer)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.restorePeers(snapshot); err != nil {
+		return err
+	}
+
+	log.Printf("info: state: restored %d peers from %s\n", len(snapshot), s.state.path)
+
+	_, err = s.syncGeneration()
+
+	return err
+}
+
+// saveState writes the configuration of every Peer of the device to the
+// state file. The file is replaced atomically, such that it is never left
+// partially written.
+func (s *Server) saveState() error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	dev, err := s.wg.Device(s.deviceName)
+	if err != nil {
+		return err
+	}
+
+	sf := stateFile{Device: s.deviceName, Peers: make([]*statePeer, len(dev.Peers))}
+	for i, peer := range dev.Peers {
+		sf.Peers[i] = &statePeer{PublicKey: peer.PublicKey.String(), PeerConfig: *snapshotConfig(peer)}
+	}
+
+	data, err := json.MarshalIndent(&sf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the file contains preshared keys, so is readable only by WG-API.
+	f, err := os.CreateTemp(filepath.Dir(s.state.path), "."+filepath.Base(s.state.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.state.path)
+}
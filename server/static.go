@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+)
+
+// StaticHandler serves the files beneath dir to GET and HEAD requests, such
+// as a custom frontend of the API, passing any other request to next. Paths
+// that are not files, such as the routes of a single page application, are
+// served the index.html of dir, and directories are never listed.
+func StaticHandler(dir string, next http.Handler) http.Handler {
+	fs := staticFS{http.Dir(dir)}
+	files := http.FileServer(fs)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+
+		if f, err := fs.Open(path.Clean("/" + r.URL.Path)); err != nil {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/"
+		} else {
+			f.Close()
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+// staticFS opens only files, and directories containing an index.html, such
+// that the contents of a directory are not listed.
+type staticFS struct {
+	http.FileSystem
+}
+
+func (fs staticFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		index, err := fs.FileSystem.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+
+		index.Close()
+	}
+
+	return f, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// stats are the runtime statistics of a Server.
+type stats struct {
+	started time.Time
+
+	// methods has an entry for every method of the Server, created with it,
+	// such that it may be read and updated without locking.
+	methods map[string]*methodStats
+
+	droppedEvents atomic.Uint64
+}
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the
+// histogram of how long the requests of each method take.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// methodStats counts the requests of a method.
+type methodStats struct {
+	requests atomic.Uint64
+	errors   atomic.Uint64
+
+	// nanos is the total duration of the requests, and buckets count those
+	// taking at most each of durationBuckets.
+	nanos   atomic.Uint64
+	buckets []atomic.Uint64
+}
+
+func newStats(methods map[string]method) *stats {
+	st := &stats{started: time.Now(), methods: make(map[string]*methodStats, len(methods))}
+
+	for name := range methods {
+		st.methods[name] = &methodStats{buckets: make([]atomic.Uint64, len(durationBuckets))}
+	}
+
+	return st
+}
+
+// record counts a request of method, whether it failed and how long it took.
+func (st *stats) record(method string, failed bool, d time.Duration) {
+	if m, ok := st.methods[method]; ok {
+		m.requests.Add(1)
+		m.nanos.Add(uint64(max(d, 0)))
+
+		if failed {
+			m.errors.Add(1)
+		}
+
+		for i, le := range durationBuckets {
+			if d.Seconds() <= le {
+				m.buckets[i].Add(1)
+			}
+		}
+	}
+}
+
+// GetServerStats returns runtime statistics of the server, such as its uptime
+// and the requests made of each method.
+func (s *Server) GetServerStats(ctx context.Context, req *client.GetServerStatsRequest) (*client.GetServerStatsResponse, error) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	res := &client.ServerStats{
+		StartedAt:  s.stats.started.UTC(),
+		Uptime:     time.Since(s.stats.started).Round(time.Second).String(),
+		Methods:    make(map[string]*client.MethodStats, len(s.stats.methods)),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: &client.MemoryStats{
+			HeapAllocBytes: mem.HeapAlloc,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+		},
+		EventQueueDepth: len(s.events),
+		EventQueueSize:  cap(s.events),
+		DroppedEvents:   s.stats.droppedEvents.Load(),
+	}
+
+	for name, m := range s.stats.methods {
+		ms := &client.MethodStats{
+			Requests:        m.requests.Load(),
+			Errors:          m.errors.Load(),
+			DurationSeconds: time.Duration(m.nanos.Load()).Seconds(),
+			DurationBuckets: make([]*client.DurationBucket, len(durationBuckets)),
+		}
+
+		for i, le := range durationBuckets {
+			ms.DurationBuckets[i] = &client.DurationBucket{LE: le, Count: m.buckets[i].Load()}
+		}
+
+		res.Methods[name] = ms
+	}
+
+	return &client.GetServerStatsResponse{Stats: res}, nil
+}
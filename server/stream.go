@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// streamFlush is how many Peers are written to a streamed response before it
+// is flushed to the client.
+const streamFlush = 256
+
+// peerStream is the Peers of the device, each converted only as it is
+// written, such that a device of many Peers is never held in memory as both
+// its dump and its response.
+type peerStream struct {
+	s      *Server
+	dev    *wgtypes.Device
+	stored map[string]*store.Peer
+}
+
+// streamPeers dumps the device and the metadata of its Peers, to be written
+// one at a time by each.
+func (s *Server) streamPeers(ctx context.Context) (*peerStream, error) {
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	stored, err := s.storedIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &peerStream{s: s, dev: dev, stored: stored}, nil
+}
+
+// each calls fn with every Peer of the device in turn, stopping at the first
+// error.
+func (ps *peerStream) each(fn func(*client.Peer) error) error {
+	for _, p := range ps.dev.Peers {
+		peer := peer2rpc(p)
+
+		if stored, ok := ps.stored[peer.PublicKey]; ok {
+			setStored(peer, stored)
+		}
+
+		ps.s.setRate(peer)
+		ps.s.setLatency(peer)
+
+		if err := fn(peer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PeersHandler streams every Peer of the device as JSON Lines, the same as
+// ListPeers but written and flushed as each Peer is encoded rather than as a
+// single JSON-RPC response, for devices of tens of thousands of Peers. The
+// generation of the device is given in the Wg-Api-Generation header.
+func PeersHandler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.enabled("ListPeers") {
+			http.NotFound(w, r)
+			return
+		} else if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ps, err := s.streamPeers(r.Context())
+		if err != nil {
+			log.Printf("error: peers: %s\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Wg-Api-Generation", strconv.FormatUint(s.gen.observe(ps.dev), 10))
+		w.Header().Set("Trailer", client.StreamErrorTrailer)
+
+		flusher, _ := w.(http.Flusher)
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+
+		var n int
+
+		err = ps.each(func(peer *client.Peer) error {
+			if err := enc.Encode(peer); err != nil {
+				return err
+			}
+
+			if n++; n%streamFlush == 0 {
+				if err := bw.Flush(); err != nil {
+					return err
+				}
+
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+
+			return nil
+		})
+		if err == nil {
+			err = bw.Flush()
+		}
+
+		// once the response has begun its status can no longer be changed,
+		// so the error is given to the client in a trailer.
+		if err != nil {
+			log.Printf("error: peers: %s\n", err)
+			w.Header().Set(client.StreamErrorTrailer, err.Error())
+		}
+	})
+}
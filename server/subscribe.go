@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+const (
+	// subscriberQueueSize is the number of Events that may be waiting to be
+	// written to a subscriber before it is disconnected, such that a slow
+	// client cannot hold Events in memory indefinitely.
+	subscriberQueueSize = 256
+
+	// streamKeepAlive is how often a comment is written to an idle event
+	// stream, such that proxies do not close it.
+	streamKeepAlive = 30 * time.Second
+)
+
+// subscribers are the clients of the event stream of the Server, to which
+// every Event published is also written.
+type subscribers struct {
+	enabled bool
+
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// subscriber receives the Events of the types it is interested in, or every
+// Event if types is empty. events is closed if the subscriber falls behind,
+// when slow is set, or the streams are closed.
+type subscriber struct {
+	types  map[string]bool
+	events chan *client.Event
+	slow   bool
+}
+
+// subscribe returns a subscriber to Events of types.
+func (ss *subscribers) subscribe(types []string) *subscriber {
+	sub := &subscriber{types: make(map[string]bool, len(types)), events: make(chan *client.Event, subscriberQueueSize)}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.closed {
+		close(sub.events)
+		return sub
+	} else if ss.subs == nil {
+		ss.subs = make(map[*subscriber]struct{})
+	}
+
+	ss.subs[sub] = struct{}{}
+
+	return sub
+}
+
+// unsubscribe stops writing Events to sub.
+func (ss *subscribers) unsubscribe(sub *subscriber) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.subs, sub)
+}
+
+// publish writes event to every subscriber interested in it, without
+// blocking. A subscriber whose queue is full is disconnected, rather than
+// silently missing Events.
+func (ss *subscribers) publish(event *client.Event) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for sub := range ss.subs {
+		if len(sub.types) > 0 && !sub.types[event.Type] {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			delete(ss.subs, sub)
+			sub.slow = true
+			close(sub.events)
+		}
+	}
+}
+
+// close disconnects every subscriber, and any that subscribe later.
+func (ss *subscribers) close() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for sub := range ss.subs {
+		delete(ss.subs, sub)
+		close(sub.events)
+	}
+
+	ss.closed = true
+}
+
+// CloseEventStreams ends every stream of the EventsHandler, and refuses any
+// opened later, such that the HTTP server serving them may shut down without
+// waiting for their clients to disconnect.
+func (s *Server) CloseEventStreams() {
+	s.subscribers.close()
+}
+
+// WithEventStream configures the Server to stream Events to clients of the
+// EventsHandler, and to publish peer.handshake and peer.endpoint_changed
+// Events as they are observed.
+func WithEventStream() Option {
+	return func(s *Server) {
+		s.subscribers.enabled = true
+	}
+}
+
+// publishing returns true if the Events published by the Server have any
+// destination.
+func (s *Server) publishing() bool {
+	return len(s.sinks) > 0 || s.subscribers.enabled
+}
+
+// EventsHandler streams the Events of the device as Server-Sent Events, as
+// they are published, such that a client need not poll ListPeers for
+// changes. Each is named by the type of the Event, with the Event as its
+// data. Only Events of the types given by the type query parameter are
+// written, if any, which may be given more than once or separated by commas.
+// Events published while a client is disconnected are not written once it
+// reconnects, so it should list the Peers of the device again. It is not
+// served if ListPeers is disabled.
+func EventsHandler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.subscribers.enabled || !s.enabled("ListPeers") {
+			http.NotFound(w, r)
+			return
+		} else if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var types []string
+		for _, t := range r.URL.Query()["type"] {
+			types = append(types, strings.Split(t, ",")...)
+		}
+
+		sub := s.subscribers.subscribe(types)
+		defer s.subscribers.unsubscribe(sub)
+
+		// the stream is open for as long as the client remains connected,
+		// beyond the write timeout of the rest of the api.
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		t := time.NewTicker(streamKeepAlive)
+		defer t.Stop()
+
+		var id uint64
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-t.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+
+			case event, ok := <-sub.events:
+				if !ok {
+					if sub.slow {
+						log.Printf("warn: events: disconnected %s from event stream, too slow\n", r.RemoteAddr)
+					}
+
+					return
+				}
+
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("error: events: %s\n", err)
+					continue
+				}
+
+				id++
+
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event.Type, data); err != nil {
+					return
+				}
+			}
+
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
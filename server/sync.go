@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func validateSyncPeersRequest(req *client.SyncPeersRequest) error {
+	if req == nil {
+		return invalidParam("", "", "request body required")
+	}
+
+	// an omitted list is more likely a mistake than a request to remove
+	// every Peer.
+	if req.Peers == nil {
+		return invalidParam("peers", "", "peers is required, an empty list removes every peer")
+	}
+
+	return validateOnError(req.OnError)
+}
+
+// SyncPeers changes the Peers of the device to exactly those of the request,
+// such that a controller converges the device in a single call. Only the
+// minimal changes are applied: Peers not given are removed, Peers given that
+// differ from those on the device are added or updated, and Peers already as
+// given are left alone. The behaviour on failure of any one Peer is
+// controlled by OnError, as with ApplyBatch.
+func (s *Server) SyncPeers(ctx context.Context, req *client.SyncPeersRequest) (*client.SyncPeersResponse, error) {
+	if err := validateSyncPeersRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkBatchSize("peers", len(req.Peers)); err != nil {
+		return nil, err
+	}
+
+	desired := make([]batchItem, len(req.Peers))
+	wanted := make(map[string]bool, len(req.Peers))
+
+	for i, peer := range req.Peers {
+		switch {
+		case peer == nil:
+			desired[i].err = invalidParam("peers", "", "peer is required")
+
+		case wanted[peer.PublicKey]:
+			desired[i] = batchItem{publicKey: peer.PublicKey, err: invalidParam("peers", peer.PublicKey, "peer is given more than once")}
+
+		default:
+			wanted[peer.PublicKey] = true
+			desired[i] = s.addPeerItem(ctx, peer)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dev, err := s.device(ctx)
+	if err != nil {
+		return nil, deviceError("could not get WireGuard device", err)
+	}
+
+	stored, err := s.storedIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current := indexPeers(dev.Peers)
+
+	// indexes are those of each item within the request, or -1 for Peers
+	// removed.
+	var items []batchItem
+	var indexes []int
+	unchanged := 0
+
+	for i, item := range desired {
+		if item.err == nil {
+			syncPeerConfig(&item.config)
+
+			if j, ok := current[item.config.PublicKey]; ok && !syncChanges(dev.Peers[j], item, stored[item.publicKey]) {
+				unchanged++
+				continue
+			}
+		}
+
+		items = append(items, item)
+		indexes = append(indexes, i)
+	}
+
+	var removals []string
+	for _, peer := range dev.Peers {
+		if publicKey := peer.PublicKey.String(); !wanted[publicKey] {
+			removals = append(removals, publicKey)
+		}
+	}
+
+	sort.Strings(removals)
+
+	for _, publicKey := range removals {
+		remove := &client.RemovePeerRequest{PublicKey: publicKey}
+
+		item := batchItem{publicKey: publicKey}
+		item.config, item.err = removePeerConfig(remove)
+		if item.err == nil {
+			item.err = s.checkRemovePeer(ctx, remove)
+		}
+
+		items = append(items, item)
+		indexes = append(indexes, -1)
+	}
+
+	res, err := s.applyBatch(ctx, items, req.OnError, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range res.Results {
+		result.Index = indexes[i]
+	}
+
+	return &client.SyncPeersResponse{
+		OK:         res.OK,
+		RolledBack: res.RolledBack,
+		Results:    res.Results,
+		Unchanged:  unchanged,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
+
+// syncPeerConfig changes the configuration of a Peer given to SyncPeers such
+// that it replaces that of the Peer on the device, rather than adding to it.
+// A preshared key or keepalive not given is removed, but an endpoint is left
+// as the Peer roams.
+func syncPeerConfig(config *wgtypes.PeerConfig) {
+	config.ReplaceAllowedIPs = true
+
+	if config.PresharedKey == nil {
+		config.PresharedKey = new(wgtypes.Key)
+	}
+
+	if config.PersistentKeepaliveInterval == nil {
+		config.PersistentKeepaliveInterval = new(time.Duration)
+	}
+}
+
+// syncChanges returns true if applying item would change peer, already on
+// the device, or the information stored about it, which may be nil.
+func syncChanges(peer wgtypes.Peer, item batchItem, stored *store.Peer) bool {
+	after := simulateConfig([]wgtypes.Peer{peer}, wgtypes.Config{Peers: []wgtypes.PeerConfig{item.config}})
+
+	if !peerConfigEqual(peer, after[0]) {
+		return true
+	} else if item.config.Endpoint != nil && item.config.Endpoint.String() != peer.Endpoint.String() {
+		return true
+	}
+
+	if stored == nil {
+		stored = &store.Peer{}
+	}
+
+	switch {
+	case item.req.Metadata != nil && !maps.Equal(item.req.Metadata, stored.Metadata):
+		return true
+
+	case item.tmpl != nil && item.req.Template != stored.Template:
+		return true
+
+	case item.req.ExpiresAt != nil && !item.req.ExpiresAt.Equal(stored.ExpiresAt):
+		return true
+
+	// a ttl only sets the expiry of a Peer that has none, otherwise every
+	// sync would extend it.
+	case item.req.TTL != "" && stored.ExpiresAt.IsZero():
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template is a named set of defaults and constraints for Peers, which are
+// applied to an AddPeerRequest that references it.
+type Template struct {
+	// AllowedIPs are given to the Peer if the request does not specify any.
+	AllowedIPs []string `yaml:"allowed_ips"`
+
+	// AllowedIPsWithin, if set, requires every allowed ip of the Peer to be
+	// within one of these ranges.
+	AllowedIPsWithin []string `yaml:"allowed_ips_within"`
+
+	// PersistentKeepAlive is given to the Peer if the request does not
+	// specify one.
+	PersistentKeepAlive string `yaml:"persistent_keep_alive"`
+
+	// RequirePresharedKey rejects any request without a preshared key.
+	RequirePresharedKey bool `yaml:"require_preshared_key"`
+
+	// QuotaBytes, if non-zero, is the total number of bytes the Peer may
+	// receive and transmit before it is removed.
+	QuotaBytes int64 `yaml:"quota_bytes"`
+
+	// Expiry, if non-zero, is how long after being added the Peer is
+	// removed, unless the request gives an expiry.
+	Expiry time.Duration `yaml:"expiry"`
+
+	// Metadata is merged with the metadata of the request, with the request
+	// taking precedence.
+	Metadata map[string]string `yaml:"metadata"`
+
+	within []*net.IPNet
+}
+
+// LoadTemplates reads named Templates from a YAML (or JSON) file.
+func LoadTemplates(filename string) (map[string]*Template, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates map[string]*Template
+	if err := yaml.Unmarshal(data, &templates); err != nil {
+		return nil, err
+	}
+
+	for name, tmpl := range templates {
+		if tmpl == nil {
+			return nil, fmt.Errorf("template %q: template is empty", name)
+		}
+
+		if err := tmpl.init(); err != nil {
+			return nil, fmt.Errorf("template %q: %w", name, err)
+		}
+	}
+
+	return templates, nil
+}
+
+func (t *Template) init() error {
+	for _, allowedIP := range t.AllowedIPs {
+		if _, _, err := net.ParseCIDR(allowedIP); err != nil {
+			return fmt.Errorf("allowed_ips: range %q is not valid: %w", allowedIP, err)
+		}
+	}
+
+	t.within = nil
+	for _, allowedIP := range t.AllowedIPsWithin {
+		_, n, err := net.ParseCIDR(allowedIP)
+		if err != nil {
+			return fmt.Errorf("allowed_ips_within: range %q is not valid: %w", allowedIP, err)
+		}
+
+		t.within = append(t.within, n)
+	}
+
+	if t.PersistentKeepAlive != "" {
+		if err := validateKeepAlive(t.PersistentKeepAlive); err != nil {
+			return fmt.Errorf("persistent_keep_alive: %w", err)
+		}
+	}
+
+	if t.QuotaBytes < 0 {
+		return fmt.Errorf("quota_bytes must be positive")
+	} else if t.Expiry < 0 {
+		return fmt.Errorf("expiry must be positive")
+	}
+
+	return nil
+}
+
+// apply returns a copy of req with the defaults of the Template applied, or
+// an error if req does not meet the constraints of the Template.
+func (t *Template) apply(req *client.AddPeerRequest) (*client.AddPeerRequest, error) {
+	c := *req
+
+	if len(c.AllowedIPs) == 0 {
+		c.AllowedIPs = t.AllowedIPs
+	}
+
+	if c.PersistentKeepAlive == "" {
+		c.PersistentKeepAlive = t.PersistentKeepAlive
+	}
+
+	if t.RequirePresharedKey && (c.PresharedKey == "" || c.PresharedKey == client.NoPresharedKey) {
+		return nil, invalidParam("preshared_key", "", fmt.Sprintf("preshared key is required by template %q", req.Template))
+	}
+
+	if len(t.within) > 0 {
+		for _, allowedIP := range c.AllowedIPs {
+			_, n, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				return nil, invalidParam("allowed_ips", allowedIP, fmt.Sprintf("range %q is not valid: %s", allowedIP, err))
+			}
+
+			if !netWithinAny(n, t.within) {
+				return nil, invalidParam("allowed_ips", allowedIP, fmt.Sprintf("range %q is not permitted by template %q", allowedIP, req.Template))
+			}
+		}
+	}
+
+	if len(t.Metadata) > 0 {
+		c.Metadata = make(map[string]string, len(t.Metadata)+len(req.Metadata))
+
+		for k, v := range t.Metadata {
+			c.Metadata[k] = v
+		}
+
+		for k, v := range req.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+
+	return &c, nil
+}
+
+// resolveTemplate applies the Template named by req, if any, returning the
+// resulting request and the Template.
+func (s *Server) resolveTemplate(req *client.AddPeerRequest) (*client.AddPeerRequest, *Template, error) {
+	if req == nil || req.Template == "" {
+		return req, nil, nil
+	}
+
+	tmpl, ok := s.templates[req.Template]
+	if !ok {
+		return nil, nil, invalidParam("template", req.Template, "unknown template")
+	}
+
+	req, err := tmpl.apply(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, tmpl, nil
+}
+
+// netWithinAny returns true if n is entirely contained by any of nets.
+func netWithinAny(n *net.IPNet, nets []*net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+
+	for _, parent := range nets {
+		pOnes, pBits := parent.Mask.Size()
+
+		if bits == pBits && ones >= pOnes && parent.Contains(n.IP) {
+			return true
+		}
+	}
+
+	return false
+}
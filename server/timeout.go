@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/server/jsonrpc"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// timeoutError returns the error given when a request did not complete
+// before its context was done, err being the error of the context.
+func timeoutError(err error) *jsonrpc.Error {
+	if errors.Is(err, context.Canceled) {
+		return jsonrpc.ServerError(client.ErrCodeTimeout, "request cancelled", &client.ErrorData{})
+	}
+
+	return jsonrpc.ServerError(client.ErrCodeTimeout, "request timed out", &client.ErrorData{Retryable: true})
+}
+
+// device dumps the device, returning the error of ctx if it is done first.
+// The dump cannot itself be cancelled, and is abandoned in the background.
+func (s *Server) device(ctx context.Context) (*wgtypes.Device, error) {
+	if ctx.Done() == nil {
+		return s.wg.Device(s.deviceName)
+	}
+
+	type result struct {
+		dev *wgtypes.Device
+		err error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		dev, err := s.wg.Device(s.deviceName)
+		ch <- result{dev, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.dev, res.err
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
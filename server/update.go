@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/jamescun/wg-api/client"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// updatePeerRequest validates an UpdatePeerRequest and converts it into the
+// AddPeerRequest updating the Peer.
+func updatePeerRequest(req *client.UpdatePeerRequest) (*client.AddPeerRequest, error) {
+	if req == nil {
+		return nil, invalidParam("", "", "request body required")
+	}
+
+	if req.ClearKeepAlive && req.PersistentKeepAlive != "" {
+		return nil, invalidParam("clear_keep_alive", "true", "persistent_keep_alive and clear_keep_alive cannot both be given")
+	}
+
+	add := &client.AddPeerRequest{
+		PublicKey:           req.PublicKey,
+		PresharedKey:        req.PresharedKey,
+		Endpoint:            req.Endpoint,
+		PersistentKeepAlive: req.PersistentKeepAlive,
+		AllowedIPs:          req.AllowedIPs,
+		Metadata:            req.Metadata,
+		ExpiresAt:           req.ExpiresAt,
+		TTL:                 req.TTL,
+		UpdateOnly:          true,
+		ClearEndpoint:       req.ClearEndpoint,
+		Force:               req.Force,
+		ExpectedGeneration:  req.ExpectedGeneration,
+		DryRun:              req.DryRun,
+	}
+
+	if req.ClearKeepAlive {
+		add.PersistentKeepAlive = client.DisableKeepAlive
+	}
+
+	return add, nil
+}
+
+// UpdatePeer changes the configuration of a Peer already on the device,
+// failing with a PeerNotFound error rather than adding it if it is not. Unlike
+// AddPeer, the allowed ips of the Peer may be replaced, and its endpoint and
+// persistent keepalive cleared. It is evaluated by the Policy as AddPeer, as
+// it makes no change to a Peer that AddPeer could not.
+func (s *Server) UpdatePeer(ctx context.Context, req *client.UpdatePeerRequest) (*client.UpdatePeerResponse, error) {
+	add, err := updatePeerRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := addPeerConfig(add)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAddPeer(add); err != nil {
+		return nil, err
+	}
+
+	if req.ReplaceAllowedIPs {
+		peer.ReplaceAllowedIPs = true
+
+		// allowed ips assigned by IP address management remain leased to
+		// the Peer, so must remain routed to it.
+		if lister, ok := s.ipam.(LeaseLister); ok {
+			leased, err := lister.Leased(ctx, req.PublicKey)
+			if err != nil {
+				return nil, ipamError("could not list allowed ips", err)
+			}
+
+			for _, allowedIP := range leased {
+				if _, aip, err := net.ParseCIDR(allowedIP); err == nil {
+					peer.AllowedIPs = append(peer.AllowedIPs, *aip)
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := []wgtypes.PeerConfig{peer}
+	if req.ClearEndpoint {
+		if peers, err = s.withoutEndpoint(ctx, peer); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkOverlaps(ctx, peers, req.Force); err != nil {
+		return nil, err
+	}
+
+	res, err := s.apply(ctx, wgtypes.Config{Peers: peers}, nil, req.ExpectedGeneration, req.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if !res.DryRun {
+		if err := s.recordPeer(ctx, add, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &client.UpdatePeerResponse{
+		OK:         true,
+		DryRun:     res.DryRun,
+		Changes:    res.Changes,
+		Generation: res.Generation,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/store"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// counters are the transfer counters of a Peer at a point in time.
+type counters struct {
+	at            time.Time
+	receiveBytes  int64
+	transmitBytes int64
+}
+
+// defaultUsageInterval is how often usage is recorded in the Store, if it
+// supports Accounting, when no usage interval is configured.
+const defaultUsageInterval = 5 * time.Minute
+
+// runUsage publishes a peer.usage Event for every Peer each usage interval,
+// and records it in the Store if it supports Accounting, until ctx is
+// cancelled.
+func (s *Server) runUsage(ctx context.Context) {
+	interval := s.usageInterval
+	if interval <= 0 {
+		interval = defaultUsageInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	previous := make(map[wgtypes.Key]counters)
+
+	for {
+		dev, err := s.wg.Device(s.deviceName)
+		if err != nil {
+			log.Printf("error: events: could not get WireGuard device: %s\n", err)
+		} else {
+			previous = s.publishUsage(ctx, previous, dev.Peers)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-t.C:
+		}
+	}
+}
+
+// publishUsage publishes the traffic of each Peer since previous, returning
+// the current counters of each Peer. Peers not in previous are only
+// recorded, as their counters may include traffic already reported.
+func (s *Server) publishUsage(ctx context.Context, previous map[wgtypes.Key]counters, peers []wgtypes.Peer) map[wgtypes.Key]counters {
+	now := time.Now().UTC()
+	current := make(map[wgtypes.Key]counters, len(peers))
+
+	var events []*client.Event
+	var rpcPeers []*client.Peer
+
+	for _, peer := range peers {
+		current[peer.PublicKey] = counters{at: now, receiveBytes: peer.ReceiveBytes, transmitBytes: peer.TransmitBytes}
+
+		prev, ok := previous[peer.PublicKey]
+		if !ok {
+			continue
+		}
+
+		usage := &client.Usage{
+			Since:         prev.at,
+			ReceiveBytes:  peer.ReceiveBytes - prev.receiveBytes,
+			TransmitBytes: peer.TransmitBytes - prev.transmitBytes,
+		}
+
+		// counters are reset if the peer is removed and added again.
+		if usage.ReceiveBytes < 0 || usage.TransmitBytes < 0 {
+			usage.ReceiveBytes = peer.ReceiveBytes
+			usage.TransmitBytes = peer.TransmitBytes
+		}
+
+		rpcPeer := peer2rpc(peer)
+		rpcPeers = append(rpcPeers, rpcPeer)
+
+		events = append(events, &client.Event{
+			Type:      client.EventPeerUsage,
+			Time:      now,
+			Device:    s.deviceName,
+			PublicKey: rpcPeer.PublicKey,
+			Peer:      rpcPeer,
+			Usage:     usage,
+		})
+	}
+
+	if err := s.attachStored(ctx, rpcPeers); err != nil {
+		log.Printf("error: events: %s\n", err)
+	}
+
+	// only the leader records usage, such that it is not counted twice.
+	accounting, _ := s.store.(store.Accounting)
+	if !s.isLeader() {
+		accounting = nil
+	}
+
+	for _, event := range events {
+		s.publish(event)
+
+		if accounting != nil {
+			err := accounting.AddUsage(ctx, &store.Usage{
+				PublicKey:     event.PublicKey,
+				Since:         event.Usage.Since,
+				Until:         event.Time,
+				ReceiveBytes:  event.Usage.ReceiveBytes,
+				TransmitBytes: event.Usage.TransmitBytes,
+			})
+			if err != nil {
+				log.Printf("error: accounting: could not record usage of %s: %s\n", event.PublicKey, err)
+			}
+		}
+	}
+
+	return current
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jamescun/wg-api/events"
+	"github.com/jamescun/wg-api/redact"
+	"github.com/jamescun/wg-api/server"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	natsURL       = flag.String("nats-url", "", "")
+	natsSubject   = flag.String("nats-subject", "wg-api.{{.Device}}.{{.Type}}", "")
+	natsCreds     = flag.String("nats-creds", "", "")
+	natsJetStream = flag.Bool("nats-jetstream", false, "")
+	natsRedact    = flag.String("nats-redact", "", "")
+
+	kafkaBrokers  = flag.StringSlice("kafka-brokers", nil, "")
+	kafkaTopic    = flag.String("kafka-topic", "wg-api", "")
+	kafkaSASL     = flag.String("kafka-sasl", "", "")
+	kafkaUsername = flag.String("kafka-username", "", "")
+	kafkaTLS      = flag.Bool("kafka-tls", false, "")
+	kafkaTLSCA    = flag.String("kafka-tls-ca", "", "")
+	kafkaRedact   = flag.String("kafka-redact", "", "")
+
+	redisURL     = flag.String("redis-url", "", "")
+	redisChannel = flag.String("redis-channel", "wg-api.{{.Device}}.{{.Type}}", "")
+	redisMirror  = flag.String("redis-mirror", "", "")
+	redisRedact  = flag.String("redis-redact", "", "")
+
+	webhookURL     = flag.String("webhook-url", "", "")
+	webhookEvents  = flag.StringSlice("webhook-events", events.DefaultWebhookEvents, "")
+	webhookRetries = flag.Int("webhook-retries", 5, "")
+	webhookRedact  = flag.String("webhook-redact", "", "")
+
+	usageInterval = flag.Duration("usage-interval", 0, "")
+	eventStream   = flag.Bool("event-stream", false, "")
+
+	alertWebhook   = flag.String("alert-webhook", "", "")
+	alertSlack     = flag.String("alert-slack", "", "")
+	alertEmail     = flag.StringArray("alert-email", nil, "")
+	alertSMTP      = flag.String("alert-smtp", "", "")
+	alertEmailFrom = flag.String("alert-email-from", "", "")
+	alertSMTPUser  = flag.String("alert-smtp-username", "", "")
+	alertMessage   = flag.String("alert-message", events.DefaultAlertMessage, "")
+	alertRateLimit = flag.Int("alert-rate-limit", 10, "")
+	alertRedact    = flag.String("alert-redact", "", "")
+)
+
+// redisMirrorInterval is how often the Peers mirrored into Redis are
+// replaced.
+const redisMirrorInterval = 30 * time.Second
+
+// loadEventSinks connects to each configured destination of Events,
+// returning the options that configure the server with them, and the
+// Redis EventSink if any, which must be started once the server is created.
+func loadEventSinks() ([]server.Option, *events.Redis, error) {
+	var sinks []server.EventSink
+	var rds *events.Redis
+
+	// add adds an EventSink, redacting the Events published to it if
+	// configured.
+	add := func(sink server.EventSink, mode string) error {
+		r, err := loadRedactor(mode)
+		if err != nil {
+			return err
+		}
+
+		sinks = append(sinks, events.Redacted(sink, r))
+		return nil
+	}
+
+	if *natsURL != "" {
+		n, err := events.NewNATS(&events.NATSConfig{
+			URL:         *natsURL,
+			Subject:     *natsSubject,
+			Credentials: *natsCreds,
+			JetStream:   *natsJetStream,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := add(n, *natsRedact); err != nil {
+			return nil, nil, fmt.Errorf("--nats-redact: %w", err)
+		}
+	}
+
+	if len(*kafkaBrokers) > 0 {
+		cfg := &events.KafkaConfig{
+			Brokers:       *kafkaBrokers,
+			Topic:         *kafkaTopic,
+			SASLMechanism: *kafkaSASL,
+			Username:      *kafkaUsername,
+			Password:      os.Getenv("WGAPI_KAFKA_PASSWORD"),
+		}
+
+		if *kafkaTLS {
+			cfg.TLS = &tls.Config{}
+
+			if *kafkaTLSCA != "" {
+				pool, err := loadCertificatePool(*kafkaTLSCA)
+				if err != nil {
+					return nil, nil, fmt.Errorf("could not load kafka ca: %w", err)
+				}
+
+				cfg.TLS.RootCAs = pool
+			}
+		}
+
+		k, err := events.NewKafka(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := add(k, *kafkaRedact); err != nil {
+			return nil, nil, fmt.Errorf("--kafka-redact: %w", err)
+		}
+	}
+
+	if *redisURL != "" {
+		var err error
+
+		rds, err = events.NewRedis(context.Background(), &events.RedisConfig{
+			URL:     *redisURL,
+			Channel: *redisChannel,
+			Mirror:  *redisMirror,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := add(rds, *redisRedact); err != nil {
+			return nil, nil, fmt.Errorf("--redis-redact: %w", err)
+		}
+	}
+
+	if *webhookURL != "" {
+		w, err := events.NewWebhook(&events.WebhookConfig{
+			URL:     *webhookURL,
+			Secret:  os.Getenv("WGAPI_WEBHOOK_SECRET"),
+			Types:   *webhookEvents,
+			Retries: *webhookRetries,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := add(w, *webhookRedact); err != nil {
+			return nil, nil, fmt.Errorf("--webhook-redact: %w", err)
+		}
+	}
+
+	if *alertWebhook != "" || *alertSlack != "" || len(*alertEmail) > 0 || os.Getenv("WGAPI_PAGERDUTY_KEY") != "" {
+		cfg := &events.NotifierConfig{
+			Webhook:      *alertWebhook,
+			Slack:        *alertSlack,
+			PagerDutyKey: os.Getenv("WGAPI_PAGERDUTY_KEY"),
+			Message:      *alertMessage,
+			RateLimit:    *alertRateLimit,
+		}
+
+		if len(*alertEmail) > 0 {
+			cfg.Email = &events.EmailConfig{
+				SMTP:     *alertSMTP,
+				From:     *alertEmailFrom,
+				To:       *alertEmail,
+				Username: *alertSMTPUser,
+				Password: os.Getenv("WGAPI_SMTP_PASSWORD"),
+			}
+		}
+
+		n, err := events.NewNotifier(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := add(n, *alertRedact); err != nil {
+			return nil, nil, fmt.Errorf("--alert-redact: %w", err)
+		}
+	}
+
+	if len(sinks) == 0 && !*eventStream {
+		return nil, nil, nil
+	}
+
+	var opts []server.Option
+
+	if len(sinks) > 0 {
+		opts = append(opts, server.WithEventSink(sinks...))
+	}
+
+	if *eventStream {
+		opts = append(opts, server.WithEventStream())
+	}
+
+	if *usageInterval > 0 {
+		opts = append(opts, server.WithUsageInterval(*usageInterval))
+	}
+
+	return opts, rds, nil
+}
+
+// loadRedactor returns the Redactor of mode, keyed by the WGAPI_REDACT_KEY
+// environment variable, or nil if mode is empty.
+func loadRedactor(mode string) (*redact.Redactor, error) {
+	return redact.New(mode, os.Getenv("WGAPI_REDACT_KEY"))
+}
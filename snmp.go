@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"github.com/jamescun/wg-api/client"
+	"github.com/jamescun/wg-api/snmp"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	snmpEnabled = flag.Bool("snmp", false, "")
+	snmpMaster  = flag.String("snmp-master", snmp.DefaultMaster, "")
+	snmpOID     = flag.String("snmp-oid", snmp.DefaultOID, "")
+)
+
+// startSNMP registers the device with the SNMP agent of the host, if
+// enabled.
+func startSNMP(ctx context.Context, c client.Client) error {
+	if !*snmpEnabled {
+		return nil
+	}
+
+	agent, err := snmp.NewAgent(*snmpMaster, *snmpOID)
+	if err != nil {
+		return err
+	}
+
+	go agent.Run(ctx, c)
+
+	return nil
+}
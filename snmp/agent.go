@@ -0,0 +1,237 @@
+// Package snmp exposes the device and Peers of a WG-API server to SNMP, as an
+// AgentX (RFC 2741) subagent of the SNMP agent of the host, such as net-snmp,
+// which remains responsible for communities and authentication.
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// DefaultMaster is the address of the AgentX master agent of net-snmp.
+const DefaultMaster = "/var/agentx/master"
+
+// cacheTTL is how long a snapshot of the device is answered from, such that
+// walking the MIB does not list the Peers of the device for every variable.
+const cacheTTL = 5 * time.Second
+
+// reconnectInterval is how long after the connection to the master agent is
+// lost it is reconnected.
+const reconnectInterval = 5 * time.Second
+
+// Agent is an AgentX subagent registering the WG-API MIB.
+type Agent struct {
+	master string
+	root   OID
+
+	cache     mib
+	cacheTime time.Time
+}
+
+// NewAgent returns an Agent registering the WG-API MIB at root, as dotted
+// object identifier, with the master agent at master. master is the path of a
+// unix socket, or a host and port to connect to over TCP.
+func NewAgent(master, root string) (*Agent, error) {
+	oid, err := ParseOID(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{master: master, root: oid}, nil
+}
+
+// Run answers requests from the master agent for the MIB of the device of c,
+// reconnecting if the connection is lost, until ctx is cancelled.
+func (a *Agent) Run(ctx context.Context, c client.Client) {
+	for {
+		if err := a.session(ctx, c); err != nil && ctx.Err() == nil {
+			log.Printf("error: snmp: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// session opens a session with the master agent, registers the MIB and
+// answers requests until the connection is closed.
+func (a *Agent) session(ctx context.Context, c client.Client) error {
+	network := "tcp"
+	if strings.HasPrefix(a.master, "/") {
+		network = "unix"
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, network, a.master)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	var open encoder
+	open.Write([]byte{0, 0, 0, 0})
+	open.oid(a.root, false)
+	open.octetString([]byte("WG-API"))
+
+	sessionID, err := a.request(conn, &header{typ: pduOpen, packetID: 1}, open.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not open session: %w", err)
+	}
+
+	var register encoder
+	register.Write([]byte{0, 127, 0, 0})
+	register.oid(a.root, false)
+
+	if _, err := a.request(conn, &header{typ: pduRegister, sessionID: sessionID, packetID: 2}, register.Bytes()); err != nil {
+		return fmt.Errorf("could not register %s: %w", a.root, err)
+	}
+
+	log.Printf("info: snmp: registered %s with %s\n", a.root, a.master)
+
+	for {
+		h, d, err := readPDU(conn)
+		if err != nil {
+			return err
+		}
+
+		if h.typ == pduClose {
+			return fmt.Errorf("session closed by master agent")
+		} else if h.typ == pduCleanupSet {
+			continue
+		}
+
+		payload := a.handle(ctx, c, h, d)
+
+		res := &header{typ: pduResponse, sessionID: h.sessionID, transactionID: h.transactionID, packetID: h.packetID}
+		if err := writePDU(conn, res, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// request sends a PDU to the master agent, returning the session id of its
+// response if it is successful.
+func (a *Agent) request(conn net.Conn, h *header, payload []byte) (uint32, error) {
+	if err := writePDU(conn, h, payload); err != nil {
+		return 0, err
+	}
+
+	res, d, err := readPDU(conn)
+	if err != nil {
+		return 0, err
+	} else if res.typ != pduResponse {
+		return 0, fmt.Errorf("unexpected pdu type %d", res.typ)
+	}
+
+	d.uint32()
+
+	if code := d.uint16(); code != errNone {
+		return 0, fmt.Errorf("master agent returned error %d", code)
+	}
+
+	return res.sessionID, nil
+}
+
+// handle answers a request from the master agent, returning the payload of
+// the response.
+func (a *Agent) handle(ctx context.Context, c client.Client, h *header, d *decoder) []byte {
+	switch h.typ {
+	case pduGet, pduGetNext, pduGetBulk:
+	case pduTestSet:
+		return response(errNotWritable, 1, nil)
+	case pduCommitSet, pduUndoSet, pduPing:
+		return response(errNone, 0, nil)
+	default:
+		return response(errGen, 0, nil)
+	}
+
+	m, err := a.snapshot(ctx, c)
+	if err != nil {
+		log.Printf("error: snmp: could not collect device: %s\n", err)
+		return response(errGen, 0, nil)
+	}
+
+	var nonRepeaters, maxRepetitions int
+	if h.typ == pduGetBulk {
+		nonRepeaters, maxRepetitions = int(d.uint16()), int(d.uint16())
+	}
+
+	ranges := d.searchRanges()
+	if d.err != nil {
+		return response(errGen, 0, nil)
+	}
+
+	var vars []*variable
+
+	switch h.typ {
+	case pduGet:
+		for _, r := range ranges {
+			vars = append(vars, m.get(r.start))
+		}
+
+	case pduGetNext:
+		for _, r := range ranges {
+			vars = append(vars, m.next(r))
+		}
+
+	case pduGetBulk:
+		nonRepeaters = min(nonRepeaters, len(ranges))
+
+		for _, r := range ranges[:nonRepeaters] {
+			vars = append(vars, m.next(r))
+		}
+
+		repeaters := append([]searchRange{}, ranges[nonRepeaters:]...)
+
+		for i := 0; i < maxRepetitions && len(repeaters) > 0; i++ {
+			done := true
+
+			for j := range repeaters {
+				v := m.next(repeaters[j])
+				vars = append(vars, v)
+
+				if v.typ != typeEndOfMibView {
+					repeaters[j].start, repeaters[j].include = v.oid, false
+					done = false
+				}
+			}
+
+			if done {
+				break
+			}
+		}
+	}
+
+	return response(errNone, 0, vars)
+}
+
+// snapshot returns the variables of the MIB, collected at most cacheTTL ago.
+func (a *Agent) snapshot(ctx context.Context, c client.Client) (mib, error) {
+	if a.cache != nil && time.Since(a.cacheTime) < cacheTTL {
+		return a.cache, nil
+	}
+
+	m, err := collect(ctx, c, a.root)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cache, a.cacheTime = m, time.Now()
+
+	return m, nil
+}
@@ -0,0 +1,328 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// types of AgentX PDU, as defined by RFC 2741.
+const (
+	pduOpen       = 1
+	pduClose      = 2
+	pduRegister   = 3
+	pduGet        = 5
+	pduGetNext    = 6
+	pduGetBulk    = 7
+	pduTestSet    = 8
+	pduCommitSet  = 9
+	pduUndoSet    = 10
+	pduCleanupSet = 11
+	pduPing       = 13
+	pduResponse   = 18
+)
+
+// flags of the header of an AgentX PDU.
+const (
+	flagNonDefaultContext = 0x08
+	flagNetworkByteOrder  = 0x10
+)
+
+// types of variable, as defined by RFC 2741.
+const (
+	typeInteger        = 2
+	typeOctetString    = 4
+	typeGauge32        = 66
+	typeCounter64      = 70
+	typeNoSuchObject   = 128
+	typeNoSuchInstance = 129
+	typeEndOfMibView   = 130
+)
+
+// errors of a Response PDU.
+const (
+	errNone        = 0
+	errGen         = 5
+	errNotWritable = 17
+)
+
+// headerSize is the size of the header of every AgentX PDU.
+const headerSize = 20
+
+// OID is an SNMP object identifier.
+type OID []uint32
+
+// ParseOID parses an object identifier in dotted form, such as
+// 1.3.6.1.4.1.8072.
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.Trim(s, "."), ".")
+	oid := make(OID, len(parts))
+
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object identifier %q", s)
+		}
+
+		oid[i] = uint32(n)
+	}
+
+	return oid, nil
+}
+
+func (o OID) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// compare returns -1, 0 or 1 if o is lexicographically before, equal to or
+// after p.
+func (o OID) compare(p OID) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		if o[i] < p[i] {
+			return -1
+		} else if o[i] > p[i] {
+			return 1
+		}
+	}
+
+	switch {
+	case len(o) < len(p):
+		return -1
+	case len(o) > len(p):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// header is the header of an AgentX PDU.
+type header struct {
+	typ           byte
+	flags         byte
+	sessionID     uint32
+	transactionID uint32
+	packetID      uint32
+}
+
+// searchRange is a range of OIDs to search given in Get, GetNext and GetBulk
+// PDUs.
+type searchRange struct {
+	start   OID
+	include bool
+	end     OID
+}
+
+// variable is a value of the MIB, or the exception that there is no value.
+type variable struct {
+	oid   OID
+	typ   uint16
+	value interface{}
+}
+
+// decoder reads the fields of the payload of a PDU.
+type decoder struct {
+	order binary.ByteOrder
+	buf   []byte
+	err   error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	} else if len(d.buf) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.take(2); b != nil {
+		return d.order.Uint16(b)
+	}
+
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.take(4); b != nil {
+		return d.order.Uint32(b)
+	}
+
+	return 0
+}
+
+// oid reads an object identifier, returning whether its include field is set.
+func (d *decoder) oid() (OID, bool) {
+	b := d.take(4)
+	if b == nil {
+		return nil, false
+	}
+
+	n, prefix, include := int(b[0]), b[1], b[2] != 0
+
+	var oid OID
+	if prefix != 0 {
+		oid = OID{1, 3, 6, 1, uint32(prefix)}
+	}
+
+	for i := 0; i < n; i++ {
+		oid = append(oid, d.uint32())
+	}
+
+	return oid, include
+}
+
+func (d *decoder) octetString() []byte {
+	n := int(d.uint32())
+	b := d.take(n)
+	d.take((4 - n%4) % 4)
+	return b
+}
+
+func (d *decoder) searchRanges() []searchRange {
+	var ranges []searchRange
+
+	for d.err == nil && len(d.buf) > 0 {
+		start, include := d.oid()
+		end, _ := d.oid()
+
+		ranges = append(ranges, searchRange{start: start, include: include, end: end})
+	}
+
+	return ranges
+}
+
+// encoder writes the fields of the payload of a PDU, always in network byte
+// order.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) uint16(n uint16) {
+	e.Write(binary.BigEndian.AppendUint16(nil, n))
+}
+
+func (e *encoder) uint32(n uint32) {
+	e.Write(binary.BigEndian.AppendUint32(nil, n))
+}
+
+func (e *encoder) oid(oid OID, include bool) {
+	var inc byte
+	if include {
+		inc = 1
+	}
+
+	e.Write([]byte{byte(len(oid)), 0, inc, 0})
+
+	for _, n := range oid {
+		e.uint32(n)
+	}
+}
+
+func (e *encoder) octetString(s []byte) {
+	e.uint32(uint32(len(s)))
+	e.Write(s)
+	e.Write(make([]byte, (4-len(s)%4)%4))
+}
+
+func (e *encoder) variable(v *variable) {
+	e.uint16(v.typ)
+	e.uint16(0)
+	e.oid(v.oid, false)
+
+	switch value := v.value.(type) {
+	case int32:
+		e.uint32(uint32(value))
+	case uint32:
+		e.uint32(value)
+	case uint64:
+		e.Write(binary.BigEndian.AppendUint64(nil, value))
+	case string:
+		e.octetString([]byte(value))
+	}
+}
+
+// readPDU reads a PDU, returning its header and payload.
+func readPDU(r io.Reader) (*header, *decoder, error) {
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, err
+	}
+
+	if buf[0] != 1 {
+		return nil, nil, fmt.Errorf("unsupported agentx version %d", buf[0])
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if buf[2]&flagNetworkByteOrder != 0 {
+		order = binary.BigEndian
+	}
+
+	h := &header{
+		typ:           buf[1],
+		flags:         buf[2],
+		sessionID:     order.Uint32(buf[4:]),
+		transactionID: order.Uint32(buf[8:]),
+		packetID:      order.Uint32(buf[12:]),
+	}
+
+	payload := make([]byte, order.Uint32(buf[16:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	d := &decoder{order: order, buf: payload}
+
+	// only the default context is registered, so any other context is
+	// skipped.
+	if h.flags&flagNonDefaultContext != 0 {
+		switch h.typ {
+		case pduGet, pduGetNext, pduGetBulk, pduTestSet:
+			d.octetString()
+		}
+	}
+
+	return h, d, nil
+}
+
+// writePDU writes a PDU of header and payload.
+func writePDU(w io.Writer, h *header, payload []byte) error {
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	buf[0] = 1
+	buf[1] = h.typ
+	buf[2] = flagNetworkByteOrder
+	binary.BigEndian.PutUint32(buf[4:], h.sessionID)
+	binary.BigEndian.PutUint32(buf[8:], h.transactionID)
+	binary.BigEndian.PutUint32(buf[12:], h.packetID)
+	binary.BigEndian.PutUint32(buf[16:], uint32(len(payload)))
+
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+// response returns the payload of a Response PDU.
+func response(errCode, index uint16, vars []*variable) []byte {
+	var e encoder
+
+	e.uint32(0)
+	e.uint16(errCode)
+	e.uint16(index)
+
+	for _, v := range vars {
+		e.variable(v)
+	}
+
+	return e.Bytes()
+}
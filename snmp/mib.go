@@ -0,0 +1,122 @@
+package snmp
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamescun/wg-api/client"
+)
+
+// DefaultOID is the root of the WG-API MIB unless configured otherwise,
+// within the netSnmpPlaypen subtree reserved for local use.
+const DefaultOID = "1.3.6.1.4.1.8072.9999.9999.51820"
+
+// objects of the WG-API MIB, relative to its root.
+var (
+	oidDeviceName       = OID{1, 1}
+	oidDevicePublicKey  = OID{1, 2}
+	oidDeviceListenPort = OID{1, 3}
+	oidDeviceNumPeers   = OID{1, 4}
+	oidDeviceGeneration = OID{1, 5}
+
+	// columns of wgPeerTable, indexed by the 32 bytes of the public key of
+	// each Peer, the not-accessible column 1.
+	oidPeerPublicKey     = OID{2, 1, 2}
+	oidPeerEndpoint      = OID{2, 1, 3}
+	oidPeerAllowedIPs    = OID{2, 1, 4}
+	oidPeerReceiveBytes  = OID{2, 1, 5}
+	oidPeerTransmitBytes = OID{2, 1, 6}
+	oidPeerHandshakeAge  = OID{2, 1, 7}
+)
+
+// mib is a snapshot of every variable of the WG-API MIB, sorted by OID.
+type mib []*variable
+
+// collect takes a snapshot of the device and Peers of c, as the variables of
+// the MIB rooted at root.
+func collect(ctx context.Context, c client.Client, root OID) (mib, error) {
+	info, err := c.GetDeviceInfo(ctx, &client.GetDeviceInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := c.ListPeers(ctx, &client.ListPeersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	var m mib
+
+	add := func(object, index OID, typ uint16, value interface{}) {
+		oid := append(append(append(OID{}, root...), object...), index...)
+		m = append(m, &variable{oid: oid, typ: typ, value: value})
+	}
+
+	dev := info.Device
+	scalar := OID{0}
+
+	add(oidDeviceName, scalar, typeOctetString, dev.Name)
+	add(oidDevicePublicKey, scalar, typeOctetString, dev.PublicKey)
+	add(oidDeviceListenPort, scalar, typeInteger, int32(dev.ListenPort))
+	add(oidDeviceNumPeers, scalar, typeGauge32, uint32(len(list.Peers)))
+	add(oidDeviceGeneration, scalar, typeCounter64, dev.Generation)
+
+	now := time.Now()
+
+	for _, peer := range list.Peers {
+		key, err := base64.StdEncoding.DecodeString(peer.PublicKey)
+		if err != nil {
+			continue
+		}
+
+		index := make(OID, len(key))
+		for i, b := range key {
+			index[i] = uint32(b)
+		}
+
+		// 0 if the Peer has never completed a handshake.
+		var age uint32
+		if !peer.LastHandshake.IsZero() {
+			age = uint32(min(max(now.Sub(peer.LastHandshake).Seconds(), 1), math.MaxUint32))
+		}
+
+		add(oidPeerPublicKey, index, typeOctetString, peer.PublicKey)
+		add(oidPeerEndpoint, index, typeOctetString, peer.Endpoint)
+		add(oidPeerAllowedIPs, index, typeOctetString, strings.Join(peer.AllowedIPs, ","))
+		add(oidPeerReceiveBytes, index, typeCounter64, uint64(peer.ReceiveBytes))
+		add(oidPeerTransmitBytes, index, typeCounter64, uint64(peer.TransmitBytes))
+		add(oidPeerHandshakeAge, index, typeGauge32, age)
+	}
+
+	sort.Slice(m, func(i, j int) bool { return m[i].oid.compare(m[j].oid) < 0 })
+
+	return m, nil
+}
+
+// get returns the variable of oid, or noSuchObject if there is none.
+func (m mib) get(oid OID) *variable {
+	i := sort.Search(len(m), func(i int) bool { return m[i].oid.compare(oid) >= 0 })
+	if i < len(m) && m[i].oid.compare(oid) == 0 {
+		return m[i]
+	}
+
+	return &variable{oid: oid, typ: typeNoSuchObject}
+}
+
+// next returns the first variable within r, or endOfMibView if there is none.
+func (m mib) next(r searchRange) *variable {
+	i := sort.Search(len(m), func(i int) bool {
+		c := m[i].oid.compare(r.start)
+		return c > 0 || (c == 0 && r.include)
+	})
+
+	if i < len(m) && (len(r.end) == 0 || m[i].oid.compare(r.end) < 0) {
+		return m[i]
+	}
+
+	return &variable{oid: r.start, typ: typeEndOfMibView}
+}
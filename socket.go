@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/jamescun/wg-api/server"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	socketMode        = flag.String("socket-mode", "", "")
+	socketAllowUsers  = flag.StringArray("socket-allow-user", nil, "")
+	socketAllowGroups = flag.StringArray("socket-allow-group", nil, "")
+)
+
+// unixSocket returns the path of the unix socket of addr, given as
+// unix:<path> or unix://<path>, and true if it is one.
+func unixSocket(addr string) (string, bool) {
+	socket, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return "", false
+	}
+
+	return strings.TrimPrefix(socket, "//"), true
+}
+
+// listenUnix listens on the unix socket at path, replacing any left behind,
+// with the octal permissions of mode. By default only the user of WG-API may
+// connect, unless peerCred is set, in which case the credentials of each
+// process connecting rather than the permissions decide whether it may.
+func listenUnix(path, mode string, peerCred bool) (net.Listener, error) {
+	perm := uint64(0600)
+	if mode != "" {
+		var err error
+		if perm, err = strconv.ParseUint(mode, 8, 32); err != nil {
+			return nil, fmt.Errorf("invalid socket mode %q", mode)
+		}
+	} else if peerCred {
+		perm = 0666
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// peerCredAuth returns the authentication of requests made over a unix
+// socket by processes of users or groups, given by name or id, if any.
+func peerCredAuth(users, groups []string) (func(http.Handler) http.Handler, error) {
+	if len(users) == 0 && len(groups) == 0 {
+		return nil, nil
+	}
+
+	var uids, gids []uint32
+
+	for _, name := range users {
+		id, err := lookupID(name, user.Lookup, func(u *user.User) string { return u.Uid })
+		if err != nil {
+			return nil, fmt.Errorf("unknown user %q: %w", name, err)
+		}
+
+		uids = append(uids, id)
+	}
+
+	for _, name := range groups {
+		id, err := lookupID(name, user.LookupGroup, func(g *user.Group) string { return g.Gid })
+		if err != nil {
+			return nil, fmt.Errorf("unknown group %q: %w", name, err)
+		}
+
+		gids = append(gids, id)
+	}
+
+	return server.AuthPeerCred(uids, gids)
+}
+
+// lookupID returns the numeric id of name, or of the user or group named by
+// it if it is not numeric.
+func lookupID[T any](name string, lookup func(string) (T, error), id func(T) string) (uint32, error) {
+	if n, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+
+	v, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.ParseUint(id(v), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(n), nil
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Open returns the Store described by spec, one of "memory" or
+// "sqlite:<filename>".
+func Open(spec string) (Store, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "memory":
+		return NewMemory(), nil
+
+	case "sqlite":
+		if arg == "" {
+			return nil, fmt.Errorf("sqlite store requires a filename, such as sqlite:/var/lib/wg-api/state.db")
+		}
+
+		return OpenSQLite(arg)
+
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
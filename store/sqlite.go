@@ -0,0 +1,471 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations are applied in order to bring the schema of a SQLite database
+// up to date, the number of migrations applied is kept in user_version.
+// Migrations must never be changed once released, only appended to.
+var migrations = []string{
+	`CREATE TABLE peers (
+		public_key  TEXT PRIMARY KEY,
+		metadata    TEXT NOT NULL DEFAULT '{}',
+		template    TEXT NOT NULL DEFAULT '',
+		expires_at  INTEGER NOT NULL DEFAULT 0,
+		quota_bytes INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE leases (
+		prefix     TEXT PRIMARY KEY,
+		public_key TEXT NOT NULL
+	);
+
+	CREATE INDEX leases_public_key ON leases (public_key);
+
+	CREATE TABLE usage (
+		public_key     TEXT NOT NULL,
+		since          INTEGER NOT NULL,
+		until          INTEGER NOT NULL,
+		receive_bytes  INTEGER NOT NULL,
+		transmit_bytes INTEGER NOT NULL
+	);
+
+	CREATE INDEX usage_public_key_until ON usage (public_key, until);
+
+	CREATE TABLE audit (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		time        INTEGER NOT NULL,
+		method      TEXT NOT NULL,
+		remote_addr TEXT NOT NULL,
+		duration    TEXT NOT NULL,
+		error       TEXT NOT NULL DEFAULT ''
+	);`,
+
+	`ALTER TABLE peers ADD COLUMN config TEXT NOT NULL DEFAULT '';
+
+	ALTER TABLE peers ADD COLUMN transfer_offset INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE leader (
+		id         INTEGER PRIMARY KEY CHECK (id = 1),
+		holder     TEXT NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+
+	`ALTER TABLE usage ADD COLUMN period INTEGER NOT NULL DEFAULT 0;
+
+	CREATE INDEX usage_period_until ON usage (period, until);
+
+	CREATE INDEX audit_time ON audit (time);`,
+}
+
+// Periods of usage records, those recorded are raw and may be rolled up into
+// the total of an hour, then a day.
+const (
+	usageRaw = iota
+	usageHourly
+	usageDaily
+)
+
+// SQLite is a Store persisted to an embedded SQLite database, which also
+// keeps Leases, Accounting and an AuditLog, and can elect a leader between
+// instances sharing the database.
+type SQLite struct {
+	db *sql.DB
+}
+
+var (
+	_ Store      = (*SQLite)(nil)
+	_ Leases     = (*SQLite)(nil)
+	_ Accounting = (*SQLite)(nil)
+	_ AuditLog   = (*SQLite)(nil)
+	_ Compactor  = (*SQLite)(nil)
+	_ Elector    = (*SQLite)(nil)
+)
+
+// OpenSQLite opens, creating if necessary, the SQLite database at filename
+// and applies any outstanding migrations.
+func OpenSQLite(filename string) (*SQLite, error) {
+	dsn := "file:" + filename + "?" + url.Values{
+		"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite permits only one writer, serializing in the pool avoids busy
+	// errors.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate database: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *SQLite) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database version %d is newer than supported version %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n).UTC()
+}
+
+// GetPeer returns the Peer with the given public key, or ErrNotFound.
+func (s *SQLite) GetPeer(ctx context.Context, publicKey string) (*Peer, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT public_key, metadata, template, expires_at, quota_bytes, config, transfer_offset FROM peers WHERE public_key = ?`, publicKey)
+
+	peer, err := scanPeer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return peer, err
+}
+
+// ListPeers returns all Peers, ordered by public key.
+func (s *SQLite) ListPeers(ctx context.Context) ([]*Peer, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT public_key, metadata, template, expires_at, quota_bytes, config, transfer_offset FROM peers ORDER BY public_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []*Peer
+
+	for rows.Next() {
+		peer, err := scanPeer(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers, rows.Err()
+}
+
+func scanPeer(row interface{ Scan(...interface{}) error }) (*Peer, error) {
+	var peer Peer
+	var metadata, config string
+	var expiresAt int64
+
+	if err := row.Scan(&peer.PublicKey, &metadata, &peer.Template, &expiresAt, &peer.QuotaBytes, &config, &peer.TransferOffset); err != nil {
+		return nil, err
+	}
+
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &peer.Config); err != nil {
+			return nil, fmt.Errorf("invalid config of peer %s: %w", peer.PublicKey, err)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(metadata), &peer.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata of peer %s: %w", peer.PublicKey, err)
+	}
+
+	if len(peer.Metadata) == 0 {
+		peer.Metadata = nil
+	}
+
+	peer.ExpiresAt = fromUnixNano(expiresAt)
+
+	return &peer, nil
+}
+
+// PutPeer inserts or replaces a Peer.
+func (s *SQLite) PutPeer(ctx context.Context, peer *Peer) error {
+	metadata, err := json.Marshal(peer.Metadata)
+	if err != nil {
+		return err
+	}
+
+	if peer.Metadata == nil {
+		metadata = []byte("{}")
+	}
+
+	var config []byte
+	if peer.Config != nil {
+		if config, err = json.Marshal(peer.Config); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO peers (public_key, metadata, template, expires_at, quota_bytes, config, transfer_offset) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		peer.PublicKey, string(metadata), peer.Template, unixNano(peer.ExpiresAt), peer.QuotaBytes, string(config), peer.TransferOffset,
+	)
+
+	return err
+}
+
+// DeletePeer removes a Peer, it is not an error if it does not exist.
+func (s *SQLite) DeletePeer(ctx context.Context, publicKey string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM peers WHERE public_key = ?`, publicKey)
+	return err
+}
+
+// ListLeases returns all Leases, ordered by prefix.
+func (s *SQLite) ListLeases(ctx context.Context) ([]*Lease, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT prefix, public_key FROM leases ORDER BY prefix`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leases []*Lease
+
+	for rows.Next() {
+		lease := new(Lease)
+		if err := rows.Scan(&lease.Prefix, &lease.PublicKey); err != nil {
+			return nil, err
+		}
+
+		leases = append(leases, lease)
+	}
+
+	return leases, rows.Err()
+}
+
+// PutLease inserts a Lease, or returns ErrConflict if its prefix is leased
+// to another Peer.
+func (s *SQLite) PutLease(ctx context.Context, lease *Lease) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO leases (prefix, public_key) VALUES (?, ?) ON CONFLICT (prefix) DO UPDATE SET public_key = excluded.public_key WHERE public_key = excluded.public_key`,
+		lease.Prefix, lease.PublicKey,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// DeleteLeases removes every Lease of a Peer.
+func (s *SQLite) DeleteLeases(ctx context.Context, publicKey string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM leases WHERE public_key = ?`, publicKey)
+	return err
+}
+
+// AddUsage records the traffic of a Peer over a period of time.
+func (s *SQLite) AddUsage(ctx context.Context, usage *Usage) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO usage (public_key, since, until, receive_bytes, transmit_bytes) VALUES (?, ?, ?, ?, ?)`,
+		usage.PublicKey, unixNano(usage.Since), unixNano(usage.Until), usage.ReceiveBytes, usage.TransmitBytes,
+	)
+
+	return err
+}
+
+// TotalUsage returns the total traffic of a Peer recorded since the given
+// time.
+func (s *SQLite) TotalUsage(ctx context.Context, publicKey string, since time.Time) (*Usage, error) {
+	usage := &Usage{PublicKey: publicKey, Since: since}
+
+	var until sql.NullInt64
+
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(receive_bytes), 0), COALESCE(SUM(transmit_bytes), 0), MAX(until) FROM usage WHERE public_key = ? AND until > ?`,
+		publicKey, unixNano(since),
+	).Scan(&usage.ReceiveBytes, &usage.TransmitBytes, &until)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.Until = fromUnixNano(until.Int64)
+
+	return usage, nil
+}
+
+// AppendAudit records a request.
+func (s *SQLite) AppendAudit(ctx context.Context, record *AuditRecord) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO audit (time, method, remote_addr, duration, error) VALUES (?, ?, ?, ?, ?)`,
+		unixNano(record.Time), record.Method, record.RemoteAddr, record.Duration, record.Error,
+	)
+
+	return err
+}
+
+// ListAudit returns at most limit of the most recent records, newest first.
+func (s *SQLite) ListAudit(ctx context.Context, limit int) ([]*AuditRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT time, method, remote_addr, duration, error FROM audit ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*AuditRecord
+
+	for rows.Next() {
+		record := new(AuditRecord)
+
+		var t int64
+		if err := rows.Scan(&t, &record.Method, &record.RemoteAddr, &record.Duration, &record.Error); err != nil {
+			return nil, err
+		}
+
+		record.Time = fromUnixNano(t)
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// Compact rolls up usage older than the ages of retention into the total of
+// each hour and day, and deletes usage and audit records older than their
+// retention, as of now. Only complete hours and days are rolled up, such that
+// each has a single total.
+func (s *SQLite) Compact(ctx context.Context, now time.Time, retention *Retention) (*Compaction, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res := new(Compaction)
+
+	rollups := []struct {
+		after  time.Duration
+		bucket time.Duration
+		period int
+	}{
+		{retention.HourlyAfter, time.Hour, usageHourly},
+		{retention.DailyAfter, 24 * time.Hour, usageDaily},
+	}
+
+	for _, rollup := range rollups {
+		if rollup.after <= 0 {
+			continue
+		}
+
+		cutoff := now.Add(-rollup.after).Truncate(rollup.bucket).UnixNano()
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO usage (public_key, since, until, receive_bytes, transmit_bytes, period) SELECT public_key, MIN(since), MAX(until), SUM(receive_bytes), SUM(transmit_bytes), ? FROM usage WHERE period < ? AND until < ? GROUP BY public_key, until / ?`,
+			rollup.period, rollup.period, cutoff, rollup.bucket.Nanoseconds(),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := execRows(ctx, tx, `DELETE FROM usage WHERE period < ? AND until < ?`, rollup.period, cutoff)
+		if err != nil {
+			return nil, err
+		}
+
+		res.RolledUp += n
+	}
+
+	if retention.Usage > 0 {
+		res.UsageDeleted, err = execRows(ctx, tx, `DELETE FROM usage WHERE until < ?`, now.Add(-retention.Usage).UnixNano())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if retention.Audit > 0 {
+		res.AuditDeleted, err = execRows(ctx, tx, `DELETE FROM audit WHERE time < ?`, now.Add(-retention.Audit).UnixNano())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, tx.Commit()
+}
+
+// execRows executes query in tx, returning the number of rows it affected.
+func execRows(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Campaign attempts to become, or remain, leader as holder for ttl. It
+// returns the current leader, which is holder if successful.
+func (s *SQLite) Campaign(ctx context.Context, holder string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO leader (id, holder, expires_at) VALUES (1, ?, ?) ON CONFLICT (id) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at WHERE leader.holder = excluded.holder OR leader.expires_at < ?`,
+		holder, now.Add(ttl).UnixNano(), now.UnixNano(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var leader string
+	if err := s.db.QueryRowContext(ctx, `SELECT holder FROM leader WHERE id = 1`).Scan(&leader); err != nil {
+		return "", err
+	}
+
+	return leader, nil
+}
+
+// Resign gives up leadership if it is held by holder.
+func (s *SQLite) Resign(ctx context.Context, holder string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM leader WHERE id = 1 AND holder = ?`, holder)
+	return err
+}
@@ -0,0 +1,338 @@
+// Package store persists information about Peers that cannot be kept on the
+// WireGuard device itself, such as their metadata.
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a record does not exist in the Store.
+var ErrNotFound = errors.New("store: not found")
+
+// Peer is the information kept about a Peer, keyed by its public key.
+type Peer struct {
+	PublicKey string            `json:"public_key"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+
+	// Template is the name of the template the Peer was added with.
+	Template string `json:"template,omitempty"`
+
+	// ExpiresAt, if non-zero, is when the Peer should be removed.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+
+	// QuotaBytes, if non-zero, is the total number of bytes the Peer may
+	// receive and transmit before it should be removed.
+	QuotaBytes int64 `json:"quota_bytes,omitempty"`
+
+	// Config is the configuration of the Peer on the device, kept when
+	// highly available such that a standby can restore it.
+	Config *PeerConfig `json:"config,omitempty"`
+
+	// TransferOffset is the number of bytes received and transmitted by the
+	// Peer before it was restored onto the device, which are not included in
+	// the counters of the device.
+	TransferOffset int64 `json:"transfer_offset,omitempty"`
+}
+
+// PeerConfig is the configuration of a Peer on the device.
+type PeerConfig struct {
+	PresharedKey        string        `json:"preshared_key,omitempty"`
+	Endpoint            string        `json:"endpoint,omitempty"`
+	PersistentKeepAlive time.Duration `json:"persistent_keep_alive,omitempty"`
+	AllowedIPs          []string      `json:"allowed_ips,omitempty"`
+
+	// TransferBytes is the total number of bytes received and transmitted
+	// by the Peer, including its TransferOffset.
+	TransferBytes int64 `json:"transfer_bytes,omitempty"`
+}
+
+// Store is implemented by persistence backends.
+type Store interface {
+	// GetPeer returns the Peer with the given public key, or ErrNotFound.
+	GetPeer(ctx context.Context, publicKey string) (*Peer, error)
+
+	// ListPeers returns all Peers, ordered by public key.
+	ListPeers(ctx context.Context) ([]*Peer, error)
+
+	// PutPeer inserts or replaces a Peer.
+	PutPeer(ctx context.Context, peer *Peer) error
+
+	// DeletePeer removes a Peer, it is not an error if it does not exist.
+	DeletePeer(ctx context.Context, publicKey string) error
+}
+
+// ErrConflict is returned when a Lease is already held by another Peer.
+var ErrConflict = errors.New("store: conflict")
+
+// Lease is an address range allocated to a Peer by IP address management.
+type Lease struct {
+	Prefix    string `json:"prefix"`
+	PublicKey string `json:"public_key"`
+}
+
+// Leases is implemented by Stores that can keep the address ranges
+// allocated to Peers.
+type Leases interface {
+	// ListLeases returns all Leases, ordered by prefix.
+	ListLeases(ctx context.Context) ([]*Lease, error)
+
+	// PutLease inserts a Lease, or returns ErrConflict if its prefix is
+	// leased to another Peer.
+	PutLease(ctx context.Context, lease *Lease) error
+
+	// DeleteLeases removes every Lease of a Peer.
+	DeleteLeases(ctx context.Context, publicKey string) error
+}
+
+// Usage is the traffic of a Peer over a period of time.
+type Usage struct {
+	PublicKey     string    `json:"public_key"`
+	Since         time.Time `json:"since"`
+	Until         time.Time `json:"until"`
+	ReceiveBytes  int64     `json:"receive_bytes"`
+	TransmitBytes int64     `json:"transmit_bytes"`
+}
+
+// Accounting is implemented by Stores that can keep the usage of Peers.
+type Accounting interface {
+	// AddUsage records the traffic of a Peer over a period of time.
+	AddUsage(ctx context.Context, usage *Usage) error
+
+	// TotalUsage returns the total traffic of a Peer recorded since the
+	// given time.
+	TotalUsage(ctx context.Context, publicKey string, since time.Time) (*Usage, error)
+}
+
+// AuditRecord describes a request that may have changed the device.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	RemoteAddr string    `json:"remote_addr"`
+	Duration   string    `json:"duration"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLog is implemented by Stores that can keep a record of requests.
+type AuditLog interface {
+	// AppendAudit records a request.
+	AppendAudit(ctx context.Context, record *AuditRecord) error
+
+	// ListAudit returns at most limit of the most recent records, newest
+	// first.
+	ListAudit(ctx context.Context, limit int) ([]*AuditRecord, error)
+}
+
+// Retention is how long a Compactor keeps records as they age. A zero
+// duration disables that step.
+type Retention struct {
+	// HourlyAfter is the age after which usage is rolled up into the total
+	// of each hour, and DailyAfter that after which it is rolled up into
+	// the total of each day.
+	HourlyAfter time.Duration
+	DailyAfter  time.Duration
+
+	// Usage and Audit are the ages after which usage and audit records are
+	// deleted.
+	Usage time.Duration
+	Audit time.Duration
+}
+
+// Compaction is the outcome of compacting a Store.
+type Compaction struct {
+	// RolledUp is the number of usage records replaced by their total.
+	RolledUp int64
+
+	// UsageDeleted and AuditDeleted are the number of usage and audit
+	// records deleted.
+	UsageDeleted int64
+	AuditDeleted int64
+}
+
+// Compactor is implemented by Stores whose Accounting and AuditLog can be
+// rolled up and deleted as they age, such that they do not grow without
+// bound.
+type Compactor interface {
+	// Compact rolls up and deletes the records older than retention as of
+	// now.
+	Compact(ctx context.Context, now time.Time, retention *Retention) (*Compaction, error)
+}
+
+// Elector is implemented by Stores shared between instances of WG-API that
+// can elect one of them as leader.
+type Elector interface {
+	// Campaign attempts to become, or remain, leader as holder for ttl. It
+	// returns the current leader, which is holder if successful.
+	Campaign(ctx context.Context, holder string, ttl time.Duration) (string, error)
+
+	// Resign gives up leadership if it is held by holder.
+	Resign(ctx context.Context, holder string) error
+}
+
+// Memory is a Store that does not persist beyond the lifetime of the
+// process.
+type Memory struct {
+	mu     sync.RWMutex
+	peers  map[string]*Peer
+	leases map[string]string
+
+	leader          string
+	leaderExpiresAt time.Time
+}
+
+var (
+	_ Store   = (*Memory)(nil)
+	_ Leases  = (*Memory)(nil)
+	_ Elector = (*Memory)(nil)
+)
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{peers: make(map[string]*Peer), leases: make(map[string]string)}
+}
+
+// GetPeer returns the Peer with the given public key, or ErrNotFound.
+func (m *Memory) GetPeer(ctx context.Context, publicKey string) (*Peer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	peer, ok := m.peers[publicKey]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return peer.clone(), nil
+}
+
+// ListPeers returns all Peers, ordered by public key.
+func (m *Memory) ListPeers(ctx context.Context) ([]*Peer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer.clone())
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PublicKey < peers[j].PublicKey
+	})
+
+	return peers, nil
+}
+
+// PutPeer inserts or replaces a Peer.
+func (m *Memory) PutPeer(ctx context.Context, peer *Peer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.peers[peer.PublicKey] = peer.clone()
+
+	return nil
+}
+
+// DeletePeer removes a Peer, it is not an error if it does not exist.
+func (m *Memory) DeletePeer(ctx context.Context, publicKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.peers, publicKey)
+
+	return nil
+}
+
+// ListLeases returns all Leases, ordered by prefix.
+func (m *Memory) ListLeases(ctx context.Context) ([]*Lease, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	leases := make([]*Lease, 0, len(m.leases))
+	for prefix, publicKey := range m.leases {
+		leases = append(leases, &Lease{Prefix: prefix, PublicKey: publicKey})
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Prefix < leases[j].Prefix
+	})
+
+	return leases, nil
+}
+
+// PutLease inserts a Lease, or returns ErrConflict if its prefix is leased
+// to another Peer.
+func (m *Memory) PutLease(ctx context.Context, lease *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if publicKey, ok := m.leases[lease.Prefix]; ok && publicKey != lease.PublicKey {
+		return ErrConflict
+	}
+
+	m.leases[lease.Prefix] = lease.PublicKey
+
+	return nil
+}
+
+// DeleteLeases removes every Lease of a Peer.
+func (m *Memory) DeleteLeases(ctx context.Context, publicKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for prefix, holder := range m.leases {
+		if holder == publicKey {
+			delete(m.leases, prefix)
+		}
+	}
+
+	return nil
+}
+
+// Campaign attempts to become, or remain, leader as holder for ttl. It
+// returns the current leader, which is holder if successful.
+func (m *Memory) Campaign(ctx context.Context, holder string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if m.leader == "" || m.leader == holder || now.After(m.leaderExpiresAt) {
+		m.leader = holder
+		m.leaderExpiresAt = now.Add(ttl)
+	}
+
+	return m.leader, nil
+}
+
+// Resign gives up leadership if it is held by holder.
+func (m *Memory) Resign(ctx context.Context, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.leader == holder {
+		m.leader = ""
+	}
+
+	return nil
+}
+
+func (p *Peer) clone() *Peer {
+	c := *p
+
+	if p.Config != nil {
+		config := *p.Config
+		config.AllowedIPs = append([]string(nil), p.Config.AllowedIPs...)
+		c.Config = &config
+	}
+
+	if p.Metadata != nil {
+		c.Metadata = make(map[string]string, len(p.Metadata))
+		for k, v := range p.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+
+	return &c
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/jamescun/wg-api/server"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	readOnlyTokens  = flag.StringArray("token-ro", nil, "")
+	readWriteTokens = flag.StringArray("token-rw", nil, "")
+	tokenFile       = flag.String("token-file", "", "")
+)
+
+// Scopes of the tokens of --token-file.
+const (
+	scopeReadOnly  = "read-only"
+	scopeReadWrite = "read-write"
+)
+
+// tokenConfig is a token of --token-file.
+type tokenConfig struct {
+	Token string `yaml:"token"`
+
+	// Scope is read-only or read-write, by default read-write.
+	Scope string `yaml:"scope"`
+}
+
+// loadTokens returns the read-write and read-only tokens of the command line,
+// the environment and --token-file.
+func loadTokens() (rw, ro []string, err error) {
+	rw = append(append(rw, *authTokens...), *readWriteTokens...)
+	rw = append(rw, envArray("WGAPI_TOKENS")...)
+
+	ro = append(ro, *readOnlyTokens...)
+	ro = append(ro, envArray("WGAPI_TOKENS_RO")...)
+
+	if *tokenFile == "" {
+		return rw, ro, nil
+	}
+
+	data, err := os.ReadFile(*tokenFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file struct {
+		Tokens []*tokenConfig `yaml:"tokens"`
+	}
+
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, nil, err
+	}
+
+	for i, cfg := range file.Tokens {
+		if cfg == nil || cfg.Token == "" {
+			return nil, nil, fmt.Errorf("token %d: token is required", i)
+		}
+
+		switch cfg.Scope {
+		case "", scopeReadWrite:
+			rw = append(rw, cfg.Token)
+		case scopeReadOnly:
+			ro = append(ro, cfg.Token)
+		default:
+			return nil, nil, fmt.Errorf("token %d: unknown scope %q", i, cfg.Scope)
+		}
+	}
+
+	return rw, ro, nil
+}
+
+// tokenAuths returns the authentication of requests by read-write tokens rw
+// and read-only tokens ro, if any.
+func tokenAuths(rw, ro []string) []func(http.Handler) http.Handler {
+	var auths []func(http.Handler) http.Handler
+
+	if len(rw) > 0 {
+		auths = append(auths, server.AuthTokens(rw...))
+	}
+
+	if len(ro) > 0 {
+		auths = append(auths, server.AuthReadOnlyTokens(ro...))
+	}
+
+	return auths
+}